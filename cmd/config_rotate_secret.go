@@ -89,9 +89,10 @@ func runRotateSecret(cmd *cobra.Command, _ []string) error {
 
 	// Best-effort audit entry. Failure here must not stop us from
 	// printing the new secret.
-	_ = server.NewAuditLog(server.AuditPath(cfg)).Write(server.AuditEntry{
-		Action: "rotate-secret",
-	})
+	if audit, err := server.OpenAuditLog(cfg); err == nil {
+		_ = audit.Write(server.AuditEntry{Action: "rotate-secret"})
+		_ = audit.Close()
+	}
 
 	if rotateSecretQuiet {
 		fmt.Fprintln(cmd.OutOrStdout(), newSecret)
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+
+	"github.com/descoped/dddns/internal/config"
+	"github.com/descoped/dddns/internal/logging"
+)
+
+// logFormat is the --log-format flag; it overrides cfg.Log.Format.
+var logFormat string
+
+// setupLogging builds the operational logger from cfg.Log at level and
+// installs it as slog's default, which also routes the standard log
+// package through it. The returned func restores the previous default
+// and stderr, and closes any sink files and sockets.
+func setupLogging(cfg *config.Config, level slog.Level) (*slog.Logger, func(), error) {
+	logger, closeSinks, err := logging.FromConfig(cfg.Log, logFormat, level)
+	if err != nil {
+		return nil, nil, fmt.Errorf("log sinks: %w", err)
+	}
+	prev, flags := slog.Default(), log.Flags()
+	slog.SetDefault(logger)
+	return logger, func() {
+		// SetDefault leaves the log package pointed at logger's handler
+		// when prev is slog's built-in default; reset it explicitly.
+		slog.SetDefault(prev)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+		_ = closeSinks()
+	}, nil
+}
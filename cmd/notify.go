@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/descoped/dddns/internal/config"
+	"github.com/descoped/dddns/internal/logging"
+	"github.com/descoped/dddns/internal/notify"
+	"github.com/spf13/cobra"
+)
+
+var notifyCmd = &cobra.Command{
+	Use:   "notify",
+	Short: "Change notifications",
+	Long:  `Manage the webhooks and email configured under notify: in the config file.`,
+}
+
+var notifyTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Send a test notification to every webhook and the email recipients",
+	Long: `Send a test event to every configured webhook and, if notify.email is set,
+to the email recipients, regardless of the events they subscribe to, the
+failure threshold or the rate limit. Reports each delivery and exits non-zero
+if any fails.`,
+	RunE: runNotifyTest,
+}
+
+// init registers the notify command and its subcommands.
+func init() {
+	rootCmd.AddCommand(notifyCmd)
+	notifyCmd.AddCommand(notifyTestCmd)
+}
+
+// runNotifyTest loads the config and delegates to performNotifyTest.
+func runNotifyTest(cmd *cobra.Command, _ []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	logger, restoreLog, err := setupLogging(cfg, logging.LevelNotice)
+	if err != nil {
+		return err
+	}
+	defer restoreLog()
+
+	n, err := notify.New(cfg.Notify, logger)
+	if err != nil {
+		return err
+	}
+	return performNotifyTest(context.Background(), n, cfg.Hostname, cmd.OutOrStdout())
+}
+
+// performNotifyTest sends a test event through n and prints one line
+// per target. Extracted from runNotifyTest so tests can point it at an
+// httptest receiver.
+func performNotifyTest(ctx context.Context, n *notify.Notifier, hostname string, w io.Writer) error {
+	if n == nil {
+		return fmt.Errorf("no webhooks or email configured (add a notify: block to the config)")
+	}
+	deliveries := n.Deliver(ctx, notify.Event{Kind: notify.EventTest, Hostname: hostname})
+	failed := 0
+	for _, d := range deliveries {
+		if d.Err != nil {
+			failed++
+			fmt.Fprintf(w, "✗ %s: %v (%d attempts)\n", d.Name, d.Err, d.Attempts)
+			continue
+		}
+		fmt.Fprintf(w, "✓ %s\n", d.Name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d notifications failed", failed, len(deliveries))
+	}
+	return nil
+}
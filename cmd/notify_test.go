@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/descoped/dddns/internal/config"
+	"github.com/descoped/dddns/internal/logging"
+	"github.com/descoped/dddns/internal/notify"
+)
+
+// TestPerformNotifyTest_ReportsEachWebhook verifies the test event
+// reaches every webhook — including one subscribed only to failures —
+// and that a failing webhook is reported and fails the command.
+func TestPerformNotifyTest_ReportsEachWebhook(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	t.Cleanup(ok.Close)
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	t.Cleanup(broken.Close)
+
+	n, err := notify.New(&config.NotifyConfig{Webhooks: []config.WebhookConfig{
+		{Name: "alerts", URL: ok.URL, Events: []string{"failed"}},
+		{Name: "chat", Format: "slack", URL: broken.URL},
+	}}, logging.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	err = performNotifyTest(t.Context(), n, "home.example.com", buf)
+	if err == nil || !strings.Contains(err.Error(), "1 of 2") {
+		t.Errorf("err = %v, want 1 of 2 failed", err)
+	}
+	mustContainAll(t, buf.String(), []string{"✓ alerts", "✗ chat: HTTP 403"})
+}
+
+func TestPerformNotifyTest_NoWebhooks(t *testing.T) {
+	err := performNotifyTest(t.Context(), nil, "home.example.com", &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "no webhooks") {
+		t.Errorf("err = %v", err)
+	}
+}
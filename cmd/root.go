@@ -37,6 +37,7 @@ func init() {
 
 	// Global flags
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.dddns/config.yaml)")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "", "log output format: text or json (default: config log.format, else text)")
 }
 
 // checkConfigPermissions ensures config file has secure permissions (600 or 400)
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
@@ -13,6 +14,8 @@ import (
 	"time"
 
 	"github.com/descoped/dddns/internal/config"
+	"github.com/descoped/dddns/internal/logging"
+	"github.com/descoped/dddns/internal/reqsign"
 	"github.com/descoped/dddns/internal/server"
 	"github.com/spf13/cobra"
 )
@@ -36,23 +39,36 @@ var serveStatusCmd = &cobra.Command{
 	Long: `Print a human-readable summary of the last HTTP request the
 serve-mode listener handled: when it arrived, where from, the auth
 outcome, the resulting action, and any error. Reads
-<data-dir>/serve-status.json written by the server on every request.`,
+<data-dir>/serve-status.json written by the server on every request.
+
+With --probe, query the running listener's /healthz and /readyz
+endpoints instead and print each check. Exits non-zero unless both
+report "ok" — suitable for a supervisor or container HEALTHCHECK.`,
 	RunE: runServeStatus,
 }
 
+var serveStatusProbe bool
+
+var serveVerbose bool
+
 var (
 	serveTestHostname string
 	serveTestIP       string
+	serveTestSign     bool
 )
 
 var serveTestCmd = &cobra.Command{
 	Use:   "test",
-	Short: "Send a local Basic-Auth'd request to the serve-mode listener",
+	Short: "Send a local authenticated request to the serve-mode listener",
 	Long: `Craft a dyndns-style GET to 127.0.0.1 on the configured bind port,
 using the shared secret from the config. Prints the HTTP status and
 response body. Exits 0 on "good" / "nochg", non-zero on any other
 dyndns code or network failure.
 
+The request uses Basic Auth by default. With --sign (implied when
+server.require_signature is set) it carries an HMAC-SHA256 signature
+instead, so the secret itself never goes over the wire.
+
 This is the SSH debug path — run it from a shell on the router to
 confirm the listener is reachable, the credential matches, and the
 handler wiring produces an expected response.`,
@@ -64,7 +80,12 @@ func init() {
 	serveCmd.AddCommand(serveStatusCmd)
 	serveCmd.AddCommand(serveTestCmd)
 
+	serveCmd.Flags().BoolVarP(&serveVerbose, "verbose", "v", false, "Log per-request updater steps at debug level")
+
+	serveStatusCmd.Flags().BoolVar(&serveStatusProbe, "probe", false, "Query the listener's /healthz and /readyz instead of reading the status file")
+
 	serveTestCmd.Flags().StringVar(&serveTestHostname, "hostname", "", "Override hostname (default: cfg.Hostname)")
+	serveTestCmd.Flags().BoolVar(&serveTestSign, "sign", false, "Sign the request with HMAC-SHA256 instead of sending Basic Auth")
 	serveTestCmd.Flags().StringVar(&serveTestIP, "ip", "1.2.3.4", "myip query param (handler ignores for the actual UPSERT — this is just for the wire-level test)")
 }
 
@@ -73,8 +94,13 @@ func runServe(_ *cobra.Command, _ []string) error {
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
+	logger, restoreLog, err := setupLogging(cfg, logging.Level(false, serveVerbose))
+	if err != nil {
+		return err
+	}
+	defer restoreLog()
 
-	srv, err := server.NewServer(cfg)
+	srv, err := server.NewServer(cfg, logger)
 	if err != nil {
 		return err
 	}
@@ -104,6 +130,7 @@ func runServeTest(cmd *cobra.Command, _ []string) error {
 		hostname,
 		cfg.Server.SharedSecret,
 		serveTestIP,
+		serveTestSign || cfg.Server.RequireSignature,
 		cmd.OutOrStdout(),
 	)
 }
@@ -111,14 +138,20 @@ func runServeTest(cmd *cobra.Command, _ []string) error {
 // performServeTest is the side-effect-free core of runServeTest. It is
 // called with an explicit base URL so tests can point at an
 // httptest.NewServer rather than a real loopback listener.
-func performServeTest(baseURL, hostname, secret, myip string, out io.Writer) error {
+func performServeTest(baseURL, hostname, secret, myip string, sign bool, out io.Writer) error {
 	u := baseURL + "/nic/update?hostname=" + url.QueryEscape(hostname) + "&myip=" + url.QueryEscape(myip)
 
 	req, err := http.NewRequest(http.MethodGet, u, nil)
 	if err != nil {
 		return fmt.Errorf("build request: %w", err)
 	}
-	req.SetBasicAuth("dddns", secret)
+	if sign {
+		if err := reqsign.SignRequest(req, secret); err != nil {
+			return fmt.Errorf("sign request: %w", err)
+		}
+	} else {
+		req.SetBasicAuth("dddns", secret)
+	}
 
 	client := &http.Client{Timeout: 10 * time.Second}
 	resp, err := client.Do(req)
@@ -165,6 +198,13 @@ func runServeStatus(cmd *cobra.Command, _ []string) error {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
+	if serveStatusProbe {
+		if cfg.Server == nil {
+			return fmt.Errorf("serve mode not configured (no server block in config)")
+		}
+		return performProbe(loopbackURL(cfg.Server.Bind), cmd.OutOrStdout())
+	}
+
 	path := server.StatusPath(cfg)
 	snap, err := server.ReadStatus(path)
 	if err != nil {
@@ -188,3 +228,39 @@ func runServeStatus(cmd *cobra.Command, _ []string) error {
 	}
 	return nil
 }
+
+// performProbe queries /healthz then /readyz under baseURL and prints
+// each check. It returns an error when either endpoint is unreachable
+// or reports anything other than "ok".
+func performProbe(baseURL string, out io.Writer) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	var failed []string
+	for _, path := range []string{"/healthz", "/readyz"} {
+		resp, err := client.Get(baseURL + path)
+		if err != nil {
+			return fmt.Errorf("probe %s: %w", path, err)
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		_ = resp.Body.Close()
+
+		var report server.ProbeReport
+		if err := json.Unmarshal(body, &report); err != nil {
+			return fmt.Errorf("probe %s: HTTP %d with unparseable body: %w", path, resp.StatusCode, err)
+		}
+		fmt.Fprintf(out, "%s: %s (HTTP %d)\n", path, report.Status, resp.StatusCode)
+		for _, c := range report.Checks {
+			line := fmt.Sprintf("  %-12s %s", c.Name, c.Status)
+			if c.Detail != "" {
+				line += " — " + c.Detail
+			}
+			fmt.Fprintln(out, line)
+		}
+		if report.Status != server.ProbeOK {
+			failed = append(failed, path)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("probe failed: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,412 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/descoped/dddns/internal/config"
+	"github.com/descoped/dddns/internal/server"
+	"github.com/spf13/cobra"
+)
+
+var serveAuditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Query, summarise and export the serve-mode audit log",
+	Long: `Read the serve-mode audit log (the live file and its rotated
+generation) and print the entries that match the filters, oldest first.
+
+Filters combine with AND. --since/--until accept an RFC 3339 time or a
+duration back from now ("24h", "90m"). --remote accepts an IP, a CIDR,
+or an exact address string.
+
+--format selects text (default), json (an array; one object per line
+with --follow) or csv. --summary prints counts by action and auth
+outcome plus failed-auth attempts per source instead of the entries.
+--follow keeps printing new matching entries until interrupted.`,
+	Example: `  dddns serve audit --since 24h --auth bad
+  dddns serve audit --summary --since 168h
+  dddns serve audit --remote 192.168.1.0/24 --format csv > audit.csv
+  dddns serve audit --follow`,
+	RunE: runServeAudit,
+}
+
+var serveAuditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check the audit log's hash chain for tampering",
+	Long: `Walk the rotated and live audit files and check that every entry's
+hash matches its contents, that sequence numbers are consecutive, and
+that each entry links to the hash of the one before it. With
+server.audit_hmac_key configured, every entry's MAC is checked too.
+
+Deleted, reordered, and modified lines are reported with file and line
+number, and the command exits non-zero. Lines written before the chain
+was introduced are counted as legacy and not checked. Lines removed
+from the very end of the log cannot be detected by the chain alone;
+compare the reported last sequence number with an earlier run.`,
+	Example: `  dddns serve audit verify
+  dddns serve audit verify --format json`,
+	Args: cobra.NoArgs,
+	RunE: runServeAuditVerify,
+}
+
+var (
+	auditSince    string
+	auditUntil    string
+	auditRemote   string
+	auditAuth     string
+	auditAction   string
+	auditHostname string
+	auditFormat   string
+	auditSummary  bool
+	auditFollow   bool
+
+	auditVerifyFormat string
+)
+
+// auditFollowInterval is how often --follow polls the live file.
+const auditFollowInterval = time.Second
+
+func init() {
+	serveCmd.AddCommand(serveAuditCmd)
+	serveAuditCmd.AddCommand(serveAuditVerifyCmd)
+	serveAuditVerifyCmd.Flags().StringVar(&auditVerifyFormat, "format", "text", "Output format: text, json")
+
+	f := serveAuditCmd.Flags()
+	f.StringVar(&auditSince, "since", "", "Only entries at or after this time (RFC 3339 or duration ago, e.g. 24h)")
+	f.StringVar(&auditUntil, "until", "", "Only entries at or before this time (RFC 3339 or duration ago)")
+	f.StringVar(&auditRemote, "remote", "", "Only entries from this IP or CIDR")
+	f.StringVar(&auditAuth, "auth", "", "Only entries with this auth outcome (ok, bad, locked, missing, unsigned)")
+	f.StringVar(&auditAction, "action", "", "Only entries with this action (updated, nochg-dns, cidr-deny, ...)")
+	f.StringVar(&auditHostname, "hostname", "", "Only entries for this hostname")
+	f.StringVar(&auditFormat, "format", "text", "Output format: text, json, csv")
+	f.BoolVar(&auditSummary, "summary", false, "Print aggregate counts instead of entries")
+	f.BoolVar(&auditFollow, "follow", false, "Keep printing new entries as they are appended")
+}
+
+func runServeAudit(cmd *cobra.Command, _ []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	filter, err := buildAuditFilter(time.Now())
+	if err != nil {
+		return err
+	}
+	if auditSummary && auditFollow {
+		return fmt.Errorf("--summary and --follow cannot be combined")
+	}
+
+	ctx := context.Background()
+	if auditFollow {
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+	}
+	return performServeAudit(ctx, server.AuditPath(cfg), filter, auditFormat, auditSummary, auditFollow, cmd.OutOrStdout(), cmd.ErrOrStderr())
+}
+
+func runServeAuditVerify(cmd *cobra.Command, _ []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	var key []byte
+	if cfg.Server != nil && cfg.Server.AuditHMACKey != "" {
+		key = []byte(cfg.Server.AuditHMACKey)
+	}
+	return performServeAuditVerify(server.AuditPath(cfg), key, auditVerifyFormat, cmd.OutOrStdout())
+}
+
+// performServeAuditVerify prints the VerifyAudit report for path and
+// returns an error when the chain is broken.
+func performServeAuditVerify(path string, key []byte, format string, out io.Writer) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown --format %q (want text or json)", format)
+	}
+	r, err := server.VerifyAudit(path, key)
+	if err != nil {
+		return err
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintf(out, "Files:          %d\n", len(r.Files))
+		fmt.Fprintf(out, "Entries:        %d\n", r.Entries)
+		if r.AnchorSeq > 0 {
+			fmt.Fprintf(out, "Anchor:         seq %d (older generations deleted by rotation)\n", r.AnchorSeq)
+		}
+		if r.PreChain > 0 {
+			fmt.Fprintf(out, "Pre-chain:      %d (written before the hash chain; not verified)\n", r.PreChain)
+		}
+		if r.LastSeq > 0 {
+			fmt.Fprintf(out, "Sequence:       %d — %d\n", r.FirstSeq, r.LastSeq)
+			fmt.Fprintf(out, "Last hash:      %s\n", r.LastHash)
+		}
+		mac := "not checked (no server.audit_hmac_key)"
+		if r.MACChecked {
+			mac = "checked"
+		}
+		fmt.Fprintf(out, "MAC:            %s\n", mac)
+		for _, p := range r.Problems {
+			fmt.Fprintf(out, "  %s:%d  seq %d  %s\n", p.File, p.Line, p.Seq, p.Msg)
+		}
+	}
+
+	if !r.OK() {
+		return fmt.Errorf("audit log failed verification: %d problem(s)", len(r.Problems))
+	}
+	if format == "text" {
+		fmt.Fprintln(out, "✓ Audit chain intact")
+	}
+	return nil
+}
+
+// buildAuditFilter turns the flag values into a server.AuditFilter.
+func buildAuditFilter(now time.Time) (server.AuditFilter, error) {
+	since, err := parseAuditTime(auditSince, now)
+	if err != nil {
+		return server.AuditFilter{}, fmt.Errorf("--since: %w", err)
+	}
+	until, err := parseAuditTime(auditUntil, now)
+	if err != nil {
+		return server.AuditFilter{}, fmt.Errorf("--until: %w", err)
+	}
+	return server.AuditFilter{
+		Since:    since,
+		Until:    until,
+		Remote:   auditRemote,
+		Auth:     auditAuth,
+		Action:   auditAction,
+		Hostname: auditHostname,
+	}, nil
+}
+
+// parseAuditTime accepts RFC 3339 or a Go duration counted back from now.
+func parseAuditTime(v string, now time.Time) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 time nor a positive duration", v)
+	}
+	return now.Add(-d), nil
+}
+
+// performServeAudit is the core of runServeAudit, taking the audit path
+// and writers explicitly so tests can drive it against a temp file.
+func performServeAudit(ctx context.Context, path string, filter server.AuditFilter, format string, summary, follow bool, out, errOut io.Writer) error {
+	switch format {
+	case "text", "json", "csv":
+	default:
+		return fmt.Errorf("unknown --format %q (want text, json or csv)", format)
+	}
+
+	entries, skipped, err := server.ReadAudit(path)
+	if err != nil {
+		return err
+	}
+	if skipped > 0 {
+		fmt.Fprintf(errOut, "warning: skipped %d malformed audit line(s)\n", skipped)
+	}
+	var matched []server.AuditEntry
+	for _, e := range entries {
+		if filter.Match(e) {
+			matched = append(matched, e)
+		}
+	}
+
+	if summary {
+		return writeAuditSummary(out, format, server.SummarizeAudit(matched))
+	}
+
+	w, err := newAuditWriter(format, follow, out)
+	if err != nil {
+		return err
+	}
+	for _, e := range matched {
+		if err := w.write(e); err != nil {
+			return err
+		}
+	}
+	if !follow {
+		return w.close()
+	}
+
+	var offset int64
+	if info, err := os.Stat(path); err == nil {
+		offset = info.Size()
+	}
+	var werr error
+	err = server.FollowAudit(ctx, path, offset, auditFollowInterval, func(e server.AuditEntry) {
+		if werr == nil && filter.Match(e) {
+			werr = w.write(e)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if werr != nil {
+		return werr
+	}
+	return w.close()
+}
+
+// auditWriter renders entries in one of the supported formats.
+type auditWriter struct {
+	format string
+	out    io.Writer
+	csv    *csv.Writer
+	stream bool // json: one object per line instead of an array
+	n      int
+}
+
+var auditCSVHeader = []string{
+	"ts", "remote", "peer", "hostname", "myip_claimed", "myip_verified",
+	"auth_scheme", "auth", "action", "route53_change_id", "error",
+}
+
+func newAuditWriter(format string, follow bool, out io.Writer) (*auditWriter, error) {
+	w := &auditWriter{format: format, out: out, stream: follow}
+	if format == "csv" {
+		w.csv = csv.NewWriter(out)
+		if err := w.csv.Write(auditCSVHeader); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+func (w *auditWriter) write(e server.AuditEntry) error {
+	defer func() { w.n++ }()
+	switch w.format {
+	case "json":
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		switch {
+		case w.stream:
+			_, err = fmt.Fprintf(w.out, "%s\n", data)
+		case w.n == 0:
+			_, err = fmt.Fprintf(w.out, "[\n  %s", data)
+		default:
+			_, err = fmt.Fprintf(w.out, ",\n  %s", data)
+		}
+		return err
+	case "csv":
+		if err := w.csv.Write([]string{
+			e.Timestamp.Format(time.RFC3339), e.RemoteAddr, e.Peer, e.Hostname,
+			e.MyIPClaimed, e.MyIPVerified, e.AuthScheme, e.AuthOutcome,
+			e.Action, e.Route53ChangeID, e.Err,
+		}); err != nil {
+			return err
+		}
+		w.csv.Flush() // keep --follow output live
+		return w.csv.Error()
+	default:
+		line := fmt.Sprintf("%s  %-21s  %-8s  %-12s  %s",
+			e.Timestamp.Format(time.RFC3339), e.RemoteAddr, dash(e.AuthOutcome), dash(e.Action), dash(e.Hostname))
+		if e.Peer != "" {
+			line += "  via " + e.Peer
+		}
+		if e.Err != "" {
+			line += "  error: " + e.Err
+		}
+		_, err := fmt.Fprintln(w.out, line)
+		return err
+	}
+}
+
+// close terminates a JSON array; other formats need nothing.
+func (w *auditWriter) close() error {
+	if w.format != "json" || w.stream {
+		return nil
+	}
+	if w.n == 0 {
+		_, err := fmt.Fprintln(w.out, "[]")
+		return err
+	}
+	_, err := fmt.Fprintln(w.out, "\n]")
+	return err
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// writeAuditSummary prints s as text, JSON, or CSV (one row per count).
+func writeAuditSummary(out io.Writer, format string, s server.AuditSummary) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	case "csv":
+		cw := csv.NewWriter(out)
+		_ = cw.Write([]string{"kind", "key", "count"})
+		for _, k := range sortedKeys(s.ByAction) {
+			_ = cw.Write([]string{"action", k, fmt.Sprint(s.ByAction[k])})
+		}
+		for _, k := range sortedKeys(s.ByAuth) {
+			_ = cw.Write([]string{"auth", k, fmt.Sprint(s.ByAuth[k])})
+		}
+		for _, sc := range s.BadAuthBySource {
+			_ = cw.Write([]string{"bad_auth_source", sc.Source, fmt.Sprint(sc.Count)})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+
+	fmt.Fprintf(out, "Entries:        %d\n", s.Total)
+	if s.Total > 0 {
+		fmt.Fprintf(out, "Span:           %s — %s\n", s.First.Format(time.RFC3339), s.Last.Format(time.RFC3339))
+	}
+	printCounts(out, "By action:", s.ByAction)
+	printCounts(out, "By auth:", s.ByAuth)
+	if len(s.BadAuthBySource) > 0 {
+		fmt.Fprintln(out, "Failed auth by source:")
+		for _, sc := range s.BadAuthBySource {
+			fmt.Fprintf(out, "  %-39s %d\n", sc.Source, sc.Count)
+		}
+	}
+	return nil
+}
+
+func printCounts(out io.Writer, title string, counts map[string]int) {
+	if len(counts) == 0 {
+		return
+	}
+	fmt.Fprintln(out, title)
+	for _, k := range sortedKeys(counts) {
+		fmt.Fprintf(out, "  %-16s %d\n", k, counts[k])
+	}
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/descoped/dddns/internal/server"
+)
+
+// writeAuditFixture writes a small audit log: one success and two
+// failed-auth attempts from the same source.
+func writeAuditFixture(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	lines := []string{
+		`{"ts":"2026-04-17T12:00:00Z","remote":"192.168.1.1:4000","hostname":"home.example.com","auth":"ok","action":"updated"}`,
+		`{"ts":"2026-04-17T12:05:00Z","remote":"10.0.0.7:5000","auth":"bad"}`,
+		`{"ts":"2026-04-17T12:06:00Z","remote":"10.0.0.7:5001","auth":"locked"}`,
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestServeAudit_TextFiltered(t *testing.T) {
+	path := writeAuditFixture(t)
+	var out, errOut bytes.Buffer
+	err := performServeAudit(context.Background(), path, server.AuditFilter{Remote: "10.0.0.0/8"}, "text", false, false, &out, &errOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(out.String(), "\n"); n != 2 {
+		t.Errorf("printed %d lines, want 2:\n%s", n, out.String())
+	}
+	if strings.Contains(out.String(), "192.168.1.1") {
+		t.Errorf("filtered-out entry printed:\n%s", out.String())
+	}
+}
+
+func TestServeAudit_JSONArray(t *testing.T) {
+	path := writeAuditFixture(t)
+	var out, errOut bytes.Buffer
+	if err := performServeAudit(context.Background(), path, server.AuditFilter{}, "json", false, false, &out, &errOut); err != nil {
+		t.Fatal(err)
+	}
+	var entries []server.AuditEntry
+	if err := json.Unmarshal(out.Bytes(), &entries); err != nil {
+		t.Fatalf("output is not a JSON array: %v\n%s", err, out.String())
+	}
+	if len(entries) != 3 {
+		t.Errorf("got %d entries, want 3", len(entries))
+	}
+
+	// No matches still yields valid JSON.
+	out.Reset()
+	_ = performServeAudit(context.Background(), path, server.AuditFilter{Action: "none"}, "json", false, false, &out, &errOut)
+	if strings.TrimSpace(out.String()) != "[]" {
+		t.Errorf("empty result = %q, want []", out.String())
+	}
+}
+
+func TestServeAudit_CSV(t *testing.T) {
+	path := writeAuditFixture(t)
+	var out, errOut bytes.Buffer
+	if err := performServeAudit(context.Background(), path, server.AuditFilter{Auth: "ok"}, "csv", false, false, &out, &errOut); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0][0] != "ts" || rows[1][1] != "192.168.1.1:4000" {
+		t.Errorf("rows = %v", rows)
+	}
+}
+
+func TestServeAudit_SummaryJSON(t *testing.T) {
+	path := writeAuditFixture(t)
+	var out, errOut bytes.Buffer
+	if err := performServeAudit(context.Background(), path, server.AuditFilter{}, "json", true, false, &out, &errOut); err != nil {
+		t.Fatal(err)
+	}
+	var s server.AuditSummary
+	if err := json.Unmarshal(out.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Total != 3 || len(s.BadAuthBySource) != 1 || s.BadAuthBySource[0] != (server.SourceCount{Source: "10.0.0.7", Count: 2}) {
+		t.Errorf("summary = %+v", s)
+	}
+}
+
+func TestServeAudit_UnknownFormat(t *testing.T) {
+	var out, errOut bytes.Buffer
+	err := performServeAudit(context.Background(), writeAuditFixture(t), server.AuditFilter{}, "xml", false, false, &out, &errOut)
+	if err == nil || !strings.Contains(err.Error(), "xml") {
+		t.Errorf("err = %v, want unknown format", err)
+	}
+}
+
+func TestParseAuditTime(t *testing.T) {
+	now := time.Date(2026, 4, 17, 12, 0, 0, 0, time.UTC)
+	if got, _ := parseAuditTime("", now); !got.IsZero() {
+		t.Errorf("empty = %v, want zero", got)
+	}
+	if got, _ := parseAuditTime("90m", now); !got.Equal(now.Add(-90 * time.Minute)) {
+		t.Errorf("90m = %v", got)
+	}
+	if got, _ := parseAuditTime("2026-04-01T00:00:00Z", now); !got.Equal(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("RFC 3339 = %v", got)
+	}
+	if _, err := parseAuditTime("yesterday", now); err == nil {
+		t.Error("expected error for unparseable value")
+	}
+}
+
+func TestServeAuditVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	a := server.NewAuditLog(path)
+	for i := 0; i < 3; i++ {
+		if err := a.Write(server.AuditEntry{Action: "updated"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out bytes.Buffer
+	if err := performServeAuditVerify(path, nil, "text", &out); err != nil {
+		t.Fatalf("intact chain: %v\n%s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "Sequence:       1 — 3") {
+		t.Errorf("output:\n%s", out.String())
+	}
+
+	data, _ := os.ReadFile(path)
+	lines := strings.SplitAfter(string(data), "\n")
+	_ = os.WriteFile(path, []byte(lines[0]+lines[2]), 0600)
+	out.Reset()
+	err := performServeAuditVerify(path, nil, "json", &out)
+	if err == nil {
+		t.Fatal("expected error for a deleted line")
+	}
+	var r server.AuditVerifyReport
+	if err := json.Unmarshal(out.Bytes(), &r); err != nil || len(r.Problems) != 1 || r.Problems[0].Line != 2 {
+		t.Errorf("report = %+v (%v)", r, err)
+	}
+}
@@ -3,6 +3,8 @@ package cmd
 import (
 	"bytes"
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
@@ -126,3 +128,62 @@ func TestServeStatus_RejectsMalformedJSON(t *testing.T) {
 		t.Errorf("error should cite parse/status failure, got: %v", err)
 	}
 }
+
+// TestServeStatusProbe_AllOK drives performProbe against a stub that
+// answers both endpoints healthy.
+func TestServeStatusProbe_AllOK(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(server.ProbeReport{
+			Status: server.ProbeOK,
+			Checks: []server.ProbeResult{{Name: "wan_ip", Status: server.ProbeOK, Detail: "203.0.113.42"}},
+		})
+	}))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	if err := performProbe(ts.URL, &buf); err != nil {
+		t.Fatalf("performProbe: %v", err)
+	}
+	for _, want := range []string{"/healthz: ok (HTTP 200)", "/readyz: ok (HTTP 200)", "wan_ip", "203.0.113.42"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+// TestServeStatusProbe_NotReady verifies a 503 readiness report turns
+// into a non-nil error naming the failing endpoint, while the detail
+// still reaches stdout for the operator.
+func TestServeStatusProbe_NotReady(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := server.ProbeReport{Status: server.ProbeOK}
+		if r.URL.Path == "/readyz" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			report = server.ProbeReport{
+				Status: server.ProbeFail,
+				Checks: []server.ProbeResult{{Name: "route53", Status: server.ProbeFail, Detail: "AccessDenied"}},
+			}
+		}
+		_ = json.NewEncoder(w).Encode(report)
+	}))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	err := performProbe(ts.URL, &buf)
+	if err == nil || !strings.Contains(err.Error(), "/readyz") {
+		t.Errorf("err = %v, want failure naming /readyz", err)
+	}
+	if !strings.Contains(buf.String(), "AccessDenied") {
+		t.Errorf("failing check detail not printed:\n%s", buf.String())
+	}
+}
+
+func TestServeStatusProbe_Unreachable(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	url := ts.URL
+	ts.Close()
+
+	if err := performProbe(url, &bytes.Buffer{}); err == nil {
+		t.Error("expected error when the listener is down")
+	}
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/descoped/dddns/internal/config"
 	"github.com/descoped/dddns/internal/constants"
+	"github.com/descoped/dddns/internal/reqsign"
 	"github.com/spf13/cobra"
 )
 
@@ -29,7 +30,7 @@ func TestServeTest_Good(t *testing.T) {
 	defer ts.Close()
 
 	var buf bytes.Buffer
-	if err := performServeTest(ts.URL, "test.example.com", "secret", "1.2.3.4", &buf); err != nil {
+	if err := performServeTest(ts.URL, "test.example.com", "secret", "1.2.3.4", false, &buf); err != nil {
 		t.Errorf("expected nil, got %v", err)
 	}
 	if !strings.Contains(buf.String(), "HTTP 200") || !strings.Contains(buf.String(), "good 1.2.3.4") {
@@ -42,17 +43,39 @@ func TestServeTest_NoChange(t *testing.T) {
 	defer ts.Close()
 
 	var buf bytes.Buffer
-	if err := performServeTest(ts.URL, "test.example.com", "secret", "1.2.3.4", &buf); err != nil {
+	if err := performServeTest(ts.URL, "test.example.com", "secret", "1.2.3.4", false, &buf); err != nil {
 		t.Errorf("expected nil for nochg, got %v", err)
 	}
 }
 
+// TestServeTest_SignedVerifies checks --sign produces a signature the
+// server-side verifier accepts, and no Basic credentials.
+func TestServeTest_SignedVerifies(t *testing.T) {
+	v := reqsign.NewVerifier(0, 0)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := r.BasicAuth(); ok {
+			t.Error("signed request also carried Basic Auth")
+		}
+		if err := v.Verify(r.Header.Get("Authorization"), "secret", r.Method, r.URL.Path, r.URL.Query()); err != nil {
+			_, _ = w.Write([]byte("badauth\n"))
+			return
+		}
+		_, _ = w.Write([]byte("good 1.2.3.4\n"))
+	}))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	if err := performServeTest(ts.URL, "test.example.com", "secret", "1.2.3.4", true, &buf); err != nil {
+		t.Errorf("expected nil, got %v\n%s", err, buf.String())
+	}
+}
+
 func TestServeTest_BadAuth(t *testing.T) {
 	ts := newStubServer(t, http.StatusOK, "badauth\n")
 	defer ts.Close()
 
 	var buf bytes.Buffer
-	err := performServeTest(ts.URL, "test.example.com", "wrong", "1.2.3.4", &buf)
+	err := performServeTest(ts.URL, "test.example.com", "wrong", "1.2.3.4", false, &buf)
 	if err == nil {
 		t.Error("expected error for badauth body")
 	}
@@ -63,7 +86,7 @@ func TestServeTest_CIDRDeny403(t *testing.T) {
 	defer ts.Close()
 
 	var buf bytes.Buffer
-	err := performServeTest(ts.URL, "test.example.com", "secret", "1.2.3.4", &buf)
+	err := performServeTest(ts.URL, "test.example.com", "secret", "1.2.3.4", false, &buf)
 	if err == nil {
 		t.Error("expected error for 403 response")
 	}
@@ -74,7 +97,7 @@ func TestServeTest_Dnserr(t *testing.T) {
 	defer ts.Close()
 
 	var buf bytes.Buffer
-	err := performServeTest(ts.URL, "test.example.com", "secret", "1.2.3.4", &buf)
+	err := performServeTest(ts.URL, "test.example.com", "secret", "1.2.3.4", false, &buf)
 	if err == nil {
 		t.Error("expected error for dnserr body")
 	}
@@ -84,7 +107,7 @@ func TestServeTest_NetworkError(t *testing.T) {
 	// Point at an address nothing is listening on (port 1 is privileged
 	// and unlikely to be bound by userland).
 	var buf bytes.Buffer
-	err := performServeTest("http://127.0.0.1:1", "test.example.com", "secret", "1.2.3.4", &buf)
+	err := performServeTest("http://127.0.0.1:1", "test.example.com", "secret", "1.2.3.4", false, &buf)
 	if err == nil {
 		t.Error("expected error for unreachable target")
 	}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/descoped/dddns/internal/config"
+	"github.com/descoped/dddns/internal/updater"
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the last known IP and any update failure streak",
+	Long: `Print the hostname, the last IP written to the cache and when, and
+the current run of consecutive failed updates: how many, since when,
+and the class of the last error. Reads <data-dir>/update-failures.json,
+which a successful update removes.
+
+Exits non-zero while an escalated failure streak is open (see the
+escalation block in the configuration), so it can drive a monitoring
+check.`,
+	RunE: runStatus,
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+}
+
+func runStatus(cmd *cobra.Command, _ []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	return performStatus(cmd.OutOrStdout(), cfg)
+}
+
+// performStatus prints cfg's cache and failure state to out. It returns
+// an error when the failure streak has escalated.
+func performStatus(out io.Writer, cfg *config.Config) error {
+	st, err := updater.ReadFailureState(updater.FailureStatePath(cfg))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Hostname:           %s\n", cfg.Hostname)
+	switch ip, updated := updater.ReadCache(cfg.IPCacheFile); {
+	case ip == "":
+		fmt.Fprintln(out, "Last known IP:      none cached")
+	case updated.IsZero():
+		fmt.Fprintf(out, "Last known IP:      %s\n", ip)
+	default:
+		fmt.Fprintf(out, "Last known IP:      %s (written %s)\n", ip, updated.Format(time.RFC3339))
+	}
+	formatFailureState(out, st)
+	if st.Escalated {
+		return fmt.Errorf("update failing: %d consecutive failures since %s", st.Failures, st.FirstFailure.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// formatFailureState renders the update failure streak st. Shared by
+// status and verify.
+func formatFailureState(w io.Writer, st updater.FailureState) {
+	if st.Failures == 0 {
+		fmt.Fprintln(w, "Update failures:    none")
+		return
+	}
+	fmt.Fprintf(w, "Update failures:    %d consecutive since %s", st.Failures, st.FirstFailure.Format(time.RFC3339))
+	if st.Escalated {
+		fmt.Fprint(w, " (escalated)")
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Last failure:       %s\n", st.LastFailure.Format(time.RFC3339))
+	fmt.Fprintf(w, "Error class:        %s\n", st.ErrorClass)
+	fmt.Fprintf(w, "Last error:         %s\n", st.LastError)
+}
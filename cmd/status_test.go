@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/descoped/dddns/internal/config"
+	"github.com/descoped/dddns/internal/updater"
+)
+
+// TestStatus_NoFailures covers the healthy shape: the cached IP and its
+// timestamp, no failures, exit 0.
+func TestStatus_NoFailures(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{Hostname: "home.example.com", IPCacheFile: filepath.Join(dir, "last-ip.txt")}
+	cache := "last_known_ip: 203.0.113.10\nlast_updated: 2026-10-01T12:00:00Z\n"
+	if err := os.WriteFile(cfg.IPCacheFile, []byte(cache), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := performStatus(&out, cfg); err != nil {
+		t.Fatalf("performStatus: %v", err)
+	}
+	mustContainAll(t, out.String(), []string{
+		"Hostname:           home.example.com",
+		"Last known IP:      203.0.113.10 (written 2026-10-01T12:00:00Z)",
+		"Update failures:    none",
+	})
+}
+
+// TestStatus_EscalatedStreak verifies an escalated streak is printed in
+// full and turns into a non-zero exit, while one below the thresholds
+// is shown but exits 0.
+func TestStatus_EscalatedStreak(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{Hostname: "home.example.com", IPCacheFile: filepath.Join(dir, "last-ip.txt")}
+	first := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	st := updater.FailureState{
+		Failures:     5,
+		FirstFailure: first,
+		LastFailure:  first.Add(2 * time.Hour),
+		ErrorClass:   updater.ClassAuth,
+		LastError:    "failed to update Route53: route53 error (HTTP 403): AccessDenied: nope",
+	}
+	write := func(st updater.FailureState) {
+		data, _ := json.Marshal(st)
+		if err := os.WriteFile(updater.FailureStatePath(cfg), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(st)
+	var out bytes.Buffer
+	if err := performStatus(&out, cfg); err != nil {
+		t.Fatalf("streak below threshold failed status: %v", err)
+	}
+	mustContainAll(t, out.String(), []string{
+		"Last known IP:      none cached",
+		"Update failures:    5 consecutive since 2026-10-01T08:00:00Z\n",
+		"Last failure:       2026-10-01T10:00:00Z",
+		"Error class:        auth",
+		"Last error:         failed to update Route53",
+	})
+
+	st.Escalated = true
+	write(st)
+	out.Reset()
+	err := performStatus(&out, cfg)
+	if err == nil || !strings.Contains(err.Error(), "5 consecutive failures") {
+		t.Errorf("err = %v, want the escalated streak", err)
+	}
+	if !strings.Contains(out.String(), "(escalated)") {
+		t.Errorf("output does not mark the streak escalated:\n%s", out.String())
+	}
+}
@@ -2,14 +2,17 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os/signal"
 	"syscall"
-	"time"
 
 	"github.com/descoped/dddns/internal/commands/myip"
 	"github.com/descoped/dddns/internal/config"
+	"github.com/descoped/dddns/internal/logging"
+	"github.com/descoped/dddns/internal/mqtt"
+	"github.com/descoped/dddns/internal/notify"
 	"github.com/descoped/dddns/internal/updater"
 	"github.com/spf13/cobra"
 )
@@ -37,8 +40,8 @@ func init() {
 	updateCmd.Flags().BoolVarP(&forceUpdate, "force", "f", false, "Force update even if IP hasn't changed")
 	updateCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be done without making changes")
 	updateCmd.Flags().StringVar(&customIP, "ip", "", "Use specific IP address instead of auto-detecting")
-	updateCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Suppress non-error output (for cron)")
-	updateCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Emit per-step diagnostic output (overrides --quiet)")
+	updateCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Log only updates, warnings and errors (for cron)")
+	updateCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Log per-step diagnostics at debug level (overrides --quiet)")
 }
 
 // runUpdate wires the cobra command to the updater package. It builds a
@@ -52,6 +55,23 @@ func runUpdate(_ *cobra.Command, _ []string) error {
 	if err := cfg.Validate(); err != nil {
 		return fmt.Errorf("invalid configuration: %w", err)
 	}
+	// --verbose overrides --quiet so operators can flip on diagnostic output
+	// for a one-off cron investigation without editing the bootscript.
+	logger, restoreLog, err := setupLogging(cfg, logging.Level(quiet, verbose))
+	if err != nil {
+		return err
+	}
+	defer restoreLog()
+
+	notifier, err := notify.New(cfg.Notify, logger)
+	if err != nil {
+		return err
+	}
+	notifier.SetStatePath(notify.EmailStatePath(cfg))
+	publisher, err := mqtt.NewPublisher(cfg.MQTT, cfg.Hostname, logger)
+	if err != nil {
+		return err
+	}
 
 	// Cancel on SIGINT/SIGTERM; bound total runtime to cfg.UpdateTimeout
 	// (defaults to 30 s — raise in config for slow networks).
@@ -60,18 +80,14 @@ func runUpdate(_ *cobra.Command, _ []string) error {
 	ctx, cancel := context.WithTimeout(ctx, cfg.UpdateTimeoutOrDefault())
 	defer cancel()
 
-	// --verbose overrides --quiet so operators can flip on diagnostic output
-	// for a one-off cron investigation without editing the bootscript.
-	effectiveQuiet := quiet && !verbose
-	if !effectiveQuiet {
-		log.Printf("[%s] Checking for IP changes...", time.Now().Format("2006-01-02 15:04:05"))
-	}
+	logger.Info("checking for IP changes", logging.KeyHostname, cfg.Hostname)
 
 	opts := updater.Options{
-		Force:   forceUpdate,
-		DryRun:  dryRun,
-		Quiet:   effectiveQuiet,
-		Verbose: verbose,
+		Force:    forceUpdate,
+		DryRun:   dryRun,
+		Logger:   logger,
+		Notifier: notifier,
+		MQTT:     publisher,
 	}
 	if customIP != "" {
 		if err := myip.ValidatePublicIP(customIP); err != nil {
@@ -81,5 +97,14 @@ func runUpdate(_ *cobra.Command, _ []string) error {
 	}
 
 	_, err = updater.Update(ctx, cfg, opts)
+	// Below the escalation thresholds a failure is recorded and logged,
+	// but cron sees success.
+	var failure *updater.FailureError
+	if errors.As(err, &failure) && !failure.Escalated {
+		logger.Warn("update failed below escalation threshold",
+			slog.Int("consecutive_failures", failure.State.Failures),
+			logging.Err(err))
+		return nil
+	}
 	return err
 }
@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"github.com/descoped/dddns/internal/config"
+	"github.com/descoped/dddns/internal/logging"
+	"github.com/descoped/dddns/internal/updater"
 	"github.com/descoped/dddns/internal/verify"
 	"github.com/spf13/cobra"
 )
@@ -19,9 +21,13 @@ var verifyCmd = &cobra.Command{
 	RunE:  runVerify,
 }
 
+var verifyVerbose bool
+
 // init registers the verify command.
 func init() {
 	rootCmd.AddCommand(verifyCmd)
+
+	verifyCmd.Flags().BoolVarP(&verifyVerbose, "verbose", "v", false, "Log each lookup's result and timing at debug level")
 }
 
 // runVerify performs DNS verification by delegating to verify.Run and
@@ -38,15 +44,30 @@ func runVerify(_ *cobra.Command, _ []string) error {
 		return fmt.Errorf("invalid configuration: %w", err)
 	}
 
+	logger, restoreLog, err := setupLogging(cfg, logging.Level(false, verifyVerbose))
+	if err != nil {
+		return err
+	}
+	defer restoreLog()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	report, err := verify.Run(ctx, cfg)
+	report, err := verify.Run(ctx, cfg, logger)
 	if err != nil {
 		return err
 	}
 
 	formatVerifyReport(os.Stdout, report, cfg.TTL)
+
+	// The updater's view: a record that matches now may still have
+	// failed to update for a while.
+	st, err := updater.ReadFailureState(updater.FailureStatePath(cfg))
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	formatFailureState(os.Stdout, st)
 	return nil
 }
 
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/descoped/dddns/internal/dns"
+)
+
+// awsConn is what the hand-rolled AWS JSON-protocol clients (SSM,
+// DynamoDB, Secrets Manager) share: the exec role's credentials and
+// the transport.
+type awsConn struct {
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+
+	httpClient *http.Client
+	now        func() time.Time
+	endpoint   string // blank = derive from region
+}
+
+// awsAPI describes one JSON-protocol service.
+type awsAPI struct {
+	service     string // SigV4 service name and endpoint host prefix
+	target      string // X-Amz-Target prefix, e.g. "AmazonSSM."
+	contentType string // application/x-amz-json-1.0 or -1.1
+}
+
+// awsError is a non-200 response. Type is the unqualified exception
+// name from the body's __type, e.g. "ResourceNotFoundException".
+type awsError struct {
+	Action string
+	Status string
+	Type   string
+	Body   string
+}
+
+func (e *awsError) Error() string { return fmt.Sprintf("%s %s: %s", e.Action, e.Status, e.Body) }
+
+// do performs one SigV4-signed request for action and decodes the
+// response into out.
+func (c *awsConn) do(ctx context.Context, api awsAPI, action string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("marshal %s request: %w", action, err)
+	}
+
+	endpoint := c.endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.%s.amazonaws.com/", api.service, c.region)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build %s request: %w", action, err)
+	}
+	req.Header.Set("Content-Type", api.contentType)
+	req.Header.Set("X-Amz-Target", api.target+action)
+	req.ContentLength = int64(len(body))
+
+	// Compute payload hash for SigV4 and sign via the shared signer
+	// (same signer the Route53 client uses; just a different service
+	// name + region here).
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	dns.SignRequest(req, c.accessKey, c.secretKey, c.sessionToken, c.region, api.service, payloadHash, c.now())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s HTTP: %w", action, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	// A single parameter, item or secret is <1 KB and a page of
+	// GetParametersByPath at most a few tens of KB. Cap at 1 MB as
+	// belt-and-braces against a compromised / misrouted endpoint
+	// streaming a giant body into a memory-constrained Lambda.
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("read %s body: %w", action, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		e := &awsError{Action: action, Status: resp.Status, Body: string(raw)}
+		var typed struct {
+			Type string `json:"__type"`
+		}
+		if json.Unmarshal(raw, &typed) == nil {
+			e.Type = typed.Type[strings.LastIndex(typed.Type, "#")+1:]
+		}
+		return e
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return fmt.Errorf("decode %s response: %w", action, err)
+	}
+	return nil
+}
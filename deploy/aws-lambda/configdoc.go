@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	dddnsconfig "github.com/descoped/dddns/internal/config"
+	"go.yaml.in/yaml/v3"
+)
+
+// A deployment can take its settings from a dddns config document —
+// the config.yaml format the cron and serve modes read on the device,
+// checked by the same Config.ValidateWith — instead of HOSTED_ZONE_ID,
+// DDDNS_HOSTNAME and DDDNS_TTL. The document is kept encrypted: in an
+// SSM SecureString named by DDDNS_CONFIG_PARAM, or as a KMS ciphertext
+// in DDDNS_CONFIG_KMS (base64, as `aws kms encrypt` prints it), which
+// the Lambda decrypts with a hand-signed KMS Decrypt at cold start.
+//
+// From the document the Lambda uses:
+//
+//   - hosted_zone_id and hostname, for a single-host deployment;
+//   - ttl, the record TTL, or with a routing table the TTL of routes
+//     that set none;
+//   - aws_access_key and aws_secret_key, when set, for the Route53
+//     calls instead of the exec role (the other AWS calls stay on the
+//     role); when both are absent the role signs them;
+//   - server.shared_secret, when set, as the dyndns secret of a
+//     single-host deployment instead of SSM_SECRET_PARAM, and
+//     server.require_signature.
+//
+// With DDDNS_ROUTES_PARAM the routing table names the hostnames, zones
+// and secrets, so a document that sets hosted_zone_id, hostname or
+// server.shared_secret is refused. Anything else in the document is
+// validated, and the keys the Lambda does not use are logged at cold
+// start. An encrypted config.secure cannot be used: its vaults are
+// bound to the device's key.
+
+// configSecretRef is the secret reference of a shared secret taken from
+// the config document; fetchSecret answers it from cfg.sharedSecret.
+const configSecretRef = "dddns-config:server.shared_secret"
+
+// kmsClient is a minimal AWS KMS client: Decrypt only, over the AWS
+// JSON 1.1 protocol like ssmClient.
+type kmsClient struct {
+	awsConn
+}
+
+// decryptRequest / decryptResponse mirror the AWS JSON 1.1 shape of
+// Decrypt. []byte fields travel base64-encoded, as encoding/json
+// encodes them. Symmetric ciphertext names its key, so no KeyId.
+type decryptRequest struct {
+	CiphertextBlob []byte `json:"CiphertextBlob"`
+}
+
+type decryptResponse struct {
+	Plaintext []byte `json:"Plaintext"`
+}
+
+var kmsAPI = awsAPI{service: "kms", target: "TrentService.", contentType: "application/x-amz-json-1.1"}
+
+// decrypt returns the plaintext of a symmetric KMS ciphertext.
+func (c *kmsClient) decrypt(ctx context.Context, blob []byte) ([]byte, error) {
+	var out decryptResponse
+	if err := c.do(ctx, kmsAPI, "Decrypt", decryptRequest{CiphertextBlob: blob}, &out); err != nil {
+		return nil, err
+	}
+	if len(out.Plaintext) == 0 {
+		return nil, errors.New("kms decrypt: empty plaintext")
+	}
+	return out.Plaintext, nil
+}
+
+// loadConfigDocument fetches the config document cfg names, if any,
+// and applies it to cfg.
+func loadConfigDocument(ctx context.Context, cfg *config, ssm *ssmClient, kms *kmsClient, logger *slog.Logger) error {
+	var doc []byte
+	switch {
+	case cfg.configParam != "":
+		v, err := ssm.getParameter(ctx, cfg.configParam)
+		if err != nil {
+			return fmt.Errorf("DDDNS_CONFIG_PARAM: %w", err)
+		}
+		doc = []byte(v)
+	case cfg.configKMS != "":
+		blob, err := base64.StdEncoding.DecodeString(strings.TrimSpace(cfg.configKMS))
+		if err != nil {
+			return fmt.Errorf("DDDNS_CONFIG_KMS is not base64: %w", err)
+		}
+		if doc, err = kms.decrypt(ctx, blob); err != nil {
+			return fmt.Errorf("DDDNS_CONFIG_KMS: %w", err)
+		}
+	default:
+		return nil
+	}
+	return applyConfigDocument(cfg, doc, logger)
+}
+
+// documentKeys are the keys of the config document the Lambda uses;
+// the keys under server are prefixed "server.".
+var documentKeys = map[string]bool{
+	"aws_access_key":           true,
+	"aws_secret_key":           true,
+	"hosted_zone_id":           true,
+	"hostname":                 true,
+	"ttl":                      true,
+	"server":                   true,
+	"server.shared_secret":     true,
+	"server.require_signature": true,
+}
+
+// applyConfigDocument parses and validates doc and takes the
+// deployment's settings from it.
+func applyConfigDocument(cfg *config, doc []byte, logger *slog.Logger) error {
+	dc, err := dddnsconfig.Parse(doc)
+	if err != nil {
+		return fmt.Errorf("dddns config: %w", err)
+	}
+	routed := cfg.routesParam != ""
+	if routed && (dc.HostedZoneID != "" || dc.Hostname != "" || (dc.Server != nil && dc.Server.SharedSecret != "")) {
+		return errors.New("dddns config: with DDDNS_ROUTES_PARAM the routing table names hostnames, zones and secrets; remove hosted_zone_id, hostname and server.shared_secret")
+	}
+	if err := dc.ValidateWith(dddnsconfig.ValidateOptions{AmbientCredentials: true, NoHost: routed}); err != nil {
+		return fmt.Errorf("dddns config: %w", err)
+	}
+	if ignored := ignoredKeys(doc); len(ignored) > 0 {
+		logger.Warn("dddns config: keys not used by the Lambda", slog.Any("keys", ignored))
+	}
+
+	cfg.route53AccessKey, cfg.route53SecretKey = dc.AWSAccessKey, dc.AWSSecretKey
+	cfg.ttl = dc.TTL
+	if !routed {
+		cfg.hostedZoneID = dc.HostedZoneID
+		cfg.hostname = dc.Hostname
+	}
+	if dc.Server != nil {
+		if dc.Server.SharedSecret != "" {
+			cfg.sharedSecret = dc.Server.SharedSecret
+			cfg.ssmSecretParam = configSecretRef
+		}
+		cfg.requireSignature = cfg.requireSignature || dc.Server.RequireSignature
+	}
+	if !routed && cfg.ssmSecretParam == "" {
+		return errors.New("dddns config: set server.shared_secret, or SSM_SECRET_PARAM")
+	}
+	return nil
+}
+
+// ignoredKeys lists, sorted, the keys of doc outside documentKeys. doc
+// has already been parsed, so it is a mapping.
+func ignoredKeys(doc []byte) []string {
+	var top map[string]any
+	if err := yaml.Unmarshal(doc, &top); err != nil {
+		return nil
+	}
+	var ignored []string
+	for k, v := range top {
+		if !documentKeys[k] {
+			ignored = append(ignored, k)
+			continue
+		}
+		if sub, ok := v.(map[string]any); ok {
+			for sk := range sub {
+				if !documentKeys[k+"."+sk] {
+					ignored = append(ignored, k+"."+sk)
+				}
+			}
+		}
+	}
+	slices.Sort(ignored)
+	return ignored
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/descoped/dddns/internal/logging"
+)
+
+const testConfigDoc = `hosted_zone_id: Z9CONFIGZONE
+hostname: cfg.example.com
+ttl: 120
+server:
+  shared_secret: from-the-document
+  require_signature: true
+`
+
+// fakeKMS decrypts a "ciphertext" that is the plaintext reversed.
+func fakeKMS(t *testing.T) *kmsClient {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Amz-Target"); got != "TrentService.Decrypt" {
+			t.Errorf("X-Amz-Target = %q", got)
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") || !strings.Contains(r.Header.Get("Authorization"), "/kms/aws4_request") {
+			t.Errorf("Authorization = %q, want a SigV4 signature for kms", r.Header.Get("Authorization"))
+		}
+		var in decryptRequest
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil || len(in.CiphertextBlob) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"__type":"InvalidCiphertextException"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(decryptResponse{Plaintext: reversed(in.CiphertextBlob)})
+	}))
+	t.Cleanup(srv.Close)
+	return &kmsClient{awsConn: awsConn{
+		region:       "us-east-1",
+		accessKey:    "AKIATEST",
+		secretKey:    "SECRETTEST",
+		sessionToken: "SESSIONTOKENTEST",
+		httpClient:   srv.Client(),
+		endpoint:     srv.URL,
+		now:          time.Now,
+	}}
+}
+
+func reversed(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i, c := range b {
+		out[len(b)-1-i] = c
+	}
+	return out
+}
+
+// TestLoadConfigDocument_KMS verifies a KMS-encrypted document is
+// decrypted and replaces the env-configured host, and that its shared
+// secret authenticates a push.
+func TestLoadConfigDocument_KMS(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+	h.cfg.configKMS = base64.StdEncoding.EncodeToString(reversed([]byte(testConfigDoc)))
+	if err := loadConfigDocument(context.Background(), h.cfg, h.ssm, fakeKMS(t), logging.Discard()); err != nil {
+		t.Fatal(err)
+	}
+	if h.cfg.hostedZoneID != "Z9CONFIGZONE" || h.cfg.hostname != "cfg.example.com" || h.cfg.ttl != 120 {
+		t.Errorf("cfg = %q %q %d", h.cfg.hostedZoneID, h.cfg.hostname, h.cfg.ttl)
+	}
+	if !h.cfg.requireSignature {
+		t.Error("server.require_signature not applied")
+	}
+
+	h.cfg.requireSignature = false
+	resp, _ := h.handle(context.Background(), mkRequest(basicAuth("dddns", "from-the-document"), "cfg.example.com", testSourceIP))
+	if strings.TrimSpace(resp.Body) != "good "+testSourceIP {
+		t.Errorf("push with the document's secret = %q", resp.Body)
+	}
+}
+
+// TestLoadConfigDocument_SSM verifies a document in an SSM SecureString
+// keeps SSM_SECRET_PARAM when it has no shared secret.
+func TestLoadConfigDocument_SSM(t *testing.T) {
+	ssm := &localSSM{}
+	ssm.put("/dddns/config", "hosted_zone_id: Z9CONFIGZONE\nhostname: cfg.example.com\n")
+	h, _ := newTestHandler(t, ssm.ServeHTTP)
+	h.cfg.configParam = "/dddns/config"
+	if err := loadConfigDocument(context.Background(), h.cfg, h.ssm, nil, logging.Discard()); err != nil {
+		t.Fatal(err)
+	}
+	if h.cfg.hostname != "cfg.example.com" || h.cfg.ttl != 300 || h.cfg.ssmSecretParam != "/dddns/test/shared_secret" {
+		t.Errorf("cfg = %q ttl %d secret %q", h.cfg.hostname, h.cfg.ttl, h.cfg.ssmSecretParam)
+	}
+	if h.cfg.route53AccessKey != "" {
+		t.Errorf("Route53 credentials %q set without any in the document", h.cfg.route53AccessKey)
+	}
+}
+
+func TestApplyConfigDocument(t *testing.T) {
+	cases := []struct {
+		name, doc, ssmParam, wantErr string
+	}{
+		{"fails Validate", "hosted_zone_id: Z9CONFIGZONE\n", "/p", "hostname is required"},
+		{"bad ttl", "hosted_zone_id: Z9\nhostname: a.example.com\nttl: -1\n", "/p", "ttl must be positive"},
+		{"not yaml", "hostname: [", "/p", "parse config"},
+		{"no secret", "hosted_zone_id: Z9\nhostname: a.example.com\n", "", "server.shared_secret"},
+		{"one of the key pair", "aws_access_key: AKIAOWN\nhosted_zone_id: Z9\nhostname: a.example.com\n", "/p", "aws_secret_key is required"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config{accessKey: "AKIAROLE", secretKey: "role-secret", ssmSecretParam: tc.ssmParam}
+			err := applyConfigDocument(cfg, []byte(tc.doc), logging.Discard())
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("err = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+
+	cfg := &config{accessKey: "AKIAROLE", secretKey: "role-secret", ssmSecretParam: "/p"}
+	doc := "aws_access_key: AKIAOWN\naws_secret_key: own-secret\nhosted_zone_id: Z9\nhostname: a.example.com\n"
+	if err := applyConfigDocument(cfg, []byte(doc), logging.Discard()); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.route53AccessKey != "AKIAOWN" || cfg.route53SecretKey != "own-secret" || cfg.accessKey != "AKIAROLE" {
+		t.Errorf("credentials: route53 %q/%q, role %q", cfg.route53AccessKey, cfg.route53SecretKey, cfg.accessKey)
+	}
+}
+
+// TestApplyConfigDocument_Routed verifies a document alongside a
+// routing table supplies the deployment-wide settings, and is refused
+// when it names a host the table should.
+func TestApplyConfigDocument_Routed(t *testing.T) {
+	cfg := &config{accessKey: "AKIAROLE", secretKey: "role-secret", routesParam: "/dddns/routes"}
+	doc := "aws_access_key: AKIAOWN\naws_secret_key: own-secret\nttl: 60\nserver:\n  require_signature: true\n"
+	if err := applyConfigDocument(cfg, []byte(doc), logging.Discard()); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.route53AccessKey != "AKIAOWN" || cfg.ttl != 60 || !cfg.requireSignature || cfg.hostname != "" {
+		t.Errorf("cfg = route53 %q ttl %d signature %v hostname %q", cfg.route53AccessKey, cfg.ttl, cfg.requireSignature, cfg.hostname)
+	}
+
+	for _, doc := range []string{
+		"hostname: a.example.com\n",
+		"hosted_zone_id: Z9\n",
+		"server:\n  shared_secret: s\n",
+	} {
+		cfg := &config{routesParam: "/dddns/routes"}
+		if err := applyConfigDocument(cfg, []byte(doc), logging.Discard()); err == nil || !strings.Contains(err.Error(), "DDDNS_ROUTES_PARAM") {
+			t.Errorf("%q: err = %v, want a DDDNS_ROUTES_PARAM conflict", doc, err)
+		}
+	}
+}
+
+func TestIgnoredKeys(t *testing.T) {
+	doc := testConfigDoc + "  bind: \":53353\"\nip_source: local\nhooks:\n  pre_update: [\"true\"]\n"
+	got := ignoredKeys([]byte(doc))
+	want := []string{"hooks", "ip_source", "server.bind"}
+	if !slices.Equal(got, want) {
+		t.Errorf("ignoredKeys = %v, want %v", got, want)
+	}
+}
+
+func TestKMSDecrypt_Error(t *testing.T) {
+	_, err := fakeKMS(t).decrypt(context.Background(), nil)
+	var ae *awsError
+	if err == nil || !errors.As(err, &ae) || ae.Type != "InvalidCiphertextException" {
+		t.Errorf("err = %v, want InvalidCiphertextException", err)
+	}
+}
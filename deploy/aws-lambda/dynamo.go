@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+)
+
+// dynamoClient is a minimal DynamoDB client for one table — the four
+// item operations the update store needs, signed with the same
+// hand-rolled SigV4 signer as ssmClient.
+//
+// The wire protocol is AWS JSON 1.0 over POST:
+//
+//	POST / HTTP/1.1
+//	Host: dynamodb.<region>.amazonaws.com
+//	X-Amz-Target: DynamoDB_20120810.GetItem
+//	Content-Type: application/x-amz-json-1.0
+//	{"TableName": "...", "Key": {"pk": {"S": "..."}, ...}}
+type dynamoClient struct {
+	awsConn
+	table string
+}
+
+// item is a DynamoDB item in wire form: attribute name → typed value,
+// e.g. {"ip": {"S": "203.0.113.7"}}. Only the S and N types are used.
+type item map[string]map[string]string
+
+func str(v string) map[string]string { return map[string]string{"S": v} }
+
+func num(v int64) map[string]string { return map[string]string{"N": strconv.FormatInt(v, 10)} }
+
+// str returns the string attribute name, "" when absent.
+func (it item) str(name string) string { return it[name]["S"] }
+
+// errConditionFailed is returned when a write's ConditionExpression
+// does not hold.
+var errConditionFailed = errors.New("conditional check failed")
+
+type getItemRequest struct {
+	TableName      string `json:"TableName"`
+	Key            item   `json:"Key"`
+	ConsistentRead bool   `json:"ConsistentRead"`
+}
+
+type putItemRequest struct {
+	TableName string `json:"TableName"`
+	Item      item   `json:"Item"`
+}
+
+// writeRequest carries the condition and update shared by UpdateItem
+// and DeleteItem; the expression attribute names keep attribute names
+// clear of DynamoDB's reserved words.
+type writeRequest struct {
+	TableName                 string            `json:"TableName"`
+	Key                       item              `json:"Key"`
+	UpdateExpression          string            `json:"UpdateExpression,omitempty"`
+	ConditionExpression       string            `json:"ConditionExpression,omitempty"`
+	ExpressionAttributeNames  map[string]string `json:"ExpressionAttributeNames,omitempty"`
+	ExpressionAttributeValues item              `json:"ExpressionAttributeValues,omitempty"`
+	ReturnValues              string            `json:"ReturnValues,omitempty"`
+}
+
+// getItem reads key with a strongly consistent read; nil when absent.
+func (c *dynamoClient) getItem(ctx context.Context, key item) (item, error) {
+	var out struct {
+		Item item `json:"Item"`
+	}
+	if err := c.call(ctx, "GetItem", getItemRequest{TableName: c.table, Key: key, ConsistentRead: true}, &out); err != nil {
+		return nil, err
+	}
+	return out.Item, nil
+}
+
+// putItem writes it unconditionally.
+func (c *dynamoClient) putItem(ctx context.Context, it item) error {
+	return c.call(ctx, "PutItem", putItemRequest{TableName: c.table, Item: it}, &struct{}{})
+}
+
+// updateItem applies in and returns the attributes in.ReturnValues
+// selects.
+func (c *dynamoClient) updateItem(ctx context.Context, in writeRequest) (item, error) {
+	in.TableName = c.table
+	var out struct {
+		Attributes item `json:"Attributes"`
+	}
+	if err := c.call(ctx, "UpdateItem", in, &out); err != nil {
+		return nil, err
+	}
+	return out.Attributes, nil
+}
+
+// deleteItem deletes in.Key if in.ConditionExpression holds.
+func (c *dynamoClient) deleteItem(ctx context.Context, in writeRequest) error {
+	in.TableName = c.table
+	return c.call(ctx, "DeleteItem", in, &struct{}{})
+}
+
+var dynamoAPI = awsAPI{service: "dynamodb", target: "DynamoDB_20120810.", contentType: "application/x-amz-json-1.0"}
+
+// call performs one AWS JSON 1.0 request for action. A failed
+// condition is errConditionFailed.
+func (c *dynamoClient) call(ctx context.Context, action string, in, out any) error {
+	err := c.do(ctx, dynamoAPI, action, in, out)
+	var ae *awsError
+	if errors.As(err, &ae) && ae.Type == "ConditionalCheckFailedException" {
+		return errConditionFailed
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// emfWriter writes one CloudWatch Embedded Metric Format record per
+// invocation. Lambda ships stdout to CloudWatch Logs, which extracts
+// the metrics from the record — dashboards and alarms without a
+// PutMetricData call or its IAM grant. See
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format_Specification.html
+//
+// Metrics, in namespace (DDDNS_METRICS_NAMESPACE), dimensioned by
+// Function:
+//
+//	Requests        Count         also by Outcome (the dyndns code)
+//	AuthFailures    Count         badauth responses
+//	SSMCacheHits    Count         secret / routing-table cache lookups served from memory
+//	SSMCacheMisses  Count         ... that fetched from SSM or Secrets Manager
+//	Route53Latency  Milliseconds  only when an UPSERT was attempted
+//
+// The record also carries SourceIP and Hostname as plain properties:
+// searchable in Logs Insights ("auth failures per source") without
+// becoming dimensions, so a scan from many addresses cannot mint
+// billable custom metrics.
+type emfWriter struct {
+	mu        sync.Mutex
+	w         io.Writer
+	namespace string
+	function  string // AWS_LAMBDA_FUNCTION_NAME
+	now       func() time.Time
+}
+
+// requestMetrics is what one invocation measured on its way to the
+// response.
+type requestMetrics struct {
+	cacheHits   int
+	cacheMisses int
+	route53     time.Duration // zero = no UPSERT attempted
+}
+
+// cache counts one cache lookup.
+func (m *requestMetrics) cache(hit bool) {
+	if hit {
+		m.cacheHits++
+	} else {
+		m.cacheMisses++
+	}
+}
+
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+// emit writes the record for an invocation answered with outcome. A
+// nil writer (metrics disabled) emits nothing.
+func (e *emfWriter) emit(m *requestMetrics, outcome, sourceIP, hostname string) {
+	if e == nil {
+		return
+	}
+	authFailures := 0
+	if outcome == "badauth" {
+		authFailures = 1
+	}
+	counters := []emfMetric{
+		{"AuthFailures", "Count"},
+		{"SSMCacheHits", "Count"},
+		{"SSMCacheMisses", "Count"},
+	}
+	record := map[string]any{
+		"Function":       e.function,
+		"Outcome":        outcome,
+		"Requests":       1,
+		"AuthFailures":   authFailures,
+		"SSMCacheHits":   m.cacheHits,
+		"SSMCacheMisses": m.cacheMisses,
+		"SourceIP":       sourceIP,
+	}
+	if hostname != "" {
+		record["Hostname"] = hostname
+	}
+	if m.route53 > 0 {
+		counters = append(counters, emfMetric{"Route53Latency", "Milliseconds"})
+		record["Route53Latency"] = m.route53.Milliseconds()
+	}
+	// Two directives: only Requests is split by Outcome, which keeps
+	// the custom-metric count (and bill) to one per outcome seen plus
+	// one per counter.
+	record["_aws"] = map[string]any{
+		"Timestamp": e.now().UnixMilli(),
+		"CloudWatchMetrics": []emfDirective{
+			{Namespace: e.namespace, Dimensions: [][]string{{"Function", "Outcome"}, {"Function"}}, Metrics: []emfMetric{{"Requests", "Count"}}},
+			{Namespace: e.namespace, Dimensions: [][]string{{"Function"}}, Metrics: counters},
+		},
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, _ = e.w.Write(append(line, '\n'))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHandler_EmitsEMF verifies one EMF record per invocation: the
+// outcome dimension, auth failures, cache hits and misses, and the
+// Route53 latency metric only when an UPSERT ran.
+func TestHandler_EmitsEMF(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+	var buf bytes.Buffer
+	h.metrics = &emfWriter{w: &buf, namespace: "dddns", function: "dddns-home", now: time.Now}
+
+	_, _ = h.handle(context.Background(), mkRequest(basicAuth("dddns", testSecret), testHostname, testSourceIP))
+	_, _ = h.handle(context.Background(), mkRequest(basicAuth("dddns", "wrong-secret"), testHostname, "198.51.100.7"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d EMF records, want 2:\n%s", len(lines), buf.String())
+	}
+	var good, bad struct {
+		AWS struct {
+			Timestamp         int64 `json:"Timestamp"`
+			CloudWatchMetrics []emfDirective
+		} `json:"_aws"`
+		Function       string
+		Outcome        string
+		SourceIP       string
+		Requests       int
+		AuthFailures   int
+		SSMCacheHits   int
+		SSMCacheMisses int
+		Route53Latency *int64
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &good); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &bad); err != nil {
+		t.Fatal(err)
+	}
+
+	if good.Outcome != "good" || good.Function != "dddns-home" || good.Requests != 1 || good.AuthFailures != 0 {
+		t.Errorf("good record = %+v", good)
+	}
+	if good.SSMCacheMisses != 1 || good.SSMCacheHits != 0 || good.Route53Latency == nil {
+		t.Errorf("good record cache %d/%d, latency %v", good.SSMCacheMisses, good.SSMCacheHits, good.Route53Latency)
+	}
+	if bad.Outcome != "badauth" || bad.AuthFailures != 1 || bad.SourceIP != "198.51.100.7" || bad.SSMCacheHits != 1 || bad.Route53Latency != nil {
+		t.Errorf("badauth record = %+v", bad)
+	}
+
+	// Only Requests is split by Outcome; every metric in a directive
+	// must be a member of the record.
+	d := bad.AWS.CloudWatchMetrics
+	if len(d) != 2 || d[0].Namespace != "dddns" || len(d[0].Dimensions) != 2 || d[0].Metrics[0].Name != "Requests" {
+		t.Fatalf("directives = %+v", d)
+	}
+	var members map[string]any
+	_ = json.Unmarshal([]byte(lines[1]), &members)
+	for _, dir := range d {
+		for _, m := range dir.Metrics {
+			if _, ok := members[m.Name]; !ok {
+				t.Errorf("metric %s declared but not in the record", m.Name)
+			}
+		}
+		for _, dims := range dir.Dimensions {
+			if len(dims) == 0 || dims[0] != "Function" {
+				t.Errorf("dimension set %v does not lead with Function", dims)
+			}
+		}
+	}
+	if bad.AWS.Timestamp == 0 {
+		t.Error("record has no timestamp")
+	}
+}
@@ -3,14 +3,22 @@ package main
 import (
 	"context"
 	"crypto/subtle"
-	"encoding/base64"
-	"log"
+	"errors"
+	"log/slog"
 	"net"
+	"net/url"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/descoped/dddns/internal/commands/myip"
+	"github.com/descoped/dddns/internal/dns"
+	"github.com/descoped/dddns/internal/dyndns"
+	"github.com/descoped/dddns/internal/lockout"
+	"github.com/descoped/dddns/internal/logging"
+	"github.com/descoped/dddns/internal/reqsign"
 )
 
 // dnsClient is the subset of the Route53 client the Lambda handler
@@ -23,12 +31,49 @@ type dnsClient interface {
 // init by main; lambda.Start routes every request through handle.
 type handler struct {
 	cfg         *config
-	route53     dnsClient
+	newDNS      func(r route) (dnsClient, error) // Route53 client for a route's zone, hostname and TTL
 	ssm         *ssmClient
+	secrets     *secretsClient // secret references that are Secrets Manager ARNs
 	secretCache *secretCache
+	routeCache  *routeCache       // multi-host deployments (cfg.routesParam)
+	store       *updateStore      // nil = no DynamoDB table; every push UPSERTs
+	lockout     *lockoutStore     // nil = no auth-failure lockout beyond API Gateway throttling
+	verifier    *reqsign.Verifier // replay cache for HMAC-signed requests; per container
+	metrics     *emfWriter        // nil = no EMF records
+	logger      *slog.Logger      // nil = slog.Default()
+}
+
+// routeTable returns the hostnames this deployment serves: the
+// SSM-loaded table when cfg.routesParam is set, else the single
+// env-configured hostname.
+func (h *handler) routeTable(ctx context.Context, m *requestMetrics) (*routeTable, error) {
+	if h.cfg.routesParam == "" {
+		return singleRoute(h.cfg), nil
+	}
+	t, hit, err := h.routeCache.get(ctx, h.ssm, h.cfg.routesParam, h.cfg.ttl)
+	m.cache(hit)
+	return t, err
+}
+
+// fetchSecret returns the values that authenticate against the secret
+// reference ref: the SSM parameter's value, a Secrets Manager secret's
+// current and previous values, or the config document's secret.
+func (h *handler) fetchSecret(ctx context.Context, ref string) ([]string, error) {
+	if ref == configSecretRef {
+		return []string{h.cfg.sharedSecret}, nil
+	}
+	if isSecretsManagerRef(ref) {
+		return h.secrets.stagedValues(ctx, ref)
+	}
+	v, err := h.ssm.getParameter(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return []string{v}, nil
 }
 
-// secretCache holds the SSM-fetched shared secret between invocations.
+// secretCache holds the fetched shared secrets between invocations,
+// per secret reference.
 // Lambda container reuse means we pay the SSM GetParameter round trip
 // at most every `ttl` seconds instead of every request — which keeps
 // the steady-state cost low while still picking up rotations promptly.
@@ -36,146 +81,424 @@ type handler struct {
 // window the old secret also still works (UniFi UI caches on its side
 // too), so we don't strictly need to invalidate on auth failure.
 type secretCache struct {
-	mu        sync.Mutex
-	value     string
+	mu      sync.Mutex
+	entries map[string]cachedSecret
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+type cachedSecret struct {
+	values    []string
 	fetchedAt time.Time
-	ttl       time.Duration
-	now       func() time.Time
 }
 
-func (c *secretCache) get(ctx context.Context, ssm *ssmClient, name string) (string, error) {
+// get returns name's values, and whether they were served from the
+// cache rather than fetched.
+func (c *secretCache) get(ctx context.Context, name string, fetch func(context.Context, string) ([]string, error)) ([]string, bool, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if c.value != "" && c.now().Sub(c.fetchedAt) < c.ttl {
-		return c.value, nil
+	if e, ok := c.entries[name]; ok && c.now().Sub(e.fetchedAt) < c.ttl {
+		return e.values, true, nil
 	}
-	v, err := ssm.getParameter(ctx, name)
+	v, err := fetch(ctx, name)
 	if err != nil {
 		// Surface the freshest SSM error even if we have a stale cached
 		// value — refusing to auth when SSM is unreachable is the
 		// fail-closed posture we want. UniFi will retry.
-		return "", err
-	}
-	c.value = v
-	c.fetchedAt = c.now()
-	return v, nil
-}
-
-// handle implements the dyndns v2 protocol
-// (https://help.dyn.com/remote-access-api/perform-update/). The
-// response body is a plain-text diagnostic ("good <ip>" / "nohost" /
-// "badauth" / "dnserr" / "nochg <ip>"), HTTP 200 in all cases —
-// dyndns clients inspect the body, not the status code.
-func (h *handler) handle(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
-	// L6 — the only IP we will publish is the TCP source IP that
-	// API Gateway recorded. The myip query parameter is ignored
-	// entirely (same posture as serve-mode on the listener: the
-	// client's claim is not authoritative). On API Gateway HTTP
-	// API, RequestContext.HTTP.SourceIP is populated from the TCP
-	// connection peer, so this is ground truth.
-	sourceIP := req.RequestContext.HTTP.SourceIP
-	if sourceIP == "" {
-		// Should never happen under API Gateway HTTP API — if it
-		// does, we're in a misconfigured environment and blind
-		// publishing is unsafe. Fail closed.
-		return dyndns("dnserr no source ip"), nil
+		return nil, false, err
 	}
-	if net.ParseIP(sourceIP) == nil {
-		return dyndns("dnserr source ip unparseable"), nil
+	if c.entries == nil {
+		c.entries = map[string]cachedSecret{}
 	}
+	c.entries[name] = cachedSecret{values: v, fetchedAt: c.now()}
+	return v, false, nil
+}
 
-	// Auth — Basic, constant-time compare against SSM-stored secret.
-	user, pass, ok := parseBasicAuth(req.Headers)
-	if !ok {
-		return dyndns("badauth"), nil
+// handle implements the dyndns v2 protocol through the pipeline
+// shared with serve mode (internal/dyndns), with lambdaRequest as its
+// Backend. The response body is a plain-text diagnostic ("good <ip>" /
+// "nohost" / "badauth" / "dnserr" / "nochg <ip>"), HTTP 200 in all
+// cases — dyndns clients inspect the body, not the status code.
+//
+// Every invocation ends with one log record carrying the response code
+// as action, the source IP and hostname, and duration_ms — and, with
+// metrics enabled, one EMF record (emf.go).
+func (h *handler) handle(ctx context.Context, req events.APIGatewayV2HTTPRequest) (resp events.APIGatewayV2HTTPResponse, err error) {
+	start := time.Now()
+	logger := logging.OrDefault(h.logger)
+	m := &requestMetrics{}
+	defer func() {
+		action := outcome(resp.Body)
+		logOutcome(ctx, logger, req, action, time.Since(start))
+		h.metrics.emit(m, action, req.RequestContext.HTTP.SourceIP, strings.TrimSpace(req.QueryStringParameters["hostname"]))
+	}()
+
+	r, _ := dyndns.Handle(ctx, &dyndns.Request{
+		Method:        req.RequestContext.HTTP.Method,
+		Path:          requestPath(req),
+		Query:         requestQuery(req),
+		Authorization: headerValue(req.Headers, "Authorization"),
+		RemoteAddr:    req.RequestContext.HTTP.SourceIP,
+	}, &lambdaRequest{h: h, logger: logger, m: m}, dyndns.Options{
+		RequireSignature: h.cfg.requireSignature,
+		AllowDryRun:      true,
+	})
+	return textResponse(r), nil
+}
+
+// lambdaRequest is the dyndns.Backend of one invocation: the source-IP
+// checks, the lockout table, the routing table and the client's stored
+// secret, the source IP as the published address, and the update
+// store in front of Route53.
+type lambdaRequest struct {
+	h      *handler
+	logger *slog.Logger
+	m      *requestMetrics
+
+	sourceIP string // canonical, once admitted
+	client   string // lockout.Key of sourceIP
+	user     string // authenticated client; "" on the single-host route
+	table    *routeTable
+	route    route // the route Owns matched
+}
+
+// Admit is L6 — the only IP we will publish is the TCP source IP that
+// API Gateway (or the Function URL) recorded. The myip query parameter
+// is ignored entirely (same posture as serve-mode on the listener: the
+// client's claim is not authoritative).
+func (l *lambdaRequest) Admit(_ context.Context, req *dyndns.Request) error {
+	if req.RemoteAddr == "" {
+		// Should never happen under API Gateway — if it does, we're
+		// in a misconfigured environment and blind publishing is
+		// unsafe. Fail closed.
+		return &dyndns.Error{Detail: "no source ip"}
+	}
+	addr := net.ParseIP(req.RemoteAddr)
+	if addr == nil {
+		return &dyndns.Error{Detail: "source ip unparseable"}
+	}
+	// Canonical form: an IPv4-mapped IPv6 peer is published as IPv4.
+	l.sourceIP = addr.String()
+	// Never publish an address the rest of the internet can't reach —
+	// a private, loopback or link-local peer means a proxy or VPC
+	// endpoint sits in front of API Gateway. Same checks as the
+	// cron path's public-IP validation, for either family.
+	if err := myip.ValidatePublicAddr(l.sourceIP); err != nil {
+		l.logger.Warn("source ip rejected", slog.String(logging.KeyRemoteAddr, l.sourceIP), logging.Err(err))
+		return &dyndns.Error{Detail: "source ip not public", Err: err}
 	}
-	_ = user // UniFi's inadyn sends a username; we ignore it and auth on the secret only.
+	// An IPv6 peer updates the AAAA record. It only reaches a
+	// dual-stack endpoint, whose IAM policy also grants AAAA; without
+	// DDDNS_IPV6 fail with a clear dnserr rather than AccessDenied.
+	if dns.RecordType(l.sourceIP) == "AAAA" && !l.h.cfg.ipv6 {
+		return &dyndns.Error{Detail: "ipv6 disabled"}
+	}
+	l.client = lockout.Key(l.sourceIP)
+	return nil
+}
 
-	expected, err := h.secretCache.get(ctx, h.ssm, h.cfg.ssmSecretParam)
-	if err != nil {
-		log.Printf("ssm fetch failed: %v", err)
-		return dyndns("dnserr ssm"), nil
+// Authenticate checks an HMAC signature (internal/reqsign) or a Basic
+// password, compared in constant time, against the client's stored
+// secret. L3 — a client locked out after repeated failures is refused
+// without its credentials being checked, like serve mode; a wrong
+// secret or signature, or an unknown client, counts toward it.
+func (l *lambdaRequest) Authenticate(ctx context.Context, req *dyndns.Request, cred dyndns.Credentials) (dyndns.AuthResult, error) {
+	h := l.h
+	pending, version, locked := h.lockoutState(ctx, l.logger, l.client)
+	if locked {
+		return dyndns.AuthLockedOut, nil
 	}
-	if subtle.ConstantTimeCompare([]byte(pass), []byte(expected)) != 1 {
-		return dyndns("badauth"), nil
+	bad := func(err error) (dyndns.AuthResult, error) {
+		h.recordAuthFailure(ctx, l.logger, l.client)
+		return dyndns.AuthBadCredentials, err
 	}
 
-	// Hostname match — RFC 1035 case-insensitive. Mirror serve-mode
-	// behaviour from internal/server/handler.go.
-	hostname := strings.TrimSpace(req.QueryStringParameters["hostname"])
-	if hostname == "" {
-		return dyndns("notfqdn"), nil
+	table, err := h.routeTable(ctx, l.m)
+	if err != nil {
+		l.logger.Error("routing table fetch failed", logging.Err(err))
+		return dyndns.AuthUnavailable, &dyndns.Error{Detail: "ssm", Err: err}
 	}
-	if !strings.EqualFold(hostname, h.cfg.hostname) {
-		return dyndns("nohost"), nil
+
+	// Identify the client. Basic Auth names it; a signed request does
+	// not, so its client is the owner of the requested hostname. The
+	// single-host route has no owner: any username matches and only
+	// the secret authenticates. An unknown client is badauth, so an
+	// unauthenticated caller cannot enumerate the table.
+	user := cred.Username
+	if cred.Scheme == dyndns.SchemeHMAC {
+		if r, ok := table.lookup(strings.TrimSpace(req.Query.Get("hostname"))); ok {
+			user = r.Username
+		}
+	}
+	secretParam, ok := table.secretFor(user)
+	if !ok {
+		return bad(nil)
 	}
 
-	// Dry-run short-circuit. Accepts ?dry-run=true (or =1) so operators
-	// can verify the whole auth + hostname-match pipeline against a
-	// live deploy without mutating Route53. The response still looks
-	// like a successful update ("good <ip>") so a dyndns client won't
-	// go into a retry loop — but the "dry-run" marker lets downstream
-	// log scraping tell the difference.
-	if isDryRun(req.QueryStringParameters["dry-run"]) {
-		log.Printf("dry-run: would UPSERT %s -> %s (skipping Route53)", h.cfg.hostname, sourceIP)
-		return dyndns("good " + sourceIP + " (dry-run)"), nil
+	expected, hit, err := h.secretCache.get(ctx, secretParam, h.fetchSecret)
+	l.m.cache(hit)
+	if err != nil {
+		if isSecretsManagerRef(secretParam) {
+			l.logger.Error("secrets manager fetch failed", logging.Err(err))
+			return dyndns.AuthUnavailable, &dyndns.Error{Detail: "secretsmanager", Err: err}
+		}
+		l.logger.Error("ssm fetch failed", logging.Err(err))
+		return dyndns.AuthUnavailable, &dyndns.Error{Detail: "ssm", Err: err}
+	}
+	if cred.Scheme == dyndns.SchemeHMAC {
+		if err := h.verifySignature(cred.Authorization, expected, req); err != nil {
+			l.logger.Warn("signature rejected", slog.String(logging.KeyRemoteAddr, l.sourceIP), logging.Err(err))
+			return bad(err)
+		}
+	} else if !matchesAny(cred.Password, expected) {
+		return bad(nil)
+	}
+	if pending > 0 {
+		if err := h.lockout.clear(ctx, l.client, version); err != nil {
+			l.logger.Warn("lockout state clear failed", logging.Err(err))
+		}
 	}
+	l.user, l.table = user, table
+	return dyndns.AuthOK, nil
+}
+
+// Owns matches among the hostnames the authenticated client owns.
+func (l *lambdaRequest) Owns(hostname string) bool {
+	rt, ok := l.table.owns(l.user, hostname)
+	l.route = rt
+	return ok
+}
 
-	// Route53 UPSERT. Bound by the handler's context (API Gateway
-	// applies its own 30s integration timeout; we add a shorter
-	// deadline so a hung Route53 call can't eat our execution
-	// budget).
+// Address is the source IP Admit checked.
+func (l *lambdaRequest) Address(context.Context, *dyndns.Request) (string, error) {
+	return l.sourceIP, nil
+}
+
+// Update is the Route53 UPSERT of the matched route, behind the update
+// store's claim when there is one. It is bound by a deadline shorter
+// than API Gateway's 30s integration timeout, so a hung Route53 call
+// can't eat our execution budget.
+func (l *lambdaRequest) Update(ctx context.Context, _, ip string) (string, string, error) {
+	h, rt := l.h, l.route
+	rtype := dns.RecordType(ip)
+	nochg, claimed, prev := h.claimUpdate(ctx, l.logger, rt.Hostname, rtype, ip)
+	if nochg {
+		return "nochg-store", ip, nil
+	}
+	route53, err := h.newDNS(rt)
+	if err != nil {
+		l.logger.Error("route53 client init failed", logging.Err(err))
+		h.releaseClaim(ctx, l.logger, claimed, rt.Hostname, rtype, ip, prev)
+		return "", "", err
+	}
 	upctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
-	if err := h.route53.UpdateIP(upctx, sourceIP); err != nil {
-		log.Printf("route53 update failed: %v", err)
-		return dyndns("dnserr " + err.Error()), nil
+	upsertStart := time.Now()
+	err = route53.UpdateIP(upctx, ip)
+	l.m.route53 = time.Since(upsertStart)
+	if err != nil {
+		l.logger.Error("route53 update failed", logging.Err(err))
+		h.releaseClaim(ctx, l.logger, claimed, rt.Hostname, rtype, ip, prev)
+		return "", "", &dyndns.Error{Detail: err.Error(), Err: err}
+	}
+	if claimed {
+		if err := h.store.record(ctx, rt.Hostname, rtype, ip, prev); err != nil {
+			l.logger.Warn("update history write failed", logging.Err(err))
+		}
 	}
+	return "updated", ip, nil
+}
 
-	return dyndns("good " + sourceIP), nil
+// lockoutState reads client's lockout state: whether it is locked
+// out, else its pending failures and the version to clear them at.
+// Like the update store, an unreachable table degrades to no lockout
+// rather than refusing every push.
+func (h *handler) lockoutState(ctx context.Context, logger *slog.Logger, client string) (pending int, version int64, locked bool) {
+	if h.lockout == nil {
+		return 0, 0, false
+	}
+	st, version, err := h.lockout.load(ctx, client)
+	if err != nil {
+		logger.Warn("lockout state read failed", logging.Err(err))
+		return 0, 0, false
+	}
+	if st.Locked(h.lockout.now()) {
+		logger.Warn("client locked out", slog.String(logging.KeyRemoteAddr, client), slog.Time("locked_until", st.LockedUntil))
+		return 0, 0, true
+	}
+	return len(st.Failures), version, false
 }
 
-// isDryRun reports whether the query-string value represents a truthy
-// dry-run request. Accepts "true"/"1"/"yes"/"on" case-insensitively;
-// anything else (including empty) returns false.
-func isDryRun(v string) bool {
-	switch strings.ToLower(strings.TrimSpace(v)) {
-	case "true", "1", "yes", "on":
-		return true
+// recordAuthFailure counts a failed authentication toward client's
+// lockout.
+func (h *handler) recordAuthFailure(ctx context.Context, logger *slog.Logger, client string) {
+	if h.lockout == nil {
+		return
+	}
+	locked, err := h.lockout.fail(ctx, client)
+	switch {
+	case err != nil:
+		logger.Warn("lockout state write failed", logging.Err(err))
+	case locked:
+		logger.Warn("client locked out after repeated auth failures",
+			slog.String(logging.KeyRemoteAddr, client),
+			slog.Int("max_failures", h.lockout.rule.MaxFailures),
+			slog.Duration("lockout", h.lockout.rule.Duration))
 	}
-	return false
 }
 
-// dyndns builds a minimal HTTP 200 text/plain response.
-func dyndns(body string) events.APIGatewayV2HTTPResponse {
+// claimUpdate consults the update store before an UPSERT. nochg
+// reports that hostname already has ip, or that a concurrent push is
+// publishing it; claimed that the store now records ip, replacing
+// prev. A store outage degrades to an unconditional UPSERT rather
+// than failing the push — Route53 UPSERT is idempotent.
+func (h *handler) claimUpdate(ctx context.Context, logger *slog.Logger, hostname, rtype, ip string) (nochg, claimed bool, prev string) {
+	if h.store == nil {
+		return false, false, ""
+	}
+	last, err := h.store.last(ctx, hostname, rtype)
+	if err != nil {
+		logger.Warn("update store read failed", logging.Err(err))
+		return false, false, ""
+	}
+	if last == ip {
+		return true, false, ""
+	}
+	prev, err = h.store.claim(ctx, hostname, rtype, ip)
+	switch {
+	case errors.Is(err, errConditionFailed):
+		return true, false, ""
+	case err != nil:
+		logger.Warn("update store claim failed", logging.Err(err))
+		return false, false, ""
+	}
+	return false, true, prev
+}
+
+// releaseClaim undoes a claimUpdate claim after a failed UPSERT, so
+// the client's retry is not answered nochg.
+func (h *handler) releaseClaim(ctx context.Context, logger *slog.Logger, claimed bool, hostname, rtype, ip, prev string) {
+	if !claimed {
+		return
+	}
+	if err := h.store.release(ctx, hostname, rtype, ip, prev); err != nil {
+		logger.Error("update store release failed; the next push of this address answers nochg", logging.Err(err))
+	}
+}
+
+// verifySignature checks a signed request against each accepted secret
+// value. The verifier records the nonce only once a signature matches,
+// so trying the previous value after the current one is replay-safe.
+func (h *handler) verifySignature(authz string, secrets []string, req *dyndns.Request) error {
+	err := reqsign.ErrBadSignature
+	for _, secret := range secrets {
+		err = h.verifier.Verify(authz, secret, req.Method, req.Path, req.Query)
+		if !errors.Is(err, reqsign.ErrBadSignature) {
+			return err
+		}
+	}
+	return err
+}
+
+// matchesAny reports whether pass equals one of secrets, comparing
+// each in constant time.
+func matchesAny(pass string, secrets []string) bool {
+	ok := 0
+	for _, secret := range secrets {
+		ok |= subtle.ConstantTimeCompare([]byte(pass), []byte(secret))
+	}
+	return ok == 1
+}
+
+// outcome is the dyndns code of a response body — its first word —
+// or "dry-run" for a dry run.
+func outcome(body string) string {
+	words := strings.Fields(body)
+	if len(words) == 0 {
+		return ""
+	}
+	if words[0] == "good" && strings.HasSuffix(strings.TrimSpace(body), "(dry-run)") {
+		return "dry-run"
+	}
+	return words[0]
+}
+
+// logOutcome writes the invocation's summary record with action, the
+// response's outcome. The level follows it: good and dry-run at
+// Notice, badauth at Warn, dnserr at Error, anything else at Info.
+func logOutcome(ctx context.Context, logger *slog.Logger, req events.APIGatewayV2HTTPRequest, action string, elapsed time.Duration) {
+	level := slog.LevelInfo
+	switch action {
+	case "good", "dry-run":
+		level = logging.LevelNotice
+	case "badauth":
+		level = slog.LevelWarn
+	case "dnserr":
+		level = slog.LevelError
+	}
+	sourceIP := req.RequestContext.HTTP.SourceIP
+	attrs := []slog.Attr{
+		slog.String(logging.KeyAction, action),
+		slog.String(logging.KeyRemoteAddr, sourceIP),
+		logging.DurationMS(elapsed),
+	}
+	if hostname := strings.TrimSpace(req.QueryStringParameters["hostname"]); hostname != "" {
+		attrs = append(attrs, slog.String(logging.KeyHostname, hostname))
+	}
+	if action == "good" || action == "dry-run" {
+		attrs = append(attrs,
+			slog.String(logging.KeyNewIP, sourceIP),
+			slog.String(logging.KeySource, "peer"))
+	}
+	logger.LogAttrs(ctx, level, "dyndns request", attrs...)
+}
+
+// textResponse is r as an API Gateway response: text/plain, HTTP 200
+// for every dyndns code.
+func textResponse(r dyndns.Response) events.APIGatewayV2HTTPResponse {
+	if r.Code == "" {
+		return events.APIGatewayV2HTTPResponse{StatusCode: r.Status}
+	}
 	return events.APIGatewayV2HTTPResponse{
-		StatusCode: 200,
+		StatusCode: r.Status,
 		Headers:    map[string]string{"Content-Type": "text/plain; charset=utf-8"},
-		Body:       body + "\n",
+		Body:       r.Body(),
 	}
 }
 
-// parseBasicAuth extracts user+pass from the standard Basic header.
-// Case-insensitive header lookup — API Gateway normalizes header keys
-// to lowercase, but tests may populate them either way.
-func parseBasicAuth(headers map[string]string) (user, pass string, ok bool) {
-	var raw string
+// headerValue returns the named header. Case-insensitive lookup — API
+// Gateway normalizes header keys to lowercase, but tests may populate
+// them either way.
+func headerValue(headers map[string]string, name string) string {
 	for k, v := range headers {
-		if strings.EqualFold(k, "Authorization") {
-			raw = v
-			break
+		if strings.EqualFold(k, name) {
+			return v
 		}
 	}
-	if !strings.HasPrefix(raw, "Basic ") {
-		return "", "", false
+	return ""
+}
+
+// requestPath is the path a signing client saw. RawPath is set on the
+// HTTP API payload; requestContext.http.path is the fallback.
+func requestPath(req events.APIGatewayV2HTTPRequest) string {
+	if req.RawPath != "" {
+		return req.RawPath
 	}
-	decoded, err := base64.StdEncoding.DecodeString(raw[len("Basic "):])
-	if err != nil {
-		return "", "", false
+	return req.RequestContext.HTTP.Path
+}
+
+// requestQuery rebuilds the query for signature verification. The raw
+// query string is authoritative; the parsed map (which comma-joins
+// repeated keys) is only used when API Gateway omitted it.
+func requestQuery(req events.APIGatewayV2HTTPRequest) url.Values {
+	if req.RawQueryString != "" {
+		if q, err := url.ParseQuery(req.RawQueryString); err == nil {
+			return q
+		}
 	}
-	parts := strings.SplitN(string(decoded), ":", 2)
-	if len(parts) != 2 {
-		return "", "", false
+	q := url.Values{}
+	for k, v := range req.QueryStringParameters {
+		q.Set(k, v)
 	}
-	return parts[0], parts[1], true
+	return q
 }
@@ -1,31 +1,51 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/descoped/dddns/internal/dyndns"
+	"github.com/descoped/dddns/internal/dyndns/dyndnstest"
+	"github.com/descoped/dddns/internal/logging"
+	"github.com/descoped/dddns/internal/reqsign"
 )
 
-// stubRoute53 records the IPs it was asked to publish. Mirrors the
-// dnsClient interface exactly so it drops into handler.route53.
+// stubRoute53 records the IPs it was asked to publish, and the routes
+// it was built for. forRoute drops into handler.newDNS.
 type stubRoute53 struct {
 	mu     sync.Mutex
 	pushed []string
+	routes []route
+	err    error // returned by UpdateIP instead of publishing
+}
+
+func (s *stubRoute53) forRoute(r route) (dnsClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.routes = append(s.routes, r)
+	return s, nil
 }
 
 func (s *stubRoute53) UpdateIP(_ context.Context, ip string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
 	s.pushed = append(s.pushed, ip)
 	return nil
 }
@@ -42,8 +62,9 @@ const (
 // newTestHandler wires a *handler with a stub Route53 client and an
 // httptest server standing in for SSM. The Route53 stub accepts any
 // UpdateIP call and records the IP; the SSM stub returns testSecret
-// as the parameter value. Tests can override the SSM behaviour.
-func newTestHandler(t *testing.T, ssmHandler http.HandlerFunc) *handler {
+// as the parameter value. Tests can override the SSM behaviour, and
+// read the Route53 stub newTestHandler returns.
+func newTestHandler(t *testing.T, ssmHandler http.HandlerFunc) (*handler, *stubRoute53) {
 	t.Helper()
 
 	if ssmHandler == nil {
@@ -64,7 +85,7 @@ func newTestHandler(t *testing.T, ssmHandler http.HandlerFunc) *handler {
 	}
 
 	r53 := &stubRoute53{}
-	ssm := &ssmClient{
+	ssm := &ssmClient{awsConn: awsConn{
 		region:       cfg.region,
 		accessKey:    cfg.accessKey,
 		secretKey:    cfg.secretKey,
@@ -72,14 +93,17 @@ func newTestHandler(t *testing.T, ssmHandler http.HandlerFunc) *handler {
 		httpClient:   ssmSrv.Client(),
 		endpoint:     ssmSrv.URL,
 		now:          time.Now,
-	}
+	}}
 
 	return &handler{
 		cfg:         cfg,
-		route53:     r53,
+		newDNS:      r53.forRoute,
 		ssm:         ssm,
 		secretCache: &secretCache{ttl: time.Minute, now: time.Now},
-	}
+		routeCache:  &routeCache{ttl: time.Minute, now: time.Now},
+		verifier:    reqsign.NewVerifier(0, 0),
+		logger:      logging.Discard(),
+	}, r53
 }
 
 func defaultSSMStub(w http.ResponseWriter, r *http.Request) {
@@ -113,6 +137,7 @@ func mkRequest(auth, hostname, sourceIP string) events.APIGatewayV2HTTPRequest {
 			"myip":     "198.51.100.1", // deliberately NOT what we expect — handler must ignore this and use SourceIP
 		},
 	}
+	req.RequestContext.HTTP.Method = "GET"
 	req.RequestContext.HTTP.SourceIP = sourceIP
 	return req
 }
@@ -122,7 +147,7 @@ func basicAuth(user, pass string) string {
 }
 
 func TestHandler_HappyPath_PublishesSourceIP(t *testing.T) {
-	h := newTestHandler(t, nil)
+	h, _ := newTestHandler(t, nil)
 
 	resp, err := h.handle(context.Background(), mkRequest(basicAuth("dddns", testSecret), testHostname, testSourceIP))
 	if err != nil {
@@ -137,7 +162,7 @@ func TestHandler_HappyPath_PublishesSourceIP(t *testing.T) {
 }
 
 func TestHandler_WrongSecret_Badauth(t *testing.T) {
-	h := newTestHandler(t, nil)
+	h, _ := newTestHandler(t, nil)
 
 	resp, _ := h.handle(context.Background(), mkRequest(basicAuth("dddns", "wrong-secret"), testHostname, testSourceIP))
 	if got := strings.TrimSpace(resp.Body); got != "badauth" {
@@ -146,7 +171,7 @@ func TestHandler_WrongSecret_Badauth(t *testing.T) {
 }
 
 func TestHandler_MissingAuth_Badauth(t *testing.T) {
-	h := newTestHandler(t, nil)
+	h, _ := newTestHandler(t, nil)
 
 	resp, _ := h.handle(context.Background(), mkRequest("", testHostname, testSourceIP))
 	if got := strings.TrimSpace(resp.Body); got != "badauth" {
@@ -155,7 +180,7 @@ func TestHandler_MissingAuth_Badauth(t *testing.T) {
 }
 
 func TestHandler_MalformedAuth_Badauth(t *testing.T) {
-	h := newTestHandler(t, nil)
+	h, _ := newTestHandler(t, nil)
 
 	// Not Base64, not colon-separated.
 	resp, _ := h.handle(context.Background(), mkRequest("Basic garbage!!", testHostname, testSourceIP))
@@ -165,7 +190,7 @@ func TestHandler_MalformedAuth_Badauth(t *testing.T) {
 }
 
 func TestHandler_WrongHostname_Nohost(t *testing.T) {
-	h := newTestHandler(t, nil)
+	h, _ := newTestHandler(t, nil)
 
 	resp, _ := h.handle(context.Background(), mkRequest(basicAuth("dddns", testSecret), "other.example.com", testSourceIP))
 	if got := strings.TrimSpace(resp.Body); got != "nohost" {
@@ -174,7 +199,7 @@ func TestHandler_WrongHostname_Nohost(t *testing.T) {
 }
 
 func TestHandler_HostnameCaseInsensitive(t *testing.T) {
-	h := newTestHandler(t, nil)
+	h, _ := newTestHandler(t, nil)
 
 	resp, _ := h.handle(context.Background(), mkRequest(basicAuth("dddns", testSecret), strings.ToUpper(testHostname), testSourceIP))
 	if got := strings.TrimSpace(resp.Body); got != "good "+testSourceIP {
@@ -183,7 +208,7 @@ func TestHandler_HostnameCaseInsensitive(t *testing.T) {
 }
 
 func TestHandler_EmptyHostname_Notfqdn(t *testing.T) {
-	h := newTestHandler(t, nil)
+	h, _ := newTestHandler(t, nil)
 
 	resp, _ := h.handle(context.Background(), mkRequest(basicAuth("dddns", testSecret), "", testSourceIP))
 	if got := strings.TrimSpace(resp.Body); got != "notfqdn" {
@@ -192,7 +217,7 @@ func TestHandler_EmptyHostname_Notfqdn(t *testing.T) {
 }
 
 func TestHandler_MissingSourceIP_Dnserr(t *testing.T) {
-	h := newTestHandler(t, nil)
+	h, _ := newTestHandler(t, nil)
 
 	// No sourceIP set. Under real API Gateway this can't happen; tests
 	// verify the fail-closed branch fires when it somehow does.
@@ -207,7 +232,7 @@ func TestHandler_MyipParamIgnored(t *testing.T) {
 	// (RFC 5737 TEST-NET-2) but the handler MUST publish SourceIP
 	// (203.0.113.42, TEST-NET-3) instead. If it ever publishes the
 	// myip value, the body won't match.
-	h := newTestHandler(t, nil)
+	h, _ := newTestHandler(t, nil)
 
 	resp, _ := h.handle(context.Background(), mkRequest(basicAuth("dddns", testSecret), testHostname, testSourceIP))
 	body := strings.TrimSpace(resp.Body)
@@ -228,7 +253,7 @@ func TestSecretCache_SingleRoundTrip(t *testing.T) {
 		calls++
 		defaultSSMStub(w, r)
 	}
-	h := newTestHandler(t, ssmHandler)
+	h, _ := newTestHandler(t, ssmHandler)
 
 	req := mkRequest(basicAuth("dddns", testSecret), testHostname, testSourceIP)
 
@@ -243,37 +268,13 @@ func TestSecretCache_SingleRoundTrip(t *testing.T) {
 	}
 }
 
-func TestParseBasicAuth(t *testing.T) {
-	cases := []struct {
-		name, header, user, pass string
-		ok                       bool
-	}{
-		{"standard", "Basic " + base64.StdEncoding.EncodeToString([]byte("u:p")), "u", "p", true},
-		{"case-insensitive prefix not supported", "basic " + base64.StdEncoding.EncodeToString([]byte("u:p")), "", "", false},
-		{"empty", "", "", "", false},
-		{"bearer token", "Bearer xyz", "", "", false},
-		{"missing colon", "Basic " + base64.StdEncoding.EncodeToString([]byte("nocolon")), "", "", false},
-		{"invalid base64", "Basic %%%", "", "", false},
-	}
-	for _, tc := range cases {
-		t.Run(tc.name, func(t *testing.T) {
-			u, p, ok := parseBasicAuth(map[string]string{"Authorization": tc.header})
-			if ok != tc.ok || u != tc.user || p != tc.pass {
-				t.Errorf("parseBasicAuth(%q) = (%q, %q, %v), want (%q, %q, %v)",
-					tc.header, u, p, ok, tc.user, tc.pass, tc.ok)
-			}
-		})
-	}
-}
-
 // TestHandler_DryRun_SkipsRoute53 is the headline test for the
 // ?dry-run=true feature. The handler must authenticate, match the
 // hostname, and return "good <ip> (dry-run)" WITHOUT calling
 // route53.UpdateIP. Regression: a silent regression here would
 // actually UPSERT during what operators think is a test.
 func TestHandler_DryRun_SkipsRoute53(t *testing.T) {
-	h := newTestHandler(t, nil)
-	r53 := h.route53.(*stubRoute53)
+	h, r53 := newTestHandler(t, nil)
 
 	req := mkRequest(basicAuth("dddns", testSecret), testHostname, testSourceIP)
 	req.QueryStringParameters["dry-run"] = "true"
@@ -291,13 +292,54 @@ func TestHandler_DryRun_SkipsRoute53(t *testing.T) {
 	}
 }
 
+// TestHandler_LogsOutcome checks the per-invocation record: the dry-run
+// is tagged as such with the shared field names, and a rejected request
+// logs at WARN without echoing the credential.
+func TestHandler_LogsOutcome(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, logging.FormatJSON, slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.logger = logger
+
+	req := mkRequest(basicAuth("dddns", testSecret), testHostname, testSourceIP)
+	req.QueryStringParameters["dry-run"] = "true"
+	_, _ = h.handle(context.Background(), req)
+	_, _ = h.handle(context.Background(), mkRequest(basicAuth("dddns", "wrong-secret"), testHostname, testSourceIP))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2:\n%s", len(lines), buf.String())
+	}
+	var dry, bad map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &dry); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &bad); err != nil {
+		t.Fatal(err)
+	}
+	if dry["action"] != "dry-run" || dry["new_ip"] != testSourceIP || dry["hostname"] != testHostname || dry["level"] != "NOTICE" {
+		t.Errorf("dry-run record = %v", dry)
+	}
+	if _, ok := dry["duration_ms"]; !ok {
+		t.Error("dry-run record has no duration_ms")
+	}
+	if bad["action"] != "badauth" || bad["level"] != "WARN" {
+		t.Errorf("badauth record = %v", bad)
+	}
+	if strings.Contains(buf.String(), "wrong-secret") || strings.Contains(buf.String(), testSecret) {
+		t.Error("log output contains a credential")
+	}
+}
+
 // TestHandler_DryRun_StillEnforcesAuth ensures dry-run is not a bypass
 // for authentication. A bad secret must still fail 'badauth' before
 // the dry-run branch is even reached — otherwise the endpoint would
 // leak information about which hostnames exist.
 func TestHandler_DryRun_StillEnforcesAuth(t *testing.T) {
-	h := newTestHandler(t, nil)
-	r53 := h.route53.(*stubRoute53)
+	h, r53 := newTestHandler(t, nil)
 
 	req := mkRequest(basicAuth("dddns", "wrong-secret"), testHostname, testSourceIP)
 	req.QueryStringParameters["dry-run"] = "true"
@@ -316,7 +358,7 @@ func TestHandler_DryRun_StillEnforcesAuth(t *testing.T) {
 // hostnames on a shared endpoint must not get different responses
 // for dry-run vs real.
 func TestHandler_DryRun_StillMatchesHostname(t *testing.T) {
-	h := newTestHandler(t, nil)
+	h, _ := newTestHandler(t, nil)
 
 	req := mkRequest(basicAuth("dddns", testSecret), "other.example.com", testSourceIP)
 	req.QueryStringParameters["dry-run"] = "true"
@@ -327,31 +369,140 @@ func TestHandler_DryRun_StillMatchesHostname(t *testing.T) {
 	}
 }
 
-// TestIsDryRun_Matrix documents the truthy values recognised. Anything
-// not in the explicit allow-list (including the empty string) returns
-// false — no accidental enabling from a stray whitespace header.
-func TestIsDryRun_Matrix(t *testing.T) {
-	truthy := []string{"true", "1", "yes", "on", "TRUE", "True", "  true  ", "YES"}
-	falsy := []string{"", "false", "0", "no", "off", "dry-run", "enabled", " "}
+func TestHeaderValue_LowercaseHeaderKey(t *testing.T) {
+	// API Gateway normalizes header keys to lowercase before handing
+	// them to the Lambda. Verify the lookup is case-insensitive.
+	h := map[string]string{"authorization": basicAuth("dddns", testSecret)}
+	c, ok := dyndns.ParseCredentials(headerValue(h, "Authorization"))
+	if !ok || c.Username != "dddns" || c.Password != testSecret {
+		t.Errorf("lowercase header key not matched: got (%q, %q, %v)", c.Username, c.Password, ok)
+	}
+}
 
-	for _, v := range truthy {
-		if !isDryRun(v) {
-			t.Errorf("isDryRun(%q) = false, want true", v)
-		}
+// mkSignedRequest is mkRequest with an HMAC signature over the raw
+// query, the way `dddns serve test --sign` produces one.
+func mkSignedRequest(t *testing.T, secret, hostname string) events.APIGatewayV2HTTPRequest {
+	t.Helper()
+	q := url.Values{"hostname": {hostname}, "myip": {"198.51.100.1"}}
+	nonce, err := reqsign.NewNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := mkRequest(reqsign.Header(secret, "GET", "/nic/update", q, time.Now(), nonce), hostname, testSourceIP)
+	req.RawPath = "/nic/update"
+	req.RawQueryString = q.Encode()
+	req.RequestContext.HTTP.Method = "GET"
+	return req
+}
+
+func TestHandler_SignedRequest(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+	req := mkSignedRequest(t, testSecret, testHostname)
+
+	resp, _ := h.handle(context.Background(), req)
+	if got := strings.TrimSpace(resp.Body); got != "good "+testSourceIP {
+		t.Fatalf("body = %q, want good", got)
+	}
+	resp, _ = h.handle(context.Background(), req)
+	if got := strings.TrimSpace(resp.Body); got != "badauth" {
+		t.Errorf("replayed request body = %q, want badauth", got)
+	}
+
+	resp, _ = h.handle(context.Background(), mkSignedRequest(t, "wrong-secret", testHostname))
+	if got := strings.TrimSpace(resp.Body); got != "badauth" {
+		t.Errorf("wrong-secret body = %q, want badauth", got)
+	}
+}
+
+func TestHandler_RequireSignature_RejectsBasic(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+	h.cfg.requireSignature = true
+
+	resp, _ := h.handle(context.Background(), mkRequest(basicAuth("dddns", testSecret), testHostname, testSourceIP))
+	if got := strings.TrimSpace(resp.Body); got != "badauth" {
+		t.Errorf("body = %q, want badauth", got)
+	}
+	resp, _ = h.handle(context.Background(), mkSignedRequest(t, testSecret, testHostname))
+	if got := strings.TrimSpace(resp.Body); got != "good "+testSourceIP {
+		t.Errorf("signed body = %q, want good", got)
 	}
-	for _, v := range falsy {
-		if isDryRun(v) {
-			t.Errorf("isDryRun(%q) = true, want false", v)
+}
+
+// TestHandler_SourceIPFamilies covers the source checks: a non-public
+// peer is rejected before auth, an IPv6 peer needs DDDNS_IPV6, and an
+// IPv4-mapped peer is published as plain IPv4.
+func TestHandler_SourceIPFamilies(t *testing.T) {
+	cases := []struct {
+		source string
+		ipv6   bool
+		want   string
+	}{
+		{"10.0.0.1", true, "dnserr source ip not public"},
+		{"127.0.0.1", true, "dnserr source ip not public"},
+		{"::1", true, "dnserr source ip not public"},
+		{"fd00::1", true, "dnserr source ip not public"},
+		{"fe80::1", true, "dnserr source ip not public"},
+		{"2001:db8::42", false, "dnserr ipv6 disabled"},
+		{"2001:db8::42", true, "good 2001:db8::42"},
+		{"::ffff:" + testSourceIP, false, "good " + testSourceIP},
+	}
+	for _, c := range cases {
+		h, _ := newTestHandler(t, nil)
+		h.cfg.ipv6 = c.ipv6
+		resp, _ := h.handle(context.Background(), mkRequest(basicAuth("dddns", testSecret), testHostname, c.source))
+		if got := strings.TrimSpace(resp.Body); got != c.want {
+			t.Errorf("source %s (ipv6=%v) = %q, want %q", c.source, c.ipv6, got, c.want)
 		}
 	}
 }
 
-func TestParseBasicAuth_LowercaseHeaderKey(t *testing.T) {
-	// API Gateway normalizes header keys to lowercase before handing
-	// them to the Lambda. Verify the lookup is case-insensitive.
-	h := map[string]string{"authorization": basicAuth("dddns", testSecret)}
-	u, p, ok := parseBasicAuth(h)
-	if !ok || u != "dddns" || p != testSecret {
-		t.Errorf("lowercase header key not matched: got (%q, %q, %v)", u, p, ok)
+// TestHandler_DualStack verifies a client filling both families with
+// one request over each: each push publishes its own source address,
+// which the Route53 client writes as A or AAAA.
+func TestHandler_DualStack(t *testing.T) {
+	h, r53 := newTestHandler(t, nil)
+	h.cfg.ipv6 = true
+
+	for _, source := range []string{testSourceIP, "2001:db8::42"} {
+		resp, _ := h.handle(context.Background(), mkRequest(basicAuth("dddns", testSecret), testHostname, source))
+		if got := strings.TrimSpace(resp.Body); got != "good "+source {
+			t.Errorf("source %s = %q", source, got)
+		}
 	}
+	if len(r53.pushed) != 2 || r53.pushed[0] != testSourceIP || r53.pushed[1] != "2001:db8::42" {
+		t.Errorf("pushed = %v, want both families", r53.pushed)
+	}
+}
+
+// TestHandler_Conformance runs the dyndns conformance table, shared
+// with serve mode, against the Lambda: a single-host deployment,
+// published as the API Gateway source IP.
+func TestHandler_Conformance(t *testing.T) {
+	dyndnstest.Run(t, func(t *testing.T, opts dyndnstest.Options) dyndnstest.Target {
+		ssm := &localSSM{}
+		ssm.put("/dddns/test/shared_secret", dyndnstest.Secret)
+		h, r53 := newTestHandler(t, ssm.ServeHTTP)
+		h.cfg.hostname = dyndnstest.Hostname
+		h.cfg.requireSignature = opts.RequireSignature
+		return dyndnstest.Target{
+			Do: func(method, path, rawQuery, authorization string) (int, string) {
+				r := httptest.NewRequest(method, path+"?"+rawQuery, nil)
+				if authorization != "" {
+					r.Header.Set("Authorization", authorization)
+				}
+				resp, err := h.handle(context.Background(), apiGatewayRequest(r, testSourceIP))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return resp.StatusCode, resp.Body
+			},
+			Updates: func() int {
+				r53.mu.Lock()
+				defer r53.mu.Unlock()
+				return len(r53.pushed)
+			},
+			IP:     testSourceIP,
+			DryRun: true,
+		}
+	})
 }
@@ -0,0 +1,206 @@
+//go:build lambdalocal
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/descoped/dddns/internal/dns"
+	"github.com/descoped/dddns/internal/lockout"
+	"github.com/descoped/dddns/internal/reqsign"
+)
+
+func init() { localMain = serveLocal }
+
+// paramFlags collects repeated -param NAME=VALUE flags.
+type paramFlags map[string]string
+
+func (p paramFlags) String() string { return fmt.Sprint(map[string]string(p)) }
+
+func (p paramFlags) Set(v string) error {
+	name, value, ok := strings.Cut(v, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("want NAME=VALUE, got %q", v)
+	}
+	p[name] = value
+	return nil
+}
+
+// envOr returns the env var name, or def when it is unset.
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// serveLocal runs the handler behind a local HTTP listener until
+// SIGINT or SIGTERM. SSM and Route53 are in-memory stand-ins unless
+// an -ssm-endpoint or -route53-endpoint points at a local fake such as
+// LocalStack; -dynamodb-endpoint and -table add the update store and
+// the auth-failure lockout, on DynamoDB Local for instance.
+func serveLocal(logger *slog.Logger) error {
+	fs := flag.NewFlagSet("dddns-lambda-local", flag.ContinueOnError)
+	var (
+		listen       = fs.String("listen", "127.0.0.1:8053", "address the emulated API Gateway listens on")
+		hostname     = fs.String("hostname", "home.example.com", "hostname a single-host deployment serves")
+		zone         = fs.String("zone", "ZLOCAL", "hosted zone ID of -hostname")
+		ttl          = fs.Int64("ttl", 300, "record TTL in seconds")
+		secret       = fs.String("secret", os.Getenv("DDDNS_LOCAL_SECRET"), "shared secret of -hostname, seeded at -secret-param (default $DDDNS_LOCAL_SECRET)")
+		secretParam  = fs.String("secret-param", "/dddns/local/shared_secret", "SSM parameter holding the shared secret")
+		configFile   = fs.String("config", "", "dddns config.yaml to take the deployment's settings from, as DDDNS_CONFIG_PARAM would")
+		routesFile   = fs.String("routes", "", "routing table JSON file, seeded at -routes-param (multi-host)")
+		routesParam  = fs.String("routes-param", "", "SSM parameter or path of the routing table (default /dddns/local/routes with -routes)")
+		sourceIP     = fs.String("source-ip", "", "source IP every request appears to come from (default the peer; a loopback peer is not public)")
+		ipv6         = fs.Bool("ipv6", false, "publish IPv6 sources as AAAA")
+		requireSig   = fs.Bool("require-signature", false, "accept only HMAC-signed requests")
+		ssmEndpoint  = fs.String("ssm-endpoint", "", "SSM endpoint URL instead of the in-memory one")
+		r53Endpoint  = fs.String("route53-endpoint", "", "Route53 endpoint URL instead of the in-memory stand-in")
+		ddbEndpoint  = fs.String("dynamodb-endpoint", "", "DynamoDB endpoint URL of -table")
+		table        = fs.String("table", "", "DynamoDB table for the update store and the auth-failure lockout")
+		params       = paramFlags{}
+		lockoutTable string
+	)
+	fs.Var(params, "param", "NAME=VALUE seeded into the in-memory SSM, such as a route's secret (repeatable)")
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		return err
+	}
+	if *table != "" {
+		if *ddbEndpoint == "" {
+			return errors.New("-table needs -dynamodb-endpoint")
+		}
+		lockoutTable = *table
+	}
+
+	cfg := &config{
+		region:           envOr("AWS_REGION", "us-east-1"),
+		accessKey:        envOr("AWS_ACCESS_KEY_ID", "local"),
+		secretKey:        envOr("AWS_SECRET_ACCESS_KEY", "local"),
+		sessionToken:     os.Getenv("AWS_SESSION_TOKEN"),
+		hostedZoneID:     *zone,
+		hostname:         *hostname,
+		ssmSecretParam:   *secretParam,
+		ttl:              *ttl,
+		routesParam:      *routesParam,
+		requireSignature: *requireSig,
+		ipv6:             *ipv6,
+		dynamoTable:      *table,
+		historyTTL:       24 * time.Hour,
+		lockoutTable:     lockoutTable,
+		lockoutRule:      lockout.DefaultRule(),
+	}
+	if *routesFile != "" && cfg.routesParam == "" {
+		cfg.routesParam = "/dddns/local/routes"
+	}
+	if *configFile != "" {
+		doc, err := os.ReadFile(*configFile)
+		if err != nil {
+			return err
+		}
+		if err := applyConfigDocument(cfg, doc, logger); err != nil {
+			return err
+		}
+	}
+	conn := awsConn{
+		region:       cfg.region,
+		accessKey:    cfg.accessKey,
+		secretKey:    cfg.secretKey,
+		sessionToken: cfg.sessionToken,
+		httpClient:   http.DefaultClient,
+		now:          time.Now,
+	}
+
+	ssmConn := conn
+	ssmConn.endpoint = *ssmEndpoint
+	if *ssmEndpoint == "" {
+		fake := &localSSM{}
+		for name, value := range params {
+			fake.put(name, value)
+		}
+		if *routesFile != "" {
+			raw, err := os.ReadFile(*routesFile)
+			if err != nil {
+				return err
+			}
+			fake.put(cfg.routesParam, string(raw))
+		} else if cfg.routesParam == "" && cfg.ssmSecretParam != configSecretRef {
+			if *secret == "" {
+				return errors.New("-secret (or DDDNS_LOCAL_SECRET) is required for a single-host deployment")
+			}
+			fake.put(cfg.ssmSecretParam, *secret)
+		}
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		go func() { _ = http.Serve(ln, fake) }()
+		ssmConn.endpoint = "http://" + ln.Addr().String() + "/"
+	}
+
+	emu := &localEmulator{sourceIP: *sourceIP}
+	var newDNS func(route) (dnsClient, error)
+	if *r53Endpoint == "" {
+		emu.records = &localRoute53{logger: logger}
+		newDNS = emu.records.forRoute
+	} else {
+		newDNS = func(r route) (dnsClient, error) {
+			c, err := dns.NewRoute53Client(context.Background(),
+				cfg.region, cfg.accessKey, cfg.secretKey, cfg.sessionToken,
+				r.HostedZoneID, r.Hostname, r.TTL)
+			if err != nil {
+				return nil, err
+			}
+			c.SetEndpoint(*r53Endpoint)
+			return c, nil
+		}
+	}
+
+	var store *updateStore
+	var authLockout *lockoutStore
+	if *table != "" {
+		ddbConn := conn
+		ddbConn.endpoint = *ddbEndpoint
+		db := &dynamoClient{awsConn: ddbConn, table: *table}
+		store = &updateStore{db: db, historyTTL: cfg.historyTTL, now: time.Now}
+		authLockout = &lockoutStore{db: db, rule: cfg.lockoutRule, now: time.Now}
+	}
+
+	emu.h = &handler{
+		cfg:         cfg,
+		newDNS:      newDNS,
+		ssm:         &ssmClient{awsConn: ssmConn},
+		secrets:     &secretsClient{awsConn: conn},
+		secretCache: &secretCache{ttl: 60 * time.Second, now: time.Now},
+		routeCache:  &routeCache{ttl: 60 * time.Second, now: time.Now},
+		store:       store,
+		lockout:     authLockout,
+		verifier:    reqsign.NewVerifier(0, 0),
+		logger:      logger,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	srv := &http.Server{Addr: *listen, Handler: emu, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	logger.Info("local emulator listening", slog.String("addr", *listen))
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
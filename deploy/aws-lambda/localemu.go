@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/descoped/dddns/internal/dns"
+	"github.com/descoped/dddns/internal/logging"
+)
+
+// localEmulator serves handler.handle over plain HTTP, translating each
+// request into the API Gateway HTTP API event the deployed Lambda
+// receives — so a dyndns client such as inadyn can run end-to-end
+// against the handler on a laptop. It is the core of the lambdalocal
+// build (local_main.go); the Lambda itself never starts it.
+type localEmulator struct {
+	h        *handler
+	sourceIP string        // replaces the peer address in the event; "" = the peer's
+	records  *localRoute53 // the Route53 stand-in, for GET /_local/records; nil = a real endpoint
+}
+
+func (e *localEmulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/_local/records" && e.records != nil {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(e.records.published())
+		return
+	}
+	resp, err := e.h.handle(r.Context(), apiGatewayRequest(r, e.sourceIP))
+	if err != nil {
+		// API Gateway answers an invocation error with a bare 500.
+		http.Error(w, `{"message":"Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+	for k, v := range resp.Headers {
+		w.Header().Set(k, v)
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.WriteString(w, resp.Body)
+}
+
+// apiGatewayRequest builds the payload-2.0 event API Gateway would
+// deliver for r: lowercased headers with repeated values joined by
+// commas, the raw and parsed query, and the peer address as
+// requestContext.http.sourceIp unless sourceIP overrides it.
+func apiGatewayRequest(r *http.Request, sourceIP string) events.APIGatewayV2HTTPRequest {
+	if sourceIP == "" {
+		sourceIP = r.RemoteAddr
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			sourceIP = host
+		}
+	}
+	headers := make(map[string]string, len(r.Header))
+	for k, v := range r.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ",")
+	}
+	if r.Host != "" {
+		headers["host"] = r.Host
+	}
+	var query map[string]string
+	if q := r.URL.Query(); len(q) > 0 {
+		query = make(map[string]string, len(q))
+		for k, v := range q {
+			query[k] = strings.Join(v, ",")
+		}
+	}
+	now := time.Now()
+	req := events.APIGatewayV2HTTPRequest{
+		Version:               "2.0",
+		RouteKey:              r.Method + " " + r.URL.Path,
+		RawPath:               r.URL.EscapedPath(),
+		RawQueryString:        r.URL.RawQuery,
+		Headers:               headers,
+		QueryStringParameters: query,
+	}
+	req.RequestContext.DomainName = r.Host
+	req.RequestContext.Stage = "$default"
+	req.RequestContext.TimeEpoch = now.UnixMilli()
+	req.RequestContext.Time = now.UTC().Format("02/Jan/2006:15:04:05 -0700")
+	req.RequestContext.HTTP = events.APIGatewayV2HTTPRequestContextHTTPDescription{
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Protocol:  r.Proto,
+		SourceIP:  sourceIP,
+		UserAgent: r.UserAgent(),
+	}
+	return req
+}
+
+// localSSM is an in-memory SSM endpoint: GetParameter and
+// GetParametersByPath over the AWS JSON protocol, so the handler's own
+// ssmClient (and its caches) run unchanged against it.
+type localSSM struct {
+	mu     sync.Mutex
+	params map[string]string
+}
+
+func (s *localSSM) put(name, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.params == nil {
+		s.params = map[string]string{}
+	}
+	s.params[name] = value
+}
+
+func (s *localSSM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", ssmAPI.contentType)
+	var out any
+	switch r.Header.Get("X-Amz-Target") {
+	case ssmAPI.target + "GetParameter":
+		var in getParameterRequest
+		_ = json.NewDecoder(r.Body).Decode(&in)
+		v, ok := s.params[in.Name]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = fmt.Fprintf(w, `{"__type":"ParameterNotFound","message":"%s"}`, in.Name)
+			return
+		}
+		var resp getParameterResponse
+		resp.Parameter.Name, resp.Parameter.Value, resp.Parameter.Type = in.Name, v, "SecureString"
+		out = resp
+	case ssmAPI.target + "GetParametersByPath":
+		var in getParametersByPathRequest
+		_ = json.NewDecoder(r.Body).Decode(&in)
+		var resp getParametersByPathResponse
+		for name, v := range s.params {
+			if strings.HasPrefix(name, in.Path) {
+				resp.Parameters = append(resp.Parameters, ssmParameter{Name: name, Value: v})
+			}
+		}
+		sort.Slice(resp.Parameters, func(i, j int) bool { return resp.Parameters[i].Name < resp.Parameters[j].Name })
+		out = resp
+	default:
+		http.Error(w, `{"__type":"InvalidAction"}`, http.StatusBadRequest)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// localRoute53 is the in-memory Route53 stand-in: each route gets a
+// dnsClient that records, rather than UPSERTs, the published address.
+type localRoute53 struct {
+	mu      sync.Mutex
+	records map[string]localRecord // by "<hostname> <A|AAAA>"
+	logger  *slog.Logger
+}
+
+// localRecord is one published record, as GET /_local/records lists it.
+type localRecord struct {
+	Name         string `json:"name"`
+	Type         string `json:"type"`
+	Value        string `json:"value"`
+	TTL          int64  `json:"ttl"`
+	HostedZoneID string `json:"hosted_zone_id"`
+}
+
+// forRoute drops into handler.newDNS.
+func (r *localRoute53) forRoute(rt route) (dnsClient, error) {
+	return &localRecordClient{r: r, rt: rt}, nil
+}
+
+type localRecordClient struct {
+	r  *localRoute53
+	rt route
+}
+
+func (c *localRecordClient) UpdateIP(ctx context.Context, ip string) error {
+	rec := localRecord{Name: c.rt.Hostname, Type: dns.RecordType(ip), Value: ip, TTL: c.rt.TTL, HostedZoneID: c.rt.HostedZoneID}
+	c.r.mu.Lock()
+	if c.r.records == nil {
+		c.r.records = map[string]localRecord{}
+	}
+	c.r.records[rec.Name+" "+rec.Type] = rec
+	c.r.mu.Unlock()
+	logging.OrDefault(c.r.logger).LogAttrs(ctx, logging.LevelNotice, "route53 stand-in UPSERT",
+		slog.String(logging.KeyHostname, rec.Name),
+		slog.String("type", rec.Type),
+		slog.String(logging.KeyNewIP, ip))
+	return nil
+}
+
+// published lists the records UPSERTed so far, by name and type.
+func (r *localRoute53) published() []localRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]localRecord, 0, len(r.records))
+	for _, rec := range r.records {
+		out = append(out, rec)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].Type < out[j].Type
+	})
+	return out
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/descoped/dddns/internal/logging"
+	"github.com/descoped/dddns/internal/reqsign"
+)
+
+func TestAPIGatewayRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "http://dyn.example.net/nic/update?hostname=a.example.com&myip=1.2.3.4&x=1&x=2", nil)
+	r.RemoteAddr = "198.51.100.4:51000"
+	r.Header.Set("Authorization", "Basic Zm9vOmJhcg==")
+	r.Header.Set("User-Agent", "inadyn/2.12")
+
+	req := apiGatewayRequest(r, "")
+	if got := req.RequestContext.HTTP.SourceIP; got != "198.51.100.4" {
+		t.Errorf("SourceIP = %q, want the peer's host", got)
+	}
+	if got := req.Headers["authorization"]; got != "Basic Zm9vOmJhcg==" {
+		t.Errorf("authorization header = %q", got)
+	}
+	if got := req.Headers["host"]; got != "dyn.example.net" {
+		t.Errorf("host header = %q", got)
+	}
+	if got := req.QueryStringParameters["x"]; got != "1,2" {
+		t.Errorf("repeated query value = %q, want comma-joined", got)
+	}
+	if req.RawPath != "/nic/update" || req.RequestContext.HTTP.Method != http.MethodGet || req.RequestContext.HTTP.UserAgent != "inadyn/2.12" {
+		t.Errorf("request line not carried over: %+v", req.RequestContext.HTTP)
+	}
+	if requestPath(req) != "/nic/update" || requestQuery(req).Get("hostname") != "a.example.com" {
+		t.Error("handler helpers do not see the path and query")
+	}
+
+	if got := apiGatewayRequest(r, "203.0.113.50").RequestContext.HTTP.SourceIP; got != "203.0.113.50" {
+		t.Errorf("overridden SourceIP = %q", got)
+	}
+}
+
+// TestLocalEmulator runs a push through the emulator, with the handler
+// reading its secret from the in-memory SSM and publishing to the
+// Route53 stand-in.
+func TestLocalEmulator(t *testing.T) {
+	fake := &localSSM{}
+	fake.put("/dddns/local/shared_secret", testSecret)
+	ssmSrv := httptest.NewServer(fake)
+	t.Cleanup(ssmSrv.Close)
+
+	records := &localRoute53{logger: logging.Discard()}
+	cfg := &config{
+		region:         "us-east-1",
+		accessKey:      "local",
+		secretKey:      "local",
+		hostedZoneID:   testZoneID,
+		hostname:       testHostname,
+		ssmSecretParam: "/dddns/local/shared_secret",
+		ttl:            300,
+	}
+	emu := &localEmulator{
+		sourceIP: testSourceIP,
+		records:  records,
+		h: &handler{
+			cfg:         cfg,
+			newDNS:      records.forRoute,
+			ssm:         &ssmClient{awsConn: awsConn{region: cfg.region, accessKey: "local", secretKey: "local", httpClient: ssmSrv.Client(), endpoint: ssmSrv.URL, now: time.Now}},
+			secretCache: &secretCache{ttl: time.Minute, now: time.Now},
+			routeCache:  &routeCache{ttl: time.Minute, now: time.Now},
+			verifier:    reqsign.NewVerifier(0, 0),
+			logger:      logging.Discard(),
+		},
+	}
+	srv := httptest.NewServer(emu)
+	t.Cleanup(srv.Close)
+
+	get := func(pass string) string {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/nic/update?hostname="+testHostname, nil)
+		req.SetBasicAuth("dddns", pass)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, _ := io.ReadAll(resp.Body)
+		return strings.TrimSpace(string(body))
+	}
+	if got := get("wrong-secret"); got != "badauth" {
+		t.Errorf("wrong secret = %q, want badauth", got)
+	}
+	if got := get(testSecret); got != "good "+testSourceIP {
+		t.Fatalf("right secret = %q, want good", got)
+	}
+
+	resp, err := srv.Client().Get(srv.URL + "/_local/records")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var got []localRecord
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := localRecord{Name: testHostname, Type: "A", Value: testSourceIP, TTL: 300, HostedZoneID: testZoneID}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("published records = %+v, want [%+v]", got, want)
+	}
+}
+
+func TestLocalSSM_GetParametersByPath(t *testing.T) {
+	fake := &localSSM{}
+	fake.put("/dddns/routes/b", "2")
+	fake.put("/dddns/routes/a", "1")
+	fake.put("/other", "x")
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	c := &ssmClient{awsConn: awsConn{region: "us-east-1", accessKey: "local", secretKey: "local", httpClient: srv.Client(), endpoint: srv.URL, now: time.Now}}
+	params, err := c.getParametersByPath(t.Context(), "/dddns/routes/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(params) != 2 || params[0].Name != "/dddns/routes/a" || params[1].Value != "2" {
+		t.Errorf("params = %+v", params)
+	}
+	if _, err := c.getParameter(t.Context(), "/missing"); err == nil || !strings.Contains(err.Error(), "ParameterNotFound") {
+		t.Errorf("missing parameter error = %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/descoped/dddns/internal/lockout"
+)
+
+// lockoutStore keeps the auth-failure lockout of each client (source
+// IPv4 address or IPv6 /64, see lockout.Key) in DynamoDB, so it holds
+// across invocations and containers, which share no memory. The policy
+// is internal/lockout's, the same serve mode's Authenticator applies.
+//
+// Item layout (same key schema and TTL attribute as the update store,
+// whose table it may share — '#' cannot occur in a hostname pk):
+//
+//	pk=lockout#<client>  sk=state   state (JSON lockout.State), version, expires_at
+//
+// Writes are optimistic: each carries the version it read, so failures
+// recorded concurrently by two containers are both counted. DynamoDB
+// drops an item at expires_at, once neither a failure nor the lockout
+// matters any more.
+type lockoutStore struct {
+	db   *dynamoClient
+	rule lockout.Rule
+	now  func() time.Time
+}
+
+// Condition expressions of a lockout write: the item is still at the
+// version the state was read from.
+const (
+	condNoVersion = "attribute_not_exists(#ver)"
+	condVersionIs = "#ver = :ver"
+)
+
+// lockoutAttempts bounds fail's optimistic retries. Contention needs
+// concurrent failures from one client, which reserved concurrency caps.
+const lockoutAttempts = 8
+
+func lockoutKey(client string) item {
+	return item{"pk": str("lockout#" + client), "sk": str("state")}
+}
+
+// load returns client's lockout state and the version it was read at,
+// 0 when there is none.
+func (s *lockoutStore) load(ctx context.Context, client string) (lockout.State, int64, error) {
+	var st lockout.State
+	it, err := s.db.getItem(ctx, lockoutKey(client))
+	if err != nil || it == nil {
+		return st, 0, err
+	}
+	version, _ := strconv.ParseInt(it["version"]["N"], 10, 64)
+	if err := json.Unmarshal([]byte(it.str("state")), &st); err != nil {
+		return st, 0, fmt.Errorf("lockout state of %s: %w", client, err)
+	}
+	return st, version, nil
+}
+
+// versionCond is the condition that the item is still at version.
+func versionCond(in *writeRequest, version int64) {
+	in.ExpressionAttributeNames = map[string]string{"#ver": "version"}
+	if version == 0 {
+		in.ConditionExpression = condNoVersion
+		return
+	}
+	in.ConditionExpression = condVersionIs
+	in.ExpressionAttributeValues = item{":ver": num(version)}
+}
+
+// fail records an auth failure for client and reports whether it
+// started a lockout. A write that lost a race with another container
+// is retried on the fresh state, up to lockoutAttempts times.
+func (s *lockoutStore) fail(ctx context.Context, client string) (bool, error) {
+	for range lockoutAttempts {
+		st, version, err := s.load(ctx, client)
+		if err != nil {
+			return false, err
+		}
+		locked := s.rule.Fail(&st, s.now())
+		raw, err := json.Marshal(st)
+		if err != nil {
+			return false, err
+		}
+		in := writeRequest{Key: lockoutKey(client), UpdateExpression: "SET #state = :state, #exp = :exp, #ver = :next"}
+		versionCond(&in, version)
+		in.ExpressionAttributeNames["#state"] = "state"
+		in.ExpressionAttributeNames["#exp"] = "expires_at"
+		if in.ExpressionAttributeValues == nil {
+			in.ExpressionAttributeValues = item{}
+		}
+		in.ExpressionAttributeValues[":state"] = str(string(raw))
+		in.ExpressionAttributeValues[":exp"] = num(s.rule.Expires(st).Unix() + 1)
+		in.ExpressionAttributeValues[":next"] = num(version + 1)
+		_, err = s.db.updateItem(ctx, in)
+		if !errors.Is(err, errConditionFailed) {
+			return locked, err
+		}
+	}
+	return false, errors.New("lockout state kept changing under concurrent writes")
+}
+
+// clear drops client's pending failures after a successful
+// authentication, unless the state changed since it was read at
+// version — a concurrent failure then stands.
+func (s *lockoutStore) clear(ctx context.Context, client string, version int64) error {
+	in := writeRequest{Key: lockoutKey(client)}
+	versionCond(&in, version)
+	err := s.db.deleteItem(ctx, in)
+	if errors.Is(err, errConditionFailed) {
+		return nil
+	}
+	return err
+}
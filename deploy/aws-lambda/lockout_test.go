@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/descoped/dddns/internal/lockout"
+)
+
+// newLockoutHandler wires a handler whose lockout lives in a fake
+// DynamoDB table, on a clock the test advances.
+func newLockoutHandler(t *testing.T) (*handler, *stubRoute53, *fakeDynamo, *time.Time) {
+	t.Helper()
+	h, r53 := newTestHandler(t, nil)
+	f, db := newFakeDynamo(t)
+	now := time.Now()
+	h.lockout = &lockoutStore{db: db, rule: lockout.DefaultRule(), now: func() time.Time { return now }}
+	return h, r53, f, &now
+}
+
+// TestHandler_Lockout verifies MaxFailures wrong secrets lock the
+// source out — the right secret included — for the lockout duration,
+// without affecting other sources.
+func TestHandler_Lockout(t *testing.T) {
+	h, r53, _, now := newLockoutHandler(t)
+
+	for range lockout.MaxFailures {
+		if got := push(t, h, "wrong-secret", testSourceIP); got != "badauth" {
+			t.Fatalf("wrong secret = %q", got)
+		}
+	}
+	if got := push(t, h, testSecret, testSourceIP); got != "badauth" {
+		t.Errorf("locked-out source with the right secret = %q, want badauth", got)
+	}
+	if got := push(t, h, testSecret, "198.51.100.7"); got != "good 198.51.100.7" {
+		t.Errorf("other source = %q, want good", got)
+	}
+	if len(r53.pushed) != 1 {
+		t.Errorf("Route53 UPSERTs = %v, want only the other source's", r53.pushed)
+	}
+
+	*now = now.Add(lockout.Duration)
+	if got := push(t, h, testSecret, testSourceIP); got != "good "+testSourceIP {
+		t.Errorf("after the lockout = %q, want good", got)
+	}
+}
+
+// TestHandler_Lockout_SuccessClearsFailures verifies a successful push
+// forgives earlier typos, and that a missing Authorization header is
+// not counted.
+func TestHandler_Lockout_SuccessClearsFailures(t *testing.T) {
+	h, _, f, _ := newLockoutHandler(t)
+
+	for range lockout.MaxFailures - 1 {
+		push(t, h, "wrong-secret", testSourceIP)
+	}
+	if got := push(t, h, testSecret, testSourceIP); got != "good "+testSourceIP {
+		t.Fatalf("right secret = %q", got)
+	}
+	if len(f.items) != 0 {
+		t.Errorf("lockout items after success = %v, want none", f.items)
+	}
+
+	for range lockout.MaxFailures {
+		resp, _ := h.handle(context.Background(), mkRequest("", testHostname, testSourceIP))
+		if got := strings.TrimSpace(resp.Body); got != "badauth" {
+			t.Fatalf("no auth = %q", got)
+		}
+	}
+	push(t, h, "wrong-secret", testSourceIP)
+	if got := push(t, h, testSecret, testSourceIP); got != "nochg "+testSourceIP && got != "good "+testSourceIP {
+		t.Errorf("after unauthenticated requests = %q, want not locked out", got)
+	}
+}
+
+// TestHandler_Lockout_ConcurrentFailures verifies failures recorded by
+// concurrent invocations are all counted.
+func TestHandler_Lockout_ConcurrentFailures(t *testing.T) {
+	h, _, f, _ := newLockoutHandler(t)
+
+	var wg sync.WaitGroup
+	for range lockout.MaxFailures - 1 {
+		wg.Go(func() { push(t, h, "wrong-secret", testSourceIP) })
+	}
+	wg.Wait()
+
+	st, _, err := h.lockout.load(context.Background(), lockout.Key(testSourceIP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Failures) != lockout.MaxFailures-1 {
+		t.Errorf("recorded failures = %d, want %d (%v)", len(st.Failures), lockout.MaxFailures-1, f.items)
+	}
+}
+
+// TestHandler_Lockout_IPv6Prefix verifies an IPv6 client cannot escape
+// its lockout by moving within its /64.
+func TestHandler_Lockout_IPv6Prefix(t *testing.T) {
+	h, _, _, _ := newLockoutHandler(t)
+	h.cfg.ipv6 = true
+
+	for range lockout.MaxFailures {
+		push(t, h, "wrong-secret", "2001:db8:1:2::10")
+	}
+	if got := push(t, h, testSecret, "2001:db8:1:2::99"); got != "badauth" {
+		t.Errorf("same /64 = %q, want badauth", got)
+	}
+}
+
+// TestHandler_Lockout_TableDown verifies an unreachable table degrades
+// to no lockout rather than refusing pushes.
+func TestHandler_Lockout_TableDown(t *testing.T) {
+	h, _, f, _ := newLockoutHandler(t)
+	f.fail = true
+
+	push(t, h, "wrong-secret", testSourceIP)
+	if got := push(t, h, testSecret, testSourceIP); got != "good "+testSourceIP {
+		t.Errorf("with the lockout table down = %q, want good", got)
+	}
+}
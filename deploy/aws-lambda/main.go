@@ -1,40 +1,68 @@
 // Command aws-lambda is the dddns deployment form that runs behind an
-// API Gateway HTTP API on AWS Lambda. It receives dyndns v2 push
-// requests from an upstream DDNS client (typically UniFi Dream's
-// built-in inadyn, which can't reach a same-host loopback listener
-// and needs a public HTTPS endpoint to push to) and performs the
-// Route53 UPSERT, reusing the SigV4 signer and Route53Client from
-// internal/dns.
+// API Gateway HTTP API on AWS Lambda — or a Lambda Function URL, or
+// an API Gateway REST API; the payload format is detected per request
+// (see payload.go). It receives dyndns v2 push requests from an
+// upstream DDNS client (typically UniFi Dream's built-in inadyn, which
+// can't reach a same-host loopback listener and needs a public HTTPS
+// endpoint to push to) and performs the Route53 UPSERT, reusing the
+// SigV4 signer and Route53Client from internal/dns.
 //
 // It does NOT depend on aws-sdk-go-v2. The single external runtime
 // dependency added for this deployment form is github.com/aws/aws-lambda-go,
 // which provides only the Lambda runtime wrapper + event struct
-// types — no AWS API client code. SSM GetParameter is implemented
-// here via the same hand-rolled SigV4 signer (just a different
-// service name and endpoint). Total binary size stays under 10 MB.
+// types — no AWS API client code. SSM GetParameter and the DynamoDB
+// item calls of the optional update store are implemented here via
+// the same hand-rolled SigV4 signer (just a different service name
+// and endpoint). The stripped linux/arm64 binary `just build-aws-lambda`
+// produces stays under 10 MB; CI checks it.
 //
 // Configuration is entirely driven by environment variables — nothing
 // is hard-coded to a particular account, region, hosted zone, or
 // hostname. Tofu provides the values; Lambda surfaces them via the
-// function's runtime env.
+// function's runtime env. A deployment serves either one hostname
+// (HOSTED_ZONE_ID, DDDNS_HOSTNAME, SSM_SECRET_PARAM) or the routing
+// table in DDDNS_ROUTES_PARAM (see routes.go). Either may take its
+// settings from an encrypted dddns config document, DDDNS_CONFIG_PARAM
+// or DDDNS_CONFIG_KMS (see configdoc.go).
+//
+// The binary has a second entry point: with
+// DDDNS_ENTRYPOINT=rotate-secret it is the Secrets Manager rotation
+// function for a shared secret kept there (see rotate.go).
+//
+// Built with -tags lambdalocal it is instead a local emulator that
+// serves the handler over plain HTTP with in-memory SSM and Route53
+// stand-ins, for end-to-end runs against a dyndns client on a laptop
+// (see local_main.go and localemu.go).
+//
+// Logs are slog records on stderr, JSON by default so CloudWatch Logs
+// Insights can query fields directly. DDDNS_LOG_FORMAT ("json" or
+// "text") and DDDNS_LOG_LEVEL ("debug" … "error") override. With
+// DDDNS_METRICS_NAMESPACE set, each request also writes a CloudWatch
+// Embedded Metric Format record to stdout (see emf.go).
 package main
 
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
 
 	"github.com/descoped/dddns/internal/dns"
+	"github.com/descoped/dddns/internal/lockout"
+	"github.com/descoped/dddns/internal/logging"
+	"github.com/descoped/dddns/internal/reqsign"
 )
 
-// config captures the per-deployment values the Lambda needs. All of
-// these come from the Lambda function's env vars — set via
-// terraform/opentofu at deploy time, never from a config file.
+// config captures the per-deployment values the Lambda needs. They
+// come from the Lambda function's env vars, set via terraform/opentofu
+// at deploy time — except that an encrypted dddns config document, when
+// configured, replaces the host, zone, TTL, credential and secret
+// settings it holds (see applyConfigDocument).
 type config struct {
 	region         string // AWS_REGION — provided by Lambda
 	accessKey      string // AWS_ACCESS_KEY_ID — STS creds from exec role
@@ -42,30 +70,51 @@ type config struct {
 	sessionToken   string // AWS_SESSION_TOKEN — ditto
 	hostedZoneID   string // HOSTED_ZONE_ID — Route53 zone to UPSERT into
 	hostname       string // DDDNS_HOSTNAME — record name the handler accepts
-	ssmSecretParam string // SSM_SECRET_PARAM — SSM name holding the shared secret
+	ssmSecretParam string // SSM_SECRET_PARAM — SSM name, or Secrets Manager ARN, holding the shared secret
 	ttl            int64  // DDDNS_TTL — DNS TTL seconds (default 300)
+	routesParam    string // DDDNS_ROUTES_PARAM — SSM parameter, or path ending in "/", holding the routing table
+
+	configParam      string // DDDNS_CONFIG_PARAM — SSM SecureString holding a dddns config document
+	configKMS        string // DDDNS_CONFIG_KMS — base64 KMS ciphertext of a dddns config document
+	sharedSecret     string // the config document's server.shared_secret; see configSecretRef
+	route53AccessKey string // the config document's aws_access_key; empty = the exec role's
+	route53SecretKey string // the config document's aws_secret_key
+
+	requireSignature bool // DDDNS_REQUIRE_SIGNATURE — reject Basic Auth, accept only HMAC-signed requests
+	ipv6             bool // DDDNS_IPV6 — publish IPv6 sources as AAAA (dual-stack endpoint)
+
+	dynamoTable string        // DDDNS_DYNAMODB_TABLE — update store for nochg and history; empty = none
+	historyTTL  time.Duration // DDDNS_HISTORY_DAYS — update history retention (default 30, 0 = no history)
+
+	lockoutTable string       // DDDNS_LOCKOUT_TABLE — DynamoDB table for the auth-failure lockout; empty = none
+	lockoutRule  lockout.Rule // DDDNS_MAX_AUTH_FAILURES, DDDNS_AUTH_FAILURE_WINDOW, DDDNS_LOCKOUT_DURATION — default lockout.DefaultRule
+
+	metricsNamespace string // DDDNS_METRICS_NAMESPACE — CloudWatch namespace of the EMF records; empty = none
+	functionName     string // AWS_LAMBDA_FUNCTION_NAME — provided by Lambda; the metrics' Function dimension
 }
 
-func loadConfig() (*config, error) {
-	required := func(name string) (string, error) {
-		v := os.Getenv(name)
-		if v == "" {
-			return "", fmt.Errorf("required env var %s is unset", name)
-		}
-		return v, nil
+func required(name string) (string, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return "", fmt.Errorf("required env var %s is unset", name)
 	}
+	return v, nil
+}
 
+// loadConn reads the region and the exec role's credentials, which
+// both entry points need.
+func loadConn() (awsConn, error) {
 	region, err := required("AWS_REGION")
 	if err != nil {
-		return nil, err
+		return awsConn{}, err
 	}
 	ak, err := required("AWS_ACCESS_KEY_ID")
 	if err != nil {
-		return nil, err
+		return awsConn{}, err
 	}
 	sk, err := required("AWS_SECRET_ACCESS_KEY")
 	if err != nil {
-		return nil, err
+		return awsConn{}, err
 	}
 	// AWS_SESSION_TOKEN is required on Lambda (the exec role hands out
 	// temporary creds); we treat an empty value as a configuration error
@@ -73,19 +122,43 @@ func loadConfig() (*config, error) {
 	// unsigned requests.
 	st, err := required("AWS_SESSION_TOKEN")
 	if err != nil {
-		return nil, err
+		return awsConn{}, err
 	}
-	zone, err := required("HOSTED_ZONE_ID")
-	if err != nil {
-		return nil, err
-	}
-	host, err := required("DDDNS_HOSTNAME")
+	return awsConn{
+		region:       region,
+		accessKey:    ak,
+		secretKey:    sk,
+		sessionToken: st,
+		httpClient:   http.DefaultClient,
+		now:          time.Now,
+	}, nil
+}
+
+func loadConfig() (*config, error) {
+	conn, err := loadConn()
 	if err != nil {
 		return nil, err
 	}
-	ssmParam, err := required("SSM_SECRET_PARAM")
-	if err != nil {
-		return nil, err
+	// With a routing table the zone, hostname and secret come from
+	// SSM per route; with a config document main fills them in from
+	// it (with both, the document holds the deployment-wide settings);
+	// otherwise this deployment serves one hostname.
+	routesParam := os.Getenv("DDDNS_ROUTES_PARAM")
+	configParam, configKMS := os.Getenv("DDDNS_CONFIG_PARAM"), os.Getenv("DDDNS_CONFIG_KMS")
+	zone, host, ssmParam := os.Getenv("HOSTED_ZONE_ID"), os.Getenv("DDDNS_HOSTNAME"), os.Getenv("SSM_SECRET_PARAM")
+	switch {
+	case configParam != "" && configKMS != "":
+		return nil, fmt.Errorf("set DDDNS_CONFIG_PARAM or DDDNS_CONFIG_KMS, not both")
+	case configParam == "" && configKMS == "" && routesParam == "":
+		if zone, err = required("HOSTED_ZONE_ID"); err != nil {
+			return nil, err
+		}
+		if host, err = required("DDDNS_HOSTNAME"); err != nil {
+			return nil, err
+		}
+		if ssmParam, err = required("SSM_SECRET_PARAM"); err != nil {
+			return nil, err
+		}
 	}
 
 	// Optional knobs.
@@ -97,34 +170,131 @@ func loadConfig() (*config, error) {
 		}
 	}
 
+	requireSig, _ := strconv.ParseBool(os.Getenv("DDDNS_REQUIRE_SIGNATURE"))
+	ipv6, _ := strconv.ParseBool(os.Getenv("DDDNS_IPV6"))
+
+	historyDays := 30
+	if v := os.Getenv("DDDNS_HISTORY_DAYS"); v != "" {
+		parsed, perr := strconv.Atoi(v)
+		if perr != nil || parsed < 0 {
+			return nil, fmt.Errorf("DDDNS_HISTORY_DAYS %q must be a non-negative number of days", v)
+		}
+		historyDays = parsed
+	}
+
+	rule := lockout.DefaultRule()
+	if v := os.Getenv("DDDNS_MAX_AUTH_FAILURES"); v != "" {
+		parsed, perr := strconv.Atoi(v)
+		if perr != nil || parsed < 1 {
+			return nil, fmt.Errorf("DDDNS_MAX_AUTH_FAILURES %q must be a positive number", v)
+		}
+		rule.MaxFailures = parsed
+	}
+	for _, d := range []struct {
+		env string
+		dst *time.Duration
+	}{
+		{"DDDNS_AUTH_FAILURE_WINDOW", &rule.FailureWindow},
+		{"DDDNS_LOCKOUT_DURATION", &rule.Duration},
+	} {
+		if v := os.Getenv(d.env); v != "" {
+			parsed, perr := time.ParseDuration(v)
+			if perr != nil || parsed <= 0 {
+				return nil, fmt.Errorf("%s %q must be a positive duration such as 60s or 5m", d.env, v)
+			}
+			*d.dst = parsed
+		}
+	}
+
 	return &config{
-		region:         region,
-		accessKey:      ak,
-		secretKey:      sk,
-		sessionToken:   st,
+		region:         conn.region,
+		accessKey:      conn.accessKey,
+		secretKey:      conn.secretKey,
+		sessionToken:   conn.sessionToken,
 		hostedZoneID:   zone,
 		hostname:       host,
 		ssmSecretParam: ssmParam,
 		ttl:            ttl,
+		routesParam:    routesParam,
+
+		configParam: configParam,
+		configKMS:   configKMS,
+
+		requireSignature: requireSig,
+		ipv6:             ipv6,
+
+		dynamoTable: os.Getenv("DDDNS_DYNAMODB_TABLE"),
+		historyTTL:  time.Duration(historyDays) * 24 * time.Hour,
+
+		lockoutTable: os.Getenv("DDDNS_LOCKOUT_TABLE"),
+		lockoutRule:  rule,
+
+		metricsNamespace: os.Getenv("DDDNS_METRICS_NAMESPACE"),
+		functionName:     os.Getenv("AWS_LAMBDA_FUNCTION_NAME"),
 	}, nil
 }
 
+// newLogger builds the handler's logger from DDDNS_LOG_FORMAT and
+// DDDNS_LOG_LEVEL.
+func newLogger() (*slog.Logger, error) {
+	format := os.Getenv("DDDNS_LOG_FORMAT")
+	if format == "" {
+		format = logging.FormatJSON
+	}
+	level, err := logging.ParseLevel(os.Getenv("DDDNS_LOG_LEVEL"))
+	if err != nil {
+		return nil, fmt.Errorf("DDDNS_LOG_LEVEL: %w", err)
+	}
+	l, err := logging.New(os.Stderr, format, level)
+	if err != nil {
+		return nil, fmt.Errorf("DDDNS_LOG_FORMAT: %w", err)
+	}
+	return l.With("component", "lambda"), nil
+}
+
+// localMain replaces lambda.Start when set; only the lambdalocal build
+// (local_main.go) sets it.
+var localMain func(logger *slog.Logger) error
+
+// fatal logs msg with err and exits; Lambda reports the init failure.
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, logging.Err(err))
+	os.Exit(1)
+}
+
 func main() {
-	cfg, err := loadConfig()
+	logger, err := newLogger()
 	if err != nil {
-		log.Fatalf("dddns-lambda: config load failed: %v", err)
+		fallback, _ := logging.New(os.Stderr, logging.FormatJSON, slog.LevelInfo)
+		fatal(fallback, "logger init failed", err)
+	}
+	slog.SetDefault(logger)
+
+	if localMain != nil {
+		if err := localMain(logger); err != nil {
+			fatal(logger, "local emulator failed", err)
+		}
+		return
 	}
 
-	route53, err := dns.NewRoute53Client(
-		context.Background(),
-		cfg.region, cfg.accessKey, cfg.secretKey, cfg.sessionToken,
-		cfg.hostedZoneID, cfg.hostname, cfg.ttl,
-	)
+	// The same binary is the Secrets Manager rotation function; tofu
+	// deploys it a second time with DDDNS_ENTRYPOINT=rotate-secret.
+	if os.Getenv("DDDNS_ENTRYPOINT") == "rotate-secret" {
+		conn, err := loadConn()
+		if err != nil {
+			fatal(logger, "config load failed", err)
+		}
+		r := &rotator{secrets: &secretsClient{awsConn: conn}, newSecret: newRandomSecret, logger: logger}
+		lambda.Start(r.handle)
+		return
+	}
+
+	cfg, err := loadConfig()
 	if err != nil {
-		log.Fatalf("dddns-lambda: Route53 client init failed: %v", err)
+		fatal(logger, "config load failed", err)
 	}
 
-	ssm := &ssmClient{
+	conn := awsConn{
 		region:       cfg.region,
 		accessKey:    cfg.accessKey,
 		secretKey:    cfg.secretKey,
@@ -132,12 +302,66 @@ func main() {
 		httpClient:   http.DefaultClient,
 		now:          time.Now,
 	}
+	ssm := &ssmClient{awsConn: conn}
+	secrets := &secretsClient{awsConn: conn}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	err = loadConfigDocument(ctx, cfg, ssm, &kmsClient{awsConn: conn}, logger)
+	cancel()
+	if err != nil {
+		fatal(logger, "config load failed", err)
+	}
+
+	// A Route53 client is a few strings and an http.Client, so one is
+	// built per update for the route's zone, hostname and TTL — signed
+	// with the config document's credentials when it has them.
+	newDNS := func(r route) (dnsClient, error) {
+		ak, sk, st := cfg.accessKey, cfg.secretKey, cfg.sessionToken
+		if cfg.route53AccessKey != "" {
+			ak, sk, st = cfg.route53AccessKey, cfg.route53SecretKey, ""
+		}
+		return dns.NewRoute53Client(
+			context.Background(),
+			cfg.region, ak, sk, st,
+			r.HostedZoneID, r.Hostname, r.TTL,
+		)
+	}
+
+	var store *updateStore
+	if cfg.dynamoTable != "" {
+		store = &updateStore{
+			db:         &dynamoClient{awsConn: conn, table: cfg.dynamoTable},
+			historyTTL: cfg.historyTTL,
+			now:        time.Now,
+		}
+	}
+
+	var authLockout *lockoutStore
+	if cfg.lockoutTable != "" {
+		authLockout = &lockoutStore{
+			db:   &dynamoClient{awsConn: conn, table: cfg.lockoutTable},
+			rule: cfg.lockoutRule,
+			now:  time.Now,
+		}
+	}
+
+	var metrics *emfWriter
+	if cfg.metricsNamespace != "" {
+		metrics = &emfWriter{w: os.Stdout, namespace: cfg.metricsNamespace, function: cfg.functionName, now: time.Now}
+	}
 
 	h := &handler{
 		cfg:         cfg,
-		route53:     route53,
+		newDNS:      newDNS,
 		ssm:         ssm,
+		secrets:     secrets,
 		secretCache: &secretCache{ttl: 60 * time.Second, now: time.Now},
+		routeCache:  &routeCache{ttl: 60 * time.Second, now: time.Now},
+		store:       store,
+		lockout:     authLockout,
+		verifier:    reqsign.NewVerifier(0, 0),
+		metrics:     metrics,
+		logger:      logger,
 	}
-	lambda.Start(h.handle)
+	lambda.Start(h.invoke)
 }
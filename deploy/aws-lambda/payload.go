@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// The event shapes the Lambda can be invoked with. An HTTP API with
+// payload format 2.0 and a Function URL deliver the same JSON — a
+// Function URL's domain is <id>.lambda-url.<region>.on.aws — and both
+// take the same response. A REST API, or an HTTP API integration left
+// at payload format 1.0, delivers the proxy event of version 1.0.
+const (
+	payloadHTTPAPI     = "http-api"     // API Gateway HTTP API, payload format 2.0
+	payloadFunctionURL = "function-url" // Lambda Function URL
+	payloadREST        = "rest-api"     // API Gateway REST API (or payload format 1.0)
+)
+
+// payloadProbe holds just enough of an event to tell the shapes apart.
+type payloadProbe struct {
+	Version        string `json:"version"`
+	HTTPMethod     string `json:"httpMethod"`
+	RequestContext struct {
+		DomainName string `json:"domainName"`
+	} `json:"requestContext"`
+}
+
+// payloadFormat names the shape of event: version 1.0 events, and
+// REST API events that carry no version at all, have a top-level
+// httpMethod; version 2.0 ones do not.
+func payloadFormat(event json.RawMessage) (string, error) {
+	var p payloadProbe
+	if err := json.Unmarshal(event, &p); err != nil {
+		return "", fmt.Errorf("decode event: %w", err)
+	}
+	switch {
+	case p.HTTPMethod != "" || p.Version == "1.0":
+		return payloadREST, nil
+	case p.Version == "2.0" && strings.Contains(p.RequestContext.DomainName, ".lambda-url."):
+		return payloadFunctionURL, nil
+	case p.Version == "2.0":
+		return payloadHTTPAPI, nil
+	}
+	return "", fmt.Errorf("unsupported event (version %q): not an API Gateway or Function URL request", p.Version)
+}
+
+// invoke is the Lambda entry point: it detects the event's payload
+// format, runs handle on its payload-2.0 form, and answers in the
+// format the caller expects.
+func (h *handler) invoke(ctx context.Context, event json.RawMessage) (any, error) {
+	format, err := payloadFormat(event)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case payloadREST:
+		var v1 events.APIGatewayProxyRequest
+		if err := json.Unmarshal(event, &v1); err != nil {
+			return nil, fmt.Errorf("decode %s event: %w", format, err)
+		}
+		resp, err := h.handle(ctx, fromRESTRequest(v1))
+		if err != nil {
+			return nil, err
+		}
+		return events.APIGatewayProxyResponse{
+			StatusCode: resp.StatusCode,
+			Headers:    resp.Headers,
+			Body:       resp.Body,
+		}, nil
+	default:
+		// A Function URL event decodes as the HTTP API one field for
+		// field: requestContext.http.sourceIp is the caller's address
+		// in both, and the response shapes match too.
+		var v2 events.APIGatewayV2HTTPRequest
+		if err := json.Unmarshal(event, &v2); err != nil {
+			return nil, fmt.Errorf("decode %s event: %w", format, err)
+		}
+		return h.handle(ctx, v2)
+	}
+}
+
+// fromRESTRequest translates a REST API (version 1.0) proxy event into
+// the payload-2.0 form handle reads. The source IP is
+// requestContext.identity.sourceIp, which API Gateway takes from the
+// TCP peer as HTTP APIs do. The path is requestContext.path, the one
+// the client requested — stage prefix included — so a signature over
+// it verifies; the top-level path lacks the stage. REST events carry
+// no raw query string, so it is re-encoded from the decoded values.
+func fromRESTRequest(v1 events.APIGatewayProxyRequest) events.APIGatewayV2HTTPRequest {
+	headers := make(map[string]string, len(v1.Headers))
+	for k, v := range v1.Headers {
+		headers[strings.ToLower(k)] = v
+	}
+	for k, vs := range v1.MultiValueHeaders {
+		headers[strings.ToLower(k)] = strings.Join(vs, ",")
+	}
+
+	query := url.Values{}
+	for k, v := range v1.QueryStringParameters {
+		query.Set(k, v)
+	}
+	for k, vs := range v1.MultiValueQueryStringParameters {
+		query[k] = vs
+	}
+	var params map[string]string
+	if len(query) > 0 {
+		params = make(map[string]string, len(query))
+		for k, vs := range query {
+			params[k] = strings.Join(vs, ",")
+		}
+	}
+
+	path := v1.RequestContext.Path
+	if path == "" {
+		path = v1.Path
+	}
+
+	req := events.APIGatewayV2HTTPRequest{
+		Version:               "2.0",
+		RouteKey:              v1.HTTPMethod + " " + v1.Resource,
+		RawPath:               path,
+		RawQueryString:        query.Encode(),
+		Headers:               headers,
+		QueryStringParameters: params,
+		Body:                  v1.Body,
+		IsBase64Encoded:       v1.IsBase64Encoded,
+	}
+	req.RequestContext.AccountID = v1.RequestContext.AccountID
+	req.RequestContext.APIID = v1.RequestContext.APIID
+	req.RequestContext.DomainName = v1.RequestContext.DomainName
+	req.RequestContext.RequestID = v1.RequestContext.RequestID
+	req.RequestContext.Stage = v1.RequestContext.Stage
+	req.RequestContext.TimeEpoch = v1.RequestContext.RequestTimeEpoch
+	req.RequestContext.HTTP = events.APIGatewayV2HTTPRequestContextHTTPDescription{
+		Method:    v1.HTTPMethod,
+		Path:      path,
+		Protocol:  v1.RequestContext.Protocol,
+		SourceIP:  v1.RequestContext.Identity.SourceIP,
+		UserAgent: v1.RequestContext.Identity.UserAgent,
+	}
+	return req
+}
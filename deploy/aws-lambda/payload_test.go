@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/descoped/dddns/internal/reqsign"
+)
+
+// Trimmed events as each integration delivers a GET
+// /nic/update?hostname=… from testSourceIP.
+func httpAPIEvent(auth string) string {
+	return fmt.Sprintf(`{
+		"version": "2.0",
+		"routeKey": "GET /nic/update",
+		"rawPath": "/nic/update",
+		"rawQueryString": "hostname=%[1]s&myip=198.51.100.1",
+		"headers": {"authorization": %[2]q, "host": "abc123.execute-api.eu-north-1.amazonaws.com"},
+		"queryStringParameters": {"hostname": %[1]q, "myip": "198.51.100.1"},
+		"requestContext": {
+			"domainName": "abc123.execute-api.eu-north-1.amazonaws.com",
+			"http": {"method": "GET", "path": "/nic/update", "sourceIp": %[3]q}
+		}
+	}`, testHostname, auth, testSourceIP)
+}
+
+func functionURLEvent(auth string) string {
+	return fmt.Sprintf(`{
+		"version": "2.0",
+		"routeKey": "$default",
+		"rawPath": "/nic/update",
+		"rawQueryString": "hostname=%[1]s",
+		"headers": {"authorization": %[2]q},
+		"queryStringParameters": {"hostname": %[1]q},
+		"requestContext": {
+			"domainName": "x7kq2abc.lambda-url.eu-north-1.on.aws",
+			"http": {"method": "GET", "path": "/nic/update", "sourceIp": %[3]q}
+		}
+	}`, testHostname, auth, testSourceIP)
+}
+
+func restEvent(auth string) string {
+	return fmt.Sprintf(`{
+		"resource": "/nic/update",
+		"path": "/nic/update",
+		"httpMethod": "GET",
+		"headers": {"Authorization": %[2]q},
+		"multiValueHeaders": {"Authorization": [%[2]q]},
+		"queryStringParameters": {"hostname": %[1]q, "myip": "198.51.100.1"},
+		"multiValueQueryStringParameters": {"hostname": [%[1]q], "myip": ["198.51.100.1"]},
+		"requestContext": {
+			"path": "/prod/nic/update",
+			"stage": "prod",
+			"identity": {"sourceIp": %[3]q, "userAgent": "inadyn/2.12"}
+		}
+	}`, testHostname, auth, testSourceIP)
+}
+
+func TestPayloadFormat(t *testing.T) {
+	cases := map[string]string{
+		httpAPIEvent(""):                       payloadHTTPAPI,
+		functionURLEvent(""):                   payloadFunctionURL,
+		restEvent(""):                          payloadREST,
+		`{"version":"1.0","httpMethod":"GET"}`: payloadREST,
+	}
+	for event, want := range cases {
+		got, err := payloadFormat(json.RawMessage(event))
+		if err != nil || got != want {
+			t.Errorf("payloadFormat = %q, %v; want %q\n%s", got, err, want, event)
+		}
+	}
+	for _, event := range []string{`{"Records":[{"eventSource":"aws:s3"}]}`, `not json`} {
+		if got, err := payloadFormat(json.RawMessage(event)); err == nil {
+			t.Errorf("payloadFormat(%s) = %q, want an error", event, got)
+		}
+	}
+}
+
+// TestInvoke_EachPayloadFormat verifies one push per integration
+// publishes the source IP and answers in that integration's shape.
+func TestInvoke_EachPayloadFormat(t *testing.T) {
+	for name, event := range map[string]func(string) string{
+		"http-api":     httpAPIEvent,
+		"function-url": functionURLEvent,
+		"rest-api":     restEvent,
+	} {
+		t.Run(name, func(t *testing.T) {
+			h, r53 := newTestHandler(t, nil)
+
+			out, err := h.invoke(context.Background(), json.RawMessage(event(basicAuth("dddns", testSecret))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var status int
+			var body string
+			switch resp := out.(type) {
+			case events.APIGatewayProxyResponse:
+				if name != "rest-api" {
+					t.Errorf("answered with a REST response")
+				}
+				status, body = resp.StatusCode, resp.Body
+			case events.APIGatewayV2HTTPResponse:
+				if name == "rest-api" {
+					t.Errorf("answered a REST event with a payload-2.0 response")
+				}
+				status, body = resp.StatusCode, resp.Body
+			default:
+				t.Fatalf("response type %T", out)
+			}
+			if status != 200 || strings.TrimSpace(body) != "good "+testSourceIP {
+				t.Errorf("response = %d %q, want good %s", status, body, testSourceIP)
+			}
+			if len(r53.pushed) != 1 || r53.pushed[0] != testSourceIP {
+				t.Errorf("Route53 UPSERTs = %v", r53.pushed)
+			}
+		})
+	}
+}
+
+// TestInvoke_RESTSignedRequest verifies a signature over the path the
+// client requested, stage prefix included, verifies on a REST API.
+func TestInvoke_RESTSignedRequest(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+
+	nonce, err := reqsign.NewNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := url.Values{"hostname": {testHostname}, "myip": {"198.51.100.1"}}
+	authz := reqsign.Header(testSecret, "GET", "/prod/nic/update", query, time.Now(), nonce)
+	out, err := h.invoke(context.Background(), json.RawMessage(restEvent(authz)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(out.(events.APIGatewayProxyResponse).Body); got != "good "+testSourceIP {
+		t.Errorf("signed REST request = %q, want good", got)
+	}
+}
+
+func TestFromRESTRequest(t *testing.T) {
+	var v1 events.APIGatewayProxyRequest
+	if err := json.Unmarshal([]byte(restEvent("Basic eA==")), &v1); err != nil {
+		t.Fatal(err)
+	}
+	req := fromRESTRequest(v1)
+	if got := req.RequestContext.HTTP.SourceIP; got != testSourceIP {
+		t.Errorf("SourceIP = %q, want identity.sourceIp", got)
+	}
+	if got := headerValue(req.Headers, "Authorization"); got != "Basic eA==" {
+		t.Errorf("Authorization = %q", got)
+	}
+	if requestPath(req) != "/prod/nic/update" || req.RequestContext.HTTP.Method != "GET" {
+		t.Errorf("path %q, method %q", requestPath(req), req.RequestContext.HTTP.Method)
+	}
+	if req.QueryStringParameters["hostname"] != testHostname || requestQuery(req).Get("myip") != "198.51.100.1" {
+		t.Errorf("query = %v / %q", req.QueryStringParameters, req.RawQueryString)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/descoped/dddns/internal/logging"
+)
+
+// rotationEvent is the payload Secrets Manager invokes a rotation
+// function with, once per step.
+type rotationEvent struct {
+	SecretID           string `json:"SecretId"`
+	ClientRequestToken string `json:"ClientRequestToken"`
+	Step               string `json:"Step"`
+}
+
+// rotator is the rotation entry point (DDDNS_ENTRYPOINT=rotate-secret):
+// it implements the four-step Secrets Manager rotation protocol for a
+// dyndns shared secret.
+//
+//  1. createSecret stores a fresh random secret as AWSPENDING.
+//  2. setSecret has nothing to do: the dyndns Lambda is the only
+//     service holding the secret and reads it at request time.
+//  3. testSecret checks the pending value is well-formed.
+//  4. finishSecret makes it AWSCURRENT; the old value becomes
+//     AWSPREVIOUS, which the dyndns Lambda keeps accepting so push
+//     clients can be switched over until the next rotation.
+//
+// Each step is idempotent, as Secrets Manager may retry it.
+type rotator struct {
+	secrets   *secretsClient
+	newSecret func() (string, error)
+	logger    *slog.Logger // nil = slog.Default()
+}
+
+// newRandomSecret returns a 256-bit hex secret, the same shape
+// scripts/rotate-secret.sh generates.
+func newRandomSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (r *rotator) handle(ctx context.Context, ev rotationEvent) error {
+	logger := logging.OrDefault(r.logger).With("step", ev.Step, "version", ev.ClientRequestToken)
+
+	desc, err := r.secrets.describeSecret(ctx, ev.SecretID)
+	if err != nil {
+		return err
+	}
+	if !desc.RotationEnabled {
+		return fmt.Errorf("secret %s does not have rotation enabled", ev.SecretID)
+	}
+	stages, ok := desc.VersionIDsToStages[ev.ClientRequestToken]
+	if !ok {
+		return fmt.Errorf("secret %s has no version %s", ev.SecretID, ev.ClientRequestToken)
+	}
+	if slices.Contains(stages, stageCurrent) {
+		logger.Info("version is already AWSCURRENT")
+		return nil
+	}
+	if !slices.Contains(stages, stagePending) {
+		return fmt.Errorf("version %s of %s is not AWSPENDING", ev.ClientRequestToken, ev.SecretID)
+	}
+
+	switch ev.Step {
+	case "createSecret":
+		return r.create(ctx, logger, ev)
+	case "setSecret":
+		return nil
+	case "testSecret":
+		v, err := r.secrets.getSecretValue(ctx, ev.SecretID, ev.ClientRequestToken, stagePending)
+		if err != nil {
+			return err
+		}
+		if len(v) < 32 {
+			return fmt.Errorf("pending secret is %d characters, want at least 32", len(v))
+		}
+		return nil
+	case "finishSecret":
+		return r.finish(ctx, logger, ev, desc)
+	}
+	return fmt.Errorf("unknown rotation step %q", ev.Step)
+}
+
+func (r *rotator) create(ctx context.Context, logger *slog.Logger, ev rotationEvent) error {
+	if _, err := r.secrets.getSecretValue(ctx, ev.SecretID, "", stageCurrent); err != nil {
+		return err
+	}
+	_, err := r.secrets.getSecretValue(ctx, ev.SecretID, ev.ClientRequestToken, stagePending)
+	if err == nil {
+		return nil // created by an earlier attempt
+	}
+	if !isNotFound(err) {
+		return err
+	}
+	secret, err := r.newSecret()
+	if err != nil {
+		return fmt.Errorf("generate secret: %w", err)
+	}
+	if err := r.secrets.putSecretValue(ctx, ev.SecretID, ev.ClientRequestToken, secret, stagePending); err != nil {
+		return err
+	}
+	logger.Info("pending secret created")
+	return nil
+}
+
+func (r *rotator) finish(ctx context.Context, logger *slog.Logger, ev rotationEvent, desc describeSecretResponse) error {
+	var current string
+	for version, stages := range desc.VersionIDsToStages {
+		if slices.Contains(stages, stageCurrent) {
+			current = version
+			break
+		}
+	}
+	if err := r.secrets.moveStage(ctx, ev.SecretID, stageCurrent, ev.ClientRequestToken, current); err != nil {
+		return err
+	}
+	logger.Log(ctx, logging.LevelNotice, "secret rotated; the previous value stays accepted until the next rotation")
+	return nil
+}
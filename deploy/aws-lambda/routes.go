@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// route is one entry of the routing table: a hostname the Lambda may
+// update, the zone and TTL it is written with, and the client allowed
+// to update it. Clients authenticate with Basic Auth as Username and
+// the secret stored in the SecretParam SSM parameter; a client may own
+// several hostnames, all with the same secret.
+//
+// In SSM each route is a JSON object:
+//
+//	{"hostname": "site1.example.com", "hosted_zone_id": "Z1ABCDEFGHIJKL",
+//	 "ttl": 300, "username": "site1", "secret_param": "/dddns/secrets/site1"}
+type route struct {
+	Hostname     string `json:"hostname"`
+	HostedZoneID string `json:"hosted_zone_id"`
+	TTL          int64  `json:"ttl,omitempty"`
+	Username     string `json:"username"`
+	SecretParam  string `json:"secret_param"`
+}
+
+// routeTable is the set of hostnames one deployment serves. The
+// single-host deployment (DDDNS_HOSTNAME) is a table of one route
+// with an empty Username, which any Basic Auth username matches.
+type routeTable struct {
+	routes []route
+}
+
+// singleRoute is the table of a single-host deployment configured
+// through HOSTED_ZONE_ID, DDDNS_HOSTNAME and SSM_SECRET_PARAM.
+func singleRoute(cfg *config) *routeTable {
+	return &routeTable{routes: []route{{
+		Hostname:     cfg.hostname,
+		HostedZoneID: cfg.hostedZoneID,
+		TTL:          cfg.ttl,
+		SecretParam:  cfg.ssmSecretParam,
+	}}}
+}
+
+// secretFor returns the secret parameter of the client user.
+func (t *routeTable) secretFor(user string) (string, bool) {
+	for _, r := range t.routes {
+		if r.Username == "" || r.Username == user {
+			return r.SecretParam, true
+		}
+	}
+	return "", false
+}
+
+// lookup returns the route for hostname (RFC 1035 case-insensitive).
+func (t *routeTable) lookup(hostname string) (route, bool) {
+	for _, r := range t.routes {
+		if strings.EqualFold(r.Hostname, hostname) {
+			return r, true
+		}
+	}
+	return route{}, false
+}
+
+// owns reports whether hostname is routed and belongs to user.
+func (t *routeTable) owns(user, hostname string) (route, bool) {
+	r, ok := t.lookup(hostname)
+	if !ok || (r.Username != "" && r.Username != user) {
+		return route{}, false
+	}
+	return r, true
+}
+
+// parseRoutes builds a table from SSM parameter values. Each value is
+// one route object or an array of them, so the table can live in one
+// parameter or one parameter per route under a path. Routes without a
+// TTL get defaultTTL.
+func parseRoutes(params []ssmParameter, defaultTTL int64) (*routeTable, error) {
+	t := &routeTable{}
+	hosts := map[string]bool{}
+	secrets := map[string]string{} // username → secret parameter
+	for _, p := range params {
+		var routes []route
+		value := strings.TrimSpace(p.Value)
+		if strings.HasPrefix(value, "[") {
+			if err := json.Unmarshal([]byte(value), &routes); err != nil {
+				return nil, fmt.Errorf("%s: %w", p.Name, err)
+			}
+		} else {
+			var r route
+			if err := json.Unmarshal([]byte(value), &r); err != nil {
+				return nil, fmt.Errorf("%s: %w", p.Name, err)
+			}
+			routes = []route{r}
+		}
+		for _, r := range routes {
+			r.Hostname = strings.ToLower(strings.TrimSpace(r.Hostname))
+			switch {
+			case r.Hostname == "" || strings.HasSuffix(r.Hostname, "."):
+				return nil, fmt.Errorf("%s: hostname %q must be a non-empty name without a trailing dot", p.Name, r.Hostname)
+			case r.HostedZoneID == "":
+				return nil, fmt.Errorf("%s: %s: hosted_zone_id is required", p.Name, r.Hostname)
+			case r.Username == "":
+				return nil, fmt.Errorf("%s: %s: username is required", p.Name, r.Hostname)
+			case r.SecretParam == "":
+				return nil, fmt.Errorf("%s: %s: secret_param is required", p.Name, r.Hostname)
+			case r.TTL < 0:
+				return nil, fmt.Errorf("%s: %s: ttl %d must not be negative", p.Name, r.Hostname, r.TTL)
+			case hosts[r.Hostname]:
+				return nil, fmt.Errorf("%s: hostname %s is routed twice", p.Name, r.Hostname)
+			}
+			if prev, ok := secrets[r.Username]; ok && prev != r.SecretParam {
+				return nil, fmt.Errorf("%s: username %s uses two secret parameters (%s, %s)", p.Name, r.Username, prev, r.SecretParam)
+			}
+			if r.TTL == 0 {
+				r.TTL = defaultTTL
+			}
+			hosts[r.Hostname] = true
+			secrets[r.Username] = r.SecretParam
+			t.routes = append(t.routes, r)
+		}
+	}
+	if len(t.routes) == 0 {
+		return nil, fmt.Errorf("routing table is empty")
+	}
+	return t, nil
+}
+
+// routeCache holds the SSM-loaded routing table between invocations,
+// like secretCache does the secret: a new or changed route is picked
+// up within ttl. name is a parameter, or a path when it ends in "/".
+type routeCache struct {
+	mu        sync.Mutex
+	table     *routeTable
+	fetchedAt time.Time
+	ttl       time.Duration
+	now       func() time.Time
+}
+
+// get returns the routing table, and whether it was served from the
+// cache rather than fetched.
+func (c *routeCache) get(ctx context.Context, ssm *ssmClient, name string, defaultTTL int64) (*routeTable, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.table != nil && c.now().Sub(c.fetchedAt) < c.ttl {
+		return c.table, true, nil
+	}
+	var params []ssmParameter
+	if strings.HasSuffix(name, "/") {
+		p, err := ssm.getParametersByPath(ctx, name)
+		if err != nil {
+			return nil, false, err
+		}
+		params = p
+	} else {
+		v, err := ssm.getParameter(ctx, name)
+		if err != nil {
+			return nil, false, err
+		}
+		params = []ssmParameter{{Name: name, Value: v}}
+	}
+	// A table that fails to load or parse fails closed, like an SSM
+	// outage does for the secret: no request is served from a table
+	// the operator has since changed.
+	t, err := parseRoutes(params, defaultTTL)
+	if err != nil {
+		return nil, false, fmt.Errorf("routing table %s: %w", name, err)
+	}
+	c.table = t
+	c.fetchedAt = c.now()
+	return t, false, nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testRoutesPath = "/dddns/routes/"
+	testAliceHost  = "alice.example.com"
+	testBobHost    = "bob.example.net"
+	testBobHost2   = "cabin.example.net"
+	testAliceSec   = "alice-secret-for-tests-only"
+	testBobSec     = "bob-secret-for-tests-only"
+)
+
+// routesSSMStub serves a two-tenant routing table under testRoutesPath,
+// one route per page so paging is exercised, and each tenant's secret.
+// pathCalls counts GetParametersByPath requests.
+func routesSSMStub(pathCalls *atomic.Int32) http.HandlerFunc {
+	routes := []string{
+		`{"hostname":"alice.example.com","hosted_zone_id":"ZALICE","username":"alice","secret_param":"/dddns/secrets/alice"}`,
+		`{"hostname":"bob.example.net","hosted_zone_id":"ZBOB","ttl":60,"username":"bob","secret_param":"/dddns/secrets/bob"}`,
+		`[{"hostname":"Cabin.example.net","hosted_zone_id":"ZBOB","username":"bob","secret_param":"/dddns/secrets/bob"}]`,
+	}
+	secrets := map[string]string{"/dddns/secrets/alice": testAliceSec, "/dddns/secrets/bob": testBobSec}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonSSM.GetParametersByPath":
+			pathCalls.Add(1)
+			var in getParametersByPathRequest
+			_ = json.NewDecoder(r.Body).Decode(&in)
+			if in.Path != testRoutesPath || !in.WithDecryption {
+				http.Error(w, "unexpected request", http.StatusBadRequest)
+				return
+			}
+			page := 0
+			if in.NextToken != "" {
+				page = int(in.NextToken[0] - '0')
+			}
+			out := getParametersByPathResponse{Parameters: []ssmParameter{
+				{Name: testRoutesPath + string(rune('a'+page)), Value: routes[page]},
+			}}
+			if page+1 < len(routes) {
+				out.NextToken = string(rune('0' + page + 1))
+			}
+			_ = json.NewEncoder(w).Encode(out)
+		case "AmazonSSM.GetParameter":
+			var in getParameterRequest
+			_ = json.NewDecoder(r.Body).Decode(&in)
+			v, ok := secrets[in.Name]
+			if !ok {
+				http.Error(w, `{"__type":"ParameterNotFound"}`, http.StatusBadRequest)
+				return
+			}
+			var out getParameterResponse
+			out.Parameter.Name, out.Parameter.Value = in.Name, v
+			_ = json.NewEncoder(w).Encode(out)
+		default:
+			http.Error(w, "unexpected target", http.StatusBadRequest)
+		}
+	}
+}
+
+// newRoutesHandler is newTestHandler serving the routesSSMStub table.
+func newRoutesHandler(t *testing.T) (*handler, *stubRoute53, *atomic.Int32) {
+	t.Helper()
+	var pathCalls atomic.Int32
+	h, r53 := newTestHandler(t, routesSSMStub(&pathCalls))
+	h.cfg.routesParam = testRoutesPath
+	h.cfg.hostedZoneID, h.cfg.hostname, h.cfg.ssmSecretParam = "", "", ""
+	return h, r53, &pathCalls
+}
+
+func TestParseRoutes(t *testing.T) {
+	ok := `{"hostname":"a.example.com","hosted_zone_id":"Z1","username":"u","secret_param":"/s/u"}`
+	table, err := parseRoutes([]ssmParameter{
+		{Name: "/r/a", Value: ok},
+		{Name: "/r/b", Value: `[{"hostname":"B.example.com","hosted_zone_id":"Z2","ttl":60,"username":"u","secret_param":"/s/u"}]`},
+	}, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, found := table.lookup("A.EXAMPLE.COM"); !found || r.TTL != 300 || r.HostedZoneID != "Z1" {
+		t.Errorf("lookup a = %+v, %v", r, found)
+	}
+	if r, found := table.lookup("b.example.com"); !found || r.TTL != 60 || r.Hostname != "b.example.com" {
+		t.Errorf("lookup b = %+v, %v", r, found)
+	}
+
+	cases := map[string]string{
+		"not json":           `{`,
+		"no hostname":        `{"hosted_zone_id":"Z1","username":"u","secret_param":"/s/u"}`,
+		"trailing dot":       `{"hostname":"a.example.com.","hosted_zone_id":"Z1","username":"u","secret_param":"/s/u"}`,
+		"no zone":            `{"hostname":"a.example.com","username":"u","secret_param":"/s/u"}`,
+		"no username":        `{"hostname":"a.example.com","hosted_zone_id":"Z1","secret_param":"/s/u"}`,
+		"no secret":          `{"hostname":"a.example.com","hosted_zone_id":"Z1","username":"u"}`,
+		"negative ttl":       `{"hostname":"a.example.com","hosted_zone_id":"Z1","ttl":-1,"username":"u","secret_param":"/s/u"}`,
+		"duplicate host":     "[" + ok + "," + ok + "]",
+		"two secrets a user": "[" + ok + `,{"hostname":"c.example.com","hosted_zone_id":"Z1","username":"u","secret_param":"/s/other"}]`,
+		"empty":              `[]`,
+	}
+	for name, value := range cases {
+		if _, err := parseRoutes([]ssmParameter{{Name: "/r/x", Value: value}}, 300); err == nil {
+			t.Errorf("%s: parseRoutes accepted %s", name, value)
+		}
+	}
+}
+
+// TestHandler_Routes_PerClient walks the multi-tenant table: each
+// client updates its own hostnames in their own zones, and neither can
+// touch the other's.
+func TestHandler_Routes_PerClient(t *testing.T) {
+	h, r53, _ := newRoutesHandler(t)
+
+	cases := []struct {
+		user, pass, host, want string
+	}{
+		{"alice", testAliceSec, testAliceHost, "good " + testSourceIP},
+		{"bob", testBobSec, testBobHost, "good " + testSourceIP},
+		{"bob", testBobSec, "CABIN.example.net", "good " + testSourceIP},
+		{"alice", testAliceSec, testBobHost, "nohost"},  // another client's hostname
+		{"bob", testBobSec, testAliceHost, "nohost"},    // ditto
+		{"alice", testBobSec, testAliceHost, "badauth"}, // another client's secret
+		{"mallory", testAliceSec, testAliceHost, "badauth"},
+		{"alice", testAliceSec, "unrouted.example.com", "nohost"},
+		{"alice", testAliceSec, "", "notfqdn"},
+	}
+	for _, c := range cases {
+		resp, err := h.handle(context.Background(), mkRequest(basicAuth(c.user, c.pass), c.host, testSourceIP))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSpace(resp.Body); got != c.want {
+			t.Errorf("%s → %q = %q, want %q", c.user, c.host, got, c.want)
+		}
+	}
+
+	if len(r53.routes) != 3 {
+		t.Fatalf("Route53 clients built for %d routes, want 3: %+v", len(r53.routes), r53.routes)
+	}
+	if r := r53.routes[0]; r.HostedZoneID != "ZALICE" || r.Hostname != testAliceHost || r.TTL != 300 {
+		t.Errorf("alice route = %+v", r)
+	}
+	if r := r53.routes[1]; r.HostedZoneID != "ZBOB" || r.Hostname != testBobHost || r.TTL != 60 {
+		t.Errorf("bob route = %+v", r)
+	}
+	if r := r53.routes[2]; r.Hostname != testBobHost2 {
+		t.Errorf("cabin route = %+v", r)
+	}
+}
+
+// TestHandler_Routes_Signed verifies a signed request is checked
+// against the secret of the hostname's owner.
+func TestHandler_Routes_Signed(t *testing.T) {
+	h, _, _ := newRoutesHandler(t)
+
+	cases := []struct {
+		secret, host, want string
+	}{
+		{testBobSec, testBobHost, "good " + testSourceIP},
+		{testAliceSec, testBobHost, "badauth"},
+		{testAliceSec, "unrouted.example.com", "badauth"},
+		{testAliceSec, "", "badauth"},
+	}
+	for _, c := range cases {
+		resp, _ := h.handle(context.Background(), mkSignedRequest(t, c.secret, c.host))
+		if got := strings.TrimSpace(resp.Body); got != c.want {
+			t.Errorf("signed %q = %q, want %q", c.host, got, c.want)
+		}
+	}
+}
+
+// TestRouteCache_TTL verifies the table is fetched once per ttl, and a
+// failed fetch fails closed.
+func TestRouteCache_TTL(t *testing.T) {
+	h, _, pathCalls := newRoutesHandler(t)
+	now := time.Now()
+	h.routeCache.now = func() time.Time { return now }
+
+	m := &requestMetrics{}
+	for range 3 {
+		if _, err := h.routeTable(context.Background(), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m.cacheMisses != 1 || m.cacheHits != 2 {
+		t.Errorf("cache misses/hits = %d/%d, want 1/2", m.cacheMisses, m.cacheHits)
+	}
+	if got := pathCalls.Load(); got != 3 { // one table, three pages
+		t.Errorf("GetParametersByPath calls = %d, want 3", got)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := h.routeTable(context.Background(), &requestMetrics{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := pathCalls.Load(); got != 6 {
+		t.Errorf("GetParametersByPath calls after ttl = %d, want 6", got)
+	}
+
+	now = now.Add(2 * time.Minute)
+	h.cfg.routesParam = "/dddns/missing"
+	resp, _ := h.handle(context.Background(), mkRequest(basicAuth("alice", testAliceSec), testAliceHost, testSourceIP))
+	if got := strings.TrimSpace(resp.Body); got != "dnserr ssm" {
+		t.Errorf("body with unloadable table = %q, want dnserr ssm", got)
+	}
+}
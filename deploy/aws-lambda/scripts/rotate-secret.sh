@@ -5,6 +5,8 @@
 #   rotate-secret.sh                    # reads SSM param name + region from ../tofu outputs
 #   rotate-secret.sh -n /some/name      # explicit SSM parameter name
 #   rotate-secret.sh -n /name -r us-east-1
+#   rotate-secret.sh -u site1           # multi-host: the secret of routes username 'site1'
+#   rotate-secret.sh -s arn:aws:secretsmanager:...  # secret_backend = "secretsmanager"
 #
 # What it does:
 #   1. Generates a 32-byte (256-bit) hex secret via openssl.
@@ -13,35 +15,52 @@
 #   3. Prints the new secret in a framed block so it's easy to copy
 #      into UniFi UI → Internet → Dynamic DNS → Password.
 #
+# With secret_backend = "secretsmanager" (-s, or the 'secret_arn' tofu
+# output) it instead calls `aws secretsmanager rotate-secret`, waits
+# for the rotation Lambda to finish, and prints the new AWSCURRENT
+# value; the previous value stays accepted until the next rotation.
+#
 # The secret is printed to stdout exactly once. It is NOT written
 # to any file, logged anywhere, or sent over the network except
 # to AWS SSM. If you miss the copy, run the script again.
 #
 # Requires: bash 4+, openssl, AWS CLI v2 authenticated to the
-# target account with ssm:PutParameter on the parameter ARN.
+# target account with ssm:PutParameter on the parameter ARN (or
+# secretsmanager:RotateSecret, DescribeSecret and GetSecretValue on
+# the secret). -u without -n also needs jq to read the tofu output.
 
 set -euo pipefail
 
 PARAM_NAME=""
 REGION=""
+TENANT=""
+SECRET_ID=""
 
 usage() {
     cat <<EOF
-Usage: $(basename "$0") [-n SSM_PARAM_NAME] [-r AWS_REGION]
+Usage: $(basename "$0") [-n SSM_PARAM_NAME | -u USERNAME | -s SECRET_ARN] [-r AWS_REGION]
 
 Options:
   -n NAME    SSM parameter name (e.g. /dddns/shared_secret).
              Defaults to the 'ssm_parameter_name' output of the
              ../tofu module if available.
+  -u USER    Multi-host deployments: rotate the secret of this
+             routes username, looked up in the
+             'tenant_secret_parameters' output of the ../tofu module.
+  -s ARN     Secrets Manager backend: rotate this secret through
+             its rotation Lambda. Defaults to the 'secret_arn'
+             output of the ../tofu module if available.
   -r REGION  AWS region. Defaults to the 'aws_region' variable of
              the ../tofu module, or AWS_REGION env var, or us-east-1.
   -h         Show this help.
 EOF
 }
 
-while getopts "n:r:h" opt; do
+while getopts "n:u:s:r:h" opt; do
     case "${opt}" in
         n) PARAM_NAME="${OPTARG}" ;;
+        u) TENANT="${OPTARG}" ;;
+        s) SECRET_ID="${OPTARG}" ;;
         r) REGION="${OPTARG}" ;;
         h) usage; exit 0 ;;
         *) usage; exit 2 ;;
@@ -52,14 +71,26 @@ done
 # supplied on the command line. This keeps the common case frictionless
 # right after `tofu apply` while allowing full override.
 TOFU_DIR="$(cd "$(dirname "$0")/../tofu" && pwd)"
-if [[ -z "${PARAM_NAME}" ]]; then
+if [[ -z "${PARAM_NAME}" && -n "${TENANT}" ]]; then
+    PARAM_NAME="$(tofu -chdir="${TOFU_DIR}" output -json tenant_secret_parameters 2>/dev/null |
+        jq -r --arg u "${TENANT}" '.[$u] // empty' 2>/dev/null || true)"
+    if [[ -z "${PARAM_NAME}" ]]; then
+        echo "ERROR: no secret parameter for username '${TENANT}' in the tofu outputs." >&2
+        echo "       Check the routes variable, or pass -n /path/to/parameter explicitly." >&2
+        exit 2
+    fi
+fi
+if [[ -z "${PARAM_NAME}" && -z "${TENANT}" && -z "${SECRET_ID}" ]]; then
+    SECRET_ID="$(tofu -chdir="${TOFU_DIR}" output -raw secret_arn 2>/dev/null || true)"
+fi
+if [[ -z "${PARAM_NAME}" && -z "${SECRET_ID}" ]]; then
     if tofu -chdir="${TOFU_DIR}" output -raw ssm_parameter_name 2>/dev/null > /tmp/.dddns-param-name; then
         PARAM_NAME="$(cat /tmp/.dddns-param-name)"
         rm -f /tmp/.dddns-param-name
     fi
 fi
 
-if [[ -z "${PARAM_NAME}" ]]; then
+if [[ -z "${PARAM_NAME}" && -z "${SECRET_ID}" ]]; then
     echo "ERROR: SSM parameter name not provided and tofu output unavailable." >&2
     echo "       Pass -n /path/to/parameter explicitly, or run from deploy/aws-lambda/" >&2
     exit 2
@@ -85,6 +116,43 @@ if ! aws sts get-caller-identity --region "${REGION}" >/dev/null 2>&1; then
     exit 1
 fi
 
+if [[ -n "${SECRET_ID}" ]]; then
+    # The rotation Lambda generates the value; wait until its
+    # finishSecret step has cleared AWSPENDING.
+    aws secretsmanager rotate-secret --region "${REGION}" --secret-id "${SECRET_ID}" --output text >/dev/null
+    PENDING=""
+    for _ in $(seq 1 30); do
+        PENDING="$(aws secretsmanager describe-secret --region "${REGION}" --secret-id "${SECRET_ID}" \
+            --query "length(VersionIdsToStages.* | [?contains(@, 'AWSPENDING')])" --output text)"
+        [[ "${PENDING}" == "0" ]] && break
+        sleep 2
+    done
+    if [[ "${PENDING}" != "0" ]]; then
+        echo "ERROR: rotation did not finish within 60s; check the rotate-secret Lambda's logs." >&2
+        exit 1
+    fi
+    SECRET="$(aws secretsmanager get-secret-value --region "${REGION}" --secret-id "${SECRET_ID}" \
+        --version-stage AWSCURRENT --query SecretString --output text)"
+
+    cat <<EOF
+
+┌──────────────────────────────────────────────────────────────────────────┐
+│                                                                          │
+│  Secret rotated in Secrets Manager: ${SECRET_ID}
+│                                                                          │
+│  Paste this value into UniFi UI → Internet → Dynamic DNS → Password:     │
+│                                                                          │
+│      ${SECRET}
+│                                                                          │
+│  The Lambda picks up the rotated value within 60s (secret cache TTL).    │
+│  The previous secret keeps working until the next rotation.              │
+│                                                                          │
+└──────────────────────────────────────────────────────────────────────────┘
+
+EOF
+    exit 0
+fi
+
 SECRET="$(openssl rand -hex 32)"
 
 aws ssm put-parameter \
@@ -103,6 +171,7 @@ cat <<EOF
 │  New shared secret stored in SSM parameter: ${PARAM_NAME}
 │                                                                          │
 │  Paste this value into UniFi UI → Internet → Dynamic DNS → Password:     │
+│  (multi-host: the client's Username is its routes username)              │
 │                                                                          │
 │      ${SECRET}
 │                                                                          │
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// secretsClient is a minimal AWS Secrets Manager client: reading a
+// secret's staged values for the dyndns handler, and the version
+// calls the rotation entry point (rotate.go) needs. Same AWS JSON 1.1
+// protocol and SigV4 signer as ssmClient.
+type secretsClient struct {
+	awsConn
+}
+
+// Secrets Manager staging labels. During a rotation the new value is
+// AWSPENDING until finishSecret makes it AWSCURRENT; the value it
+// replaces becomes AWSPREVIOUS.
+const (
+	stageCurrent  = "AWSCURRENT"
+	stagePending  = "AWSPENDING"
+	stagePrevious = "AWSPREVIOUS"
+)
+
+// isSecretsManagerRef reports whether a secret reference (the
+// single-host SSM_SECRET_PARAM, or a route's secret_param) names a
+// Secrets Manager secret — by its ARN — rather than an SSM parameter.
+func isSecretsManagerRef(ref string) bool {
+	return strings.HasPrefix(ref, "arn:") && strings.Contains(ref, ":secretsmanager:")
+}
+
+var secretsAPI = awsAPI{service: "secretsmanager", target: "secretsmanager.", contentType: "application/x-amz-json-1.1"}
+
+type getSecretValueRequest struct {
+	SecretID     string `json:"SecretId"`
+	VersionID    string `json:"VersionId,omitempty"`
+	VersionStage string `json:"VersionStage,omitempty"`
+}
+
+type getSecretValueResponse struct {
+	SecretString string `json:"SecretString"`
+	VersionID    string `json:"VersionId"`
+}
+
+// getSecretValue returns the value of the version of id selected by
+// versionID and/or stage.
+func (c *secretsClient) getSecretValue(ctx context.Context, id, versionID, stage string) (string, error) {
+	var out getSecretValueResponse
+	if err := c.do(ctx, secretsAPI, "GetSecretValue", getSecretValueRequest{SecretID: id, VersionID: versionID, VersionStage: stage}, &out); err != nil {
+		return "", err
+	}
+	if out.SecretString == "" {
+		return "", fmt.Errorf("GetSecretValue: empty SecretString for %q", id)
+	}
+	return out.SecretString, nil
+}
+
+// stagedValues returns the secret values a client may authenticate
+// with: AWSCURRENT, then AWSPREVIOUS when the secret has been rotated,
+// so clients still holding the old value keep working until the next
+// rotation.
+func (c *secretsClient) stagedValues(ctx context.Context, id string) ([]string, error) {
+	current, err := c.getSecretValue(ctx, id, "", stageCurrent)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := c.getSecretValue(ctx, id, "", stagePrevious)
+	switch {
+	case isNotFound(err):
+		return []string{current}, nil // never rotated
+	case err != nil:
+		return nil, err
+	}
+	return []string{current, previous}, nil
+}
+
+type putSecretValueRequest struct {
+	SecretID           string   `json:"SecretId"`
+	ClientRequestToken string   `json:"ClientRequestToken"`
+	SecretString       string   `json:"SecretString"`
+	VersionStages      []string `json:"VersionStages"`
+}
+
+// putSecretValue stores value as version token of id, labelled stages.
+func (c *secretsClient) putSecretValue(ctx context.Context, id, token, value string, stages ...string) error {
+	return c.do(ctx, secretsAPI, "PutSecretValue", putSecretValueRequest{
+		SecretID: id, ClientRequestToken: token, SecretString: value, VersionStages: stages,
+	}, &struct{}{})
+}
+
+type describeSecretResponse struct {
+	RotationEnabled    bool                `json:"RotationEnabled"`
+	VersionIDsToStages map[string][]string `json:"VersionIdsToStages"`
+}
+
+// describeSecret returns id's rotation flag and version stages.
+func (c *secretsClient) describeSecret(ctx context.Context, id string) (describeSecretResponse, error) {
+	var out describeSecretResponse
+	err := c.do(ctx, secretsAPI, "DescribeSecret", struct {
+		SecretID string `json:"SecretId"`
+	}{id}, &out)
+	return out, err
+}
+
+type updateSecretVersionStageRequest struct {
+	SecretID            string `json:"SecretId"`
+	VersionStage        string `json:"VersionStage"`
+	MoveToVersionID     string `json:"MoveToVersionId"`
+	RemoveFromVersionID string `json:"RemoveFromVersionId,omitempty"`
+}
+
+// moveStage attaches stage to version to, detaching it from version
+// from.
+func (c *secretsClient) moveStage(ctx context.Context, id, stage, to, from string) error {
+	return c.do(ctx, secretsAPI, "UpdateSecretVersionStage", updateSecretVersionStageRequest{
+		SecretID: id, VersionStage: stage, MoveToVersionID: to, RemoveFromVersionID: from,
+	}, &struct{}{})
+}
+
+// isNotFound reports whether err is Secrets Manager's
+// ResourceNotFoundException — e.g. no version carries the stage.
+func isNotFound(err error) bool {
+	var ae *awsError
+	return errors.As(err, &ae) && ae.Type == "ResourceNotFoundException"
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/descoped/dddns/internal/logging"
+)
+
+const testSecretARN = "arn:aws:secretsmanager:us-east-1:123456789012:secret:dddns/shared-AbCdEf"
+
+// fakeSecrets is a local Secrets Manager endpoint holding one secret:
+// its versions' values and which version carries each staging label.
+// Moving AWSCURRENT moves AWSPREVIOUS to the version it left, as the
+// real service does.
+type fakeSecrets struct {
+	mu       sync.Mutex
+	values   map[string]string // version → value; a staged version may have none yet
+	stages   map[string]string // stage → version
+	puts     int
+	rotation bool
+}
+
+func newFakeSecrets(t *testing.T, current, previous string) (*fakeSecrets, *secretsClient) {
+	t.Helper()
+	f := &fakeSecrets{
+		values:   map[string]string{"v1": current},
+		stages:   map[string]string{stageCurrent: "v1"},
+		rotation: true,
+	}
+	if previous != "" {
+		f.values["v0"] = previous
+		f.stages[stagePrevious] = "v0"
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, &secretsClient{awsConn: awsConn{
+		region:       "us-east-1",
+		accessKey:    "AKIATEST",
+		secretKey:    "SECRETTEST",
+		sessionToken: "SESSIONTOKENTEST",
+		httpClient:   srv.Client(),
+		endpoint:     srv.URL,
+		now:          time.Now,
+	}}
+}
+
+// startRotation labels token AWSPENDING, as RotateSecret does before
+// invoking the rotation function.
+func (f *fakeSecrets) startRotation(token string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stages[stagePending] = token
+}
+
+func (f *fakeSecrets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	notFound := func() {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"__type":"ResourceNotFoundException","Message":"no such version"}`))
+	}
+	var out any = struct{}{}
+	switch r.Header.Get("X-Amz-Target") {
+	case "secretsmanager.GetSecretValue":
+		var in getSecretValueRequest
+		_ = json.NewDecoder(r.Body).Decode(&in)
+		version := in.VersionID
+		if in.VersionStage != "" {
+			staged, ok := f.stages[in.VersionStage]
+			if !ok || (version != "" && version != staged) {
+				notFound()
+				return
+			}
+			version = staged
+		}
+		v, ok := f.values[version]
+		if !ok {
+			notFound()
+			return
+		}
+		out = getSecretValueResponse{SecretString: v, VersionID: version}
+	case "secretsmanager.PutSecretValue":
+		var in putSecretValueRequest
+		_ = json.NewDecoder(r.Body).Decode(&in)
+		f.puts++
+		f.values[in.ClientRequestToken] = in.SecretString
+		for _, s := range in.VersionStages {
+			f.stages[s] = in.ClientRequestToken
+		}
+	case "secretsmanager.DescribeSecret":
+		d := describeSecretResponse{RotationEnabled: f.rotation, VersionIDsToStages: map[string][]string{}}
+		for stage, version := range f.stages {
+			d.VersionIDsToStages[version] = append(d.VersionIDsToStages[version], stage)
+		}
+		out = d
+	case "secretsmanager.UpdateSecretVersionStage":
+		var in updateSecretVersionStageRequest
+		_ = json.NewDecoder(r.Body).Decode(&in)
+		if f.stages[in.VersionStage] != in.RemoveFromVersionID {
+			http.Error(w, `{"__type":"InvalidParameterException"}`, http.StatusBadRequest)
+			return
+		}
+		f.stages[in.VersionStage] = in.MoveToVersionID
+		if in.VersionStage == stageCurrent {
+			f.stages[stagePrevious] = in.RemoveFromVersionID
+			if f.stages[stagePending] == in.MoveToVersionID {
+				delete(f.stages, stagePending)
+			}
+		}
+	default:
+		http.Error(w, "unexpected target", http.StatusBadRequest)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+func TestRotator_FourSteps(t *testing.T) {
+	f, sc := newFakeSecrets(t, testSecret, "")
+	r := &rotator{secrets: sc, newSecret: newRandomSecret, logger: logging.Discard()}
+	ctx := context.Background()
+	const token = "v2"
+	f.startRotation(token)
+
+	for range 2 { // every step is safe to retry
+		for _, step := range []string{"createSecret", "setSecret", "testSecret", "finishSecret"} {
+			if err := r.handle(ctx, rotationEvent{SecretID: testSecretARN, ClientRequestToken: token, Step: step}); err != nil {
+				t.Fatalf("%s: %v", step, err)
+			}
+		}
+	}
+
+	if f.puts != 1 {
+		t.Errorf("PutSecretValue calls = %d, want 1", f.puts)
+	}
+	values, err := sc.stagedValues(ctx, testSecretARN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || len(values[0]) != 64 || values[0] == testSecret || values[1] != testSecret {
+		t.Errorf("staged values after rotation = %q, want [new 64-hex, old]", values)
+	}
+}
+
+func TestRotator_RejectsUnexpectedVersions(t *testing.T) {
+	f, sc := newFakeSecrets(t, testSecret, "")
+	r := &rotator{secrets: sc, newSecret: newRandomSecret, logger: logging.Discard()}
+	ctx := context.Background()
+
+	if err := r.handle(ctx, rotationEvent{SecretID: testSecretARN, ClientRequestToken: "unknown", Step: "createSecret"}); err == nil {
+		t.Error("unknown version accepted")
+	}
+	f.startRotation("v2")
+	if err := r.handle(ctx, rotationEvent{SecretID: testSecretARN, ClientRequestToken: "v2", Step: "bogusStep"}); err == nil {
+		t.Error("unknown step accepted")
+	}
+	f.rotation = false
+	if err := r.handle(ctx, rotationEvent{SecretID: testSecretARN, ClientRequestToken: "v2", Step: "createSecret"}); err == nil {
+		t.Error("secret without rotation enabled accepted")
+	}
+}
+
+// TestHandler_SecretsManager verifies a Secrets Manager secret
+// reference authenticates with both the current and the previous
+// value, over Basic Auth and signed requests.
+func TestHandler_SecretsManager(t *testing.T) {
+	const previous = "previous-secret-for-tests-only-0123456789"
+	h, _ := newTestHandler(t, func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "SSM must not be called", http.StatusInternalServerError)
+	})
+	_, h.secrets = newFakeSecrets(t, testSecret, previous)
+	h.cfg.ssmSecretParam = testSecretARN
+
+	cases := []struct {
+		pass, want string
+	}{
+		{testSecret, "good " + testSourceIP},
+		{previous, "good " + testSourceIP},
+		{"older-secret", "badauth"},
+	}
+	for _, c := range cases {
+		resp, _ := h.handle(context.Background(), mkRequest(basicAuth("dddns", c.pass), testHostname, testSourceIP))
+		if got := strings.TrimSpace(resp.Body); got != c.want {
+			t.Errorf("Basic %q = %q, want %q", c.pass, got, c.want)
+		}
+	}
+	resp, _ := h.handle(context.Background(), mkSignedRequest(t, previous, testHostname))
+	if got := strings.TrimSpace(resp.Body); got != "good "+testSourceIP {
+		t.Errorf("signed with the previous value = %q, want good", got)
+	}
+}
+
+func TestStagedValues_NeverRotated(t *testing.T) {
+	_, sc := newFakeSecrets(t, testSecret, "")
+	values, err := sc.stagedValues(context.Background(), testSecretARN)
+	if err != nil || !slices.Equal(values, []string{testSecret}) {
+		t.Errorf("stagedValues = %q, %v", values, err)
+	}
+	if !isSecretsManagerRef(testSecretARN) || isSecretsManagerRef("/dddns/shared_secret") {
+		t.Error("isSecretsManagerRef misclassifies references")
+	}
+}
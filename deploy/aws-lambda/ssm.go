@@ -1,23 +1,15 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
-	"time"
-
-	"github.com/descoped/dddns/internal/dns"
 )
 
 // ssmClient is a minimal AWS Systems Manager Parameter Store client —
-// just enough to fetch a single SecureString parameter for the shared
-// secret. Avoids pulling in aws-sdk-go-v2 and its reflective transport
-// machinery (several MB of binary size + noticeably slower cold start).
+// just enough to fetch SecureString parameters: the shared secret and,
+// in multi-host deployments, the routing table. Avoids pulling in
+// aws-sdk-go-v2 and its reflective transport machinery (several MB of
+// binary size + noticeably slower cold start).
 //
 // The wire protocol is AWS JSON 1.1 over POST:
 //
@@ -30,14 +22,7 @@ import (
 // Signing is standard SigV4; the session token from the Lambda exec
 // role flows through via the extended signer in internal/dns/sigv4.go.
 type ssmClient struct {
-	region       string
-	accessKey    string
-	secretKey    string
-	sessionToken string
-
-	httpClient *http.Client
-	now        func() time.Time
-	endpoint   string // blank = derive from region
+	awsConn
 }
 
 // getParameterRequest / getParameterResponse mirror the AWS JSON 1.1
@@ -64,56 +49,65 @@ func (c *ssmClient) getParameter(ctx context.Context, name string) (string, erro
 	if name == "" {
 		return "", fmt.Errorf("ssm.getParameter: empty parameter name")
 	}
-
-	body, err := json.Marshal(getParameterRequest{Name: name, WithDecryption: true})
-	if err != nil {
-		return "", fmt.Errorf("marshal GetParameter request: %w", err)
-	}
-
-	endpoint := c.endpoint
-	if endpoint == "" {
-		endpoint = fmt.Sprintf("https://ssm.%s.amazonaws.com/", c.region)
+	var out getParameterResponse
+	if err := c.call(ctx, "GetParameter", getParameterRequest{Name: name, WithDecryption: true}, &out); err != nil {
+		return "", err
 	}
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
-	if err != nil {
-		return "", fmt.Errorf("build GetParameter request: %w", err)
+	if out.Parameter.Value == "" {
+		return "", fmt.Errorf("GetParameter: empty value for %q", name)
 	}
-	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
-	req.Header.Set("X-Amz-Target", "AmazonSSM.GetParameter")
-	req.ContentLength = int64(len(body))
+	return out.Parameter.Value, nil
+}
 
-	// Compute payload hash for SigV4 and sign via the shared signer
-	// (same signer the Route53 client uses; just a different service
-	// name + region here).
-	sum := sha256.Sum256(body)
-	payloadHash := hex.EncodeToString(sum[:])
-	dns.SignRequest(req, c.accessKey, c.secretKey, c.sessionToken, c.region, "ssm", payloadHash, c.now())
+// getParametersByPathRequest / Response mirror the AWS JSON 1.1 shape
+// of GetParametersByPath, again declaring only the fields we use.
+type getParametersByPathRequest struct {
+	Path           string `json:"Path"`
+	Recursive      bool   `json:"Recursive"`
+	WithDecryption bool   `json:"WithDecryption"`
+	NextToken      string `json:"NextToken,omitempty"`
+}
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("GetParameter HTTP: %w", err)
-	}
-	defer func() { _ = resp.Body.Close() }()
+type ssmParameter struct {
+	Name  string `json:"Name"`
+	Value string `json:"Value"`
+}
 
-	// SSM responses for a single parameter are <1 KB. Cap at 64 KB as
-	// belt-and-braces against a compromised / misrouted endpoint
-	// streaming a giant body into a memory-constrained Lambda.
-	raw, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
-	if err != nil {
-		return "", fmt.Errorf("read GetParameter body: %w", err)
-	}
+type getParametersByPathResponse struct {
+	Parameters []ssmParameter `json:"Parameters"`
+	NextToken  string         `json:"NextToken"`
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("GetParameter %s: %s", resp.Status, string(raw))
-	}
+// maxPathPages bounds GetParametersByPath paging (10 parameters per
+// page by default), so a misconfigured path cannot keep a cold start
+// busy indefinitely.
+const maxPathPages = 50
 
-	var out getParameterResponse
-	if err := json.Unmarshal(raw, &out); err != nil {
-		return "", fmt.Errorf("decode GetParameter response: %w", err)
+// getParametersByPath fetches every parameter under path, recursively
+// and decrypted, following NextToken.
+func (c *ssmClient) getParametersByPath(ctx context.Context, path string) ([]ssmParameter, error) {
+	if path == "" {
+		return nil, fmt.Errorf("ssm.getParametersByPath: empty path")
 	}
-	if out.Parameter.Value == "" {
-		return "", fmt.Errorf("GetParameter: empty value for %q", name)
+	var params []ssmParameter
+	in := getParametersByPathRequest{Path: path, Recursive: true, WithDecryption: true}
+	for range maxPathPages {
+		var out getParametersByPathResponse
+		if err := c.call(ctx, "GetParametersByPath", in, &out); err != nil {
+			return nil, err
+		}
+		params = append(params, out.Parameters...)
+		if out.NextToken == "" {
+			return params, nil
+		}
+		in.NextToken = out.NextToken
 	}
-	return out.Parameter.Value, nil
+	return nil, fmt.Errorf("GetParametersByPath %s: more than %d pages", path, maxPathPages)
+}
+
+var ssmAPI = awsAPI{service: "ssm", target: "AmazonSSM.", contentType: "application/x-amz-json-1.1"}
+
+// call performs one AWS JSON 1.1 request for action.
+func (c *ssmClient) call(ctx context.Context, action string, in, out any) error {
+	return c.do(ctx, ssmAPI, action, in, out)
 }
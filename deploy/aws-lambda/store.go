@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// updateStore keeps, per hostname and record type, the address the
+// Lambda last published, plus a history of updates that DynamoDB
+// expires after historyTTL. It gives the Lambda what the cron path's
+// IP cache gives updater.Update: a push with an unchanged address
+// answers nochg without touching Route53.
+//
+// Table layout (partition key pk, sort key sk, TTL attribute
+// expires_at):
+//
+//	pk=<hostname>  sk=last#A                    ip, updated_at, expires_at
+//	pk=<hostname>  sk=history#<time>#A          ip, prev_ip, updated_at, expires_at
+//
+// The last# item doubles as a claim: it is written, conditionally on
+// holding a different address, before the Route53 UPSERT, so of two
+// concurrent pushes with the same new address only one UPSERTs. A
+// failed UPSERT releases the claim again.
+//
+// The last# item is trusted for lastTTL only. After that it reads as
+// absent, so the next push UPSERTs Route53 even with an unchanged
+// address: a record changed out-of-band is put back within lastTTL,
+// instead of being answered nochg until the address next changes.
+type updateStore struct {
+	db         *dynamoClient
+	historyTTL time.Duration // 0 = no history
+	now        func() time.Time
+}
+
+// lastTTL is how long a last# item answers nochg; DynamoDB's TTL
+// deletes it some time later.
+const lastTTL = time.Hour
+
+// Condition expressions of the claim and its release. An item written
+// before last# carried expires_at counts as stale.
+const (
+	condClaimable = "attribute_not_exists(#ip) OR #ip <> :ip OR attribute_not_exists(#exp) OR #exp <= :now"
+	condIPIs      = "#ip = :ip"
+)
+
+func lastKey(hostname, rtype string) item {
+	return item{"pk": str(strings.ToLower(hostname)), "sk": str("last#" + rtype)}
+}
+
+// last returns the address last published for hostname, "" if none
+// or if it was published more than lastTTL ago.
+func (s *updateStore) last(ctx context.Context, hostname, rtype string) (string, error) {
+	it, err := s.db.getItem(ctx, lastKey(hostname, rtype))
+	if err != nil {
+		return "", err
+	}
+	exp, _ := strconv.ParseInt(it["expires_at"]["N"], 10, 64)
+	if exp <= s.now().Unix() {
+		return "", nil
+	}
+	return it.str("ip"), nil
+}
+
+// claim records ip as published for hostname unless it already is and
+// has not gone stale, returning the address it replaces.
+// errConditionFailed means another invocation has already claimed ip.
+func (s *updateStore) claim(ctx context.Context, hostname, rtype, ip string) (prev string, err error) {
+	now := s.now().UTC()
+	old, err := s.db.updateItem(ctx, writeRequest{
+		Key:                      lastKey(hostname, rtype),
+		UpdateExpression:         "SET #ip = :ip, #updated = :updated, #exp = :exp",
+		ConditionExpression:      condClaimable,
+		ExpressionAttributeNames: map[string]string{"#ip": "ip", "#updated": "updated_at", "#exp": "expires_at"},
+		ExpressionAttributeValues: item{
+			":ip":      str(ip),
+			":updated": str(now.Format(time.RFC3339)),
+			":exp":     num(now.Add(lastTTL).Unix()),
+			":now":     num(now.Unix()),
+		},
+		ReturnValues: "UPDATED_OLD",
+	})
+	if err != nil {
+		return "", err
+	}
+	return old.str("ip"), nil
+}
+
+// release undoes claim after a failed UPSERT, restoring prev — unless
+// a later claim has replaced ip in the meantime.
+func (s *updateStore) release(ctx context.Context, hostname, rtype, ip, prev string) error {
+	in := writeRequest{
+		Key:                       lastKey(hostname, rtype),
+		ConditionExpression:       condIPIs,
+		ExpressionAttributeNames:  map[string]string{"#ip": "ip"},
+		ExpressionAttributeValues: item{":ip": str(ip)},
+	}
+	var err error
+	if prev == "" {
+		err = s.db.deleteItem(ctx, in)
+	} else {
+		in.UpdateExpression = "SET #ip = :prev"
+		in.ExpressionAttributeValues[":prev"] = str(prev)
+		_, err = s.db.updateItem(ctx, in)
+	}
+	if errors.Is(err, errConditionFailed) {
+		return nil
+	}
+	return err
+}
+
+// record appends a history entry for a published update.
+func (s *updateStore) record(ctx context.Context, hostname, rtype, ip, prev string) error {
+	if s.historyTTL <= 0 {
+		return nil
+	}
+	now := s.now().UTC()
+	it := item{
+		"pk":         str(strings.ToLower(hostname)),
+		"sk":         str("history#" + now.Format(time.RFC3339Nano) + "#" + rtype),
+		"ip":         str(ip),
+		"updated_at": str(now.Format(time.RFC3339)),
+		"expires_at": num(now.Add(s.historyTTL).Unix()),
+	}
+	if prev != "" {
+		it["prev_ip"] = str(prev)
+	}
+	return s.db.putItem(ctx, it)
+}
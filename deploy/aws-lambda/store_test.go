@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDynamo is a local DynamoDB endpoint holding one table in memory.
+// It implements the JSON 1.0 item operations dynamoClient issues, the
+// SET update expressions updateStore and lockoutStore write, and their
+// condition expressions. fail makes every request a 500.
+type fakeDynamo struct {
+	mu    sync.Mutex
+	items map[[2]string]item
+	fail  bool
+}
+
+func newFakeDynamo(t *testing.T) (*fakeDynamo, *dynamoClient) {
+	t.Helper()
+	f := &fakeDynamo{items: map[[2]string]item{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, &dynamoClient{table: "dddns-test", awsConn: awsConn{
+		region:       "us-east-1",
+		accessKey:    "AKIATEST",
+		secretKey:    "SECRETTEST",
+		sessionToken: "SESSIONTOKENTEST",
+		httpClient:   srv.Client(),
+		endpoint:     srv.URL,
+		now:          time.Now,
+	}}
+}
+
+func (f *fakeDynamo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail {
+		http.Error(w, `{"__type":"com.amazonaws.dynamodb.v20120810#InternalServerError"}`, http.StatusInternalServerError)
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/x-amz-json-1.0" || !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		http.Error(w, "unsigned or mistyped request", http.StatusBadRequest)
+		return
+	}
+	var in writeRequest
+	var put putItemRequest
+	action := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")
+	if action == "PutItem" {
+		_ = json.NewDecoder(r.Body).Decode(&put)
+		in.Key = item{"pk": put.Item["pk"], "sk": put.Item["sk"]}
+	} else {
+		_ = json.NewDecoder(r.Body).Decode(&in)
+	}
+	key := [2]string{in.Key.str("pk"), in.Key.str("sk")}
+	cur := f.items[key]
+
+	if !f.holds(in, cur) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, `{"__type":"com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException"}`)
+		return
+	}
+	var out any = struct{}{}
+	switch action {
+	case "GetItem":
+		out = map[string]item{"Item": cur}
+	case "PutItem":
+		f.items[key] = put.Item
+	case "DeleteItem":
+		delete(f.items, key)
+	case "UpdateItem":
+		next := item{"pk": in.Key["pk"], "sk": in.Key["sk"]}
+		for k, v := range cur {
+			next[k] = v
+		}
+		old := item{}
+		for _, assign := range strings.Split(strings.TrimPrefix(in.UpdateExpression, "SET "), ",") {
+			name, value, _ := strings.Cut(assign, "=")
+			attr := in.ExpressionAttributeNames[strings.TrimSpace(name)]
+			if v, ok := cur[attr]; ok {
+				old[attr] = v
+			}
+			next[attr] = in.ExpressionAttributeValues[strings.TrimSpace(value)]
+		}
+		f.items[key] = next
+		if in.ReturnValues == "UPDATED_OLD" {
+			out = map[string]item{"Attributes": old}
+		}
+	default:
+		http.Error(w, "unexpected target "+action, http.StatusBadRequest)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// holds evaluates in.ConditionExpression against the current item.
+func (f *fakeDynamo) holds(in writeRequest, cur item) bool {
+	ip := in.ExpressionAttributeValues.str(":ip")
+	switch in.ConditionExpression {
+	case "":
+		return true
+	case condClaimable:
+		exp, _ := strconv.ParseInt(cur["expires_at"]["N"], 10, 64)
+		now, _ := strconv.ParseInt(in.ExpressionAttributeValues[":now"]["N"], 10, 64)
+		return cur.str("ip") == "" || cur.str("ip") != ip || exp <= now
+	case condIPIs:
+		return cur.str("ip") == ip
+	case condNoVersion:
+		return cur["version"] == nil
+	case condVersionIs:
+		return cur["version"]["N"] == in.ExpressionAttributeValues[":ver"]["N"]
+	}
+	panic("fakeDynamo: unsupported condition " + in.ConditionExpression)
+}
+
+// history returns the history items, in no particular order.
+func (f *fakeDynamo) history() []item {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []item
+	for k, v := range f.items {
+		if strings.HasPrefix(k[1], "history#") {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func newStoreHandler(t *testing.T) (*handler, *stubRoute53, *fakeDynamo) {
+	t.Helper()
+	h, r53 := newTestHandler(t, nil)
+	f, db := newFakeDynamo(t)
+	h.store = &updateStore{db: db, historyTTL: 30 * 24 * time.Hour, now: time.Now}
+	return h, r53, f
+}
+
+// push sends a Basic Auth update for testHostname from source.
+func push(t *testing.T, h *handler, pass, source string) string {
+	t.Helper()
+	resp, err := h.handle(context.Background(), mkRequest(basicAuth("dddns", pass), testHostname, source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(resp.Body)
+}
+
+// TestHandler_UpdateStore_Nochg verifies an unchanged address answers
+// nochg without an UPSERT, and each real update lands in the history
+// with its predecessor and an expiry.
+func TestHandler_UpdateStore_Nochg(t *testing.T) {
+	h, r53, f := newStoreHandler(t)
+	const next = "203.0.113.99"
+
+	for _, c := range []struct{ source, want string }{
+		{testSourceIP, "good " + testSourceIP},
+		{testSourceIP, "nochg " + testSourceIP},
+		{next, "good " + next},
+		{next, "nochg " + next},
+	} {
+		if got := push(t, h, testSecret, c.source); got != c.want {
+			t.Errorf("push %s = %q, want %q", c.source, got, c.want)
+		}
+	}
+	if len(r53.pushed) != 2 {
+		t.Errorf("Route53 UPSERTs = %v, want 2", r53.pushed)
+	}
+
+	hist := f.history()
+	if len(hist) != 2 {
+		t.Fatalf("history = %v, want 2 entries", hist)
+	}
+	for _, it := range hist {
+		exp, _ := strconv.ParseInt(it["expires_at"]["N"], 10, 64)
+		if d := time.Until(time.Unix(exp, 0)); d < 29*24*time.Hour || d > 31*24*time.Hour {
+			t.Errorf("history item %v expires in %v, want ~30 days", it, d)
+		}
+		if it.str("ip") == next && it.str("prev_ip") != testSourceIP {
+			t.Errorf("second update prev_ip = %q, want %s", it.str("prev_ip"), testSourceIP)
+		}
+	}
+}
+
+// TestHandler_UpdateStore_LastExpires verifies the recovery from a
+// record changed out-of-band: once the last# item is lastTTL old, a
+// push of the unchanged address UPSERTs again, and so does one whose
+// item predates expires_at.
+func TestHandler_UpdateStore_LastExpires(t *testing.T) {
+	h, r53, f := newStoreHandler(t)
+	now := time.Now()
+	h.store.now = func() time.Time { return now }
+
+	if got := push(t, h, testSecret, testSourceIP); got != "good "+testSourceIP {
+		t.Fatalf("first push = %q", got)
+	}
+	now = now.Add(lastTTL - time.Minute)
+	if got := push(t, h, testSecret, testSourceIP); got != "nochg "+testSourceIP {
+		t.Errorf("push within lastTTL = %q, want nochg", got)
+	}
+	now = now.Add(2 * time.Minute)
+	if got := push(t, h, testSecret, testSourceIP); got != "good "+testSourceIP {
+		t.Errorf("push after lastTTL = %q, want good", got)
+	}
+	if got := push(t, h, testSecret, testSourceIP); got != "nochg "+testSourceIP {
+		t.Errorf("push after the refresh = %q, want nochg", got)
+	}
+	if len(r53.pushed) != 2 {
+		t.Errorf("Route53 UPSERTs = %v, want 2", r53.pushed)
+	}
+
+	f.mu.Lock()
+	key := [2]string{testHostname, "last#A"}
+	delete(f.items[key], "expires_at")
+	f.mu.Unlock()
+	if got := push(t, h, testSecret, testSourceIP); got != "good "+testSourceIP {
+		t.Errorf("push over an item without expires_at = %q, want good", got)
+	}
+}
+
+// TestHandler_UpdateStore_Concurrent verifies concurrent pushes of the
+// same new address UPSERT once.
+func TestHandler_UpdateStore_Concurrent(t *testing.T) {
+	h, r53, _ := newStoreHandler(t)
+
+	var wg sync.WaitGroup
+	results := make([]string, 8)
+	for i := range results {
+		wg.Go(func() { results[i] = push(t, h, testSecret, testSourceIP) })
+	}
+	wg.Wait()
+
+	good := 0
+	for _, r := range results {
+		switch r {
+		case "good " + testSourceIP:
+			good++
+		case "nochg " + testSourceIP:
+		default:
+			t.Errorf("unexpected response %q", r)
+		}
+	}
+	if good != 1 || len(r53.pushed) != 1 {
+		t.Errorf("good responses = %d, UPSERTs = %v; want exactly one", good, r53.pushed)
+	}
+}
+
+// TestHandler_UpdateStore_ReleaseOnFailure verifies a failed UPSERT
+// does not leave its address recorded, so the retry publishes.
+func TestHandler_UpdateStore_ReleaseOnFailure(t *testing.T) {
+	h, r53, _ := newStoreHandler(t)
+	if got := push(t, h, testSecret, testSourceIP); got != "good "+testSourceIP {
+		t.Fatalf("first push = %q", got)
+	}
+
+	r53.err = errors.New("throttled")
+	if got := push(t, h, testSecret, "203.0.113.99"); !strings.HasPrefix(got, "dnserr") {
+		t.Fatalf("failing push = %q, want dnserr", got)
+	}
+	if last, _ := h.store.last(context.Background(), testHostname, "A"); last != testSourceIP {
+		t.Errorf("last after failed UPSERT = %q, want the previous %s", last, testSourceIP)
+	}
+
+	r53.err = nil
+	if got := push(t, h, testSecret, "203.0.113.99"); got != "good 203.0.113.99" {
+		t.Errorf("retry = %q, want good", got)
+	}
+}
+
+// TestHandler_UpdateStore_Outage verifies a DynamoDB outage degrades to
+// an unconditional UPSERT.
+func TestHandler_UpdateStore_Outage(t *testing.T) {
+	h, r53, f := newStoreHandler(t)
+	f.fail = true
+	for range 2 {
+		if got := push(t, h, testSecret, testSourceIP); got != "good "+testSourceIP {
+			t.Errorf("push during outage = %q, want good", got)
+		}
+	}
+	if len(r53.pushed) != 2 {
+		t.Errorf("UPSERTs during outage = %v, want 2", r53.pushed)
+	}
+}
+
+// TestUpdateStore_FamiliesAreIndependent verifies A and AAAA are
+// tracked separately for one hostname.
+func TestUpdateStore_FamiliesAreIndependent(t *testing.T) {
+	_, db := newFakeDynamo(t)
+	s := &updateStore{db: db, now: time.Now}
+	ctx := context.Background()
+
+	if _, err := s.claim(ctx, testHostname, "A", testSourceIP); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.claim(ctx, testHostname, "AAAA", "2001:db8::42"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.claim(ctx, "HOME.example.com", "A", testSourceIP); !errors.Is(err, errConditionFailed) {
+		t.Errorf("re-claim of the same address: err = %v, want errConditionFailed", err)
+	}
+	if v4, _ := s.last(ctx, testHostname, "A"); v4 != testSourceIP {
+		t.Errorf("A = %q", v4)
+	}
+	if v6, _ := s.last(ctx, testHostname, "AAAA"); v6 != "2001:db8::42" {
+		t.Errorf("AAAA = %q", v6)
+	}
+}
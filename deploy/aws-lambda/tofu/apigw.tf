@@ -1,18 +1,27 @@
 # API Gateway HTTP API — the simpler, cheaper, newer of the two API
 # Gateway flavours. Good fit for a single-route Lambda integration.
+# Not created with function_url = true (see function_url.tf).
 
 resource "aws_apigatewayv2_api" "dddns" {
+  count = local.http_api ? 1 : 0
+
   name          = local.name
   protocol_type = "HTTP"
   description   = "dddns dyndns-v2 receiver (UniFi inadyn → Lambda → Route53)"
 
+  # "dualstack" also publishes the endpoint over IPv6, so IPv6 clients
+  # reach it with their IPv6 source address (see var.dual_stack).
+  ip_address_type = var.dual_stack ? "dualstack" : "ipv4"
+
   # CORS is off — this endpoint is meant for inadyn-style HTTP clients
   # only; browsers have no legitimate reason to hit /nic/update.
   tags = local.common_tags
 }
 
 resource "aws_apigatewayv2_integration" "lambda" {
-  api_id                 = aws_apigatewayv2_api.dddns.id
+  count = local.http_api ? 1 : 0
+
+  api_id                 = aws_apigatewayv2_api.dddns[0].id
   integration_type       = "AWS_PROXY"
   integration_uri        = aws_lambda_function.dddns.invoke_arn
   payload_format_version = "2.0"
@@ -20,13 +29,17 @@ resource "aws_apigatewayv2_integration" "lambda" {
 }
 
 resource "aws_apigatewayv2_route" "nic_update" {
-  api_id    = aws_apigatewayv2_api.dddns.id
+  count = local.http_api ? 1 : 0
+
+  api_id    = aws_apigatewayv2_api.dddns[0].id
   route_key = "GET /nic/update"
-  target    = "integrations/${aws_apigatewayv2_integration.lambda.id}"
+  target    = "integrations/${aws_apigatewayv2_integration.lambda[0].id}"
 }
 
 resource "aws_apigatewayv2_stage" "default" {
-  api_id      = aws_apigatewayv2_api.dddns.id
+  count = local.http_api ? 1 : 0
+
+  api_id      = aws_apigatewayv2_api.dddns[0].id
   name        = "$default"
   auto_deploy = true
 
@@ -40,9 +53,38 @@ resource "aws_apigatewayv2_stage" "default" {
 
 # Allow API Gateway to invoke the Lambda.
 resource "aws_lambda_permission" "apigw" {
+  count = local.http_api ? 1 : 0
+
   statement_id  = "AllowAPIGatewayInvoke"
   action        = "lambda:InvokeFunction"
   function_name = aws_lambda_function.dddns.function_name
   principal     = "apigateway.amazonaws.com"
-  source_arn    = "${aws_apigatewayv2_api.dddns.execution_arn}/*/*"
+  source_arn    = "${aws_apigatewayv2_api.dddns[0].execution_arn}/*/*"
+}
+
+# These resources gained a count with function_url; keep the existing
+# HTTP API (and its URL) of deployments created before that.
+moved {
+  from = aws_apigatewayv2_api.dddns
+  to   = aws_apigatewayv2_api.dddns[0]
+}
+
+moved {
+  from = aws_apigatewayv2_integration.lambda
+  to   = aws_apigatewayv2_integration.lambda[0]
+}
+
+moved {
+  from = aws_apigatewayv2_route.nic_update
+  to   = aws_apigatewayv2_route.nic_update[0]
+}
+
+moved {
+  from = aws_apigatewayv2_stage.default
+  to   = aws_apigatewayv2_stage.default[0]
+}
+
+moved {
+  from = aws_lambda_permission.apigw
+  to   = aws_lambda_permission.apigw[0]
 }
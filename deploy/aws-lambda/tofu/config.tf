@@ -0,0 +1,46 @@
+# Optional dddns config document (config_file): the config.yaml format
+# the device modes read, from which the Lambda takes its hostname, zone,
+# TTL and — when the document has server.shared_secret — the dyndns
+# secret. It is stored encrypted, per config_store:
+#
+#   "ssm"  a SecureString parameter, read with GetParameter at cold
+#          start (DDDNS_CONFIG_PARAM).
+#   "kms"  a ciphertext under a dedicated KMS key, carried in the
+#          function's environment and decrypted with KMS Decrypt at
+#          cold start (DDDNS_CONFIG_KMS). Lambda caps the environment
+#          at 4 KB, which bounds the document to roughly 2.5 KB.
+#
+# Either way the plaintext also sits in the tofu state, like the
+# bootstrap secrets; keep the state encrypted.
+
+resource "aws_ssm_parameter" "config" {
+  count       = local.config_ssm ? 1 : 0
+  name        = var.config_parameter_name
+  description = "dddns config document of ${local.name} (from config_file)"
+  type        = "SecureString"
+  value       = file(var.config_file)
+  tier        = "Standard"
+
+  tags = local.common_tags
+}
+
+resource "aws_kms_key" "config" {
+  count                   = local.config_kms ? 1 : 0
+  description             = "dddns config document of ${local.name}"
+  enable_key_rotation     = true
+  deletion_window_in_days = 7
+
+  tags = local.common_tags
+}
+
+resource "aws_kms_alias" "config" {
+  count         = local.config_kms ? 1 : 0
+  name          = "alias/${local.name}-config"
+  target_key_id = aws_kms_key.config[0].key_id
+}
+
+resource "aws_kms_ciphertext" "config" {
+  count     = local.config_kms ? 1 : 0
+  key_id    = aws_kms_key.config[0].key_id
+  plaintext = file(var.config_file)
+}
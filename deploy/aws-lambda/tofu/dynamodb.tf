@@ -0,0 +1,35 @@
+# Optional DynamoDB table for the Lambda's update store (var.update_store):
+# the address last published per hostname, so an unchanged push answers
+# nochg without a Route53 call, and an update history that DynamoDB
+# expires through the expires_at TTL attribute. The auth-failure
+# lockout (var.auth_lockout) keeps its per-client state in the same
+# table, under lockout#<client> keys.
+#
+# On-demand billing — a household push stream is a handful of requests
+# a day, far inside the always-free 25 WCU/RCU.
+
+resource "aws_dynamodb_table" "updates" {
+  count = local.dynamodb ? 1 : 0
+
+  name         = "${local.name}-updates"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "pk"
+  range_key    = "sk"
+
+  attribute {
+    name = "pk"
+    type = "S"
+  }
+
+  attribute {
+    name = "sk"
+    type = "S"
+  }
+
+  ttl {
+    attribute_name = "expires_at"
+    enabled        = true
+  }
+
+  tags = local.common_tags
+}
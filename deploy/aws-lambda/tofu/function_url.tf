@@ -0,0 +1,27 @@
+# Lambda Function URL — an HTTPS endpoint served by Lambda itself, with
+# no API Gateway in front: no per-request gateway charge, but also no
+# gateway throttling (reserved_concurrency is then the only rate cap).
+# A push arriving over IPv6 is still only published (as AAAA) with
+# dual_stack.
+# The handler detects the payload format, so the same zip serves both.
+
+resource "aws_lambda_function_url" "dddns" {
+  count = var.function_url ? 1 : 0
+
+  function_name = aws_lambda_function.dddns.function_name
+
+  # The handler authenticates every push itself (Basic Auth or an HMAC
+  # signature against the stored secret); IAM auth would need SigV4
+  # from the client, which inadyn cannot do.
+  authorization_type = "NONE"
+}
+
+resource "aws_lambda_permission" "function_url" {
+  count = var.function_url ? 1 : 0
+
+  statement_id           = "AllowFunctionURLInvoke"
+  action                 = "lambda:InvokeFunctionUrl"
+  function_name          = aws_lambda_function.dddns.function_name
+  principal              = "*"
+  function_url_auth_type = "NONE"
+}
@@ -2,9 +2,17 @@
 # handler actually performs:
 #
 #   1. route53:ChangeResourceRecordSets on exactly one zone + one
-#      record name + action=UPSERT.
+#      record name + action=UPSERT — with a routing table, on each
+#      routed zone for exactly its routed record names.
 #   2. ssm:GetParameter on exactly one parameter ARN + the KMS key
-#      that decrypts it.
+#      that decrypts it — with a routing table, GetParametersByPath on
+#      the table path and GetParameter on each client's secret.
+#   3. With update_store or auth_lockout, item reads and writes on the
+#      one DynamoDB table.
+#   4. With secret_backend = "secretsmanager", GetSecretValue on the
+#      one secret instead of the SSM parameter.
+#   5. With config_file, GetParameter on the config parameter, or
+#      kms:Decrypt with the config document's own key.
 #
 # No '*' resource wildcards anywhere. This is the same scoping model
 # as docs/aws-setup.md's recommended IAM policy for the cron path.
@@ -32,31 +40,34 @@ resource "aws_iam_role_policy_attachment" "logs" {
   policy_arn = "arn:aws:iam::aws:policy/service-role/AWSLambdaBasicExecutionRole"
 }
 
-# Route53 — scoped to the one hosted zone + record name, UPSERT only.
+# Route53 — scoped per hosted zone to its record names, UPSERT only.
 data "aws_iam_policy_document" "route53" {
-  statement {
-    sid    = "UpsertOneRecord"
-    effect = "Allow"
-    actions = [
-      "route53:ChangeResourceRecordSets",
-    ]
-    resources = [
-      "arn:aws:route53:::hostedzone/${var.hosted_zone_id}",
-    ]
-    condition {
-      test     = "ForAllValues:StringEquals"
-      variable = "route53:ChangeResourceRecordSetsNormalizedRecordNames"
-      values   = [lower(var.hostname)]
-    }
-    condition {
-      test     = "ForAllValues:StringEquals"
-      variable = "route53:ChangeResourceRecordSetsActions"
-      values   = ["UPSERT"]
-    }
-    condition {
-      test     = "ForAllValues:StringEquals"
-      variable = "route53:ChangeResourceRecordSetsRecordTypes"
-      values   = ["A"]
+  dynamic "statement" {
+    for_each = local.zone_hostnames
+    content {
+      sid    = "UpsertRecords${statement.key}"
+      effect = "Allow"
+      actions = [
+        "route53:ChangeResourceRecordSets",
+      ]
+      resources = [
+        "arn:aws:route53:::hostedzone/${statement.key}",
+      ]
+      condition {
+        test     = "ForAllValues:StringEquals"
+        variable = "route53:ChangeResourceRecordSetsNormalizedRecordNames"
+        values   = statement.value
+      }
+      condition {
+        test     = "ForAllValues:StringEquals"
+        variable = "route53:ChangeResourceRecordSetsActions"
+        values   = ["UPSERT"]
+      }
+      condition {
+        test     = "ForAllValues:StringEquals"
+        variable = "route53:ChangeResourceRecordSetsRecordTypes"
+        values   = var.dual_stack ? ["A", "AAAA"] : ["A"]
+      }
     }
   }
 
@@ -64,7 +75,7 @@ data "aws_iam_policy_document" "route53" {
     sid       = "ReadZoneMetadata"
     effect    = "Allow"
     actions   = ["route53:GetHostedZone", "route53:ListResourceRecordSets"]
-    resources = ["arn:aws:route53:::hostedzone/${var.hosted_zone_id}"]
+    resources = [for zone in keys(local.zone_hostnames) : "arn:aws:route53:::hostedzone/${zone}"]
   }
 }
 
@@ -74,17 +85,46 @@ resource "aws_iam_role_policy" "route53" {
   policy = data.aws_iam_policy_document.route53.json
 }
 
-# SSM — GetParameter on exactly one parameter.
+# SSM — GetParameter on exactly the secret parameters, plus the
+# routing table path for a multi-host deployment.
 data "aws_iam_policy_document" "ssm" {
-  statement {
-    sid    = "ReadSharedSecret"
-    effect = "Allow"
-    actions = [
-      "ssm:GetParameter",
-    ]
-    resources = [
-      aws_ssm_parameter.shared_secret.arn,
-    ]
+  dynamic "statement" {
+    for_each = local.managed_secret ? [] : [1]
+    content {
+      sid    = "ReadSharedSecret"
+      effect = "Allow"
+      actions = [
+        "ssm:GetParameter",
+      ]
+      resources = concat(
+        aws_ssm_parameter.shared_secret[*].arn,
+        [for p in aws_ssm_parameter.tenant_secret : p.arn],
+      )
+    }
+  }
+
+  dynamic "statement" {
+    for_each = local.config_ssm ? [1] : []
+    content {
+      sid       = "ReadConfigDocument"
+      effect    = "Allow"
+      actions   = ["ssm:GetParameter"]
+      resources = [aws_ssm_parameter.config[0].arn]
+    }
+  }
+
+  dynamic "statement" {
+    for_each = local.multi_host ? [1] : []
+    content {
+      sid    = "ReadRoutingTable"
+      effect = "Allow"
+      actions = [
+        "ssm:GetParametersByPath",
+      ]
+      resources = [
+        "arn:aws:ssm:${data.aws_region.current.region}:${data.aws_caller_identity.current.account_id}:parameter${var.routes_parameter_path}",
+      ]
+    }
   }
 
   statement {
@@ -111,3 +151,67 @@ resource "aws_iam_role_policy" "ssm" {
   role   = aws_iam_role.lambda.id
   policy = data.aws_iam_policy_document.ssm.json
 }
+
+# KMS — Decrypt with the config document's key only.
+data "aws_iam_policy_document" "config_kms" {
+  count = local.config_kms ? 1 : 0
+
+  statement {
+    sid       = "DecryptConfigDocument"
+    effect    = "Allow"
+    actions   = ["kms:Decrypt"]
+    resources = [aws_kms_key.config[0].arn]
+  }
+}
+
+resource "aws_iam_role_policy" "config_kms" {
+  count  = local.config_kms ? 1 : 0
+  name   = "kms-decrypt-config"
+  role   = aws_iam_role.lambda.id
+  policy = data.aws_iam_policy_document.config_kms[0].json
+}
+
+# DynamoDB — item operations on the update-store / lockout table only.
+data "aws_iam_policy_document" "dynamodb" {
+  count = local.dynamodb ? 1 : 0
+
+  statement {
+    sid    = "UpdateStoreItems"
+    effect = "Allow"
+    actions = [
+      "dynamodb:GetItem",
+      "dynamodb:PutItem",
+      "dynamodb:UpdateItem",
+      "dynamodb:DeleteItem",
+    ]
+    resources = [aws_dynamodb_table.updates[0].arn]
+  }
+}
+
+resource "aws_iam_role_policy" "dynamodb" {
+  count  = local.dynamodb ? 1 : 0
+  name   = "dynamodb-update-store"
+  role   = aws_iam_role.lambda.id
+  policy = data.aws_iam_policy_document.dynamodb[0].json
+}
+
+# Secrets Manager — the current and previous value of the one secret.
+data "aws_iam_policy_document" "secretsmanager" {
+  count = local.managed_secret ? 1 : 0
+
+  statement {
+    sid    = "ReadManagedSecret"
+    effect = "Allow"
+    actions = [
+      "secretsmanager:GetSecretValue",
+    ]
+    resources = [aws_secretsmanager_secret.shared_secret[0].arn]
+  }
+}
+
+resource "aws_iam_role_policy" "secretsmanager" {
+  count  = local.managed_secret ? 1 : 0
+  name   = "secretsmanager-get-secret-scoped"
+  role   = aws_iam_role.lambda.id
+  policy = data.aws_iam_policy_document.secretsmanager[0].json
+}
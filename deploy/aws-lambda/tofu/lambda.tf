@@ -29,7 +29,34 @@ resource "aws_lambda_function" "dddns" {
       # All values the Lambda reads at init — see deploy/aws-lambda/main.go.
       HOSTED_ZONE_ID   = var.hosted_zone_id
       DDDNS_HOSTNAME   = var.hostname
-      SSM_SECRET_PARAM = var.ssm_parameter_name
+      # An SSM parameter name, or with secret_backend =
+      # "secretsmanager" the secret's ARN.
+      SSM_SECRET_PARAM = local.managed_secret ? aws_secretsmanager_secret.shared_secret[0].arn : (local.ssm_secret ? var.ssm_parameter_name : "")
+      # Multi-host: the routing table path; the trailing '/' makes the
+      # Lambda read it with GetParametersByPath.
+      DDDNS_ROUTES_PARAM = local.multi_host ? "${var.routes_parameter_path}/" : ""
+      # The config document, when config_file is set: an SSM parameter
+      # name, or a base64 KMS ciphertext (see config.tf).
+      DDDNS_CONFIG_PARAM = local.config_ssm ? aws_ssm_parameter.config[0].name : ""
+      DDDNS_CONFIG_KMS   = local.config_kms ? aws_kms_ciphertext.config[0].ciphertext_blob : ""
+      # "true" rejects Basic Auth; only HMAC-signed requests are accepted.
+      DDDNS_REQUIRE_SIGNATURE = tostring(var.require_signature)
+      # "true" publishes IPv6 sources as AAAA; only a dual-stack
+      # endpoint receives them.
+      DDDNS_IPV6 = tostring(var.dual_stack)
+      # Update store: nochg short-circuit, claim, and history with TTL.
+      DDDNS_DYNAMODB_TABLE = var.update_store ? aws_dynamodb_table.updates[0].name : ""
+      DDDNS_HISTORY_DAYS   = tostring(var.history_retention_days)
+      # Auth-failure lockout per source address, same policy as serve mode.
+      DDDNS_LOCKOUT_TABLE       = var.auth_lockout ? aws_dynamodb_table.updates[0].name : ""
+      DDDNS_MAX_AUTH_FAILURES   = tostring(var.max_auth_failures)
+      DDDNS_AUTH_FAILURE_WINDOW = var.auth_failure_window
+      DDDNS_LOCKOUT_DURATION    = var.lockout_duration
+      # CloudWatch Embedded Metric Format records on stdout; "" = none.
+      DDDNS_METRICS_NAMESPACE = var.metrics ? var.metrics_namespace : ""
+      # Structured slog output; JSON is queryable in Logs Insights.
+      DDDNS_LOG_FORMAT = var.log_format
+      DDDNS_LOG_LEVEL  = var.log_level
       # GOMEMLIMIT caps the Go soft heap. The handler allocates <1 MB per
       # request; 16 MiB gives ample headroom while staying far below the
       # 128 MB Lambda memory allocation (leaving room for runtime overhead).
@@ -41,7 +68,38 @@ resource "aws_lambda_function" "dddns" {
     aws_cloudwatch_log_group.lambda,
     aws_iam_role_policy.route53,
     aws_iam_role_policy.ssm,
+    aws_iam_role_policy.dynamodb,
+    aws_iam_role_policy.secretsmanager,
+    aws_iam_role_policy.config_kms,
+    aws_ssm_parameter.route,
+    aws_secretsmanager_secret_version.bootstrap,
   ]
 
+  lifecycle {
+    precondition {
+      condition     = local.multi_host != (var.hostname != "" && var.hosted_zone_id != "")
+      error_message = "set either hostname and hosted_zone_id (one host) or routes (several hosts), not both."
+    }
+    precondition {
+      condition     = !(local.multi_host && var.secret_backend == "secretsmanager")
+      error_message = "secret_backend = \"secretsmanager\" is for single-host deployments; routes clients keep their secrets in SSM."
+    }
+    precondition {
+      condition = local.config_doc == null || !local.multi_host || (
+        try(local.config_doc.hostname, "") == "" &&
+        try(local.config_doc.hosted_zone_id, "") == "" &&
+        try(local.config_doc.server.shared_secret, "") == ""
+      )
+      error_message = "with routes, config_file holds the deployment-wide settings only; the routing table names hostnames, zones and secrets, so drop hostname, hosted_zone_id and server.shared_secret from it."
+    }
+    precondition {
+      condition = local.config_doc == null || local.multi_host || (
+        lower(try(local.config_doc.hostname, "")) == lower(var.hostname) &&
+        try(local.config_doc.hosted_zone_id, "") == var.hosted_zone_id
+      )
+      error_message = "config_file's hostname and hosted_zone_id must equal the hostname and hosted_zone_id variables, which scope the IAM policy."
+    }
+  }
+
   tags = local.common_tags
 }
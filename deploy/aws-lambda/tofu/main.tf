@@ -4,14 +4,44 @@ data "aws_caller_identity" "current" {}
 data "aws_region" "current" {}
 
 locals {
+  # A non-empty routing table makes this a multi-host deployment.
+  multi_host = length(var.routes) > 0
+
   # Resource name prefix, normalized lowercase and with dots from
-  # hostname turned into dashes so it's a valid AWS resource name.
-  name = "${var.name_prefix}-${replace(lower(var.hostname), ".", "-")}"
+  # hostname turned into dashes so it's a valid AWS resource name. A
+  # multi-host deployment is named by the prefix alone.
+  name = local.multi_host ? var.name_prefix : "${var.name_prefix}-${replace(lower(var.hostname), ".", "-")}"
+
+  # Hostnames to UPSERT, grouped by hosted zone — the IAM policy grants
+  # each zone exactly its own record names.
+  zone_hostnames = local.multi_host ? {
+    for r in var.routes : r.hosted_zone_id => lower(r.hostname)...
+  } : { (var.hosted_zone_id) = [lower(var.hostname)] }
+
+  # Which store holds the single-host shared secret.
+  ssm_secret     = !local.multi_host && var.secret_backend == "ssm"
+  managed_secret = !local.multi_host && var.secret_backend == "secretsmanager"
+
+  # The config document, decoded so tofu can check it matches the
+  # variables the IAM policy is scoped by; null without config_file.
+  config_doc = var.config_file == "" ? null : yamldecode(file(var.config_file))
+  config_ssm = local.config_doc != null && var.config_store == "ssm"
+  config_kms = local.config_doc != null && var.config_store == "kms"
+
+  # The update store and the auth-failure lockout share one table.
+  dynamodb = var.update_store || var.auth_lockout
+
+  # The endpoint is an API Gateway HTTP API unless function_url is set.
+  http_api = !var.function_url
+  endpoint = local.http_api ? aws_apigatewayv2_api.dddns[0].api_endpoint : trimsuffix(aws_lambda_function_url.dddns[0].function_url, "/")
+
+  # One secret per client of the routing table.
+  tenants = toset([for r in var.routes : r.username])
 
   common_tags = merge(
     {
       app      = "dddns"
-      hostname = var.hostname
+      hostname = local.multi_host ? "multi-host" : var.hostname
       module   = "deploy/aws-lambda"
     },
     var.tags,
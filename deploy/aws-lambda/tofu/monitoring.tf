@@ -0,0 +1,142 @@
+# Dashboard, alarms and a Logs Insights query over the metrics the
+# Lambda writes as CloudWatch Embedded Metric Format records
+# (var.metrics; see deploy/aws-lambda/emf.go). CloudWatch Logs extracts
+# them from the function's log stream, so there is no PutMetricData
+# call and no IAM grant for it.
+
+locals {
+  metric_dimensions = { Function = local.name }
+}
+
+# Any dnserr — Route53, SSM or Secrets Manager failing, or a source
+# address the Lambda refused to publish.
+resource "aws_cloudwatch_metric_alarm" "dnserr" {
+  count               = var.metrics ? 1 : 0
+  alarm_name          = "${local.name}-dnserr"
+  alarm_description   = "dddns Lambda answered dnserr: an update could not be published. See the function's logs for the cause."
+  namespace           = var.metrics_namespace
+  metric_name         = "Requests"
+  dimensions          = merge(local.metric_dimensions, { Outcome = "dnserr" })
+  statistic           = "Sum"
+  period              = 300
+  evaluation_periods  = 1
+  threshold           = 1
+  comparison_operator = "GreaterThanOrEqualToThreshold"
+  treat_missing_data  = "notBreaching"
+  alarm_actions       = var.alarm_actions
+  ok_actions          = var.alarm_actions
+
+  tags = local.common_tags
+}
+
+# A burst of badauth — a misconfigured client after a rotation, or
+# someone guessing. The auth-failures-by-source query names the source.
+resource "aws_cloudwatch_metric_alarm" "auth_failures" {
+  count               = var.metrics ? 1 : 0
+  alarm_name          = "${local.name}-auth-failures"
+  alarm_description   = "dddns Lambda rejected ${var.alarm_auth_failures}+ requests as badauth within 5 minutes. Run the '${local.name}/auth-failures-by-source' Logs Insights query."
+  namespace           = var.metrics_namespace
+  metric_name         = "AuthFailures"
+  dimensions          = local.metric_dimensions
+  statistic           = "Sum"
+  period              = 300
+  evaluation_periods  = 1
+  threshold           = var.alarm_auth_failures
+  comparison_operator = "GreaterThanOrEqualToThreshold"
+  treat_missing_data  = "notBreaching"
+  alarm_actions       = var.alarm_actions
+  ok_actions          = var.alarm_actions
+
+  tags = local.common_tags
+}
+
+resource "aws_cloudwatch_metric_alarm" "route53_latency" {
+  count               = var.metrics ? 1 : 0
+  alarm_name          = "${local.name}-route53-latency"
+  alarm_description   = "dddns Lambda's p90 Route53 UPSERT latency reached ${var.alarm_route53_latency_ms} ms."
+  namespace           = var.metrics_namespace
+  metric_name         = "Route53Latency"
+  dimensions          = local.metric_dimensions
+  extended_statistic  = "p90"
+  period              = 900
+  evaluation_periods  = 1
+  threshold           = var.alarm_route53_latency_ms
+  comparison_operator = "GreaterThanOrEqualToThreshold"
+  treat_missing_data  = "notBreaching"
+  alarm_actions       = var.alarm_actions
+  ok_actions          = var.alarm_actions
+
+  tags = local.common_tags
+}
+
+resource "aws_cloudwatch_query_definition" "auth_failures_by_source" {
+  count           = var.metrics ? 1 : 0
+  name            = "${local.name}/auth-failures-by-source"
+  log_group_names = [aws_cloudwatch_log_group.lambda.name]
+
+  query_string = <<-EOT
+    filter AuthFailures > 0
+    | stats sum(AuthFailures) as failures, latest(Hostname) as hostname by SourceIP
+    | sort failures desc
+    | limit 50
+  EOT
+}
+
+resource "aws_cloudwatch_dashboard" "dddns" {
+  count          = var.metrics ? 1 : 0
+  dashboard_name = local.name
+
+  dashboard_body = jsonencode({
+    widgets = [
+      {
+        type   = "metric", x = 0, y = 0, width = 12, height = 6
+        properties = {
+          title   = "Requests by outcome"
+          region  = data.aws_region.current.region
+          stat    = "Sum"
+          period  = 300
+          stacked = true
+          metrics = [[{ expression = "SEARCH('{${var.metrics_namespace},Function,Outcome} MetricName=\"Requests\" Function=\"${local.name}\"', 'Sum', 300)", id = "outcomes" }]]
+        }
+      },
+      {
+        type   = "metric", x = 12, y = 0, width = 12, height = 6
+        properties = {
+          title  = "Route53 UPSERT latency (ms)"
+          region = data.aws_region.current.region
+          period = 300
+          metrics = [
+            [var.metrics_namespace, "Route53Latency", "Function", local.name, { stat = "p50" }],
+            ["...", { stat = "p90" }],
+            ["...", { stat = "Maximum" }],
+          ]
+        }
+      },
+      {
+        type   = "metric", x = 0, y = 6, width = 12, height = 6
+        properties = {
+          title  = "Secret / routing-table cache"
+          region = data.aws_region.current.region
+          stat   = "Sum"
+          period = 300
+          metrics = [
+            [var.metrics_namespace, "SSMCacheHits", "Function", local.name],
+            [var.metrics_namespace, "SSMCacheMisses", "Function", local.name],
+          ]
+        }
+      },
+      {
+        type   = "metric", x = 12, y = 6, width = 12, height = 6
+        properties = {
+          title  = "Auth failures"
+          region = data.aws_region.current.region
+          stat   = "Sum"
+          period = 300
+          metrics = [
+            [var.metrics_namespace, "AuthFailures", "Function", local.name],
+          ]
+        }
+      },
+    ]
+  })
+}
@@ -1,21 +1,41 @@
 output "api_url" {
-  value       = aws_apigatewayv2_api.dddns.api_endpoint
-  description = "Base URL of the API Gateway HTTP API. Append /nic/update for the push endpoint."
+  value       = local.endpoint
+  description = "Base URL of the API Gateway HTTP API, or of the Function URL with function_url = true. Append /nic/update for the push endpoint."
 }
 
 output "nic_update_endpoint" {
-  value       = "${aws_apigatewayv2_api.dddns.api_endpoint}/nic/update"
+  value       = "${local.endpoint}/nic/update"
   description = "The complete URL inadyn should GET against."
 }
 
 output "unifi_ui_server_field" {
-  value       = "${replace(aws_apigatewayv2_api.dddns.api_endpoint, "https://", "")}/nic/update?hostname=%h&myip=%i"
+  value       = "${replace(local.endpoint, "https://", "")}/nic/update?hostname=%h&myip=%i"
   description = "Paste into UniFi UI → Internet → Dynamic DNS → Server. The myip=%i is sent by inadyn but ignored by the Lambda (sourceIp wins)."
 }
 
 output "ssm_parameter_name" {
-  value       = aws_ssm_parameter.shared_secret.name
-  description = "SSM parameter holding the shared secret. Rotate via scripts/rotate-secret.sh."
+  value       = one(aws_ssm_parameter.shared_secret[*].name)
+  description = "SSM parameter holding the shared secret. Rotate via scripts/rotate-secret.sh. Null with 'routes' or secret_backend = \"secretsmanager\"."
+}
+
+output "secret_arn" {
+  value       = one(aws_secretsmanager_secret.shared_secret[*].arn)
+  description = "Secrets Manager secret holding the shared secret with secret_backend = \"secretsmanager\". Rotate via scripts/rotate-secret.sh. Null otherwise."
+}
+
+output "tenant_secret_parameters" {
+  value       = { for user, p in aws_ssm_parameter.tenant_secret : user => p.name }
+  description = "Multi-host deployments: the SSM secret parameter of each routes username. Rotate via scripts/rotate-secret.sh -u USERNAME."
+}
+
+output "update_store_table" {
+  value       = one(aws_dynamodb_table.updates[*].name)
+  description = "DynamoDB table of last-published addresses, update history and auth-failure lockouts. Null without update_store and auth_lockout."
+}
+
+output "metrics_dashboard" {
+  value       = one(aws_cloudwatch_dashboard.dddns[*].dashboard_name)
+  description = "CloudWatch dashboard of the Lambda's metrics. Null without metrics."
 }
 
 output "lambda_function_name" {
@@ -29,6 +49,6 @@ output "cloudwatch_log_group" {
 }
 
 output "curl_test_command" {
-  value       = "curl -u 'dddns:YOUR_SECRET' '${aws_apigatewayv2_api.dddns.api_endpoint}/nic/update?hostname=${var.hostname}&myip=198.51.100.1'"
+  value       = local.multi_host ? "curl -u '${var.routes[0].username}:YOUR_SECRET' '${local.endpoint}/nic/update?hostname=${var.routes[0].hostname}&myip=198.51.100.1'" : "curl -u 'dddns:YOUR_SECRET' '${local.endpoint}/nic/update?hostname=${var.hostname}&myip=198.51.100.1'"
   description = "Smoke-test command. Replace YOUR_SECRET with the value printed by rotate-secret.sh. Expected: 'good <your-real-ip>' — myip is ignored."
 }
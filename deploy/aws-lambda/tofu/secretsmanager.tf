@@ -0,0 +1,141 @@
+# Secrets Manager backend for the shared secret (secret_backend =
+# "secretsmanager"), in place of the SSM parameter in ssm.tf.
+#
+# The secret is bootstrapped with a random placeholder like the SSM
+# one. Rotation is done by the same Lambda zip deployed a second time
+# with DDDNS_ENTRYPOINT=rotate-secret, which runs the four-step
+# Secrets Manager protocol: the new value becomes AWSCURRENT and the
+# old one AWSPREVIOUS, which the dyndns Lambda keeps accepting until
+# the next rotation. scripts/rotate-secret.sh triggers a rotation on
+# demand and prints the new value.
+
+resource "random_password" "managed_bootstrap_secret" {
+  count   = local.managed_secret ? 1 : 0
+  length  = 32
+  special = false
+}
+
+resource "aws_secretsmanager_secret" "shared_secret" {
+  count       = local.managed_secret ? 1 : 0
+  name        = "${local.name}/shared-secret"
+  description = "dddns dyndns-v2 shared secret (rotated by ${local.name}-rotate-secret; see deploy/aws-lambda/scripts/rotate-secret.sh)"
+
+  tags = local.common_tags
+}
+
+resource "aws_secretsmanager_secret_version" "bootstrap" {
+  count         = local.managed_secret ? 1 : 0
+  secret_id     = aws_secretsmanager_secret.shared_secret[0].id
+  secret_string = random_password.managed_bootstrap_secret[0].result
+
+  # Rotation adds versions and moves the staging labels; a later apply
+  # must not put the placeholder back.
+  lifecycle {
+    ignore_changes = [secret_string, version_stages]
+  }
+}
+
+# Rotation function — same zip, second entry point.
+
+resource "aws_cloudwatch_log_group" "rotate" {
+  count             = local.managed_secret ? 1 : 0
+  name              = "/aws/lambda/${local.name}-rotate-secret"
+  retention_in_days = var.log_retention_days
+  tags              = local.common_tags
+}
+
+resource "aws_iam_role" "rotate" {
+  count              = local.managed_secret ? 1 : 0
+  name               = "${local.name}-rotate-secret"
+  assume_role_policy = data.aws_iam_policy_document.assume_role.json
+  tags               = local.common_tags
+}
+
+resource "aws_iam_role_policy_attachment" "rotate_logs" {
+  count      = local.managed_secret ? 1 : 0
+  role       = aws_iam_role.rotate[0].name
+  policy_arn = "arn:aws:iam::aws:policy/service-role/AWSLambdaBasicExecutionRole"
+}
+
+# The rotation steps' calls, on the one secret only.
+data "aws_iam_policy_document" "rotate" {
+  count = local.managed_secret ? 1 : 0
+
+  statement {
+    sid    = "RotateSharedSecret"
+    effect = "Allow"
+    actions = [
+      "secretsmanager:DescribeSecret",
+      "secretsmanager:GetSecretValue",
+      "secretsmanager:PutSecretValue",
+      "secretsmanager:UpdateSecretVersionStage",
+    ]
+    resources = [aws_secretsmanager_secret.shared_secret[0].arn]
+  }
+}
+
+resource "aws_iam_role_policy" "rotate" {
+  count  = local.managed_secret ? 1 : 0
+  name   = "secretsmanager-rotate-scoped"
+  role   = aws_iam_role.rotate[0].id
+  policy = data.aws_iam_policy_document.rotate[0].json
+}
+
+resource "aws_lambda_function" "rotate" {
+  count         = local.managed_secret ? 1 : 0
+  function_name = "${local.name}-rotate-secret"
+  role          = aws_iam_role.rotate[0].arn
+
+  runtime       = "provided.al2023"
+  architectures = ["arm64"]
+  handler       = "bootstrap"
+
+  filename         = var.lambda_zip_path
+  source_code_hash = filebase64sha256(var.lambda_zip_path)
+
+  memory_size = var.lambda_memory_mb
+  timeout     = 30
+
+  environment {
+    variables = {
+      DDDNS_ENTRYPOINT = "rotate-secret"
+      DDDNS_LOG_FORMAT = var.log_format
+      DDDNS_LOG_LEVEL  = var.log_level
+      GOMEMLIMIT       = "16MiB"
+    }
+  }
+
+  depends_on = [
+    aws_cloudwatch_log_group.rotate,
+    aws_iam_role_policy.rotate,
+  ]
+
+  tags = local.common_tags
+}
+
+resource "aws_lambda_permission" "rotate" {
+  count         = local.managed_secret ? 1 : 0
+  statement_id  = "AllowSecretsManagerRotation"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.rotate[0].function_name
+  principal     = "secretsmanager.amazonaws.com"
+  source_arn    = aws_secretsmanager_secret.shared_secret[0].arn
+}
+
+resource "aws_secretsmanager_secret_rotation" "shared_secret" {
+  count               = local.managed_secret ? 1 : 0
+  secret_id           = aws_secretsmanager_secret.shared_secret[0].id
+  rotation_lambda_arn = aws_lambda_function.rotate[0].arn
+
+  # Rotation must be enabled for on-demand rotations too; without
+  # secret_rotation_days the schedule is pushed out to the maximum.
+  rotation_rules {
+    automatically_after_days = var.secret_rotation_days > 0 ? var.secret_rotation_days : 1000
+  }
+  rotate_immediately = false
+
+  depends_on = [
+    aws_lambda_permission.rotate,
+    aws_secretsmanager_secret_version.bootstrap,
+  ]
+}
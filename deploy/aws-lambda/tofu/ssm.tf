@@ -1,10 +1,12 @@
-# SecureString parameter for the dyndns shared secret.
+# SecureString parameters for the dyndns shared secret(s), plus the
+# routing table of a multi-host deployment.
 #
 # Bootstrap flow:
-#   1. tofu apply creates the parameter with a random placeholder.
-#   2. Operator runs ../scripts/rotate-secret.sh, which calls
-#      `aws ssm put-parameter --overwrite` and prints the new
-#      secret in a framed block for pasting into UniFi UI.
+#   1. tofu apply creates each secret with a random placeholder.
+#   2. Operator runs ../scripts/rotate-secret.sh (-u USERNAME for a
+#      routes client), which calls `aws ssm put-parameter --overwrite`
+#      and prints the new secret in a framed block for pasting into
+#      UniFi UI.
 #   3. ignore_changes = [value] means subsequent `tofu apply` runs
 #      don't clobber the rotated value.
 #
@@ -12,17 +14,22 @@
 # script replaces it before any real client pushes. Its sole purpose
 # is to get a valid parameter into place so the Lambda's first cold
 # start doesn't crash on an empty SSM fetch.
+#
+# With secret_backend = "secretsmanager" the single-host secret lives
+# in Secrets Manager instead (secretsmanager.tf).
 
 resource "random_password" "bootstrap_secret" {
+  count   = local.ssm_secret ? 1 : 0
   length  = 32
   special = false
 }
 
 resource "aws_ssm_parameter" "shared_secret" {
+  count       = local.ssm_secret ? 1 : 0
   name        = var.ssm_parameter_name
   description = "dddns dyndns-v2 shared secret (rotated out-of-band; see deploy/aws-lambda/scripts/rotate-secret.sh)"
   type        = "SecureString"
-  value       = random_password.bootstrap_secret.result
+  value       = random_password.bootstrap_secret[0].result
   tier        = "Standard"
 
   tags = local.common_tags
@@ -31,3 +38,46 @@ resource "aws_ssm_parameter" "shared_secret" {
     ignore_changes = [value]
   }
 }
+
+# Multi-host: one secret per routes username.
+
+resource "random_password" "tenant_bootstrap_secret" {
+  for_each = local.tenants
+  length   = 32
+  special  = false
+}
+
+resource "aws_ssm_parameter" "tenant_secret" {
+  for_each    = local.tenants
+  name        = "${var.tenant_secrets_path}/${each.key}"
+  description = "dddns dyndns-v2 secret of client ${each.key} (rotate with scripts/rotate-secret.sh -u ${each.key})"
+  type        = "SecureString"
+  value       = random_password.tenant_bootstrap_secret[each.key].result
+  tier        = "Standard"
+
+  tags = local.common_tags
+
+  lifecycle {
+    ignore_changes = [value]
+  }
+}
+
+# Multi-host: the routing table, one parameter per hostname, read by
+# the Lambda with GetParametersByPath under routes_parameter_path.
+
+resource "aws_ssm_parameter" "route" {
+  for_each    = { for r in var.routes : lower(r.hostname) => r }
+  name        = "${var.routes_parameter_path}/${each.key}"
+  description = "dddns route: ${each.key} → ${each.value.hosted_zone_id}, client ${each.value.username}"
+  type        = "String"
+  value = jsonencode({
+    hostname       = each.key
+    hosted_zone_id = each.value.hosted_zone_id
+    ttl            = each.value.ttl # null = the Lambda's DDDNS_TTL default
+    username       = each.value.username
+    secret_param   = aws_ssm_parameter.tenant_secret[each.value.username].name
+  })
+  tier = "Standard"
+
+  tags = local.common_tags
+}
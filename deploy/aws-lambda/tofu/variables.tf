@@ -4,21 +4,70 @@
 
 variable "hosted_zone_id" {
   type        = string
-  description = "Route53 hosted zone ID that contains the record to update (e.g. 'Z1ABCDEFGHIJKL')."
+  description = "Route53 hosted zone ID that contains the record to update (e.g. 'Z1ABCDEFGHIJKL'). Leave empty when 'routes' is set."
+  default     = ""
 
   validation {
-    condition     = can(regex("^Z[A-Z0-9]+$", var.hosted_zone_id))
+    condition     = var.hosted_zone_id == "" || can(regex("^Z[A-Z0-9]+$", var.hosted_zone_id))
     error_message = "hosted_zone_id must look like a Route53 zone ID (starts with 'Z', uppercase alphanumerics)."
   }
 }
 
 variable "hostname" {
   type        = string
-  description = "FQDN of the A record the Lambda is authorised to update (e.g. 'home.example.com'). Must exist inside the hosted zone — the IAM policy scopes write access to this record name only."
+  description = "FQDN of the A record the Lambda is authorised to update (e.g. 'home.example.com'). Must exist inside the hosted zone — the IAM policy scopes write access to this record name only. Leave empty when 'routes' is set."
+  default     = ""
 
   validation {
-    condition     = length(var.hostname) > 0 && !endswith(var.hostname, ".")
-    error_message = "hostname must be non-empty and must NOT end in a trailing dot — Route53 normalises automatically."
+    condition     = !endswith(var.hostname, ".")
+    error_message = "hostname must NOT end in a trailing dot — Route53 normalises automatically."
+  }
+}
+
+variable "routes" {
+  type = list(object({
+    hostname       = string
+    hosted_zone_id = string
+    username       = string
+    ttl            = optional(number)
+  }))
+  description = "Multi-host routing table: each hostname, the zone it lives in, and the client (Basic Auth username) allowed to update it. When set, hostname, hosted_zone_id and ssm_parameter_name are unused; each username gets its own secret under tenant_secrets_path, and a client can only update its own hostnames."
+  default     = []
+
+  validation {
+    condition = alltrue([for r in var.routes :
+      length(r.hostname) > 0 && !endswith(r.hostname, ".") &&
+      can(regex("^Z[A-Z0-9]+$", r.hosted_zone_id)) &&
+      can(regex("^[A-Za-z0-9_.-]+$", r.username))
+    ])
+    error_message = "each route needs a hostname without a trailing dot, a Route53 zone ID, and a username of letters, digits, '_', '.' or '-'."
+  }
+
+  validation {
+    condition     = length(distinct([for r in var.routes : lower(r.hostname)])) == length(var.routes)
+    error_message = "each hostname may appear in routes only once."
+  }
+}
+
+variable "routes_parameter_path" {
+  type        = string
+  description = "SSM path the routing table is published under, one parameter per hostname. Only used with 'routes'."
+  default     = "/dddns/routes"
+
+  validation {
+    condition     = startswith(var.routes_parameter_path, "/") && !endswith(var.routes_parameter_path, "/")
+    error_message = "routes_parameter_path must start with '/' and must not end with '/'."
+  }
+}
+
+variable "tenant_secrets_path" {
+  type        = string
+  description = "SSM path holding one SecureString secret per routes username. Rotate each via scripts/rotate-secret.sh -u USERNAME after the first apply."
+  default     = "/dddns/secrets"
+
+  validation {
+    condition     = startswith(var.tenant_secrets_path, "/") && !endswith(var.tenant_secrets_path, "/")
+    error_message = "tenant_secrets_path must start with '/' and must not end with '/'."
   }
 }
 
@@ -45,6 +94,56 @@ variable "ssm_parameter_name" {
   }
 }
 
+variable "secret_backend" {
+  type        = string
+  description = "Where the shared secret lives: \"ssm\" (a SecureString at ssm_parameter_name, rotated with scripts/rotate-secret.sh) or \"secretsmanager\" (a Secrets Manager secret rotated by a rotation Lambda; the previous value stays accepted until the next rotation). Single-host deployments only."
+  default     = "ssm"
+
+  validation {
+    condition     = contains(["ssm", "secretsmanager"], var.secret_backend)
+    error_message = "secret_backend must be \"ssm\" or \"secretsmanager\"."
+  }
+}
+
+variable "secret_rotation_days" {
+  type        = number
+  description = "With secret_backend = \"secretsmanager\": rotate the shared secret automatically every this many days. Push clients must be given the new value before the following rotation retires the old one. 0 disables automatic rotation (rotate on demand with scripts/rotate-secret.sh)."
+  default     = 0
+
+  validation {
+    condition     = var.secret_rotation_days == 0 || (var.secret_rotation_days >= 1 && var.secret_rotation_days <= 1000)
+    error_message = "secret_rotation_days must be 0 or between 1 and 1000."
+  }
+}
+
+variable "config_file" {
+  type        = string
+  description = "Path to a dddns config.yaml the Lambda takes its settings from, validated as on the device. Single-host: its hostname, zone, TTL and (with server.shared_secret) dyndns secret; its hostname and hosted_zone_id must equal the variables of the same name, which scope the IAM policy. With routes: the default TTL, Route53 credentials and server.require_signature only. Empty = configure through the variables alone."
+  default     = ""
+}
+
+variable "config_store" {
+  type        = string
+  description = "Where config_file is kept for the Lambda: \"ssm\" (a SecureString at config_parameter_name) or \"kms\" (a ciphertext under a dedicated KMS key, in the function's environment)."
+  default     = "ssm"
+
+  validation {
+    condition     = contains(["ssm", "kms"], var.config_store)
+    error_message = "config_store must be \"ssm\" or \"kms\"."
+  }
+}
+
+variable "config_parameter_name" {
+  type        = string
+  description = "SSM Parameter Store path of config_file with config_store = \"ssm\". Must start with '/'."
+  default     = "/dddns/config"
+
+  validation {
+    condition     = startswith(var.config_parameter_name, "/")
+    error_message = "config_parameter_name must start with '/'."
+  }
+}
+
 variable "reserved_concurrency" {
   type        = number
   description = "Reserved concurrent executions for the Lambda. Caps simultaneous invocations — a safety ceiling against cost runaway if a misconfigured client hammers the endpoint. 2 is plenty for a single DDNS push stream."
@@ -81,6 +180,132 @@ variable "throttle_rate" {
   default     = 10
 }
 
+variable "function_url" {
+  type        = bool
+  description = "Serve the push endpoint from a Lambda Function URL instead of an API Gateway HTTP API: no gateway charge, but no gateway throttling either (throttle_burst and throttle_rate do not apply; reserved_concurrency caps the rate)."
+  default     = false
+}
+
+variable "require_signature" {
+  type        = bool
+  description = "Reject Basic Auth and accept only HMAC-SHA256 signed requests (DDDNS-HMAC-SHA256 scheme). Leave false while the push client is UniFi's inadyn, which can only send Basic Auth."
+  default     = false
+}
+
+variable "dual_stack" {
+  type        = bool
+  description = "Serve the endpoint over IPv4 and IPv6. A push arriving over IPv6 updates the hostname's AAAA record (the IAM policy then also grants AAAA); one arriving over IPv4 updates the A record. A client fills both by pushing once over each family."
+  default     = false
+}
+
+variable "update_store" {
+  type        = bool
+  description = "Create a DynamoDB table recording the address last published per hostname (an unchanged push answers 'nochg' without calling Route53; concurrent duplicate pushes UPSERT once) and an update history."
+  default     = false
+}
+
+variable "history_retention_days" {
+  type        = number
+  description = "Days DynamoDB keeps each update-history entry before TTL expiry. 0 keeps no history. Only used with update_store."
+  default     = 30
+
+  validation {
+    condition     = var.history_retention_days >= 0 && floor(var.history_retention_days) == var.history_retention_days
+    error_message = "history_retention_days must be a whole number of days, 0 or more."
+  }
+}
+
+variable "auth_lockout" {
+  type        = bool
+  description = "Lock a source address (IPv6: its /64) out after max_auth_failures bad secrets or signatures within auth_failure_window, for lockout_duration — serve mode's policy, kept in DynamoDB so it holds across Lambda containers. Shares the update_store table."
+  default     = false
+}
+
+variable "max_auth_failures" {
+  type        = number
+  description = "Auth failures within auth_failure_window that lock a source out. Only used with auth_lockout."
+  default     = 5
+
+  validation {
+    condition     = var.max_auth_failures >= 1 && floor(var.max_auth_failures) == var.max_auth_failures
+    error_message = "max_auth_failures must be a whole number, 1 or more."
+  }
+}
+
+variable "auth_failure_window" {
+  type        = string
+  description = "Window in which max_auth_failures must occur, as a Go duration (\"60s\", \"2m\"). Only used with auth_lockout."
+  default     = "60s"
+
+  validation {
+    condition     = can(regex("^([0-9]+(\\.[0-9]+)?(ms|s|m|h))+$", var.auth_failure_window))
+    error_message = "auth_failure_window must be a duration such as 60s or 2m."
+  }
+}
+
+variable "lockout_duration" {
+  type        = string
+  description = "How long a locked-out source gets badauth regardless of its credentials, as a Go duration (\"5m\", \"1h\"). Only used with auth_lockout."
+  default     = "5m"
+
+  validation {
+    condition     = can(regex("^([0-9]+(\\.[0-9]+)?(ms|s|m|h))+$", var.lockout_duration))
+    error_message = "lockout_duration must be a duration such as 5m or 1h."
+  }
+}
+
+variable "metrics" {
+  type        = bool
+  description = "Emit CloudWatch Embedded Metric Format records (requests by outcome, auth failures, SSM cache hits/misses, Route53 latency) and create the dashboard, alarms and Logs Insights query over them. Adds roughly a dozen custom metrics to the bill."
+  default     = false
+}
+
+variable "metrics_namespace" {
+  type        = string
+  description = "CloudWatch namespace of the Lambda's metrics. Only used with metrics."
+  default     = "dddns"
+}
+
+variable "alarm_actions" {
+  type        = list(string)
+  description = "ARNs (typically an SNS topic) notified when a metrics alarm fires or recovers. Empty = alarms only change state."
+  default     = []
+}
+
+variable "alarm_auth_failures" {
+  type        = number
+  description = "Alarm when this many badauth responses arrive within 5 minutes. Only used with metrics."
+  default     = 20
+}
+
+variable "alarm_route53_latency_ms" {
+  type        = number
+  description = "Alarm when the p90 Route53 UPSERT latency over 15 minutes reaches this many milliseconds. Only used with metrics."
+  default     = 5000
+}
+
+variable "log_format" {
+  type        = string
+  description = "Lambda log output format: \"json\" (one object per line, queryable in CloudWatch Logs Insights) or \"text\"."
+  default     = "json"
+
+  validation {
+    condition     = contains(["json", "text"], var.log_format)
+    error_message = "log_format must be \"json\" or \"text\"."
+  }
+}
+
+variable "log_level" {
+  type        = string
+  description = "Minimum Lambda log level: debug, info, notice, warn or error."
+  default     = "info"
+
+  validation {
+    condition     = contains(["debug", "info", "notice", "warn", "error"], var.log_level)
+    error_message = "log_level must be one of debug, info, notice, warn, error."
+  }
+}
+
 variable "lambda_zip_path" {
   type        = string
   description = "Path to the pre-built Lambda zip relative to the tofu module. The 'just build-aws-lambda' recipe produces this file."
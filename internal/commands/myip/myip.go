@@ -72,3 +72,24 @@ func ValidatePublicIP(ip string) error {
 	}
 	return nil
 }
+
+// ValidatePublicAddr is ValidatePublicIP for either address family, for
+// callers that can publish an AAAA record too. IPv4 (including
+// IPv4-mapped IPv6) gets the ValidatePublicIP checks; IPv6 must be
+// globally-routable unicast outside the unique-local fc00::/7 range.
+func ValidatePublicAddr(ip string) error {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return fmt.Errorf("invalid IP address: %q", ip)
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return ValidatePublicIP(v4.String())
+	}
+	if !parsed.IsGlobalUnicast() {
+		return fmt.Errorf("IP is not globally unicast: %q", ip)
+	}
+	if parsed.IsPrivate() {
+		return fmt.Errorf("IP is in a unique-local (fc00::/7) range: %q", ip)
+	}
+	return nil
+}
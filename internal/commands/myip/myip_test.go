@@ -98,3 +98,31 @@ func TestValidatePublicIP(t *testing.T) {
 		})
 	}
 }
+
+func TestValidatePublicAddr(t *testing.T) {
+	tests := []struct {
+		name    string
+		ip      string
+		wantErr bool
+	}{
+		{"valid public v4", "1.2.3.4", false},
+		{"valid public v6", "2001:db8::1", false},
+		{"v4-mapped public", "::ffff:1.2.3.4", false},
+		{"v4-mapped private", "::ffff:192.168.1.1", true},
+		{"private v4", "10.0.0.1", true},
+		{"empty", "", true},
+		{"loopback v6", "::1", true},
+		{"unspecified v6", "::", true},
+		{"link-local v6", "fe80::1", true},
+		{"unique-local v6", "fd00::1", true},
+		{"multicast v6", "ff02::1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePublicAddr(tt.ip)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidatePublicAddr(%q) error = %v, wantErr %v", tt.ip, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -58,6 +58,25 @@ type Config struct {
 	// on your network routinely approach 30 s.
 	UpdateTimeout string `yaml:"update_timeout,omitempty"`
 
+	// Log routes operational log output to syslog, journald or a file.
+	// nil = plain text on stderr.
+	Log *LogConfig `yaml:"log,omitempty"`
+
+	// Notify posts change (and optionally failure) notifications to
+	// webhooks. nil = no notifications.
+	Notify *NotifyConfig `yaml:"notify,omitempty"`
+
+	// Hooks runs local commands before and after an update. nil = none.
+	Hooks *HooksConfig `yaml:"hooks,omitempty"`
+
+	// MQTT publishes the update state to a broker, optionally with Home
+	// Assistant discovery. nil = disabled.
+	MQTT *MQTTConfig `yaml:"mqtt,omitempty"`
+
+	// Escalation holds back failure notifications and the failing exit
+	// code until failures persist. nil = every failure escalates.
+	Escalation *EscalationConfig `yaml:"escalation,omitempty"`
+
 	// Server holds parameters for serve mode (dddns serve). nil when the
 	// `server:` block is absent from the config file, which disables serve
 	// mode. See ServerConfig for fields.
@@ -78,14 +97,7 @@ func (c *Config) UpdateIntervalOrDefault() string {
 // malformed. Malformed values are tolerated silently here — Validate()
 // is responsible for surfacing parse errors at config-check time.
 func (c *Config) UpdateTimeoutOrDefault() time.Duration {
-	if c.UpdateTimeout == "" {
-		return DefaultUpdateTimeout
-	}
-	d, err := time.ParseDuration(c.UpdateTimeout)
-	if err != nil || d <= 0 {
-		return DefaultUpdateTimeout
-	}
-	return d
+	return DurationOrDefault(c.UpdateTimeout, DefaultUpdateTimeout)
 }
 
 // ServerConfig holds parameters for serve mode (dddns serve).
@@ -99,6 +111,65 @@ type ServerConfig struct {
 	AllowedCIDRs []string `yaml:"allowed_cidrs"`
 	AuditLog     string   `yaml:"audit_log,omitempty"`
 	WANInterface string   `yaml:"wan_interface,omitempty"`
+
+	// AuditHMACKey, when set, adds an HMAC-SHA256 over each audit
+	// entry's chain hash, so the chain cannot be recomputed by someone
+	// who can edit the file but does not hold the key. Stored as
+	// audit_hmac_vault in the secure config.
+	AuditHMACKey string `yaml:"audit_hmac_key,omitempty"`
+
+	// Audit log rotation. The live file is rotated once it reaches
+	// AuditMaxSizeMB; rotated generations are gzipped and the newest
+	// AuditMaxFiles are kept, minus any older than AuditMaxAge. Zero
+	// values select the defaults in internal/server (10 MB, 5 files, no
+	// age limit).
+	AuditMaxSizeMB int    `yaml:"audit_max_size_mb,omitempty"`
+	AuditMaxFiles  int    `yaml:"audit_max_files,omitempty"`
+	AuditMaxAge    string `yaml:"audit_max_age,omitempty"`
+
+	// AuditSinks forwards every audit entry, in addition to the
+	// AuditLog file, to syslog or journald with one structured field per
+	// entry field. The file stays authoritative: `serve audit` and
+	// `serve audit verify` read only it.
+	AuditSinks []LogSinkConfig `yaml:"audit_sinks,omitempty"`
+
+	// Auth lockout and rate-limit tuning, applied per client address.
+	// Zero values select the defaults in internal/server (5 failures per
+	// 60s → 5m lockout; 10 successful requests per 60s).
+	MaxAuthFailures   int    `yaml:"max_auth_failures,omitempty"`
+	AuthFailureWindow string `yaml:"auth_failure_window,omitempty"`
+	LockoutDuration   string `yaml:"lockout_duration,omitempty"`
+	RateLimit         int    `yaml:"rate_limit,omitempty"`
+	RateLimitWindow   string `yaml:"rate_limit_window,omitempty"`
+
+	// RequireSignature rejects Basic Auth, accepting only HMAC-signed
+	// requests (internal/reqsign). SignatureWindow is the accepted clock
+	// skew on the signed timestamp; empty = 5m.
+	RequireSignature bool   `yaml:"require_signature,omitempty"`
+	SignatureWindow  string `yaml:"signature_window,omitempty"`
+
+	// TrustedProxies lists the CIDRs of reverse proxies whose
+	// X-Forwarded-For / Forwarded headers (and PROXY protocol headers,
+	// when ProxyProtocol is set) are believed. Empty = headers ignored,
+	// the TCP peer is the client.
+	TrustedProxies []string `yaml:"trusted_proxies,omitempty"`
+	ProxyProtocol  bool     `yaml:"proxy_protocol,omitempty"`
+
+	// Metrics enables the Prometheus /metrics endpoint. nil (block
+	// absent) disables it.
+	Metrics *MetricsConfig `yaml:"metrics,omitempty"`
+}
+
+// MetricsConfig controls the opt-in Prometheus exposition endpoint.
+//
+// With Bind empty, /metrics is mounted on the main serve listener and
+// inherits its CIDR allowlist. With Bind set, a second listener serves
+// only /metrics — useful when the scraper lives on a network that must
+// not reach /nic/update.
+type MetricsConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	Bind         string   `yaml:"bind,omitempty"`
+	AllowedCIDRs []string `yaml:"allowed_cidrs,omitempty"` // empty = inherit server.allowed_cidrs
 }
 
 // Validate reports whether the server block is well-formed. It is called
@@ -124,13 +195,95 @@ func (s *ServerConfig) Validate() error {
 			return fmt.Errorf("server.allowed_cidrs: %q is not a valid CIDR: %w", c, err)
 		}
 	}
+	if s.MaxAuthFailures < 0 {
+		return fmt.Errorf("server.max_auth_failures must not be negative")
+	}
+	if s.RateLimit < 0 {
+		return fmt.Errorf("server.rate_limit must not be negative")
+	}
+	if s.AuditMaxSizeMB < 0 {
+		return fmt.Errorf("server.audit_max_size_mb must not be negative")
+	}
+	if s.AuditMaxFiles < 0 {
+		return fmt.Errorf("server.audit_max_files must not be negative")
+	}
+	for _, d := range []struct{ field, value string }{
+		{"auth_failure_window", s.AuthFailureWindow},
+		{"lockout_duration", s.LockoutDuration},
+		{"rate_limit_window", s.RateLimitWindow},
+		{"signature_window", s.SignatureWindow},
+		{"audit_max_age", s.AuditMaxAge},
+	} {
+		if err := validatePositiveDuration("server."+d.field, d.value); err != nil {
+			return err
+		}
+	}
+	if err := validateLogSinks("server.audit_sinks", s.AuditSinks, "syslog", "journald"); err != nil {
+		return err
+	}
+	if s.AuditHMACKey != "" && len(s.AuditHMACKey) < 32 {
+		return fmt.Errorf("server.audit_hmac_key must be at least 32 characters")
+	}
+	for _, c := range s.TrustedProxies {
+		if _, _, err := net.ParseCIDR(c); err != nil {
+			return fmt.Errorf("server.trusted_proxies: %q is not a valid CIDR: %w", c, err)
+		}
+	}
+	if s.ProxyProtocol && len(s.TrustedProxies) == 0 {
+		return fmt.Errorf("server.proxy_protocol requires server.trusted_proxies (headers are only accepted from trusted peers)")
+	}
+	if m := s.Metrics; m != nil && m.Enabled {
+		if m.Bind != "" {
+			if _, _, err := net.SplitHostPort(m.Bind); err != nil {
+				return fmt.Errorf("server.metrics.bind %q is not host:port: %w", m.Bind, err)
+			}
+			if m.Bind == s.Bind {
+				return fmt.Errorf("server.metrics.bind must differ from server.bind (leave it empty to share the listener)")
+			}
+		}
+		for _, c := range m.AllowedCIDRs {
+			if _, _, err := net.ParseCIDR(c); err != nil {
+				return fmt.Errorf("server.metrics.allowed_cidrs: %q is not a valid CIDR: %w", c, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validatePositiveDuration accepts an empty value (meaning "use the
+// default") or a positive Go duration string.
+func validatePositiveDuration(field, value string) error {
+	if value == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("%s %q is not a valid duration (e.g. \"60s\", \"5m\"): %w", field, value, err)
+	}
+	if d <= 0 {
+		return fmt.Errorf("%s %q must be positive", field, value)
+	}
 	return nil
 }
 
+// DurationOrDefault parses value as a Go duration, returning def when
+// value is empty, malformed, or non-positive. Validate is responsible
+// for surfacing malformed values; this helper only resolves them.
+func DurationOrDefault(value string, def time.Duration) time.Duration {
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return def
+	}
+	return d
+}
+
 // Load reads configuration from the file recorded by SetActivePath.
-// Encrypted .secure paths are delegated to LoadSecure. Defaults are
-// applied before YAML is parsed so any fields set in the file override
-// them.
+// Encrypted .secure paths are delegated to LoadSecure. The file is
+// decoded by Parse, over its defaults; an ip_cache_file the file does
+// not set defaults to the active profile's cache path.
 func Load() (*Config, error) {
 	configFile := activeConfigPath
 	if configFile != "" && strings.HasSuffix(configFile, ".secure") {
@@ -144,18 +297,22 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("resolve cache path: %w", err)
 	}
 
-	cfg := &Config{
-		// Default values — overridden by YAML below if present.
-		AWSRegion:   "us-east-1",
-		TTL:         300,
-		IPCacheFile: cachePath,
+	withDefaults := func(data []byte) (*Config, error) {
+		cfg, err := Parse(data)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.IPCacheFile == "" {
+			cfg.IPCacheFile = cachePath
+		}
+		return cfg, nil
 	}
 
 	// If no config file is active, return just defaults — the caller
 	// will typically run Validate() which will report the missing
 	// required fields.
 	if configFile == "" {
-		return cfg, nil
+		return withDefaults(nil)
 	}
 
 	// Permission check BEFORE read: plaintext config holds AWS credentials,
@@ -165,7 +322,7 @@ func Load() (*Config, error) {
 	info, err := os.Stat(configFile)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return cfg, nil
+			return withDefaults(nil)
 		}
 		return nil, fmt.Errorf("stat config: %w", err)
 	}
@@ -178,26 +335,59 @@ func Load() (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("read config: %w", err)
 	}
+	return withDefaults(data)
+}
+
+// Parse decodes a config document in the config.yaml format over the
+// defaults: us-east-1 and a 300 s TTL. ip_cache_file has no default
+// here; Load supplies the device's. Parse is also for configs that do
+// not live in a file, such as the Lambda's; the caller runs Validate.
+func Parse(data []byte) (*Config, error) {
+	cfg := &Config{
+		// Default values — overridden by YAML below if present.
+		AWSRegion: "us-east-1",
+		TTL:       300,
+	}
 	if err := yaml.Unmarshal(data, cfg); err != nil {
 		return nil, fmt.Errorf("parse config: %w", err)
 	}
 	return cfg, nil
 }
 
+// ValidateOptions relax ValidateWith for a config that does not drive
+// a device, such as the Lambda's config document. The zero value is
+// Validate's checks.
+type ValidateOptions struct {
+	// AmbientCredentials accepts a config without aws_access_key and
+	// aws_secret_key, for a caller that signs with credentials of its
+	// own (an execution role). One key without the other is still an
+	// error.
+	AmbientCredentials bool
+	// NoHost accepts a config without hosted_zone_id and hostname, for
+	// a caller that routes hostnames itself.
+	NoHost bool
+}
+
 // Validate checks the top-level Config. It does not validate the Server
 // block — that is ServerConfig.Validate's job, called by `dddns serve`.
 func (c *Config) Validate() error {
+	return c.ValidateWith(ValidateOptions{})
+}
+
+// ValidateWith is Validate with the checks opts relaxes skipped.
+func (c *Config) ValidateWith(opts ValidateOptions) error {
 	// AWS credentials are required for security (no env vars allowed)
-	if c.AWSAccessKey == "" {
+	ambient := opts.AmbientCredentials && c.AWSAccessKey == "" && c.AWSSecretKey == ""
+	if c.AWSAccessKey == "" && !ambient {
 		return fmt.Errorf("aws_access_key is required in config file")
 	}
-	if c.AWSSecretKey == "" {
+	if c.AWSSecretKey == "" && !ambient {
 		return fmt.Errorf("aws_secret_key is required in config file")
 	}
-	if c.HostedZoneID == "" {
+	if c.HostedZoneID == "" && !opts.NoHost {
 		return fmt.Errorf("hosted_zone_id is required")
 	}
-	if c.Hostname == "" {
+	if c.Hostname == "" && !opts.NoHost {
 		return fmt.Errorf("hostname is required")
 	}
 	if c.TTL <= 0 {
@@ -218,6 +408,31 @@ func (c *Config) Validate() error {
 			return fmt.Errorf("update_timeout %q must be positive", c.UpdateTimeout)
 		}
 	}
+	if c.Log != nil {
+		if err := c.Log.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.Notify != nil {
+		if err := c.Notify.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.Hooks != nil {
+		if err := c.Hooks.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.MQTT != nil {
+		if err := c.MQTT.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.Escalation != nil {
+		if err := c.Escalation.Validate(); err != nil {
+			return err
+		}
+	}
 	// UpdateInterval has crontab syntax; full validation would pull in a
 	// cron parser. Skip here — a malformed schedule surfaces immediately
 	// when cron (re)loads the file on the target host, which is a faster
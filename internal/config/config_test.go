@@ -54,6 +54,23 @@ ip_cache_file: "/tmp/test-dddns-cache.txt"`
 	}
 }
 
+func TestParse(t *testing.T) {
+	cfg, err := config.Parse([]byte("hosted_zone_id: Z1234567890ABC\nhostname: test.example.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Hostname != "test.example.com" || cfg.HostedZoneID != "Z1234567890ABC" {
+		t.Errorf("parsed %q in %q", cfg.Hostname, cfg.HostedZoneID)
+	}
+	if cfg.AWSRegion != "us-east-1" || cfg.TTL != 300 || cfg.IPCacheFile != "" {
+		t.Errorf("defaults = region %q, ttl %d, cache %q", cfg.AWSRegion, cfg.TTL, cfg.IPCacheFile)
+	}
+
+	if _, err := config.Parse([]byte("ttl: [300]")); err == nil {
+		t.Error("Parse accepted a list as ttl")
+	}
+}
+
 func TestValidateConfig(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -108,6 +125,29 @@ func TestValidateConfig(t *testing.T) {
 	}
 }
 
+func TestValidateWith(t *testing.T) {
+	noKeys := config.Config{HostedZoneID: "Z1234567890ABC", Hostname: "test.example.com", TTL: 300}
+	if err := noKeys.Validate(); err == nil {
+		t.Error("Validate accepted a config without AWS keys")
+	}
+	if err := noKeys.ValidateWith(config.ValidateOptions{AmbientCredentials: true}); err != nil {
+		t.Errorf("AmbientCredentials: %v", err)
+	}
+	oneKey := noKeys
+	oneKey.AWSAccessKey = "AKIATEST"
+	if err := oneKey.ValidateWith(config.ValidateOptions{AmbientCredentials: true}); err == nil || !strings.Contains(err.Error(), "aws_secret_key") {
+		t.Errorf("AmbientCredentials with one key: err = %v, want aws_secret_key", err)
+	}
+
+	noHost := config.Config{TTL: 300}
+	if err := noHost.ValidateWith(config.ValidateOptions{AmbientCredentials: true}); err == nil {
+		t.Error("ValidateWith accepted a config without a host")
+	}
+	if err := noHost.ValidateWith(config.ValidateOptions{AmbientCredentials: true, NoHost: true}); err != nil {
+		t.Errorf("NoHost: %v", err)
+	}
+}
+
 func TestCreateDefaultConfig(t *testing.T) {
 	tmpDir := t.TempDir()
 	configPath := filepath.Join(tmpDir, ".dddns", "config.yaml")
@@ -289,6 +329,170 @@ func TestConfigValidate_BadIPSource(t *testing.T) {
 	}
 }
 
+func TestConfigValidate_BadLogFormat(t *testing.T) {
+	cfg := config.Config{
+		AWSAccessKey: "a",
+		AWSSecretKey: "s",
+		HostedZoneID: "Z",
+		Hostname:     "h.example.com",
+		TTL:          300,
+		Log:          &config.LogConfig{Format: "logfmt"},
+	}
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "log.format") {
+		t.Errorf("expected log.format validation error, got: %v", err)
+	}
+}
+
+func TestNotifyConfigValidate(t *testing.T) {
+	good := config.NotifyConfig{
+		Timeout:  "5s",
+		Attempts: 3,
+		Webhooks: []config.WebhookConfig{
+			{Name: "hook", URL: "https://example.com/hook", Events: []string{"updated", "failed"}, Secret: "s"},
+			{Name: "phone", Format: "ntfy", URL: "https://ntfy.sh/dddns"},
+			{Format: "gotify", URL: "http://gotify.lan", Token: "t"},
+		},
+	}
+	if err := good.Validate(); err != nil {
+		t.Errorf("valid config rejected: %v", err)
+	}
+
+	cases := []struct {
+		name string
+		mut  func(*config.NotifyConfig)
+		want string
+	}{
+		{"bad timeout", func(n *config.NotifyConfig) { n.Timeout = "soon" }, "notify.timeout"},
+		{"too many attempts", func(n *config.NotifyConfig) { n.Attempts = 11 }, "notify.attempts"},
+		{"bad format", func(n *config.NotifyConfig) { n.Webhooks[0].Format = "pager" }, "notify.webhooks[0].format"},
+		{"bad scheme", func(n *config.NotifyConfig) { n.Webhooks[0].URL = "ftp://example.com" }, "notify.webhooks[0].url"},
+		{"no host", func(n *config.NotifyConfig) { n.Webhooks[1].URL = "https://" }, "notify.webhooks[1].url"},
+		{"bad event", func(n *config.NotifyConfig) { n.Webhooks[0].Events = []string{"nochg"} }, "notify.webhooks[0].events"},
+		{"gotify without token", func(n *config.NotifyConfig) { n.Webhooks[2].Token = "" }, "notify.webhooks[2].token"},
+		{"duplicate name", func(n *config.NotifyConfig) { n.Webhooks[1].Name = "hook" }, "used twice"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			n := good
+			n.Webhooks = append([]config.WebhookConfig(nil), good.Webhooks...)
+			c.mut(&n)
+			err := n.Validate()
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Errorf("Validate() = %v, want error containing %q", err, c.want)
+			}
+		})
+	}
+}
+
+func TestEmailConfigValidate(t *testing.T) {
+	good := config.EmailConfig{
+		Host: "smtp.example.com", Username: "dddns", Password: "pw",
+		From: "dddns <dddns@example.com>", To: []string{"ops@example.com"},
+		Events: []string{"failed"}, FailureThreshold: 5, MinInterval: "30m",
+	}
+	if err := good.Validate(); err != nil {
+		t.Errorf("valid config rejected: %v", err)
+	}
+	cases := []struct {
+		name string
+		mut  func(*config.EmailConfig)
+		want string
+	}{
+		{"no host", func(e *config.EmailConfig) { e.Host = "" }, "notify.email.host"},
+		{"bad port", func(e *config.EmailConfig) { e.Port = 70000 }, "notify.email.port"},
+		{"bad security", func(e *config.EmailConfig) { e.Security = "ssl" }, "notify.email.security"},
+		{"bad auth", func(e *config.EmailConfig) { e.Auth = "cram-md5" }, "notify.email.auth"},
+		{"credentials in clear", func(e *config.EmailConfig) { e.Security = "none" }, "clear text"},
+		{"password without user", func(e *config.EmailConfig) { e.Username = "" }, "notify.email.username"},
+		{"bad from", func(e *config.EmailConfig) { e.From = "dddns" }, "notify.email.from"},
+		{"no recipients", func(e *config.EmailConfig) { e.To = nil }, "notify.email.to"},
+		{"bad recipient", func(e *config.EmailConfig) { e.To = []string{"ops@"} }, "notify.email.to[0]"},
+		{"bad event", func(e *config.EmailConfig) { e.Events = []string{"ok"} }, "notify.email.events"},
+		{"negative threshold", func(e *config.EmailConfig) { e.FailureThreshold = -1 }, "failure_threshold"},
+		{"bad interval", func(e *config.EmailConfig) { e.MinInterval = "0s" }, "notify.email.min_interval"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			e := good
+			c.mut(&e)
+			err := (&config.NotifyConfig{Email: &e}).Validate()
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Errorf("Validate() = %v, want error containing %q", err, c.want)
+			}
+		})
+	}
+}
+
+func TestHooksConfigValidate(t *testing.T) {
+	good := config.HooksConfig{PreUpdate: []string{"/data/dddns/pre.sh"}, OnError: []string{"sh", "-c", "logger dddns failed"}, Timeout: "20s"}
+	if err := good.Validate(); err != nil {
+		t.Errorf("valid config rejected: %v", err)
+	}
+	for _, bad := range []config.HooksConfig{
+		{Timeout: "-1s"},
+		{PostUpdate: []string{"", "arg"}},
+	} {
+		if err := bad.Validate(); err == nil || !strings.Contains(err.Error(), "hooks.") {
+			t.Errorf("Validate(%+v) = %v, want a hooks.* error", bad, err)
+		}
+	}
+}
+
+func TestMQTTConfigValidate(t *testing.T) {
+	good := config.MQTTConfig{Broker: "tls://mqtt.lan:8883", Username: "dddns", Password: "pw", Discovery: true, QoS: 1, Timeout: "5s"}
+	if err := good.Validate(); err != nil {
+		t.Errorf("valid config rejected: %v", err)
+	}
+	cases := []struct {
+		name string
+		mut  func(*config.MQTTConfig)
+		want string
+	}{
+		{"no broker", func(m *config.MQTTConfig) { m.Broker = "" }, "mqtt.broker"},
+		{"bad scheme", func(m *config.MQTTConfig) { m.Broker = "ws://mqtt.lan" }, "mqtt.broker scheme"},
+		{"qos 2", func(m *config.MQTTConfig) { m.QoS = 2 }, "mqtt.qos"},
+		{"wildcard prefix", func(m *config.MQTTConfig) { m.TopicPrefix = "dddns/#" }, "mqtt.topic_prefix"},
+		{"wildcard discovery", func(m *config.MQTTConfig) { m.DiscoveryPrefix = "ha/+" }, "mqtt.discovery_prefix"},
+		{"password without user", func(m *config.MQTTConfig) { m.Username = "" }, "mqtt.username"},
+		{"bad timeout", func(m *config.MQTTConfig) { m.Timeout = "0s" }, "mqtt.timeout"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := good
+			c.mut(&m)
+			if err := m.Validate(); err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Errorf("Validate() = %v, want error containing %q", err, c.want)
+			}
+		})
+	}
+}
+
+func TestEscalationConfigValidate(t *testing.T) {
+	for _, good := range []config.EscalationConfig{{Failures: 3}, {Duration: "2h"}, {Failures: 6, Duration: "3h"}} {
+		if err := good.Validate(); err != nil {
+			t.Errorf("valid config %+v rejected: %v", good, err)
+		}
+	}
+	cases := []struct {
+		name string
+		esc  config.EscalationConfig
+		want string
+	}{
+		{"empty", config.EscalationConfig{}, "set failures, duration, or both"},
+		{"negative failures", config.EscalationConfig{Failures: -1}, "escalation.failures"},
+		{"bad duration", config.EscalationConfig{Duration: "soon"}, "escalation.duration"},
+		{"zero duration", config.EscalationConfig{Failures: 2, Duration: "0s"}, "escalation.duration"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.esc.Validate(); err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Errorf("Validate() = %v, want error containing %q", err, c.want)
+			}
+		})
+	}
+}
+
 func TestServerConfigValidate(t *testing.T) {
 	good := config.ServerConfig{
 		Bind:         "127.0.0.1:53353",
@@ -309,6 +513,30 @@ func TestServerConfigValidate(t *testing.T) {
 		{"missing secret", func(s *config.ServerConfig) { s.SharedSecret = "" }, "shared_secret"},
 		{"empty cidrs", func(s *config.ServerConfig) { s.AllowedCIDRs = nil }, "allowed_cidrs"},
 		{"bad cidr", func(s *config.ServerConfig) { s.AllowedCIDRs = []string{"not-a-cidr"} }, "CIDR"},
+		{"negative max failures", func(s *config.ServerConfig) { s.MaxAuthFailures = -1 }, "max_auth_failures"},
+		{"negative rate limit", func(s *config.ServerConfig) { s.RateLimit = -1 }, "rate_limit"},
+		{"bad failure window", func(s *config.ServerConfig) { s.AuthFailureWindow = "soon" }, "auth_failure_window"},
+		{"zero lockout", func(s *config.ServerConfig) { s.LockoutDuration = "0s" }, "must be positive"},
+		{"bad rate window", func(s *config.ServerConfig) { s.RateLimitWindow = "-1m" }, "rate_limit_window"},
+		{"bad signature window", func(s *config.ServerConfig) { s.SignatureWindow = "5" }, "signature_window"},
+		{"bad metrics bind", func(s *config.ServerConfig) {
+			s.Metrics = &config.MetricsConfig{Enabled: true, Bind: "9100"}
+		}, "server.metrics.bind"},
+		{"metrics bind collides", func(s *config.ServerConfig) {
+			s.Metrics = &config.MetricsConfig{Enabled: true, Bind: s.Bind}
+		}, "must differ"},
+		{"bad metrics cidr", func(s *config.ServerConfig) {
+			s.Metrics = &config.MetricsConfig{Enabled: true, AllowedCIDRs: []string{"10.0.0.0/33"}}
+		}, "server.metrics.allowed_cidrs"},
+		{"short audit hmac key", func(s *config.ServerConfig) { s.AuditHMACKey = "short" }, "audit_hmac_key"},
+		{"negative audit max files", func(s *config.ServerConfig) { s.AuditMaxFiles = -1 }, "audit_max_files"},
+		{"bad audit max age", func(s *config.ServerConfig) { s.AuditMaxAge = "forever" }, "audit_max_age"},
+		{"file audit sink", func(s *config.ServerConfig) { s.AuditSinks = []config.LogSinkConfig{{Type: "file", Path: "/tmp/x"}} }, "audit_sinks[0].type"},
+		{"bad syslog scheme", func(s *config.ServerConfig) {
+			s.AuditSinks = []config.LogSinkConfig{{Type: "syslog", Address: "http://collector"}}
+		}, "udp://"},
+		{"bad trusted proxy", func(s *config.ServerConfig) { s.TrustedProxies = []string{"10.0.0.1"} }, "server.trusted_proxies"},
+		{"proxy protocol without trust", func(s *config.ServerConfig) { s.ProxyProtocol = true }, "requires server.trusted_proxies"},
 	}
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
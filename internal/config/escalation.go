@@ -0,0 +1,33 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// EscalationConfig sets when a run of consecutive update failures is
+// treated as an outage. Until either threshold is crossed, failures are
+// recorded but `dddns update` exits 0 and no failure notifications are
+// sent; once crossed, the failure is reported once, and a recovery is
+// reported when the next update succeeds. nil = every failure escalates.
+type EscalationConfig struct {
+	// Failures is the number of consecutive failures that escalates.
+	Failures int `yaml:"failures,omitempty"`
+	// Duration escalates once the first failure of the streak is this
+	// old (e.g. "2h").
+	Duration string `yaml:"duration,omitempty"`
+}
+
+// Validate reports whether the escalation block is well-formed.
+func (e *EscalationConfig) Validate() error {
+	if e.Failures < 0 {
+		return fmt.Errorf("escalation.failures %d must not be negative", e.Failures)
+	}
+	if err := validatePositiveDuration("escalation.duration", e.Duration); err != nil {
+		return err
+	}
+	if e.Failures == 0 && e.Duration == "" {
+		return errors.New("escalation: set failures, duration, or both")
+	}
+	return nil
+}
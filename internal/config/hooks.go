@@ -0,0 +1,42 @@
+package config
+
+import "fmt"
+
+// HooksConfig runs local commands around a DNS update. Each command is
+// an argv list executed directly, without a shell; use
+// ["sh", "-c", "..."] for shell syntax.
+//
+//   - PreUpdate runs before a change is published. A non-zero exit
+//     vetoes the update; the run ends as "vetoed", not as a failure.
+//   - PostUpdate runs after the record changed. A non-zero exit is
+//     logged; the update still succeeded.
+//   - OnError runs when the update fails.
+//
+// Hooks do not run on no-change or dry runs. Timeout bounds each
+// command (default 10s).
+type HooksConfig struct {
+	PreUpdate  []string `yaml:"pre_update,omitempty"`
+	PostUpdate []string `yaml:"post_update,omitempty"`
+	OnError    []string `yaml:"on_error,omitempty"`
+	Timeout    string   `yaml:"timeout,omitempty"`
+}
+
+// Validate reports whether the hooks block is well-formed.
+func (h *HooksConfig) Validate() error {
+	if err := validatePositiveDuration("hooks.timeout", h.Timeout); err != nil {
+		return err
+	}
+	for _, hook := range []struct {
+		name string
+		argv []string
+	}{
+		{"hooks.pre_update", h.PreUpdate},
+		{"hooks.post_update", h.PostUpdate},
+		{"hooks.on_error", h.OnError},
+	} {
+		if len(hook.argv) > 0 && hook.argv[0] == "" {
+			return fmt.Errorf("%s: the command (first element) is empty", hook.name)
+		}
+	}
+	return nil
+}
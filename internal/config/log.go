@@ -0,0 +1,102 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// LogConfig routes dddns's operational log output to one or more sinks.
+// nil or an empty Sinks list keeps the default: stderr. Format selects
+// slog's "text" (default) or "json" rendering for stderr and file
+// sinks; syslog and journald always carry fields structurally.
+type LogConfig struct {
+	Format string          `yaml:"format,omitempty"`
+	Sinks  []LogSinkConfig `yaml:"sinks,omitempty"`
+}
+
+// LogSinkConfig describes one log destination.
+//
+//   - type "stderr": log lines on stderr (the default).
+//   - type "file": log lines appended to Path.
+//   - type "syslog": RFC 5424 to Address — "udp://host:514",
+//     "tcp://host:514", or "unix:///dev/log". Empty = the local /dev/log.
+//   - type "journald": systemd-journald's native protocol on its socket
+//     (Path overrides /run/systemd/journal/socket), with structured
+//     DDDNS_* fields.
+//
+// Facility is a syslog facility name (default "daemon"); Tag is the
+// syslog APP-NAME / journald SYSLOG_IDENTIFIER (default "dddns").
+type LogSinkConfig struct {
+	Type     string `yaml:"type"`
+	Address  string `yaml:"address,omitempty"`
+	Path     string `yaml:"path,omitempty"`
+	Facility string `yaml:"facility,omitempty"`
+	Tag      string `yaml:"tag,omitempty"`
+}
+
+// SyslogFacilities maps the RFC 5424 facility names accepted in
+// LogSinkConfig.Facility to their numeric codes.
+var SyslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// validateLogSinks reports whether every sink is well-formed and of an
+// allowed type. field prefixes error messages ("log.sinks",
+// "server.audit_sinks").
+func validateLogSinks(field string, sinks []LogSinkConfig, allowed ...string) error {
+	for i, s := range sinks {
+		where := fmt.Sprintf("%s[%d]", field, i)
+		ok := false
+		for _, t := range allowed {
+			ok = ok || s.Type == t
+		}
+		if !ok {
+			return fmt.Errorf("%s.type %q must be one of: %v", where, s.Type, allowed)
+		}
+		if s.Facility != "" {
+			if _, ok := SyslogFacilities[s.Facility]; !ok {
+				return fmt.Errorf("%s.facility %q is not a syslog facility name", where, s.Facility)
+			}
+		}
+		switch s.Type {
+		case "file":
+			if s.Path == "" {
+				return fmt.Errorf("%s.path is required for a file sink", where)
+			}
+		case "syslog":
+			if s.Address == "" {
+				continue
+			}
+			u, err := url.Parse(s.Address)
+			if err != nil {
+				return fmt.Errorf("%s.address %q: %w", where, s.Address, err)
+			}
+			switch u.Scheme {
+			case "udp", "tcp":
+				if u.Host == "" {
+					return fmt.Errorf("%s.address %q has no host:port", where, s.Address)
+				}
+			case "unix":
+				if u.Path == "" {
+					return fmt.Errorf("%s.address %q has no socket path", where, s.Address)
+				}
+			default:
+				return fmt.Errorf("%s.address %q must start with udp://, tcp:// or unix://", where, s.Address)
+			}
+		}
+	}
+	return nil
+}
+
+// Validate reports whether the log block is well-formed.
+func (l *LogConfig) Validate() error {
+	switch l.Format {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("log.format %q must be \"text\" or \"json\"", l.Format)
+	}
+	return validateLogSinks("log.sinks", l.Sinks, "stderr", "file", "syslog", "journald")
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// MQTTConfig publishes the update state to an MQTT 3.1.1 broker after
+// every update, with optional Home Assistant discovery.
+//
+// Broker is "tcp://host:1883" or "tls://host:8883" (mqtt:// and
+// mqtts:// are accepted too; the port defaults by scheme). CAFile adds
+// a PEM CA bundle for TLS brokers with a private CA. Password is stored
+// encrypted in the secure config.
+//
+// State is published, retained, under TopicPrefix (default
+// "dddns/<hostname>"). With Discovery set, sensor configs go under
+// DiscoveryPrefix (default "homeassistant"). QoS is 0 (default) or 1;
+// Timeout bounds connecting and publishing (default 10s).
+type MQTTConfig struct {
+	Broker          string `yaml:"broker"`
+	ClientID        string `yaml:"client_id,omitempty"`
+	Username        string `yaml:"username,omitempty"`
+	Password        string `yaml:"password,omitempty"`
+	CAFile          string `yaml:"ca_file,omitempty"`
+	TopicPrefix     string `yaml:"topic_prefix,omitempty"`
+	Discovery       bool   `yaml:"discovery,omitempty"`
+	DiscoveryPrefix string `yaml:"discovery_prefix,omitempty"`
+	QoS             int    `yaml:"qos,omitempty"`
+	Timeout         string `yaml:"timeout,omitempty"`
+}
+
+// MQTTSchemes are the accepted MQTTConfig.Broker URL schemes.
+var MQTTSchemes = []string{"tcp", "mqtt", "tls", "mqtts", "ssl"}
+
+// Validate reports whether the mqtt block is well-formed.
+func (m *MQTTConfig) Validate() error {
+	u, err := url.Parse(m.Broker)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("mqtt.broker %q must be a URL such as tcp://host:1883 or tls://host:8883", m.Broker)
+	}
+	known := false
+	for _, s := range MQTTSchemes {
+		known = known || u.Scheme == s
+	}
+	if !known {
+		return fmt.Errorf("mqtt.broker scheme %q must be one of: %v", u.Scheme, MQTTSchemes)
+	}
+	if m.QoS != 0 && m.QoS != 1 {
+		return fmt.Errorf("mqtt.qos %d must be 0 or 1", m.QoS)
+	}
+	if strings.ContainsAny(m.TopicPrefix, "+#") {
+		return fmt.Errorf("mqtt.topic_prefix %q must not contain MQTT wildcards", m.TopicPrefix)
+	}
+	if strings.ContainsAny(m.DiscoveryPrefix, "+#") {
+		return fmt.Errorf("mqtt.discovery_prefix %q must not contain MQTT wildcards", m.DiscoveryPrefix)
+	}
+	if m.Password != "" && m.Username == "" {
+		return fmt.Errorf("mqtt.password requires mqtt.username")
+	}
+	return validatePositiveDuration("mqtt.timeout", m.Timeout)
+}
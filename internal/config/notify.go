@@ -0,0 +1,173 @@
+package config
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"slices"
+)
+
+// NotifyConfig sends change notifications to webhooks and, with Email
+// set, by mail. Each webhook fires on the events it lists; the default is "updated" only, so a
+// failing cron run stays quiet unless "failed" is asked for.
+//
+// Timeout bounds each delivery attempt (default 10s). Attempts is the
+// total number of tries per webhook, retrying with backoff after a
+// network error, 429 or 5xx (default 3; 1 disables retries).
+type NotifyConfig struct {
+	Timeout  string          `yaml:"timeout,omitempty"`
+	Attempts int             `yaml:"attempts,omitempty"`
+	Webhooks []WebhookConfig `yaml:"webhooks,omitempty"`
+	Email    *EmailConfig    `yaml:"email,omitempty"`
+}
+
+// WebhookConfig describes one notification endpoint.
+//
+//   - format "generic" (default): the event as JSON, or Template's
+//     output, which must be JSON.
+//   - "slack", "discord", "teams": the service's incoming-webhook
+//     message shape with the rendered text.
+//   - "ntfy": URL is the topic URL (https://ntfy.sh/<topic>); Token, if
+//     set, is sent as a bearer token.
+//   - "gotify": URL is the server base URL; Token is the application
+//     token.
+//
+// Template is a text/template rendered with the event: the whole body
+// for generic, the message text for the others. Secret, when set, signs
+// the body with HMAC-SHA256 (X-Dddns-Signature). URL, Secret and Token
+// are stored encrypted in the secure config.
+type WebhookConfig struct {
+	Name     string            `yaml:"name,omitempty"`
+	Format   string            `yaml:"format,omitempty"`
+	URL      string            `yaml:"url"`
+	Events   []string          `yaml:"events,omitempty"`
+	Template string            `yaml:"template,omitempty"`
+	Secret   string            `yaml:"secret,omitempty"`
+	Token    string            `yaml:"token,omitempty"`
+	Headers  map[string]string `yaml:"headers,omitempty"`
+}
+
+// EmailConfig sends notifications by mail through an SMTP relay.
+//
+// Security is "starttls" (default, port 587), "tls" (implicit TLS, port
+// 465) or "none" (port 25, no credentials allowed). Auth is "plain" or
+// "login"; empty picks what the server advertises, preferring PLAIN.
+// Password is stored encrypted in the secure config.
+//
+// Events defaults to all of NotifyEvents. A failure is mailed once
+// FailureThreshold consecutive updates have failed (default 3), or when
+// the streak crosses the top-level escalation thresholds, and once per
+// failure streak; "recovered" follows only a mailed failure. MinInterval is the least time between
+// two mails (default 15m); mails inside it are dropped and counted in
+// the next one.
+type EmailConfig struct {
+	Host             string   `yaml:"host"`
+	Port             int      `yaml:"port,omitempty"`
+	Security         string   `yaml:"security,omitempty"`
+	Auth             string   `yaml:"auth,omitempty"`
+	Username         string   `yaml:"username,omitempty"`
+	Password         string   `yaml:"password,omitempty"`
+	From             string   `yaml:"from"`
+	To               []string `yaml:"to"`
+	Events           []string `yaml:"events,omitempty"`
+	FailureThreshold int      `yaml:"failure_threshold,omitempty"`
+	MinInterval      string   `yaml:"min_interval,omitempty"`
+}
+
+// EmailSecurity and EmailAuth are the accepted EmailConfig.Security and
+// EmailConfig.Auth values; "" selects the default.
+var (
+	EmailSecurity = []string{"starttls", "tls", "none"}
+	EmailAuth     = []string{"plain", "login"}
+)
+
+// WebhookFormats are the accepted WebhookConfig.Format values; "" is
+// generic.
+var WebhookFormats = []string{"generic", "slack", "discord", "teams", "ntfy", "gotify"}
+
+// NotifyEvents are the events a webhook can subscribe to.
+var NotifyEvents = []string{"updated", "failed", "recovered"}
+
+// MaxNotifyAttempts caps NotifyConfig.Attempts.
+const MaxNotifyAttempts = 10
+
+// Validate reports whether the notify block is well-formed.
+func (n *NotifyConfig) Validate() error {
+	if err := validatePositiveDuration("notify.timeout", n.Timeout); err != nil {
+		return err
+	}
+	if n.Attempts < 0 || n.Attempts > MaxNotifyAttempts {
+		return fmt.Errorf("notify.attempts %d must be between 1 and %d", n.Attempts, MaxNotifyAttempts)
+	}
+	names := map[string]bool{}
+	for i, w := range n.Webhooks {
+		where := fmt.Sprintf("notify.webhooks[%d]", i)
+		if w.Format != "" && !slices.Contains(WebhookFormats, w.Format) {
+			return fmt.Errorf("%s.format %q must be one of: %v", where, w.Format, WebhookFormats)
+		}
+		u, err := url.Parse(w.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s.url must be an http:// or https:// URL", where)
+		}
+		for _, e := range w.Events {
+			if !slices.Contains(NotifyEvents, e) {
+				return fmt.Errorf("%s.events: %q must be one of: %v", where, e, NotifyEvents)
+			}
+		}
+		if w.Format == "gotify" && w.Token == "" {
+			return fmt.Errorf("%s.token is required for gotify", where)
+		}
+		if w.Name != "" {
+			if names[w.Name] {
+				return fmt.Errorf("%s.name %q is used twice", where, w.Name)
+			}
+			names[w.Name] = true
+		}
+	}
+	if n.Email != nil {
+		return n.Email.Validate()
+	}
+	return nil
+}
+
+// Validate reports whether the notify.email block is well-formed.
+func (e *EmailConfig) Validate() error {
+	if e.Host == "" {
+		return fmt.Errorf("notify.email.host is required")
+	}
+	if e.Port < 0 || e.Port > 65535 {
+		return fmt.Errorf("notify.email.port %d is out of range", e.Port)
+	}
+	if e.Security != "" && !slices.Contains(EmailSecurity, e.Security) {
+		return fmt.Errorf("notify.email.security %q must be one of: %v", e.Security, EmailSecurity)
+	}
+	if e.Auth != "" && !slices.Contains(EmailAuth, e.Auth) {
+		return fmt.Errorf("notify.email.auth %q must be one of: %v", e.Auth, EmailAuth)
+	}
+	if e.Password != "" && e.Username == "" {
+		return fmt.Errorf("notify.email.password requires notify.email.username")
+	}
+	if e.Username != "" && e.Security == "none" {
+		return fmt.Errorf("notify.email.username requires security starttls or tls; credentials are never sent in clear text")
+	}
+	if _, err := mail.ParseAddress(e.From); err != nil {
+		return fmt.Errorf("notify.email.from %q is not a valid address", e.From)
+	}
+	if len(e.To) == 0 {
+		return fmt.Errorf("notify.email.to needs at least one recipient")
+	}
+	for i, to := range e.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("notify.email.to[%d] %q is not a valid address", i, to)
+		}
+	}
+	for _, ev := range e.Events {
+		if !slices.Contains(NotifyEvents, ev) {
+			return fmt.Errorf("notify.email.events: %q must be one of: %v", ev, NotifyEvents)
+		}
+	}
+	if e.FailureThreshold < 0 {
+		return fmt.Errorf("notify.email.failure_threshold %d must not be negative", e.FailureThreshold)
+	}
+	return validatePositiveDuration("notify.email.min_interval", e.MinInterval)
+}
@@ -25,6 +25,16 @@ type SecureConfig struct {
 	IPCacheFile string `yaml:"ip_cache_file"`
 	IPSource    string `yaml:"ip_source,omitempty"`
 
+	Log *LogConfig `yaml:"log,omitempty"`
+
+	Notify *SecureNotifyConfig `yaml:"notify,omitempty"`
+
+	Hooks *HooksConfig `yaml:"hooks,omitempty"`
+
+	MQTT *SecureMQTTConfig `yaml:"mqtt,omitempty"`
+
+	Escalation *EscalationConfig `yaml:"escalation,omitempty"`
+
 	// Server holds the serve-mode parameters. SecretVault is the encrypted
 	// form of the plaintext ServerConfig.SharedSecret.
 	Server *SecureServerConfig `yaml:"server,omitempty"`
@@ -38,6 +48,201 @@ type SecureServerConfig struct {
 	AllowedCIDRs []string `yaml:"allowed_cidrs"`
 	AuditLog     string   `yaml:"audit_log,omitempty"`
 	WANInterface string   `yaml:"wan_interface,omitempty"`
+
+	AuditHMACVault string `yaml:"audit_hmac_vault,omitempty"`
+
+	AuditMaxSizeMB int    `yaml:"audit_max_size_mb,omitempty"`
+	AuditMaxFiles  int    `yaml:"audit_max_files,omitempty"`
+	AuditMaxAge    string `yaml:"audit_max_age,omitempty"`
+
+	AuditSinks []LogSinkConfig `yaml:"audit_sinks,omitempty"`
+
+	MaxAuthFailures   int    `yaml:"max_auth_failures,omitempty"`
+	AuthFailureWindow string `yaml:"auth_failure_window,omitempty"`
+	LockoutDuration   string `yaml:"lockout_duration,omitempty"`
+	RateLimit         int    `yaml:"rate_limit,omitempty"`
+	RateLimitWindow   string `yaml:"rate_limit_window,omitempty"`
+
+	RequireSignature bool   `yaml:"require_signature,omitempty"`
+	SignatureWindow  string `yaml:"signature_window,omitempty"`
+
+	TrustedProxies []string `yaml:"trusted_proxies,omitempty"`
+	ProxyProtocol  bool     `yaml:"proxy_protocol,omitempty"`
+
+	Metrics *MetricsConfig `yaml:"metrics,omitempty"`
+}
+
+// SecureNotifyConfig is the at-rest form of NotifyConfig.
+type SecureNotifyConfig struct {
+	Timeout  string                `yaml:"timeout,omitempty"`
+	Attempts int                   `yaml:"attempts,omitempty"`
+	Webhooks []SecureWebhookConfig `yaml:"webhooks,omitempty"`
+	Email    *SecureEmailConfig    `yaml:"email,omitempty"`
+}
+
+// SecureWebhookConfig is the at-rest form of WebhookConfig. Webhook URLs
+// often embed the credential (Slack, Discord, Teams), so the URL is
+// encrypted along with Secret and Token.
+type SecureWebhookConfig struct {
+	Name        string            `yaml:"name,omitempty"`
+	Format      string            `yaml:"format,omitempty"`
+	URLVault    string            `yaml:"url_vault"`
+	Events      []string          `yaml:"events,omitempty"`
+	Template    string            `yaml:"template,omitempty"`
+	SecretVault string            `yaml:"secret_vault,omitempty"`
+	TokenVault  string            `yaml:"token_vault,omitempty"`
+	Headers     map[string]string `yaml:"headers,omitempty"`
+}
+
+// SecureEmailConfig is the at-rest form of EmailConfig with the SMTP
+// password in a vault.
+type SecureEmailConfig struct {
+	Host             string   `yaml:"host"`
+	Port             int      `yaml:"port,omitempty"`
+	Security         string   `yaml:"security,omitempty"`
+	Auth             string   `yaml:"auth,omitempty"`
+	Username         string   `yaml:"username,omitempty"`
+	PasswordVault    string   `yaml:"password_vault,omitempty"`
+	From             string   `yaml:"from"`
+	To               []string `yaml:"to"`
+	Events           []string `yaml:"events,omitempty"`
+	FailureThreshold int      `yaml:"failure_threshold,omitempty"`
+	MinInterval      string   `yaml:"min_interval,omitempty"`
+}
+
+// SecureMQTTConfig is the at-rest form of MQTTConfig with the broker
+// password in a vault.
+type SecureMQTTConfig struct {
+	Broker          string `yaml:"broker"`
+	ClientID        string `yaml:"client_id,omitempty"`
+	Username        string `yaml:"username,omitempty"`
+	PasswordVault   string `yaml:"password_vault,omitempty"`
+	CAFile          string `yaml:"ca_file,omitempty"`
+	TopicPrefix     string `yaml:"topic_prefix,omitempty"`
+	Discovery       bool   `yaml:"discovery,omitempty"`
+	DiscoveryPrefix string `yaml:"discovery_prefix,omitempty"`
+	QoS             int    `yaml:"qos,omitempty"`
+	Timeout         string `yaml:"timeout,omitempty"`
+}
+
+// encryptOptional encrypts v, leaving an empty value empty.
+func encryptOptional(field, v string) (string, error) {
+	if v == "" {
+		return "", nil
+	}
+	enc, err := crypto.EncryptString(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt %s: %w", field, err)
+	}
+	return enc, nil
+}
+
+// decryptOptional reverses encryptOptional.
+func decryptOptional(field, v string) (string, error) {
+	if v == "" {
+		return "", nil
+	}
+	dec, err := crypto.DecryptString(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt %s: %w", field, err)
+	}
+	return dec, nil
+}
+
+// secureNotify encrypts the webhook credentials in n.
+func secureNotify(n *NotifyConfig) (*SecureNotifyConfig, error) {
+	if n == nil {
+		return nil, nil
+	}
+	s := &SecureNotifyConfig{Timeout: n.Timeout, Attempts: n.Attempts}
+	for i, w := range n.Webhooks {
+		where := fmt.Sprintf("notify.webhooks[%d]", i)
+		sw := SecureWebhookConfig{
+			Name:     w.Name,
+			Format:   w.Format,
+			Events:   w.Events,
+			Template: w.Template,
+			Headers:  w.Headers,
+		}
+		var err error
+		if sw.URLVault, err = encryptOptional(where+".url", w.URL); err != nil {
+			return nil, err
+		}
+		if sw.SecretVault, err = encryptOptional(where+".secret", w.Secret); err != nil {
+			return nil, err
+		}
+		if sw.TokenVault, err = encryptOptional(where+".token", w.Token); err != nil {
+			return nil, err
+		}
+		s.Webhooks = append(s.Webhooks, sw)
+	}
+	if e := n.Email; e != nil {
+		s.Email = &SecureEmailConfig{
+			Host:             e.Host,
+			Port:             e.Port,
+			Security:         e.Security,
+			Auth:             e.Auth,
+			Username:         e.Username,
+			From:             e.From,
+			To:               e.To,
+			Events:           e.Events,
+			FailureThreshold: e.FailureThreshold,
+			MinInterval:      e.MinInterval,
+		}
+		var err error
+		if s.Email.PasswordVault, err = encryptOptional("notify.email.password", e.Password); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// plainNotify decrypts the webhook credentials in s.
+func plainNotify(s *SecureNotifyConfig) (*NotifyConfig, error) {
+	if s == nil {
+		return nil, nil
+	}
+	n := &NotifyConfig{Timeout: s.Timeout, Attempts: s.Attempts}
+	for i, sw := range s.Webhooks {
+		where := fmt.Sprintf("notify.webhooks[%d]", i)
+		w := WebhookConfig{
+			Name:     sw.Name,
+			Format:   sw.Format,
+			Events:   sw.Events,
+			Template: sw.Template,
+			Headers:  sw.Headers,
+		}
+		var err error
+		if w.URL, err = decryptOptional(where+".url_vault", sw.URLVault); err != nil {
+			return nil, err
+		}
+		if w.Secret, err = decryptOptional(where+".secret_vault", sw.SecretVault); err != nil {
+			return nil, err
+		}
+		if w.Token, err = decryptOptional(where+".token_vault", sw.TokenVault); err != nil {
+			return nil, err
+		}
+		n.Webhooks = append(n.Webhooks, w)
+	}
+	if se := s.Email; se != nil {
+		n.Email = &EmailConfig{
+			Host:             se.Host,
+			Port:             se.Port,
+			Security:         se.Security,
+			Auth:             se.Auth,
+			Username:         se.Username,
+			From:             se.From,
+			To:               se.To,
+			Events:           se.Events,
+			FailureThreshold: se.FailureThreshold,
+			MinInterval:      se.MinInterval,
+		}
+		var err error
+		if n.Email.Password, err = decryptOptional("notify.email.password_vault", se.PasswordVault); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
 }
 
 // SaveSecure saves config with encrypted credentials
@@ -57,6 +262,30 @@ func SaveSecure(cfg *Config, path string) error {
 		TTL:                 cfg.TTL,
 		IPCacheFile:         cfg.IPCacheFile,
 		IPSource:            cfg.IPSource,
+		Log:                 cfg.Log,
+		Hooks:               cfg.Hooks,
+		Escalation:          cfg.Escalation,
+	}
+	if secureCfg.Notify, err = secureNotify(cfg.Notify); err != nil {
+		return err
+	}
+	if m := cfg.MQTT; m != nil {
+		passwordVault, err := encryptOptional("mqtt.password", m.Password)
+		if err != nil {
+			return err
+		}
+		secureCfg.MQTT = &SecureMQTTConfig{
+			Broker:          m.Broker,
+			ClientID:        m.ClientID,
+			Username:        m.Username,
+			PasswordVault:   passwordVault,
+			CAFile:          m.CAFile,
+			TopicPrefix:     m.TopicPrefix,
+			Discovery:       m.Discovery,
+			DiscoveryPrefix: m.DiscoveryPrefix,
+			QoS:             m.QoS,
+			Timeout:         m.Timeout,
+		}
 	}
 
 	// Encrypt the server block if present.
@@ -65,12 +294,40 @@ func SaveSecure(cfg *Config, path string) error {
 		if err != nil {
 			return fmt.Errorf("failed to encrypt server.shared_secret: %w", err)
 		}
+		var auditVault string
+		if cfg.Server.AuditHMACKey != "" {
+			if auditVault, err = crypto.EncryptString(cfg.Server.AuditHMACKey); err != nil {
+				return fmt.Errorf("failed to encrypt server.audit_hmac_key: %w", err)
+			}
+		}
 		secureCfg.Server = &SecureServerConfig{
 			Bind:         cfg.Server.Bind,
 			SecretVault:  secretVault,
 			AllowedCIDRs: cfg.Server.AllowedCIDRs,
 			AuditLog:     cfg.Server.AuditLog,
 			WANInterface: cfg.Server.WANInterface,
+
+			AuditHMACVault: auditVault,
+
+			AuditMaxSizeMB: cfg.Server.AuditMaxSizeMB,
+			AuditMaxFiles:  cfg.Server.AuditMaxFiles,
+			AuditMaxAge:    cfg.Server.AuditMaxAge,
+
+			AuditSinks: cfg.Server.AuditSinks,
+
+			MaxAuthFailures:   cfg.Server.MaxAuthFailures,
+			AuthFailureWindow: cfg.Server.AuthFailureWindow,
+			LockoutDuration:   cfg.Server.LockoutDuration,
+			RateLimit:         cfg.Server.RateLimit,
+			RateLimitWindow:   cfg.Server.RateLimitWindow,
+
+			RequireSignature: cfg.Server.RequireSignature,
+			SignatureWindow:  cfg.Server.SignatureWindow,
+
+			TrustedProxies: cfg.Server.TrustedProxies,
+			ProxyProtocol:  cfg.Server.ProxyProtocol,
+
+			Metrics: cfg.Server.Metrics,
 		}
 	}
 
@@ -136,6 +393,30 @@ func LoadSecure(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to decrypt credentials: %w", err)
 	}
 
+	notifyCfg, err := plainNotify(secureCfg.Notify)
+	if err != nil {
+		return nil, err
+	}
+	var mqttCfg *MQTTConfig
+	if m := secureCfg.MQTT; m != nil {
+		password, err := decryptOptional("mqtt.password_vault", m.PasswordVault)
+		if err != nil {
+			return nil, err
+		}
+		mqttCfg = &MQTTConfig{
+			Broker:          m.Broker,
+			ClientID:        m.ClientID,
+			Username:        m.Username,
+			Password:        password,
+			CAFile:          m.CAFile,
+			TopicPrefix:     m.TopicPrefix,
+			Discovery:       m.Discovery,
+			DiscoveryPrefix: m.DiscoveryPrefix,
+			QoS:             m.QoS,
+			Timeout:         m.Timeout,
+		}
+	}
+
 	// Decrypt the server block if present.
 	var serverCfg *ServerConfig
 	if secureCfg.Server != nil {
@@ -143,12 +424,40 @@ func LoadSecure(path string) (*Config, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to decrypt server.secret_vault: %w", err)
 		}
+		var auditKey string
+		if secureCfg.Server.AuditHMACVault != "" {
+			if auditKey, err = crypto.DecryptString(secureCfg.Server.AuditHMACVault); err != nil {
+				return nil, fmt.Errorf("failed to decrypt server.audit_hmac_vault: %w", err)
+			}
+		}
 		serverCfg = &ServerConfig{
 			Bind:         secureCfg.Server.Bind,
 			SharedSecret: sharedSecret,
 			AllowedCIDRs: secureCfg.Server.AllowedCIDRs,
 			AuditLog:     secureCfg.Server.AuditLog,
 			WANInterface: secureCfg.Server.WANInterface,
+
+			AuditHMACKey: auditKey,
+
+			AuditMaxSizeMB: secureCfg.Server.AuditMaxSizeMB,
+			AuditMaxFiles:  secureCfg.Server.AuditMaxFiles,
+			AuditMaxAge:    secureCfg.Server.AuditMaxAge,
+
+			AuditSinks: secureCfg.Server.AuditSinks,
+
+			MaxAuthFailures:   secureCfg.Server.MaxAuthFailures,
+			AuthFailureWindow: secureCfg.Server.AuthFailureWindow,
+			LockoutDuration:   secureCfg.Server.LockoutDuration,
+			RateLimit:         secureCfg.Server.RateLimit,
+			RateLimitWindow:   secureCfg.Server.RateLimitWindow,
+
+			RequireSignature: secureCfg.Server.RequireSignature,
+			SignatureWindow:  secureCfg.Server.SignatureWindow,
+
+			TrustedProxies: secureCfg.Server.TrustedProxies,
+			ProxyProtocol:  secureCfg.Server.ProxyProtocol,
+
+			Metrics: secureCfg.Server.Metrics,
 		}
 	}
 
@@ -162,6 +471,11 @@ func LoadSecure(path string) (*Config, error) {
 		TTL:          secureCfg.TTL,
 		IPCacheFile:  secureCfg.IPCacheFile,
 		IPSource:     secureCfg.IPSource,
+		Log:          secureCfg.Log,
+		Notify:       notifyCfg,
+		Hooks:        secureCfg.Hooks,
+		MQTT:         mqttCfg,
+		Escalation:   secureCfg.Escalation,
 		Server:       serverCfg,
 	}, nil
 }
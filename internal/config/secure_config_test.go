@@ -27,12 +27,41 @@ func TestSaveLoadSecure_WithServerBlock(t *testing.T) {
 		TTL:          300,
 		IPCacheFile:  filepath.Join(tmpDir, "cache.txt"),
 		IPSource:     "local",
+		Log:          &config.LogConfig{Sinks: []config.LogSinkConfig{{Type: "syslog", Address: "udp://10.0.0.5:514"}}},
+		Notify: &config.NotifyConfig{
+			Timeout:  "5s",
+			Attempts: 2,
+			Webhooks: []config.WebhookConfig{
+				{Name: "slack", Format: "slack", URL: "https://hooks.slack.com/services/T0/B0/xyz", Events: []string{"updated", "failed"}},
+				{Format: "gotify", URL: "https://gotify.lan", Token: "gotify-app-token", Secret: "hook-secret", Headers: map[string]string{"X-Env": "home"}},
+			},
+			Email: &config.EmailConfig{
+				Host: "smtp.example.com", Security: "tls", Username: "dddns", Password: "smtp-password",
+				From: "dddns@example.com", To: []string{"ops@example.com"}, FailureThreshold: 4, MinInterval: "1h",
+			},
+		},
+		Hooks:      &config.HooksConfig{PostUpdate: []string{"/data/dddns/post.sh", "--reload"}, Timeout: "20s"},
+		MQTT:       &config.MQTTConfig{Broker: "tls://mqtt.lan:8883", Username: "dddns", Password: "mqtt-password", Discovery: true, QoS: 1},
+		Escalation: &config.EscalationConfig{Failures: 4, Duration: "2h"},
 		Server: &config.ServerConfig{
 			Bind:         "127.0.0.1:53353",
 			SharedSecret: "super-secret-value",
 			AllowedCIDRs: []string{"127.0.0.0/8", "192.168.1.0/24"},
 			AuditLog:     "/var/log/dddns-audit.log",
 			WANInterface: "eth8",
+			AuditHMACKey: "audit-hmac-key-0123456789abcdef0123",
+			Metrics:      &config.MetricsConfig{Enabled: true, Bind: "0.0.0.0:9153"},
+
+			TrustedProxies: []string{"10.0.0.2/32"},
+			ProxyProtocol:  true,
+
+			RequireSignature: true,
+			SignatureWindow:  "2m",
+
+			AuditMaxSizeMB: 25,
+			AuditMaxFiles:  12,
+			AuditMaxAge:    "720h",
+			AuditSinks:     []config.LogSinkConfig{{Type: "journald"}},
 		},
 	}
 
@@ -69,6 +98,64 @@ func TestSaveLoadSecure_WithServerBlock(t *testing.T) {
 	if out.Server.WANInterface != "eth8" {
 		t.Errorf("WANInterface mismatch")
 	}
+	if out.Server.Metrics == nil || !out.Server.Metrics.Enabled || out.Server.Metrics.Bind != "0.0.0.0:9153" {
+		t.Errorf("Metrics did not round-trip: %+v", out.Server.Metrics)
+	}
+	if out.Server.AuditHMACKey != in.Server.AuditHMACKey {
+		t.Errorf("AuditHMACKey did not round-trip")
+	}
+	if !out.Server.RequireSignature || out.Server.SignatureWindow != "2m" {
+		t.Errorf("signature settings did not round-trip: %v %q", out.Server.RequireSignature, out.Server.SignatureWindow)
+	}
+	if out.Log == nil || len(out.Log.Sinks) != 1 || len(out.Server.AuditSinks) != 1 {
+		t.Errorf("log sinks did not round-trip: %+v %+v", out.Log, out.Server.AuditSinks)
+	}
+	if out.Server.AuditMaxSizeMB != 25 || out.Server.AuditMaxFiles != 12 || out.Server.AuditMaxAge != "720h" {
+		t.Errorf("audit rotation did not round-trip: %d %d %q", out.Server.AuditMaxSizeMB, out.Server.AuditMaxFiles, out.Server.AuditMaxAge)
+	}
+	if len(out.Server.TrustedProxies) != 1 || !out.Server.ProxyProtocol {
+		t.Errorf("proxy settings did not round-trip: %v %v", out.Server.TrustedProxies, out.Server.ProxyProtocol)
+	}
+
+	if out.Hooks == nil || len(out.Hooks.PostUpdate) != 2 || out.Hooks.Timeout != "20s" {
+		t.Errorf("Hooks did not round-trip: %+v", out.Hooks)
+	}
+
+	if out.MQTT == nil || *out.MQTT != *in.MQTT {
+		t.Errorf("MQTT did not round-trip: %+v", out.MQTT)
+	}
+
+	if out.Escalation == nil || *out.Escalation != *in.Escalation {
+		t.Errorf("Escalation did not round-trip: %+v", out.Escalation)
+	}
+
+	// Notify block: URLs, secrets and tokens are vaulted.
+	if out.Notify == nil || len(out.Notify.Webhooks) != 2 {
+		t.Fatalf("Notify did not round-trip: %+v", out.Notify)
+	}
+	if out.Notify.Timeout != "5s" || out.Notify.Attempts != 2 {
+		t.Errorf("notify settings did not round-trip: %+v", out.Notify)
+	}
+	for i := range in.Notify.Webhooks {
+		got, want := out.Notify.Webhooks[i], in.Notify.Webhooks[i]
+		if got.URL != want.URL || got.Token != want.Token || got.Secret != want.Secret || got.Format != want.Format ||
+			len(got.Events) != len(want.Events) || got.Headers["X-Env"] != want.Headers["X-Env"] {
+			t.Errorf("webhook %d = %+v, want %+v", i, got, want)
+		}
+	}
+	if e := out.Notify.Email; e == nil || e.Password != "smtp-password" || e.Security != "tls" || e.FailureThreshold != 4 ||
+		e.MinInterval != "1h" || len(e.To) != 1 {
+		t.Errorf("email did not round-trip: %+v", out.Notify.Email)
+	}
+	raw, err := os.ReadFile(securePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leaked := range []string{"hooks.slack.com", "gotify-app-token", "hook-secret", "mqtt-password", "smtp-password"} {
+		if strings.Contains(string(raw), leaked) {
+			t.Errorf("%q appears in plaintext in the .secure file", leaked)
+		}
+	}
 }
 
 // TestSaveSecure_SecretIsEncryptedAtRest verifies that reading the on-disk
@@ -2,7 +2,8 @@
 //
 // This client issues AWS SigV4-signed HTTP requests directly to the Route53
 // API (version 2013-04-01) for the two operations dddns needs: listing a
-// single A record set and upserting an A record. The public signatures match
+// single A record set and upserting an A (or, for an IPv6 address, AAAA)
+// record. The public signatures match
 // the prior SDK-backed implementation so callers are unaffected.
 package dns
 
@@ -12,6 +13,7 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
@@ -82,6 +84,13 @@ func NewFromConfig(ctx context.Context, cfg *dddnscfg.Config) (*Route53Client, e
 	return NewRoute53Client(ctx, cfg.AWSRegion, cfg.AWSAccessKey, cfg.AWSSecretKey, "", cfg.HostedZoneID, cfg.Hostname, cfg.TTL)
 }
 
+// SetEndpoint points the client at a Route53-compatible API other than
+// AWS's, such as LocalStack, for local runs of the Lambda handler.
+// baseURL has no trailing slash, like "http://localhost:4566".
+func (r *Route53Client) SetEndpoint(baseURL string) {
+	r.baseURL = strings.TrimSuffix(baseURL, "/")
+}
+
 // fqdn returns the configured hostname in FQDN form (guaranteed trailing dot).
 func (r *Route53Client) fqdn() string {
 	if strings.HasSuffix(r.hostname, ".") {
@@ -127,10 +136,12 @@ func (r *Route53Client) GetCurrentIP(ctx context.Context) (string, error) {
 	return "", fmt.Errorf("A record not found for %s", r.hostname) //nolint:staticcheck // "A record" is a DNS term, not an article
 }
 
-// UpdateIP UPSERTs the A record with a new IP address.
+// UpdateIP UPSERTs the record for newIP's address family — A for IPv4,
+// AAAA for IPv6 — leaving the other family's record untouched.
 // Callers are expected to handle dry-run short-circuits before invoking.
 func (r *Route53Client) UpdateIP(ctx context.Context, newIP string) error {
 	fqdn := r.fqdn()
+	rtype := RecordType(newIP)
 
 	body := changeResourceRecordSetsRequest{
 		Xmlns: route53Namespace,
@@ -141,7 +152,7 @@ func (r *Route53Client) UpdateIP(ctx context.Context, newIP string) error {
 						Action: "UPSERT",
 						ResourceRecordSet: resourceRecordSet{
 							Name: fqdn,
-							Type: "A",
+							Type: rtype,
 							TTL:  r.ttl,
 							ResourceRecords: &resourceRecords{
 								ResourceRecord: []resourceRecord{{Value: newIP}},
@@ -174,11 +185,20 @@ func (r *Route53Client) UpdateIP(ctx context.Context, newIP string) error {
 	}
 
 	if _, err := r.do(req, payloadHash, xmlBody); err != nil {
-		return fmt.Errorf("failed to update A record: %w", err)
+		return fmt.Errorf("failed to update %s record: %w", rtype, err)
 	}
 	return nil
 }
 
+// RecordType returns the record type that publishes ip: "AAAA" for an
+// IPv6 address, "A" otherwise. IPv4-mapped IPv6 addresses are IPv4.
+func RecordType(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+		return "AAAA"
+	}
+	return "A"
+}
+
 // do signs the request with SigV4 and executes it. On a non-2xx response it
 // parses the error body and returns a descriptive error. The body argument
 // allows callers to avoid re-reading req.Body for signing (the signer needs
@@ -206,6 +226,22 @@ func (r *Route53Client) do(req *http.Request, payloadHash string, _ []byte) ([]b
 	return body, nil
 }
 
+// APIError is a Route53 error response. Code and Message come from the
+// XML body; Code is empty when the body could not be parsed, in which
+// case Message holds a snippet of it.
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("route53 error (HTTP %d): %s", e.Status, e.Message)
+	}
+	return fmt.Sprintf("route53 error (HTTP %d): %s: %s", e.Status, e.Code, e.Message)
+}
+
 // parseAWSError extracts Code/Message from Route53 error XML bodies. The API
 // uses ErrorResponse.Error for most errors and a flat root element for a few.
 // We try both shapes and fall back to raw body on failure.
@@ -218,7 +254,7 @@ func parseAWSError(status int, body []byte) error {
 		} `xml:"Error"`
 	}
 	if err := xml.Unmarshal(body, &wrapped); err == nil && wrapped.Error.Code != "" {
-		return fmt.Errorf("route53 error (HTTP %d): %s: %s", status, wrapped.Error.Code, wrapped.Error.Message)
+		return &APIError{Status: status, Code: wrapped.Error.Code, Message: wrapped.Error.Message}
 	}
 
 	var flat struct {
@@ -226,14 +262,14 @@ func parseAWSError(status int, body []byte) error {
 		Message string `xml:"Message"`
 	}
 	if err := xml.Unmarshal(body, &flat); err == nil && flat.Code != "" {
-		return fmt.Errorf("route53 error (HTTP %d): %s: %s", status, flat.Code, flat.Message)
+		return &APIError{Status: status, Code: flat.Code, Message: flat.Message}
 	}
 
 	snippet := strings.TrimSpace(string(body))
 	if len(snippet) > 256 {
 		snippet = snippet[:256] + "..."
 	}
-	return fmt.Errorf("route53 error (HTTP %d): %s", status, snippet)
+	return &APIError{Status: status, Message: snippet}
 }
 
 // --- XML request/response types ---
@@ -2,6 +2,7 @@ package dns
 
 import (
 	"context"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -155,6 +156,30 @@ func TestRoute53Client_UpdateIP(t *testing.T) {
 	}
 }
 
+// TestRoute53Client_UpdateIP_IPv6 verifies an IPv6 address is
+// published as an AAAA record.
+func TestRoute53Client_UpdateIP_IPv6(t *testing.T) {
+	var body string
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		w.Header().Set("Content-Type", "text/xml")
+		_, _ = io.WriteString(w, sampleChangeResponse)
+	})
+
+	if err := client.UpdateIP(context.Background(), "2001:db8::1"); err != nil {
+		t.Fatalf("UpdateIP failed: %v", err)
+	}
+	if !strings.Contains(body, "<Type>AAAA</Type>") || !strings.Contains(body, "<Value>2001:db8::1</Value>") {
+		t.Errorf("expected an AAAA record for the IPv6 address, got %s", body)
+	}
+	for ip, want := range map[string]string{"5.6.7.8": "A", "::ffff:5.6.7.8": "A", "2001:db8::1": "AAAA"} {
+		if got := RecordType(ip); got != want {
+			t.Errorf("RecordType(%q) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
 // TestRoute53Client_GetCurrentIP_EmptyHostname verifies that an empty hostname
 // does not panic. Config.Validate() catches this earlier, but the client must
 // stay safe.
@@ -290,6 +315,10 @@ func TestParseAWSError_ShapeMatrix(t *testing.T) {
 			if err == nil {
 				t.Fatal("parseAWSError returned nil; all inputs should produce a non-nil error")
 			}
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.Status != tc.status {
+				t.Errorf("error %T is not an *APIError with status %d", err, tc.status)
+			}
 			msg := err.Error()
 			for _, want := range tc.wantInclude {
 				if !strings.Contains(msg, want) {
@@ -299,3 +328,25 @@ func TestParseAWSError_ShapeMatrix(t *testing.T) {
 		})
 	}
 }
+
+func TestSetEndpoint(t *testing.T) {
+	var gotPath string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		_, _ = io.WriteString(w, sampleListResponse)
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := NewRoute53Client(context.Background(), "", "AKIDEXAMPLE", "secret", "", "Z123456", "test.example.com", 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetEndpoint(srv.URL + "/")
+	ip, err := c.GetCurrentIP(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != "1.2.3.4" || gotPath != "/2013-04-01/hostedzone/Z123456/rrset" {
+		t.Errorf("GetCurrentIP via endpoint = %q (path %q)", ip, gotPath)
+	}
+}
@@ -0,0 +1,311 @@
+// Package dyndns is the dyndns v2 update pipeline
+// (https://help.dyn.com/remote-access-api/perform-update/) shared by
+// serve mode's server.Handler and the Lambda handler, so the two answer
+// the same request the same way: method check, credential parsing,
+// the authentication outcome, hostname matching, dry-run (where the
+// transport allows it), the response
+// code and the audit record. What differs per transport — who may
+// connect, where the secret lives, which address is published and how
+// — is a Backend.
+package dyndns
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/descoped/dddns/internal/reqsign"
+)
+
+// Response codes of the dyndns v2 protocol. Clients read the body, not
+// the HTTP status, which is 200 for all of them.
+const (
+	CodeGood      = "good"
+	CodeNoChg     = "nochg"
+	CodeBadAuth   = "badauth"
+	CodeNotFQDN   = "notfqdn"
+	CodeNoHost    = "nohost"
+	CodeAbuse     = "abuse"
+	CodeDNSErr    = "dnserr"
+	CodeServerErr = "911"
+)
+
+// Authentication schemes, as recorded in Entry.AuthScheme.
+const (
+	SchemeBasic = "basic"
+	SchemeHMAC  = "hmac"
+)
+
+// Request is one update request, whatever the transport.
+type Request struct {
+	Method        string
+	Path          string // as the client requested it; a signature covers it
+	Query         url.Values
+	Authorization string // the Authorization header
+	RemoteAddr    string // the client, as the transport determined it
+}
+
+// Credentials are the parsed Authorization header.
+type Credentials struct {
+	Scheme        string // SchemeBasic or SchemeHMAC
+	Username      string // Basic only
+	Password      string // Basic only
+	Authorization string // the header, which a signature check parses
+}
+
+// ParseCredentials reads an Authorization header: a reqsign signature,
+// or Basic (scheme name case-insensitive, RFC 7617). ok is false for a
+// missing or unusable header.
+func ParseCredentials(authorization string) (c Credentials, ok bool) {
+	if reqsign.IsSigned(authorization) {
+		return Credentials{Scheme: SchemeHMAC, Authorization: authorization}, true
+	}
+	const prefix = "Basic "
+	if len(authorization) < len(prefix) || !strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return Credentials{}, false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(authorization[len(prefix):])
+	if err != nil {
+		return Credentials{}, false
+	}
+	user, pass, found := strings.Cut(string(decoded), ":")
+	if !found {
+		return Credentials{}, false
+	}
+	return Credentials{Scheme: SchemeBasic, Username: user, Password: pass, Authorization: authorization}, true
+}
+
+// AuthResult is the outcome of Backend.Authenticate.
+type AuthResult int
+
+const (
+	AuthOK AuthResult = iota
+	AuthBadCredentials
+	AuthLockedOut
+	AuthRateLimited
+	AuthUnavailable // the credentials could not be checked, e.g. the secret store is down
+)
+
+// Error is a Backend failure that says how to answer it. Any other
+// error answers a bare dnserr with action "dnserr".
+type Error struct {
+	Action string // audit action; "" = "dnserr"
+	Status int    // answer with this HTTP status and no body instead of dnserr; 0 = dnserr
+	Detail string // appended to dnserr in the body, e.g. "ssm"
+	Err    error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	if e.Detail != "" {
+		return e.Detail
+	}
+	return e.Action
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Backend is the transport-specific part of the pipeline. A Backend
+// serves one request, so it may keep state — the authenticated client,
+// the matched route — from one call to the next.
+type Backend interface {
+	// Admit decides, before anything else, whether the request may be
+	// served at all (network origin). A non-nil error rejects it.
+	Admit(ctx context.Context, req *Request) error
+	// Authenticate checks cred for req. The error, if any, is recorded
+	// in the audit entry; with AuthUnavailable it is also the answer.
+	Authenticate(ctx context.Context, req *Request, cred Credentials) (AuthResult, error)
+	// Owns reports whether the authenticated client may update
+	// hostname. DNS names compare case-insensitively (RFC 1035).
+	Owns(hostname string) bool
+	// Address is the address to publish — the transport's policy; the
+	// client's myip claim is never it.
+	Address(ctx context.Context, req *Request) (string, error)
+	// Update publishes ip for hostname. action is "updated", one
+	// starting with "nochg" when the record already had the address, or
+	// "vetoed" when local policy refused the change; published is the
+	// address the record now holds.
+	Update(ctx context.Context, hostname, ip string) (action, published string, err error)
+}
+
+// Options are the transport's protocol settings.
+type Options struct {
+	RequireSignature bool // answer Basic Auth with badauth
+	// AllowDryRun honours ?dry-run. Without it the query parameter is
+	// ignored and the request updates as usual.
+	AllowDryRun bool
+}
+
+// Response is the answer to a request.
+type Response struct {
+	Status int    // HTTP status; 200 for every dyndns code
+	Code   string // dyndns code; "" with a non-200 Status, which has no body
+	IP     string
+	Detail string // after the IP: "(dry-run)", or a dnserr reason
+}
+
+// Body is the plain-text response body, e.g. "good 203.0.113.7\n";
+// empty without a Code.
+func (r Response) Body() string {
+	if r.Code == "" {
+		return ""
+	}
+	parts := []string{r.Code}
+	for _, p := range []string{r.IP, r.Detail} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, " ") + "\n"
+}
+
+func answer(code, ip, detail string) Response {
+	return Response{Status: http.StatusOK, Code: code, IP: ip, Detail: detail}
+}
+
+// Entry is the audit record of a request. Action is the pipeline
+// step's verdict — "updated", "nochg-…", "dry-run", "vetoed", "notfqdn",
+// "nohost", "rate-limited", "method-deny", a Backend's Error.Action — or
+// empty when authentication turned the request away, as AuthOutcome
+// ("missing", "unsigned", "bad", "locked") then says.
+type Entry struct {
+	RemoteAddr   string
+	Hostname     string
+	MyIPClaimed  string
+	MyIPVerified string
+	AuthScheme   string
+	AuthOutcome  string
+	Action       string
+	Err          string
+}
+
+// Handle runs req through the pipeline against b.
+func Handle(ctx context.Context, req *Request, b Backend, opts Options) (Response, Entry) {
+	e := Entry{RemoteAddr: req.RemoteAddr}
+
+	if err := b.Admit(ctx, req); err != nil {
+		return failed(&e, err), e
+	}
+	if req.Method != http.MethodGet {
+		e.Action = "method-deny"
+		return Response{Status: http.StatusMethodNotAllowed}, e
+	}
+
+	hostname := strings.TrimSpace(req.Query.Get("hostname"))
+	e.Hostname = hostname
+	e.MyIPClaimed = req.Query.Get("myip")
+
+	// An HMAC-signed request is preferred; Basic Auth is accepted
+	// unless opts.RequireSignature. Neither a missing header nor Basic
+	// under RequireSignature reaches the Backend, so neither counts
+	// toward a lockout.
+	cred, ok := ParseCredentials(req.Authorization)
+	if !ok {
+		e.AuthOutcome = "missing"
+		return answer(CodeBadAuth, "", ""), e
+	}
+	e.AuthScheme = cred.Scheme
+	if cred.Scheme == SchemeBasic && opts.RequireSignature {
+		e.AuthOutcome = "unsigned"
+		return answer(CodeBadAuth, "", ""), e
+	}
+	result, err := b.Authenticate(ctx, req, cred)
+	if err != nil {
+		e.Err = err.Error()
+	}
+	switch result {
+	case AuthOK:
+		e.AuthOutcome = "ok"
+	case AuthLockedOut:
+		e.AuthOutcome = "locked"
+		return answer(CodeBadAuth, "", ""), e
+	case AuthRateLimited:
+		// "abuse": the client is updating too often. inadyn backs off
+		// on this code rather than retrying immediately.
+		e.AuthOutcome = "ok"
+		e.Action = "rate-limited"
+		return answer(CodeAbuse, "", ""), e
+	case AuthUnavailable:
+		return failed(&e, err), e
+	default:
+		e.AuthOutcome = "bad"
+		return answer(CodeBadAuth, "", ""), e
+	}
+
+	if hostname == "" {
+		e.Action = "notfqdn"
+		return answer(CodeNotFQDN, "", ""), e
+	}
+	if !b.Owns(hostname) {
+		e.Action = "nohost"
+		return answer(CodeNoHost, "", ""), e
+	}
+
+	ip, err := b.Address(ctx, req)
+	if err != nil {
+		return failed(&e, err), e
+	}
+	e.MyIPVerified = ip
+
+	// Dry run: the whole auth and hostname pipeline, no update. The
+	// answer looks like a success so a client does not retry, with a
+	// marker log scraping can tell apart.
+	if opts.AllowDryRun && IsDryRun(req.Query.Get("dry-run")) {
+		e.Action = "dry-run"
+		return answer(CodeGood, ip, "(dry-run)"), e
+	}
+
+	action, published, err := b.Update(ctx, hostname, ip)
+	if err != nil {
+		return failed(&e, err), e
+	}
+	e.Action = action
+	switch {
+	case action == "updated":
+		return answer(CodeGood, published, ""), e
+	case strings.HasPrefix(action, "nochg"), action == "dry-run":
+		return answer(CodeNoChg, published, ""), e
+	case action == "vetoed":
+		// Refused by policy, not failed: retrying would be refused
+		// again, so the client is told the record stands.
+		return answer(CodeNoChg, published, ""), e
+	}
+	e.Err = "unknown update action: " + action
+	return answer(CodeDNSErr, "", ""), e
+}
+
+// failed records err in e and answers it as its Error says.
+func failed(e *Entry, err error) Response {
+	e.Action = CodeDNSErr
+	var de *Error
+	if !errors.As(err, &de) {
+		e.Err = err.Error()
+		return answer(CodeDNSErr, "", "")
+	}
+	if de.Err != nil {
+		e.Err = de.Err.Error()
+	}
+	if de.Action != "" {
+		e.Action = de.Action
+	}
+	if de.Status != 0 && de.Status != http.StatusOK {
+		return Response{Status: de.Status}
+	}
+	return answer(CodeDNSErr, "", de.Detail)
+}
+
+// IsDryRun reports whether a dry-run query value is truthy: "true",
+// "1", "yes" or "on", case-insensitively. Anything else, including
+// empty, is not.
+func IsDryRun(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "true", "1", "yes", "on":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package dyndns
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestParseCredentials(t *testing.T) {
+	b64 := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	cases := []struct {
+		name, header, user, pass, scheme string
+		ok                               bool
+	}{
+		{"standard", "Basic " + b64("u:p"), "u", "p", SchemeBasic, true},
+		{"scheme case-insensitive", "basic " + b64("u:p"), "u", "p", SchemeBasic, true},
+		{"colon in password", "Basic " + b64("u:p:q"), "u", "p:q", SchemeBasic, true},
+		{"signed", "DDDNS-HMAC-SHA256 ts=1, nonce=n, sig=s", "", "", SchemeHMAC, true},
+		{"empty", "", "", "", "", false},
+		{"bearer token", "Bearer xyz", "", "", "", false},
+		{"missing colon", "Basic " + b64("nocolon"), "", "", "", false},
+		{"invalid base64", "Basic %%%", "", "", "", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, ok := ParseCredentials(tc.header)
+			if ok != tc.ok || c.Username != tc.user || c.Password != tc.pass || c.Scheme != tc.scheme {
+				t.Errorf("ParseCredentials(%q) = (%+v, %v), want (%s %q %q, %v)",
+					tc.header, c, ok, tc.scheme, tc.user, tc.pass, tc.ok)
+			}
+		})
+	}
+}
+
+// TestIsDryRun_Matrix documents the truthy values recognised. Anything
+// not in the explicit allow-list (including the empty string) returns
+// false — no accidental enabling from a stray whitespace header.
+func TestIsDryRun_Matrix(t *testing.T) {
+	truthy := []string{"true", "1", "yes", "on", "TRUE", "True", "  true  ", "YES"}
+	falsy := []string{"", "false", "0", "no", "off", "dry-run", "enabled", " "}
+
+	for _, v := range truthy {
+		if !IsDryRun(v) {
+			t.Errorf("IsDryRun(%q) = false, want true", v)
+		}
+	}
+	for _, v := range falsy {
+		if IsDryRun(v) {
+			t.Errorf("IsDryRun(%q) = true, want false", v)
+		}
+	}
+}
+
+// fakeBackend accepts the password "secret" for example.com and
+// publishes 203.0.113.7, unless told to fail a step.
+type fakeBackend struct {
+	admitErr  error
+	auth      AuthResult
+	authErr   error
+	addrErr   error
+	action    string
+	updateErr error
+	updated   bool
+}
+
+func (f *fakeBackend) Admit(context.Context, *Request) error { return f.admitErr }
+
+func (f *fakeBackend) Authenticate(_ context.Context, _ *Request, c Credentials) (AuthResult, error) {
+	if f.auth != AuthOK || f.authErr != nil {
+		return f.auth, f.authErr
+	}
+	if c.Password != "secret" {
+		return AuthBadCredentials, nil
+	}
+	return AuthOK, nil
+}
+
+func (f *fakeBackend) Owns(hostname string) bool { return hostname == "example.com" }
+
+func (f *fakeBackend) Address(context.Context, *Request) (string, error) {
+	return "203.0.113.7", f.addrErr
+}
+
+func (f *fakeBackend) Update(_ context.Context, _, ip string) (string, string, error) {
+	f.updated = true
+	if f.updateErr != nil {
+		return "", "", f.updateErr
+	}
+	if f.action == "" {
+		return "updated", ip, nil
+	}
+	return f.action, ip, nil
+}
+
+func request() *Request {
+	return &Request{
+		Method:        http.MethodGet,
+		Path:          "/nic/update",
+		Query:         url.Values{"hostname": {"example.com"}, "myip": {"198.51.100.1"}},
+		Authorization: "Basic " + base64.StdEncoding.EncodeToString([]byte("u:secret")),
+		RemoteAddr:    "192.0.2.1",
+	}
+}
+
+// TestHandle_Failures verifies how a Backend's failures are answered
+// and audited.
+func TestHandle_Failures(t *testing.T) {
+	cases := []struct {
+		name       string
+		b          *fakeBackend
+		status     int
+		body       string
+		action     string
+		authResult string
+		err        string
+	}{
+		{"admit with a status", &fakeBackend{admitErr: &Error{Action: "cidr-deny", Status: http.StatusForbidden}},
+			http.StatusForbidden, "", "cidr-deny", "", ""},
+		{"admit with a detail", &fakeBackend{admitErr: &Error{Detail: "source ip not public", Err: errors.New("private")}},
+			http.StatusOK, "dnserr source ip not public\n", "dnserr", "", "private"},
+		{"secret store down", &fakeBackend{auth: AuthUnavailable, authErr: &Error{Detail: "ssm", Err: errors.New("timeout")}},
+			http.StatusOK, "dnserr ssm\n", "dnserr", "", "timeout"},
+		{"locked out", &fakeBackend{auth: AuthLockedOut},
+			http.StatusOK, "badauth\n", "", "locked", ""},
+		{"rate limited", &fakeBackend{auth: AuthRateLimited},
+			http.StatusOK, "abuse\n", "rate-limited", "ok", ""},
+		{"address error", &fakeBackend{addrErr: &Error{Action: "wanip-error", Err: errors.New("no such interface")}},
+			http.StatusOK, "dnserr\n", "wanip-error", "ok", "no such interface"},
+		{"update error", &fakeBackend{updateErr: errors.New("throttled")},
+			http.StatusOK, "dnserr\n", "dnserr", "ok", "throttled"},
+		{"no change", &fakeBackend{action: "nochg-cache"},
+			http.StatusOK, "nochg 203.0.113.7\n", "nochg-cache", "ok", ""},
+		{"vetoed", &fakeBackend{action: "vetoed"},
+			http.StatusOK, "nochg 203.0.113.7\n", "vetoed", "ok", ""},
+		{"unknown action", &fakeBackend{action: "sideways"},
+			http.StatusOK, "dnserr\n", "sideways", "ok", "unknown update action: sideways"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, e := Handle(context.Background(), request(), tc.b, Options{})
+			if resp.Status != tc.status || resp.Body() != tc.body {
+				t.Errorf("answer = %d %q, want %d %q", resp.Status, resp.Body(), tc.status, tc.body)
+			}
+			if e.Action != tc.action || e.AuthOutcome != tc.authResult || e.Err != tc.err {
+				t.Errorf("entry = %+v, want action %q auth %q err %q", e, tc.action, tc.authResult, tc.err)
+			}
+		})
+	}
+}
+
+// TestHandle_AuditEntry verifies a successful push's audit record
+// carries the claimed and the published address.
+func TestHandle_AuditEntry(t *testing.T) {
+	resp, e := Handle(context.Background(), request(), &fakeBackend{}, Options{})
+	if resp.Body() != "good 203.0.113.7\n" {
+		t.Fatalf("body = %q", resp.Body())
+	}
+	want := Entry{
+		RemoteAddr:   "192.0.2.1",
+		Hostname:     "example.com",
+		MyIPClaimed:  "198.51.100.1",
+		MyIPVerified: "203.0.113.7",
+		AuthScheme:   SchemeBasic,
+		AuthOutcome:  "ok",
+		Action:       "updated",
+	}
+	if e != want {
+		t.Errorf("entry = %+v, want %+v", e, want)
+	}
+}
+
+// TestHandle_DryRunIsOptIn verifies ?dry-run is honoured only with
+// Options.AllowDryRun; otherwise the request updates as usual.
+func TestHandle_DryRunIsOptIn(t *testing.T) {
+	for _, tc := range []struct {
+		allow  bool
+		body   string
+		action string
+	}{
+		{true, "good 203.0.113.7 (dry-run)\n", "dry-run"},
+		{false, "good 203.0.113.7\n", "updated"},
+	} {
+		req := request()
+		req.Query.Set("dry-run", "true")
+		resp, e := Handle(context.Background(), req, &fakeBackend{}, Options{AllowDryRun: tc.allow})
+		if resp.Body() != tc.body || e.Action != tc.action {
+			t.Errorf("AllowDryRun %v: answer %q action %q, want %q %q", tc.allow, resp.Body(), e.Action, tc.body, tc.action)
+		}
+	}
+}
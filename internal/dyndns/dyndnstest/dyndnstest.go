@@ -0,0 +1,152 @@
+// Package dyndnstest is the conformance table of the dyndns pipeline:
+// the answers every transport — serve mode's handler, the Lambda —
+// must give to the same requests. Each transport's tests wire a Target
+// and call Run.
+package dyndnstest
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/descoped/dddns/internal/reqsign"
+)
+
+// Fixture is the deployment a Target serves: one hostname, pushed to
+// by Username with Secret.
+const (
+	Hostname = "home.example.com"
+	Username = "dddns"
+	Secret   = "conformance-secret"
+	Path     = "/nic/update"
+)
+
+// Options configure a Target for a case.
+type Options struct {
+	RequireSignature bool
+}
+
+// Target is one transport wired to in-memory stand-ins.
+type Target struct {
+	// Do sends a request and returns the HTTP status and body.
+	Do func(method, path, rawQuery, authorization string) (status int, body string)
+	// Updates counts the record updates made so far.
+	Updates func() int
+	// IP is the address the transport publishes for requests from Do.
+	IP string
+	// DryRun is whether the transport honours ?dry-run; one that does
+	// not must treat a dry-run request as a plain update.
+	DryRun bool
+}
+
+// Auth is how a case authenticates.
+type Auth int
+
+const (
+	AuthNone Auth = iota
+	AuthBasic
+	AuthBasicWrong
+	AuthSigned
+	AuthSignedWrong
+)
+
+// Case is one conformance check.
+type Case struct {
+	Name             string
+	Method           string // default GET
+	Query            url.Values
+	Auth             Auth
+	RequireSignature bool
+
+	Status  int    // default 200
+	Body    string // with "<ip>" standing for Target.IP; trimmed
+	Updated bool
+	DryRun  bool // Body and Updated assume Target.DryRun
+}
+
+func q(kv ...string) url.Values {
+	v := url.Values{}
+	for i := 0; i+1 < len(kv); i += 2 {
+		v.Add(kv[i], kv[i+1])
+	}
+	return v
+}
+
+// Cases is the conformance table.
+var Cases = []Case{
+	{Name: "basic", Query: q("hostname", Hostname), Auth: AuthBasic, Body: "good <ip>", Updated: true},
+	{Name: "myip is ignored", Query: q("hostname", Hostname, "myip", "198.51.100.1"), Auth: AuthBasic, Body: "good <ip>", Updated: true},
+	{Name: "hostname case-insensitive", Query: q("hostname", strings.ToUpper(Hostname)), Auth: AuthBasic, Body: "good <ip>", Updated: true},
+	{Name: "signed", Query: q("hostname", Hostname), Auth: AuthSigned, Body: "good <ip>", Updated: true},
+	{Name: "signed with signature required", Query: q("hostname", Hostname), Auth: AuthSigned, RequireSignature: true, Body: "good <ip>", Updated: true},
+	{Name: "no credentials", Query: q("hostname", Hostname), Auth: AuthNone, Body: "badauth"},
+	{Name: "wrong secret", Query: q("hostname", Hostname), Auth: AuthBasicWrong, Body: "badauth"},
+	{Name: "wrong signature", Query: q("hostname", Hostname), Auth: AuthSignedWrong, Body: "badauth"},
+	{Name: "basic with signature required", Query: q("hostname", Hostname), Auth: AuthBasic, RequireSignature: true, Body: "badauth"},
+	{Name: "bad credentials before hostname checks", Query: q("hostname", "other.example.com"), Auth: AuthBasicWrong, Body: "badauth"},
+	{Name: "no hostname", Query: q("myip", "198.51.100.1"), Auth: AuthBasic, Body: "notfqdn"},
+	{Name: "other hostname", Query: q("hostname", "other.example.com"), Auth: AuthBasic, Body: "nohost"},
+	{Name: "dry run", Query: q("hostname", Hostname, "dry-run", "true"), Auth: AuthBasic, Body: "good <ip> (dry-run)", DryRun: true},
+	{Name: "dry run false", Query: q("hostname", Hostname, "dry-run", "false"), Auth: AuthBasic, Body: "good <ip>", Updated: true},
+	{Name: "not GET", Method: http.MethodPost, Query: q("hostname", Hostname), Auth: AuthBasic, Status: http.StatusMethodNotAllowed},
+}
+
+// Run checks every case against a fresh Target from newTarget.
+func Run(t *testing.T, newTarget func(t *testing.T, opts Options) Target) {
+	t.Helper()
+	for _, c := range Cases {
+		t.Run(c.Name, func(t *testing.T) {
+			target := newTarget(t, Options{RequireSignature: c.RequireSignature})
+			method := c.Method
+			if method == "" {
+				method = http.MethodGet
+			}
+			status, body := target.Do(method, Path, c.Query.Encode(), authorization(t, c, method))
+
+			wantStatus := c.Status
+			if wantStatus == 0 {
+				wantStatus = http.StatusOK
+			}
+			wantBody, wantUpdated := c.Body, c.Updated
+			if c.DryRun && !target.DryRun {
+				wantBody, wantUpdated = "good <ip>", true
+			}
+			wantBody = strings.ReplaceAll(wantBody, "<ip>", target.IP)
+			if status != wantStatus || strings.TrimSpace(body) != wantBody {
+				t.Errorf("answer = %d %q, want %d %q", status, strings.TrimSpace(body), wantStatus, wantBody)
+			}
+			updated := target.Updates() > 0
+			if updated != wantUpdated {
+				t.Errorf("record updated = %v, want %v", updated, wantUpdated)
+			}
+		})
+	}
+}
+
+func authorization(t *testing.T, c Case, method string) string {
+	t.Helper()
+	basic := func(pass string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(Username+":"+pass))
+	}
+	signed := func(secret string) string {
+		nonce, err := reqsign.NewNonce()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reqsign.Header(secret, method, Path, c.Query, time.Now(), nonce)
+	}
+	switch c.Auth {
+	case AuthBasic:
+		return basic(Secret)
+	case AuthBasicWrong:
+		return basic("wrong-" + Secret)
+	case AuthSigned:
+		return signed(Secret)
+	case AuthSignedWrong:
+		return signed("wrong-" + Secret)
+	}
+	return ""
+}
@@ -0,0 +1,157 @@
+// Package hooks runs the operator's pre_update, post_update and
+// on_error commands. A hook receives the update's details as DDDNS_*
+// environment variables and as a JSON object on stdin; its combined
+// output is captured (capped at MaxOutput) for the log and audit trail.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Hook names, as used in config and in Run.Hook.
+const (
+	PreUpdate  = "pre_update"
+	PostUpdate = "post_update"
+	OnError    = "on_error"
+)
+
+// DefaultTimeout bounds a hook when hooks.timeout is unset.
+const DefaultTimeout = 10 * time.Second
+
+// MaxOutput caps the captured output per hook. The rest is discarded
+// so a chatty script cannot bloat the audit log.
+const MaxOutput = 2048
+
+// Input describes the update a hook runs for.
+type Input struct {
+	Hook     string `json:"hook"`
+	Hostname string `json:"hostname"`
+	OldIP    string `json:"old_ip,omitempty"`
+	NewIP    string `json:"new_ip,omitempty"`
+	Action   string `json:"action"` // "updating" (pre), "updated" (post), "failed" (on_error)
+	Source   string `json:"source,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Env returns in as DDDNS_* environment variables.
+func (in Input) Env() []string {
+	return []string{
+		"DDDNS_HOOK=" + in.Hook,
+		"DDDNS_HOSTNAME=" + in.Hostname,
+		"DDDNS_OLD_IP=" + in.OldIP,
+		"DDDNS_NEW_IP=" + in.NewIP,
+		"DDDNS_ACTION=" + in.Action,
+		"DDDNS_SOURCE=" + in.Source,
+		"DDDNS_ERROR=" + in.Error,
+	}
+}
+
+// Run is the outcome of one hook invocation. ExitCode is -1 when the
+// command could not be started or was killed; Error then says why.
+type Run struct {
+	Hook       string `json:"hook"`
+	ExitCode   int    `json:"exit_code"`
+	Output     string `json:"output,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Failed reports whether the hook did not exit 0.
+func (r Run) Failed() bool {
+	return r.ExitCode != 0 || r.Error != ""
+}
+
+// Reason describes a failure for error messages: the start or timeout
+// error, else the exit status.
+func (r Run) Reason() string {
+	if r.Error != "" {
+		return r.Error
+	}
+	return fmt.Sprintf("exit status %d", r.ExitCode)
+}
+
+// Exec runs argv with in on stdin and in its environment, killing it
+// after timeout or when ctx ends.
+func Exec(ctx context.Context, argv []string, timeout time.Duration, in Input) Run {
+	run := Run{Hook: in.Hook, ExitCode: -1}
+	if len(argv) == 0 || argv[0] == "" {
+		run.Error = "no command"
+		return run
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	stdin, err := json.Marshal(in)
+	if err != nil {
+		run.Error = err.Error()
+		return run
+	}
+	out := &cappedBuffer{max: MaxOutput}
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(), in.Env()...)
+	cmd.Stdin = bytes.NewReader(append(stdin, '\n'))
+	cmd.Stdout = out
+	cmd.Stderr = out
+	// A background grandchild holding the output pipe must not keep
+	// Wait blocked past the kill.
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	err = cmd.Run()
+	run.DurationMS = time.Since(start).Milliseconds()
+	run.Output = out.String()
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		run.Error = fmt.Sprintf("timed out after %s", timeout)
+	case ctx.Err() != nil:
+		run.Error = ctx.Err().Error()
+	case errors.As(err, &exitErr) && exitErr.Exited():
+		run.ExitCode = exitErr.ExitCode()
+	case err != nil:
+		run.Error = err.Error()
+	default:
+		run.ExitCode = 0
+	}
+	return run
+}
+
+// cappedBuffer keeps the first max bytes written to it and counts the
+// rest. Writes never fail, so the command is not disturbed.
+type cappedBuffer struct {
+	buf     bytes.Buffer
+	max     int
+	dropped int
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	if room := c.max - c.buf.Len(); room > 0 {
+		c.buf.Write(p[:min(room, len(p))])
+		c.dropped += max(len(p)-room, 0)
+	} else {
+		c.dropped += len(p)
+	}
+	return len(p), nil
+}
+
+// String returns the captured output, trimmed, with a marker when
+// output was dropped.
+func (c *cappedBuffer) String() string {
+	s := strings.TrimSpace(c.buf.String())
+	if c.dropped > 0 {
+		s += fmt.Sprintf(" …[%d bytes truncated]", c.dropped)
+	}
+	return s
+}
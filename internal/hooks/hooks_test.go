@@ -0,0 +1,105 @@
+package hooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMain doubles as the hook command: with HOOKS_TEST_HELPER set the
+// test binary behaves as the script named by its value, which keeps
+// these tests free of any shell.
+func TestMain(m *testing.M) {
+	if mode := os.Getenv("HOOKS_TEST_HELPER"); mode != "" {
+		os.Exit(helper(mode))
+	}
+	os.Exit(m.Run())
+}
+
+func helper(mode string) int {
+	switch mode {
+	case "echo":
+		stdin, _ := io.ReadAll(os.Stdin)
+		fmt.Printf("env %s %s %s\n", os.Getenv("DDDNS_HOOK"), os.Getenv("DDDNS_HOSTNAME"), os.Getenv("DDDNS_NEW_IP"))
+		fmt.Printf("stdin %s", stdin)
+		return 0
+	case "fail":
+		fmt.Fprintln(os.Stderr, "firewall reload failed")
+		return 3
+	case "sleep":
+		time.Sleep(10 * time.Second)
+		return 0
+	case "flood":
+		fmt.Print(strings.Repeat("x", MaxOutput+100))
+		return 0
+	}
+	return 99
+}
+
+func helperArgv(t *testing.T, mode string) []string {
+	t.Helper()
+	t.Setenv("HOOKS_TEST_HELPER", mode)
+	return []string{os.Args[0], "-test.run=^$"}
+}
+
+var in = Input{Hook: PostUpdate, Hostname: "home.example.com", OldIP: "198.51.100.5", NewIP: "203.0.113.10", Action: "updated", Source: "local"}
+
+func TestExec_PassesEnvAndStdin(t *testing.T) {
+	r := Exec(t.Context(), helperArgv(t, "echo"), time.Minute, in)
+	if r.Failed() || r.ExitCode != 0 || r.Hook != PostUpdate {
+		t.Fatalf("run = %+v", r)
+	}
+	lines := strings.SplitN(r.Output, "\n", 2)
+	if lines[0] != "env post_update home.example.com 203.0.113.10" {
+		t.Errorf("env line = %q", lines[0])
+	}
+	var got Input
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "stdin ")), &got); err != nil {
+		t.Fatalf("stdin is not JSON: %v: %q", err, lines[1])
+	}
+	if got != in {
+		t.Errorf("stdin = %+v, want %+v", got, in)
+	}
+}
+
+func TestExec_NonZeroExit(t *testing.T) {
+	r := Exec(t.Context(), helperArgv(t, "fail"), time.Minute, in)
+	if !r.Failed() || r.ExitCode != 3 || r.Error != "" {
+		t.Errorf("run = %+v, want exit 3", r)
+	}
+	if r.Output != "firewall reload failed" || r.Reason() != "exit status 3" {
+		t.Errorf("output %q, reason %q", r.Output, r.Reason())
+	}
+}
+
+func TestExec_Timeout(t *testing.T) {
+	start := time.Now()
+	r := Exec(t.Context(), helperArgv(t, "sleep"), 100*time.Millisecond, in)
+	if !r.Failed() || r.ExitCode != -1 || !strings.Contains(r.Error, "timed out") {
+		t.Errorf("run = %+v, want a timeout", r)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("timeout took %v", time.Since(start))
+	}
+}
+
+func TestExec_CapsOutput(t *testing.T) {
+	r := Exec(t.Context(), helperArgv(t, "flood"), time.Minute, in)
+	if !strings.HasPrefix(r.Output, strings.Repeat("x", MaxOutput)) || !strings.HasSuffix(r.Output, "[100 bytes truncated]") {
+		t.Errorf("output has %d bytes, ends %q", len(r.Output), r.Output[len(r.Output)-30:])
+	}
+}
+
+func TestExec_MissingCommand(t *testing.T) {
+	r := Exec(t.Context(), []string{"/nonexistent/dddns-hook"}, time.Minute, in)
+	if !r.Failed() || r.ExitCode != -1 || r.Error == "" {
+		t.Errorf("run = %+v, want a start error", r)
+	}
+	if r := Exec(t.Context(), nil, time.Minute, in); r.Error != "no command" {
+		t.Errorf("empty argv: %+v", r)
+	}
+}
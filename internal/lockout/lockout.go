@@ -0,0 +1,109 @@
+// Package lockout is the auth-failure lockout policy (layer L3 in the
+// security model) shared by serve mode's server.Authenticator and the
+// Lambda handler, so both lock a client out after the same failures for
+// the same time. It holds the rule and one client's state; where the
+// state lives — serve mode's in-memory LRU and lockout file, the
+// Lambda's DynamoDB table — is up to the caller.
+package lockout
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+// Default thresholds: MaxFailures or more auth failures from one client
+// within FailureWindow of each other lock that client out for Duration.
+const (
+	MaxFailures   = 5
+	FailureWindow = 60 * time.Second
+	Duration      = 5 * time.Minute
+)
+
+// Rule holds the lockout thresholds.
+type Rule struct {
+	MaxFailures   int
+	FailureWindow time.Duration
+	Duration      time.Duration
+}
+
+// DefaultRule returns the package-default thresholds.
+func DefaultRule() Rule {
+	return Rule{MaxFailures: MaxFailures, FailureWindow: FailureWindow, Duration: Duration}
+}
+
+// State is one client's lockout record: the failures still inside the
+// window, and the end of an active lockout. The JSON form is what
+// serve mode's lockout file and the Lambda's table store.
+type State struct {
+	Failures    []time.Time `json:"failures,omitempty"`
+	LockedUntil time.Time   `json:"locked_until,omitzero"`
+}
+
+// Locked reports whether s is inside a lockout at now.
+func (s *State) Locked(now time.Time) bool { return now.Before(s.LockedUntil) }
+
+// Fail records a failure at now, starting a lockout — and clearing the
+// tally — when it is the rule's MaxFailures-th inside the window. It
+// reports whether a lockout started.
+func (r Rule) Fail(s *State, now time.Time) bool {
+	s.Failures = append(Prune(s.Failures, now.Add(-r.FailureWindow)), now)
+	if len(s.Failures) < r.MaxFailures {
+		return false
+	}
+	s.LockedUntil = now.Add(r.Duration)
+	s.Failures = s.Failures[:0]
+	return true
+}
+
+// Succeed clears the pending failures after a successful
+// authentication — legitimate callers do not pay for historical typos.
+func (s *State) Succeed() { s.Failures = s.Failures[:0] }
+
+// Expires is when s stops mattering under r: the end of its lockout or
+// of its newest failure's window, whichever is later. A store may drop
+// s then.
+func (r Rule) Expires(s State) time.Time {
+	end := s.LockedUntil
+	if n := len(s.Failures); n > 0 {
+		if w := s.Failures[n-1].Add(r.FailureWindow); w.After(end) {
+			end = w
+		}
+	}
+	return end
+}
+
+// Prune drops timestamps at or before cutoff, reusing ts's backing
+// array.
+func Prune(ts []time.Time, cutoff time.Time) []time.Time {
+	kept := ts[:0]
+	for _, t := range ts {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// Key reduces a remote address ("host:port" or a bare IP) to the
+// identity lockout is tracked under: the IPv4 address, or the /64
+// prefix for IPv6 (a single host can cycle through its /64 at will, so
+// per-address tracking would be trivially bypassed). Unparseable input
+// is used verbatim.
+func Key(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	if i := strings.Index(host, "%"); i >= 0 {
+		host = host[:i]
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return remoteAddr
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
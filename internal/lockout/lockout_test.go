@@ -0,0 +1,77 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRule_FailLocksAtThreshold(t *testing.T) {
+	r := DefaultRule()
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	var s State
+	for i := 1; i < MaxFailures; i++ {
+		if r.Fail(&s, now) {
+			t.Fatalf("failure %d started a lockout", i)
+		}
+	}
+	if !r.Fail(&s, now) {
+		t.Fatal("threshold failure did not start a lockout")
+	}
+	if !s.Locked(now.Add(Duration-time.Second)) || s.Locked(now.Add(Duration)) {
+		t.Errorf("lockout until %v, want %v", s.LockedUntil, now.Add(Duration))
+	}
+	if len(s.Failures) != 0 {
+		t.Errorf("tally not cleared by the lockout: %v", s.Failures)
+	}
+}
+
+func TestRule_FailuresOutsideWindowDoNotCount(t *testing.T) {
+	r := DefaultRule()
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	var s State
+	for range MaxFailures - 1 {
+		r.Fail(&s, now)
+	}
+	if r.Fail(&s, now.Add(FailureWindow)) {
+		t.Error("failures outside the window counted toward the lockout")
+	}
+	if len(s.Failures) != 1 {
+		t.Errorf("pending failures = %d, want 1", len(s.Failures))
+	}
+	s.Succeed()
+	if len(s.Failures) != 0 {
+		t.Error("Succeed kept pending failures")
+	}
+}
+
+func TestRule_Expires(t *testing.T) {
+	r := DefaultRule()
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	var s State
+	r.Fail(&s, now)
+	if got := r.Expires(s); !got.Equal(now.Add(FailureWindow)) {
+		t.Errorf("Expires with a pending failure = %v", got)
+	}
+	s = State{LockedUntil: now.Add(Duration)}
+	if got := r.Expires(s); !got.Equal(now.Add(Duration)) {
+		t.Errorf("Expires while locked = %v", got)
+	}
+}
+
+func TestKey(t *testing.T) {
+	cases := map[string]string{
+		"203.0.113.7":                   "203.0.113.7",
+		"203.0.113.7:4242":              "203.0.113.7",
+		"[2001:db8:1:2:3:4:5:6]:443":    "2001:db8:1:2::/64",
+		"2001:db8:1:2:ffff::1":          "2001:db8:1:2::/64",
+		"::ffff:203.0.113.7":            "203.0.113.7",
+		"fe80::1%eth0":                  "fe80::/64",
+		"not-an-address":                "not-an-address",
+		"[2001:db8:1:3::9%wlan0]:51000": "2001:db8:1:3::/64",
+	}
+	for in, want := range cases {
+		if got := Key(in); got != want {
+			t.Errorf("Key(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
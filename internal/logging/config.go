@@ -0,0 +1,77 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/descoped/dddns/internal/config"
+	"github.com/descoped/dddns/internal/logsink"
+)
+
+// FromConfig builds the operational logger described by lc. format
+// overrides lc.Format when non-empty (the --log-format flag). With no
+// sinks configured it logs to stderr. stderr and file sinks render
+// slog's text or JSON; syslog and journald sinks receive structured
+// records through NewSinkHandler. The returned func closes the files
+// and sockets opened.
+func FromConfig(lc *config.LogConfig, format string, level slog.Leveler) (*slog.Logger, func() error, error) {
+	var sinks []config.LogSinkConfig
+	if lc != nil {
+		sinks = lc.Sinks
+		if format == "" {
+			format = lc.Format
+		}
+	}
+	if len(sinks) == 0 {
+		sinks = []config.LogSinkConfig{{Type: "stderr"}}
+	}
+
+	var (
+		handlers []slog.Handler
+		closers  []io.Closer
+	)
+	closeAll := func() error {
+		var errs []error
+		for _, c := range closers {
+			errs = append(errs, c.Close())
+		}
+		return errors.Join(errs...)
+	}
+	for _, sc := range sinks {
+		var (
+			h   slog.Handler
+			err error
+		)
+		switch sc.Type {
+		case "", "stderr":
+			h, err = NewHandler(os.Stderr, format, level)
+		case "file":
+			var f *os.File
+			if f, err = os.OpenFile(sc.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600); err != nil {
+				err = fmt.Errorf("open log file: %w", err)
+				break
+			}
+			closers = append(closers, f)
+			h, err = NewHandler(f, format, level)
+		default:
+			var s logsink.Sink
+			if s, err = logsink.Open(sc); err != nil {
+				break
+			}
+			closers = append(closers, s)
+			h = NewSinkHandler(s, level)
+		}
+		if err != nil {
+			_ = closeAll()
+			return nil, nil, err
+		}
+		handlers = append(handlers, h)
+	}
+	if len(handlers) == 1 {
+		return slog.New(handlers[0]), closeAll, nil
+	}
+	return slog.New(slog.NewMultiHandler(handlers...)), closeAll, nil
+}
@@ -0,0 +1,165 @@
+// Package logging builds the log/slog loggers dddns uses across the
+// CLI, the serve listener and the Lambda.
+//
+// Every component logs the same event fields under the same names
+// (KeyHostname, KeyOldIP, ...), so a collector can filter on action=
+// updated or aggregate duration_ms without parsing message text.
+// Output is slog's text or JSON format, or — when log sinks are
+// configured — records forwarded to internal/logsink.
+//
+// Attributes whose key names a credential (secret, password, token,
+// authorization, *_key, ...) are replaced with "[REDACTED]" by every
+// handler built here, whatever the caller passes as the value.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Field names shared by every dddns log line.
+const (
+	KeyHostname   = "hostname"
+	KeyOldIP      = "old_ip"
+	KeyNewIP      = "new_ip"
+	KeyAction     = "action"      // updated, nochg-cache, nochg-dns, dry-run, badauth, ...
+	KeySource     = "source"      // how new_ip was obtained: local, remote, override, peer
+	KeyDurationMS = "duration_ms" // wall-clock milliseconds of the logged operation
+	KeyRemoteAddr = "remote_addr" // client address of a serve or Lambda request
+	KeyError      = "error"
+)
+
+// LevelNotice sits between Info and Warn. Outcomes an operator wants
+// even under --quiet (a record was updated, a dry run would have) log
+// at Notice.
+const LevelNotice = slog.LevelInfo + 2
+
+// The accepted output formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Redacted replaces the value of a secret-bearing attribute.
+const Redacted = "[REDACTED]"
+
+// Level maps the CLI's --quiet/--verbose flags to a minimum level.
+// --verbose wins over --quiet, matching the update command's help.
+func Level(quiet, verbose bool) slog.Level {
+	switch {
+	case verbose:
+		return slog.LevelDebug
+	case quiet:
+		return LevelNotice
+	}
+	return slog.LevelInfo
+}
+
+// ParseLevel parses "debug", "info", "notice", "warn" or "error". An
+// empty string is Info.
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "notice":
+		return LevelNotice, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q (want debug, info, notice, warn or error)", s)
+}
+
+// New returns a logger writing format ("text" or "json"; empty is text)
+// to w at or above level.
+func New(w io.Writer, format string, level slog.Leveler) (*slog.Logger, error) {
+	h, err := NewHandler(w, format, level)
+	if err != nil {
+		return nil, err
+	}
+	return slog.New(h), nil
+}
+
+// NewHandler is New's handler, for fanning out with slog.NewMultiHandler.
+func NewHandler(w io.Writer, format string, level slog.Leveler) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: replaceAttr}
+	switch format {
+	case "", FormatText:
+		return slog.NewTextHandler(w, opts), nil
+	case FormatJSON:
+		return slog.NewJSONHandler(w, opts), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q (want text or json)", format)
+}
+
+// Discard returns a logger that drops everything.
+func Discard() *slog.Logger {
+	return slog.New(slog.DiscardHandler)
+}
+
+// OrDefault returns l, or slog.Default() when l is nil. Components take
+// an optional logger and resolve it with this at the point of use.
+func OrDefault(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return slog.Default()
+	}
+	return l
+}
+
+// DurationMS is the KeyDurationMS attribute for d.
+func DurationMS(d time.Duration) slog.Attr {
+	return slog.Int64(KeyDurationMS, d.Milliseconds())
+}
+
+// Err is the KeyError attribute for a non-nil err.
+func Err(err error) slog.Attr {
+	return slog.String(KeyError, err.Error())
+}
+
+// LevelName renders l the way the handlers here do: slog's names, plus
+// "NOTICE" for LevelNotice.
+func LevelName(l slog.Level) string {
+	if l >= LevelNotice && l < slog.LevelWarn {
+		if l == LevelNotice {
+			return "NOTICE"
+		}
+		return fmt.Sprintf("NOTICE+%d", l-LevelNotice)
+	}
+	return l.String()
+}
+
+// replaceAttr names LevelNotice and redacts secret-bearing keys.
+func replaceAttr(_ []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey {
+		if l, ok := a.Value.Any().(slog.Level); ok {
+			return slog.String(slog.LevelKey, LevelName(l))
+		}
+		return a
+	}
+	return redact(a)
+}
+
+// redact replaces the value of a when its key names a credential.
+func redact(a slog.Attr) slog.Attr {
+	if IsSecretKey(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+	return a
+}
+
+// IsSecretKey reports whether an attribute key names a credential.
+func IsSecretKey(key string) bool {
+	k := strings.ToLower(key)
+	for _, s := range []string{"secret", "password", "passwd", "token", "authorization", "credential", "signature"} {
+		if strings.Contains(k, s) {
+			return true
+		}
+	}
+	return k == "key" || strings.HasSuffix(k, "_key") || strings.HasSuffix(k, "-key")
+}
@@ -0,0 +1,105 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/descoped/dddns/internal/logsink"
+)
+
+// sinkHandler is a slog.Handler that forwards records to a
+// logsink.Sink. Attributes become Record fields in order; groups are
+// flattened to "group.key". Secret-bearing keys are redacted before
+// they reach the sink.
+type sinkHandler struct {
+	sink   logsink.Sink
+	level  slog.Leveler
+	attrs  []logsink.Field // from WithAttrs, already prefixed
+	prefix string          // open groups from WithGroup, "a.b."
+}
+
+// NewSinkHandler returns a handler writing records at or above level
+// to s. Sink write errors are returned from Handle, which slog drops;
+// a dead collector therefore never fails the caller.
+func NewSinkHandler(s logsink.Sink, level slog.Leveler) slog.Handler {
+	return &sinkHandler{sink: s, level: level}
+}
+
+func (h *sinkHandler) Enabled(_ context.Context, l slog.Level) bool {
+	floor := slog.LevelInfo
+	if h.level != nil {
+		floor = h.level.Level()
+	}
+	return l >= floor
+}
+
+func (h *sinkHandler) Handle(_ context.Context, r slog.Record) error {
+	rec := logsink.Record{
+		Time:     r.Time,
+		Severity: Severity(r.Level),
+		Message:  r.Message,
+		Fields:   append([]logsink.Field(nil), h.attrs...),
+	}
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		rec.Fields = appendFields(rec.Fields, h.prefix, a)
+		return true
+	})
+	return h.sink.Write(rec)
+}
+
+func (h *sinkHandler) WithAttrs(as []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append([]logsink.Field(nil), h.attrs...)
+	for _, a := range as {
+		c.attrs = appendFields(c.attrs, h.prefix, a)
+	}
+	return &c
+}
+
+func (h *sinkHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.prefix = h.prefix + name + "."
+	return &c
+}
+
+// appendFields flattens a into fields under prefix.
+func appendFields(fields []logsink.Field, prefix string, a slog.Attr) []logsink.Field {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return fields
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		p := prefix
+		if a.Key != "" {
+			p += a.Key + "."
+		}
+		for _, g := range a.Value.Group() {
+			fields = appendFields(fields, p, g)
+		}
+		return fields
+	}
+	a = redact(a)
+	return append(fields, logsink.Field{Key: prefix + a.Key, Value: a.Value.String()})
+}
+
+// Severity maps a slog level to the syslog severity sinks carry.
+func Severity(l slog.Level) logsink.Severity {
+	switch {
+	case l >= slog.LevelError:
+		return logsink.SevError
+	case l >= slog.LevelWarn:
+		return logsink.SevWarning
+	case l >= LevelNotice:
+		return logsink.SevNotice
+	case l >= slog.LevelInfo:
+		return logsink.SevInfo
+	}
+	return logsink.SevDebug
+}
@@ -0,0 +1,86 @@
+package logsink
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is reported for a record Async dropped because its queue
+// was full.
+var ErrQueueFull = errors.New("log sink queue full; record dropped")
+
+// asyncCloseTimeout bounds how long Close waits for queued records to
+// be delivered.
+const asyncCloseTimeout = 5 * time.Second
+
+// Async delivers records to a sink from a background goroutine through
+// a bounded queue, so a slow or unreachable collector never blocks the
+// writer. Write only enqueues: a record that does not fit is dropped
+// and reported to onError, as is every delivery error.
+type Async struct {
+	sink    Sink
+	queue   chan Record
+	onError func(error)
+	done    chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewAsync starts delivering to s through a queue of size records.
+// onError may be nil.
+func NewAsync(s Sink, size int, onError func(error)) *Async {
+	if onError == nil {
+		onError = func(error) {}
+	}
+	a := &Async{sink: s, queue: make(chan Record, size), onError: onError, done: make(chan struct{})}
+	go a.run()
+	return a
+}
+
+func (a *Async) run() {
+	defer close(a.done)
+	for r := range a.queue {
+		if err := a.sink.Write(r); err != nil {
+			a.onError(err)
+		}
+	}
+}
+
+// Write implements Sink. It never blocks; the returned error is
+// ErrQueueFull when the record was dropped, and nil otherwise.
+func (a *Async) Write(r Record) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return errors.New("log sink closed")
+	}
+	select {
+	case a.queue <- r:
+		return nil
+	default:
+		a.onError(ErrQueueFull)
+		return ErrQueueFull
+	}
+}
+
+// Close implements Sink: it waits up to asyncCloseTimeout for the queue
+// to drain, then closes the underlying sink.
+func (a *Async) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	close(a.queue)
+	a.mu.Unlock()
+
+	select {
+	case <-a.done:
+	case <-time.After(asyncCloseTimeout):
+		return errors.New("log sink close: undelivered records after " + asyncCloseTimeout.String())
+	}
+	return a.sink.Close()
+}
@@ -0,0 +1,105 @@
+package logsink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Journald sends records to systemd-journald over its native datagram
+// protocol: newline-separated KEY=value pairs, with values containing a
+// newline sent in the length-prefixed binary form. Each Field becomes
+// DDDNS_<KEY> (upper-cased), alongside MESSAGE, PRIORITY,
+// SYSLOG_IDENTIFIER and, when set, DDDNS_MSGID.
+//
+// Records larger than the socket's datagram limit (journald would need
+// a memfd) fail with an error; dddns records are a few hundred bytes.
+type Journald struct {
+	path string
+	tag  string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewJournald returns a sink for the journal socket at path.
+func NewJournald(path, tag string) *Journald {
+	return &Journald{path: path, tag: tag}
+}
+
+// Encode renders r in the journal native format.
+func (j *Journald) Encode(r Record) []byte {
+	var b bytes.Buffer
+	put := func(k, v string) {
+		if !strings.Contains(v, "\n") {
+			b.WriteString(k + "=" + v + "\n")
+			return
+		}
+		b.WriteString(k + "\n")
+		_ = binary.Write(&b, binary.LittleEndian, uint64(len(v)))
+		b.WriteString(v + "\n")
+	}
+	put("MESSAGE", r.Message)
+	put("PRIORITY", strconv.Itoa(int(r.Severity)))
+	put("SYSLOG_IDENTIFIER", j.tag)
+	if r.MsgID != "" {
+		put("DDDNS_MSGID", r.MsgID)
+	}
+	for _, f := range r.Fields {
+		put(journalName(f.Key), f.Value)
+	}
+	return b.Bytes()
+}
+
+// Write implements Sink, redialling once on failure.
+func (j *Journald) Write(r Record) error {
+	msg := j.Encode(r)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if j.conn == nil {
+			if j.conn, err = net.DialTimeout("unixgram", j.path, dialTimeout); err != nil {
+				j.conn = nil
+				continue
+			}
+		}
+		_ = j.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err = j.conn.Write(msg); err == nil {
+			return nil
+		}
+		_ = j.conn.Close()
+		j.conn = nil
+	}
+	return fmt.Errorf("journald %s: %w", j.path, err)
+}
+
+// Close implements Sink.
+func (j *Journald) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.conn == nil {
+		return nil
+	}
+	err := j.conn.Close()
+	j.conn = nil
+	return err
+}
+
+// journalName maps a field key to a journal field name: DDDNS_ prefix,
+// upper case, and anything outside [A-Z0-9_] replaced by '_'.
+func journalName(k string) string {
+	b := []byte("DDDNS_" + strings.ToUpper(k))
+	for i, c := range b {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,195 @@
+// Package logsink delivers dddns log records to pluggable destinations:
+// plain text on stderr or a file, RFC 5424 syslog over UDP, TCP or a
+// unix socket, and systemd-journald's native protocol.
+//
+// A Record is a message plus ordered key/value fields. Text sinks render
+// the fields as key=value after the message; syslog carries them as
+// RFC 5424 structured data; journald as DDDNS_<KEY> journal fields, so
+// a collector can filter on DDDNS_ACTION without parsing the message.
+//
+// Network sinks connect lazily and redial once per write after a
+// failure, so a collector that is down at startup does not stop dddns.
+// All sinks are safe for concurrent use. internal/logging adapts them
+// to log/slog.
+package logsink
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/descoped/dddns/internal/config"
+)
+
+// Severity is an RFC 5424 severity level; lower is more severe.
+type Severity int
+
+// The severities dddns emits.
+const (
+	SevError   Severity = 3
+	SevWarning Severity = 4
+	SevNotice  Severity = 5
+	SevInfo    Severity = 6
+	SevDebug   Severity = 7
+)
+
+// Field is one structured key/value pair. Keys are lower_snake_case.
+type Field struct {
+	Key   string
+	Value string
+}
+
+// Record is one log event.
+type Record struct {
+	Time     time.Time
+	Severity Severity
+	MsgID    string // syslog MSGID, e.g. "audit"; empty = "-"
+	Message  string
+	Fields   []Field
+}
+
+// Sink is a log destination.
+type Sink interface {
+	Write(Record) error
+	Close() error
+}
+
+const (
+	defaultTag      = "dddns"
+	defaultFacility = "daemon"
+	journalSocket   = "/run/systemd/journal/socket"
+)
+
+// Open constructs the sink described by c. It does not connect; network
+// and socket sinks dial on first write.
+func Open(c config.LogSinkConfig) (Sink, error) {
+	tag := c.Tag
+	if tag == "" {
+		tag = defaultTag
+	}
+	facility := c.Facility
+	if facility == "" {
+		facility = defaultFacility
+	}
+	fac, ok := config.SyslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", facility)
+	}
+
+	switch c.Type {
+	case "", "stderr":
+		return NewWriterSink(os.Stderr), nil
+	case "file":
+		f, err := os.OpenFile(c.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("open log file: %w", err)
+		}
+		return &writerSink{w: f, closer: f}, nil
+	case "syslog":
+		network, addr := "unix", "/dev/log"
+		if c.Address != "" {
+			u, err := url.Parse(c.Address)
+			if err != nil {
+				return nil, fmt.Errorf("syslog address %q: %w", c.Address, err)
+			}
+			network, addr = u.Scheme, u.Host
+			if network == "unix" {
+				addr = u.Path
+			}
+		}
+		return NewSyslog(network, addr, fac, tag), nil
+	case "journald":
+		path := c.Path
+		if path == "" {
+			path = journalSocket
+		}
+		return NewJournald(path, tag), nil
+	}
+	return nil, fmt.Errorf("unknown log sink type %q", c.Type)
+}
+
+// OpenAll opens every sink in cs, closing those already opened if one
+// fails.
+func OpenAll(cs []config.LogSinkConfig) ([]Sink, error) {
+	var sinks []Sink
+	for _, c := range cs {
+		s, err := Open(c)
+		if err != nil {
+			_ = Multi(sinks).Close()
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
+
+// Multi fans a record out to every sink. Write attempts them all and
+// joins the errors.
+type Multi []Sink
+
+// Write implements Sink.
+func (m Multi) Write(r Record) error {
+	var errs []error
+	for _, s := range m {
+		if err := s.Write(r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close implements Sink.
+func (m Multi) Close() error {
+	var errs []error
+	for _, s := range m {
+		errs = append(errs, s.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// writerSink renders records as text lines in the standard log
+// package's format: "2006/01/02 15:04:05 message key=value ...".
+type writerSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer // nil for stderr, which must not be closed
+}
+
+// NewWriterSink returns a text sink writing to w.
+func NewWriterSink(w io.Writer) Sink {
+	return &writerSink{w: w}
+}
+
+func (s *writerSink) Write(r Record) error {
+	var b strings.Builder
+	b.WriteString(r.Time.Format("2006/01/02 15:04:05 "))
+	b.WriteString(r.Message)
+	for _, f := range r.Fields {
+		b.WriteByte(' ')
+		b.WriteString(f.Key)
+		b.WriteByte('=')
+		if strings.ContainsAny(f.Value, " \"=") || f.Value == "" {
+			fmt.Fprintf(&b, "%q", f.Value)
+		} else {
+			b.WriteString(f.Value)
+		}
+	}
+	b.WriteByte('\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := io.WriteString(s.w, b.String())
+	return err
+}
+
+func (s *writerSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
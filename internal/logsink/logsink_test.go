@@ -0,0 +1,234 @@
+package logsink
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/descoped/dddns/internal/config"
+)
+
+var at = time.Date(2026, 4, 17, 12, 0, 0, 0, time.UTC)
+
+func auditLike() Record {
+	return Record{
+		Time:     at,
+		Severity: SevNotice,
+		MsgID:    "audit",
+		Message:  "audit updated ok",
+		Fields: []Field{
+			{"remote", "192.168.1.1:4000"},
+			{"action", "updated"},
+			{"error", `quote " and ] and \`},
+		},
+	}
+}
+
+func TestSyslog_Format(t *testing.T) {
+	s := NewSyslog("udp", "127.0.0.1:514", config.SyslogFacilities["daemon"], "dddns")
+	s.hostname = "udm"
+	want := fmt.Sprintf(`<29>1 2026-04-17T12:00:00.000000Z udm dddns %d audit [dddns@32473 remote="192.168.1.1:4000" action="updated" error="quote \" and \] and \\"] audit updated ok`, os.Getpid())
+	if got := s.Format(auditLike()); got != want {
+		t.Errorf("Format =\n%s\nwant\n%s", got, want)
+	}
+
+	plain := s.Format(Record{Time: at, Severity: SevInfo, Message: "hello"})
+	if !strings.HasPrefix(plain, "<30>1 ") || !strings.HasSuffix(plain, " - - hello") {
+		t.Errorf("no-field record = %q", plain)
+	}
+}
+
+func TestSyslog_UDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	s := NewSyslog("udp", pc.LocalAddr().String(), 16, "dddns")
+	defer s.Close()
+	if err := s.Write(auditLike()); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2048)
+	_ = pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); !strings.HasPrefix(got, "<133>1 ") { // local0.notice
+		t.Errorf("datagram = %q", got)
+	}
+}
+
+func TestSyslog_TCPOctetCounting(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	s := NewSyslog("tcp", ln.Addr().String(), 3, "dddns")
+	defer s.Close()
+	go func() {
+		_ = s.Write(auditLike())
+		_ = s.Write(Record{Time: at, Severity: SevInfo, Message: "second"})
+	}()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	r := bufio.NewReader(conn)
+	for _, wantSuffix := range []string{"audit updated ok", "second"} {
+		lenStr, err := r.ReadString(' ')
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(lenStr))
+		if err != nil {
+			t.Fatalf("frame length %q: %v", lenStr, err)
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(string(msg), wantSuffix) {
+			t.Errorf("frame = %q, want suffix %q", msg, wantSuffix)
+		}
+	}
+}
+
+func TestJournald_Encode(t *testing.T) {
+	j := NewJournald("", "dddns")
+	r := auditLike()
+	r.Fields = append(r.Fields, Field{"my-ip", "multi\nline"})
+	got := j.Encode(r)
+
+	for _, want := range []string{
+		"MESSAGE=audit updated ok\n",
+		"PRIORITY=5\n",
+		"SYSLOG_IDENTIFIER=dddns\n",
+		"DDDNS_MSGID=audit\n",
+		"DDDNS_REMOTE=192.168.1.1:4000\n",
+		"DDDNS_ACTION=updated\n",
+	} {
+		if !bytes.Contains(got, []byte(want)) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len("multi\nline")))
+	if !bytes.Contains(got, append(append([]byte("DDDNS_MY_IP\n"), size[:]...), "multi\nline\n"...)) {
+		t.Errorf("multi-line value not in binary form:\n%q", got)
+	}
+}
+
+func TestJournald_WritesDatagram(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "journal.sock")
+	pc, err := net.ListenPacket("unixgram", sock)
+	if err != nil {
+		t.Skipf("unixgram unavailable: %v", err)
+	}
+	defer pc.Close()
+
+	j := NewJournald(sock, "dddns")
+	defer j.Close()
+	if err := j.Write(auditLike()); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4096)
+	_ = pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], j.Encode(auditLike())) {
+		t.Errorf("datagram = %q", buf[:n])
+	}
+}
+
+func TestWriterSink_Text(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewWriterSink(&buf)
+	_ = s.Write(Record{Time: at, Message: "serve: audit write failed", Fields: []Field{{"path", "/tmp/a b"}, {"n", "3"}}})
+	want := `2026/04/17 12:00:00 serve: audit write failed path="/tmp/a b" n=3` + "\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dddns.log")
+	s, err := Open(config.LogSinkConfig{Type: "file", Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Write(Record{Time: at, Message: "to file"})
+	_ = s.Close()
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "to file") {
+		t.Errorf("file = %q", data)
+	}
+
+	if s, err := Open(config.LogSinkConfig{Type: "syslog", Address: "unix:///dev/log", Facility: "local3"}); err != nil {
+		t.Error(err)
+	} else if sl := s.(*Syslog); sl.network != "unix" || sl.addr != "/dev/log" || sl.facility != 19 {
+		t.Errorf("syslog sink = %+v", sl)
+	}
+	if _, err := Open(config.LogSinkConfig{Type: "carrier-pigeon"}); err == nil {
+		t.Error("expected error for unknown type")
+	}
+}
+
+// blockingSink holds every Write until release is closed.
+type blockingSink struct {
+	release chan struct{}
+	n       int
+}
+
+func (s *blockingSink) Write(Record) error { <-s.release; s.n++; return nil }
+func (s *blockingSink) Close() error       { return nil }
+
+// TestAsync_DropsWhenFull verifies Write never waits on a stalled sink:
+// records beyond the queue are dropped and reported, and Close delivers
+// the rest once the sink recovers.
+func TestAsync_DropsWhenFull(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{})}
+	var dropped int
+	a := NewAsync(sink, 2, func(err error) {
+		if err == ErrQueueFull {
+			dropped++
+		}
+	})
+	var full int
+	for i := 0; i < 10; i++ {
+		if err := a.Write(auditLike()); err == ErrQueueFull {
+			full++
+		}
+	}
+	// One record is in the stalled Write, two are queued.
+	if full < 7 || full != dropped {
+		t.Errorf("dropped %d (reported %d) of 10, want at least 7", full, dropped)
+	}
+	close(sink.release)
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if sink.n+full != 10 {
+		t.Errorf("delivered %d + dropped %d, want 10", sink.n, full)
+	}
+	if err := a.Write(auditLike()); err == nil {
+		t.Error("Write after Close succeeded")
+	}
+}
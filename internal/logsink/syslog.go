@@ -0,0 +1,167 @@
+package logsink
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sdID is the RFC 5424 SD-ID under which fields are sent. 32473 is the
+// private enterprise number reserved for documentation (RFC 5612);
+// collectors match on the "dddns" name.
+const sdID = "dddns@32473"
+
+// dialTimeout bounds connecting to a syslog collector, writeTimeout
+// sending one message to it, so a stalled collector fails the write
+// instead of hanging it.
+const (
+	dialTimeout  = 5 * time.Second
+	writeTimeout = 5 * time.Second
+)
+
+// Syslog is an RFC 5424 syslog sink. UDP and unix datagram sockets get
+// one message per datagram; TCP and unix stream sockets use RFC 6587
+// octet-counting framing.
+type Syslog struct {
+	network, addr string
+	facility      int
+	tag           string
+	hostname      string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewSyslog returns a sink sending to addr over network ("udp", "tcp",
+// or "unix"). facility is the numeric syslog facility; tag is APP-NAME.
+func NewSyslog(network, addr string, facility int, tag string) *Syslog {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "-"
+	}
+	return &Syslog{
+		network:  network,
+		addr:     addr,
+		facility: facility,
+		tag:      tag,
+		hostname: host,
+	}
+}
+
+// dialSyslog connects to a collector. For "unix" it tries a datagram
+// socket first (what /dev/log usually is), then a stream socket.
+func dialSyslog(network, addr string) (net.Conn, error) {
+	if network != "unix" {
+		return net.DialTimeout(network, addr, dialTimeout)
+	}
+	if c, err := net.DialTimeout("unixgram", addr, dialTimeout); err == nil {
+		return c, nil
+	}
+	return net.DialTimeout("unix", addr, dialTimeout)
+}
+
+// Format renders r as an RFC 5424 message, without transport framing.
+func (s *Syslog) Format(r Record) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d %s ",
+		s.facility*8+int(r.Severity),
+		r.Time.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		s.hostname, s.tag, os.Getpid(), nilValue(r.MsgID))
+	if len(r.Fields) == 0 {
+		b.WriteString("-")
+	} else {
+		b.WriteString("[" + sdID)
+		for _, f := range r.Fields {
+			b.WriteString(" " + sdName(f.Key) + `="` + sdEscape(f.Value) + `"`)
+		}
+		b.WriteString("]")
+	}
+	if r.Message != "" {
+		b.WriteString(" " + r.Message)
+	}
+	return b.String()
+}
+
+// Write implements Sink. A failed write is retried once on a fresh
+// connection, which covers a collector restart.
+func (s *Syslog) Write(r Record) error {
+	msg := s.Format(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			if s.conn, err = dialSyslog(s.network, s.addr); err != nil {
+				s.conn = nil
+				continue
+			}
+		}
+		frame := msg
+		if isStream(s.conn) {
+			frame = strconv.Itoa(len(msg)) + " " + msg
+		}
+		_ = s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err = s.conn.Write([]byte(frame)); err == nil {
+			return nil
+		}
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+	return fmt.Errorf("syslog %s://%s: %w", s.network, s.addr, err)
+}
+
+// Close implements Sink.
+func (s *Syslog) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// isStream reports whether c needs octet-counting framing.
+func isStream(c net.Conn) bool {
+	switch c := c.(type) {
+	case *net.TCPConn:
+		return true
+	case *net.UnixConn:
+		a := c.RemoteAddr()
+		return a != nil && a.Network() == "unix"
+	}
+	return false
+}
+
+func nilValue(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
+
+// sdName keeps a field key within RFC 5424 PARAM-NAME: printable ASCII
+// without '=', ' ', ']' or '"', at most 32 characters.
+func sdName(k string) string {
+	b := []byte(k)
+	for i, c := range b {
+		if c <= ' ' || c > '~' || c == '=' || c == ']' || c == '"' {
+			b[i] = '_'
+		}
+	}
+	if len(b) > 32 {
+		b = b[:32]
+	}
+	return string(b)
+}
+
+// sdEscape escapes '"', '\' and ']' in a PARAM-VALUE.
+func sdEscape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(v)
+}
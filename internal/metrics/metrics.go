@@ -0,0 +1,389 @@
+// Package metrics is a minimal Prometheus text-exposition registry.
+//
+// It implements only what dddns exports — counters, gauges, and
+// histograms, optionally labelled — and renders them in the text
+// format (version 0.0.4) that every Prometheus-compatible scraper
+// accepts. The official client_golang library would add several MB to
+// a binary that has to fit on UniFi devices; this file is the whole
+// replacement.
+//
+// All collector methods are safe for concurrent use.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the Content-Type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are histogram upper bounds (seconds) sized for AWS API
+// round trips: tens of milliseconds on a good day, the 30 s handler
+// timeout on a bad one.
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// collector is implemented by every metric family the Registry holds.
+type collector interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// Registry owns a set of metric families and renders them on demand.
+// The zero value is not usable — construct with NewRegistry.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+	names      map[string]bool
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// register adds c, panicking on a duplicate name. Duplicate registration
+// is a programming error caught by the first test that builds the
+// registry, so a panic is the right signal.
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[c.name()] {
+		panic("metrics: duplicate registration of " + c.name())
+	}
+	r.names[c.name()] = true
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteText renders every registered family in the Prometheus text
+// format, ordered by metric name so output is stable across scrapes.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	cs := make([]collector, len(r.collectors))
+	copy(cs, r.collectors)
+	r.mu.Unlock()
+
+	sort.Slice(cs, func(i, j int) bool { return cs[i].name() < cs[j].name() })
+
+	bw := bufio.NewWriter(w)
+	for _, c := range cs {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler returns an http.Handler that serves the registry. Only GET
+// and HEAD are accepted.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", ContentType)
+		if req.Method == http.MethodHead {
+			return
+		}
+		_ = r.WriteText(w)
+	})
+}
+
+// --- families ---
+
+// family holds the labelled children shared by every vector type.
+type family struct {
+	metricName string
+	help       string
+	kind       string
+	labelNames []string
+
+	mu       sync.Mutex
+	children map[string]*child
+}
+
+// child is one label combination. value is used by counters and gauges;
+// buckets/sum/count by histograms.
+type child struct {
+	labelValues []string
+	value       float64
+	buckets     []uint64
+	sum         float64
+	count       uint64
+}
+
+func newFamily(name, help, kind string, labels []string) *family {
+	return &family{
+		metricName: name,
+		help:       help,
+		kind:       kind,
+		labelNames: labels,
+		children:   make(map[string]*child),
+	}
+}
+
+func (f *family) name() string { return f.metricName }
+
+// get returns the child for values, creating it on first use. Caller
+// must hold f.mu.
+func (f *family) get(values []string) *child {
+	if len(values) != len(f.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.metricName, len(f.labelNames), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	c, ok := f.children[key]
+	if !ok {
+		c = &child{labelValues: append([]string(nil), values...)}
+		f.children[key] = c
+	}
+	return c
+}
+
+// peek returns the value of the child for values without creating it.
+// Caller must hold f.mu.
+func (f *family) peek(values []string) float64 {
+	if c, ok := f.children[strings.Join(values, "\xff")]; ok {
+		return c.value
+	}
+	return 0
+}
+
+// sortedChildren returns the children ordered by label values. Caller
+// must hold f.mu.
+func (f *family) sortedChildren() []*child {
+	out := make([]*child, 0, len(f.children))
+	for _, c := range f.children {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return slices.Compare(out[i].labelValues, out[j].labelValues) < 0
+	})
+	return out
+}
+
+func (f *family) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.metricName, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.metricName, f.kind)
+}
+
+// write renders counters and gauges. Histograms override it.
+func (f *family) write(w *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writeHeader(w)
+	for _, c := range f.sortedChildren() {
+		fmt.Fprintf(w, "%s%s %s\n", f.metricName, labelString(f.labelNames, c.labelValues, "", ""), formatFloat(c.value))
+	}
+}
+
+// CounterVec is a monotonically increasing counter partitioned by labels.
+type CounterVec struct{ f *family }
+
+// NewCounterVec registers a counter family. With no labels it behaves as
+// a single counter addressed by Inc() with no arguments.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{f: newFamily(name, help, "counter", labels)}
+	r.register(v.f)
+	return v
+}
+
+// Inc adds one to the child identified by values.
+func (v *CounterVec) Inc(values ...string) { v.Add(1, values...) }
+
+// Add adds delta (which must be non-negative) to the child identified
+// by values.
+func (v *CounterVec) Add(delta float64, values ...string) {
+	if delta < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	v.f.mu.Lock()
+	defer v.f.mu.Unlock()
+	v.f.get(values).value += delta
+}
+
+// Value returns the current count for values. Intended for tests and
+// for callers that mirror a counter elsewhere (e.g. MQTT).
+func (v *CounterVec) Value(values ...string) float64 {
+	v.f.mu.Lock()
+	defer v.f.mu.Unlock()
+	return v.f.peek(values)
+}
+
+// GaugeVec is a value that can go up and down, partitioned by labels.
+type GaugeVec struct{ f *family }
+
+// NewGaugeVec registers a gauge family.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{f: newFamily(name, help, "gauge", labels)}
+	r.register(v.f)
+	return v
+}
+
+// Set replaces the value of the child identified by values.
+func (v *GaugeVec) Set(val float64, values ...string) {
+	v.f.mu.Lock()
+	defer v.f.mu.Unlock()
+	v.f.get(values).value = val
+}
+
+// Value returns the current value for values.
+func (v *GaugeVec) Value(values ...string) float64 {
+	v.f.mu.Lock()
+	defer v.f.mu.Unlock()
+	return v.f.peek(values)
+}
+
+// Reset drops every child. Used by info-style gauges whose label set
+// changes (e.g. the currently published IP).
+func (v *GaugeVec) Reset() {
+	v.f.mu.Lock()
+	defer v.f.mu.Unlock()
+	v.f.children = make(map[string]*child)
+}
+
+// gaugeFunc is an unlabelled gauge whose value is computed at scrape time.
+type gaugeFunc struct {
+	metricName string
+	help       string
+	fn         func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is read from fn on every
+// scrape. fn must be safe for concurrent use.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&gaugeFunc{metricName: name, help: help, fn: fn})
+}
+
+func (g *gaugeFunc) name() string { return g.metricName }
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", g.metricName, escapeHelp(g.help))
+	fmt.Fprintf(w, "# TYPE %s gauge\n", g.metricName)
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatFloat(g.fn()))
+}
+
+// HistogramVec samples observations into cumulative buckets, partitioned
+// by labels.
+type HistogramVec struct{ f *histogramFamily }
+
+type histogramFamily struct {
+	*family
+	buckets []float64
+}
+
+// NewHistogramVec registers a histogram family. buckets must be sorted
+// ascending; nil selects DefaultBuckets. The +Inf bucket is implicit.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	if !sort.Float64sAreSorted(buckets) {
+		panic("metrics: histogram buckets must be sorted: " + name)
+	}
+	hf := &histogramFamily{family: newFamily(name, help, "histogram", labels), buckets: buckets}
+	r.register(hf)
+	return &HistogramVec{f: hf}
+}
+
+// Observe records one sample in the child identified by values.
+func (v *HistogramVec) Observe(sample float64, values ...string) {
+	v.f.mu.Lock()
+	defer v.f.mu.Unlock()
+	c := v.f.get(values)
+	if c.buckets == nil {
+		c.buckets = make([]uint64, len(v.f.buckets))
+	}
+	for i, ub := range v.f.buckets {
+		if sample <= ub {
+			c.buckets[i]++
+		}
+	}
+	c.sum += sample
+	c.count++
+}
+
+// Count returns the number of observations for values.
+func (v *HistogramVec) Count(values ...string) uint64 {
+	v.f.mu.Lock()
+	defer v.f.mu.Unlock()
+	if c, ok := v.f.children[strings.Join(values, "\xff")]; ok {
+		return c.count
+	}
+	return 0
+}
+
+func (h *histogramFamily) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w)
+	for _, c := range h.sortedChildren() {
+		for i, ub := range h.buckets {
+			var n uint64
+			if c.buckets != nil {
+				n = c.buckets[i]
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, labelString(h.labelNames, c.labelValues, "le", formatFloat(ub)), n)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, labelString(h.labelNames, c.labelValues, "le", "+Inf"), c.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, labelString(h.labelNames, c.labelValues, "", ""), formatFloat(c.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, labelString(h.labelNames, c.labelValues, "", ""), c.count)
+	}
+}
+
+// --- formatting helpers ---
+
+// labelString renders {a="x",b="y"}, optionally appending one extra
+// pair (used for the histogram "le" label). Returns "" when there are
+// no pairs at all.
+func labelString(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(n)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(values[i]))
+		b.WriteByte('"')
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extraName)
+		b.WriteString(`="`)
+		b.WriteString(extraValue)
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
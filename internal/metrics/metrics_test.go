@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func render(t *testing.T, r *Registry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	return buf.String()
+}
+
+// TestWriteText_Golden pins the exact exposition format for each family
+// type. Prometheus parsers are strict about HELP/TYPE ordering and label
+// quoting, so a byte-for-byte comparison is the useful check.
+func TestWriteText_Golden(t *testing.T) {
+	r := NewRegistry()
+	reqs := r.NewCounterVec("dddns_requests_total", "Requests handled.", "action", "auth")
+	reqs.Inc("updated", "ok")
+	reqs.Inc("updated", "ok")
+	reqs.Inc("", "bad")
+	g := r.NewGaugeVec("dddns_last_success_timestamp_seconds", "Last success.")
+	g.Set(1.7e9)
+	r.NewGaugeFunc("dddns_auth_locked", "Lockout active.", func() float64 { return 1 })
+	h := r.NewHistogramVec("dddns_route53_request_duration_seconds", "Route53 latency.", []float64{0.1, 1}, "op")
+	h.Observe(0.05, "upsert")
+	h.Observe(0.5, "upsert")
+
+	want := `# HELP dddns_auth_locked Lockout active.
+# TYPE dddns_auth_locked gauge
+dddns_auth_locked 1
+# HELP dddns_last_success_timestamp_seconds Last success.
+# TYPE dddns_last_success_timestamp_seconds gauge
+dddns_last_success_timestamp_seconds 1.7e+09
+# HELP dddns_requests_total Requests handled.
+# TYPE dddns_requests_total counter
+dddns_requests_total{action="",auth="bad"} 1
+dddns_requests_total{action="updated",auth="ok"} 2
+# HELP dddns_route53_request_duration_seconds Route53 latency.
+# TYPE dddns_route53_request_duration_seconds histogram
+dddns_route53_request_duration_seconds_bucket{op="upsert",le="0.1"} 1
+dddns_route53_request_duration_seconds_bucket{op="upsert",le="1"} 2
+dddns_route53_request_duration_seconds_bucket{op="upsert",le="+Inf"} 2
+dddns_route53_request_duration_seconds_sum{op="upsert"} 0.55
+dddns_route53_request_duration_seconds_count{op="upsert"} 2
+`
+	if got := render(t, r); got != want {
+		t.Errorf("exposition mismatch\n--- got ---\n%s\n--- want ---\n%s", got, want)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("x_total", "help with \\ and\nnewline", "v")
+	c.Inc("a\"b\\c\nd")
+	out := render(t, r)
+	if !strings.Contains(out, `x_total{v="a\"b\\c\nd"} 1`) {
+		t.Errorf("label not escaped:\n%s", out)
+	}
+	if !strings.Contains(out, `# HELP x_total help with \\ and\nnewline`) {
+		t.Errorf("help not escaped:\n%s", out)
+	}
+}
+
+func TestGaugeVec_ResetDropsChildren(t *testing.T) {
+	r := NewRegistry()
+	info := r.NewGaugeVec("ip_info", "Published IP.", "ip")
+	info.Set(1, "203.0.113.1")
+	info.Reset()
+	info.Set(1, "203.0.113.2")
+	out := render(t, r)
+	if strings.Contains(out, "203.0.113.1") {
+		t.Errorf("stale child survived Reset:\n%s", out)
+	}
+	if !strings.Contains(out, `ip_info{ip="203.0.113.2"} 1`) {
+		t.Errorf("new child missing:\n%s", out)
+	}
+}
+
+func TestValue_DoesNotCreateChild(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("x_total", "h", "v")
+	if got := c.Value("never"); got != 0 {
+		t.Errorf("Value = %v, want 0", got)
+	}
+	if strings.Contains(render(t, r), "never") {
+		t.Error("Value must not materialize a child")
+	}
+}
+
+func TestRegister_DuplicatePanics(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("dup_total", "h")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic on duplicate registration")
+		}
+	}()
+	r.NewGaugeVec("dup_total", "h")
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("x_total", "h").Inc()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET status = %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "x_total 1") {
+		t.Errorf("body = %q", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want 405", rec.Code)
+	}
+}
@@ -0,0 +1,282 @@
+// Package mqtt is a minimal MQTT 3.1.1 publisher over TCP or TLS, and
+// the Home Assistant state/discovery messages dddns sends through it.
+// Only what a short-lived publisher needs is implemented: CONNECT,
+// PUBLISH at QoS 0 or 1, and DISCONNECT. There are no subscriptions and
+// no keep-alive pings; each update opens its own connection.
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types (high nibble of the fixed header).
+const (
+	packetConnect    = 1
+	packetConnack    = 2
+	packetPublish    = 3
+	packetPuback     = 4
+	packetDisconnect = 14
+)
+
+// maxRemainingLength is the largest length the 4-byte varint encodes.
+const maxRemainingLength = 268_435_455
+
+// maxReadLength bounds the packets read accepts. A publisher only ever
+// receives CONNACK and PUBACK, two bytes each; anything near the
+// protocol limit is a broken or hostile broker, not something to
+// allocate for.
+const maxReadLength = 1024
+
+// connackErrors maps CONNACK return codes to their meaning.
+var connackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// DialOptions configures Dial.
+type DialOptions struct {
+	Broker    string // tcp://, mqtt://, tls://, mqtts:// or ssl:// URL
+	ClientID  string
+	Username  string
+	Password  string
+	TLSConfig *tls.Config // for TLS brokers; nil = system roots
+	KeepAlive time.Duration
+}
+
+// Client is one connection to a broker. It is not safe for concurrent
+// use.
+type Client struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	nextID uint16
+}
+
+// Dial connects to the broker and completes the MQTT handshake with a
+// clean session. ctx bounds the whole exchange.
+func Dial(ctx context.Context, o DialOptions) (*Client, error) {
+	if o.Password != "" && o.Username == "" {
+		// MQTT 3.1.1 §3.1.2.9: brokers drop a CONNECT that does.
+		return nil, errors.New("mqtt password set without a username")
+	}
+	u, err := url.Parse(o.Broker)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid broker URL %q", o.Broker)
+	}
+	secure := false
+	port := "1883"
+	switch u.Scheme {
+	case "tcp", "mqtt":
+	case "tls", "mqtts", "ssl":
+		secure, port = true, "8883"
+	default:
+		return nil, fmt.Errorf("unsupported broker scheme %q", u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if secure {
+		cfg := o.TLSConfig
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		cfg = cfg.Clone()
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		tc := tls.Client(conn, cfg)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+	c := &Client{conn: conn, r: bufio.NewReader(conn)}
+	c.setDeadline(ctx)
+	if err := c.connect(o); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// setDeadline applies ctx's deadline, if any, to the connection.
+func (c *Client) setDeadline(ctx context.Context) {
+	dl, _ := ctx.Deadline()
+	_ = c.conn.SetDeadline(dl)
+}
+
+// connect sends CONNECT and waits for a successful CONNACK.
+func (c *Client) connect(o DialOptions) error {
+	var flags byte = 0x02 // clean session
+	if o.Username != "" {
+		flags |= 0x80
+	}
+	if o.Password != "" {
+		flags |= 0x40
+	}
+	keepAlive := uint16(o.KeepAlive / time.Second)
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, flags) // protocol level 4 = 3.1.1
+	body = binary.BigEndian.AppendUint16(body, keepAlive)
+	body = appendString(body, o.ClientID)
+	if o.Username != "" {
+		body = appendString(body, o.Username)
+	}
+	if o.Password != "" {
+		body = appendString(body, o.Password)
+	}
+	if err := c.write(packetConnect<<4, body); err != nil {
+		return err
+	}
+
+	typ, payload, err := c.read()
+	if err != nil {
+		return fmt.Errorf("read CONNACK: %w", err)
+	}
+	if typ != packetConnack || len(payload) != 2 {
+		return fmt.Errorf("expected CONNACK, got packet type %d", typ)
+	}
+	if code := payload[1]; code != 0 {
+		if msg, ok := connackErrors[code]; ok {
+			return fmt.Errorf("broker refused connection: %s", msg)
+		}
+		return fmt.Errorf("broker refused connection: code %d", code)
+	}
+	return nil
+}
+
+// Publish sends payload to topic. At QoS 1 it waits for the broker's
+// PUBACK; ctx bounds the wait.
+func (c *Client) Publish(ctx context.Context, topic string, payload []byte, qos byte, retain bool) error {
+	if qos > 1 {
+		return fmt.Errorf("QoS %d is not supported", qos)
+	}
+	c.setDeadline(ctx)
+	header := byte(packetPublish<<4) | qos<<1
+	if retain {
+		header |= 0x01
+	}
+	body := appendString(nil, topic)
+	var id uint16
+	if qos == 1 {
+		c.nextID++
+		if c.nextID == 0 {
+			c.nextID = 1
+		}
+		id = c.nextID
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	body = append(body, payload...)
+	if err := c.write(header, body); err != nil {
+		return err
+	}
+	if qos == 0 {
+		return nil
+	}
+	for {
+		typ, ack, err := c.read()
+		if err != nil {
+			return fmt.Errorf("read PUBACK: %w", err)
+		}
+		if typ == packetPuback && len(ack) == 2 && binary.BigEndian.Uint16(ack) == id {
+			return nil
+		}
+	}
+}
+
+// Close sends DISCONNECT and closes the connection.
+func (c *Client) Close() error {
+	werr := c.write(packetDisconnect<<4, nil)
+	if err := c.conn.Close(); err != nil {
+		return err
+	}
+	return werr
+}
+
+// write sends one packet: the fixed header byte, the remaining length
+// and body.
+func (c *Client) write(header byte, body []byte) error {
+	if len(body) > maxRemainingLength {
+		return errors.New("packet too large")
+	}
+	pkt := append([]byte{header}, appendLength(nil, len(body))...)
+	_, err := c.conn.Write(append(pkt, body...))
+	return err
+}
+
+// read returns the next packet's type and body.
+func (c *Client) read() (typ byte, body []byte, err error) {
+	header, err := c.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, err := readLength(c.r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if n > maxReadLength {
+		return 0, nil, fmt.Errorf("broker sent a %d-byte packet; a publisher accepts at most %d", n, maxReadLength)
+	}
+	body = make([]byte, n)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return 0, nil, err
+	}
+	return header >> 4, body, nil
+}
+
+// appendString appends s as a length-prefixed UTF-8 string.
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// appendLength appends n as an MQTT variable-length integer.
+func appendLength(b []byte, n int) []byte {
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			return b
+		}
+	}
+}
+
+// readLength decodes an MQTT variable-length integer.
+func readLength(r io.ByteReader) (int, error) {
+	n, shift := 0, 0
+	for i := 0; i < 4; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		n |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return n, nil
+		}
+		shift += 7
+	}
+	return 0, errors.New("malformed remaining length")
+}
@@ -0,0 +1,97 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/descoped/dddns/internal/mqtt/mqtttest"
+)
+
+func TestLengthRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 127, 128, 16383, 16384, 2097151, 2097152, maxRemainingLength} {
+		enc := appendLength(nil, n)
+		got, err := readLength(bytes.NewReader(enc))
+		if err != nil || got != n {
+			t.Errorf("length %d: encoded %x, decoded %d, %v", n, enc, got, err)
+		}
+	}
+	if _, err := readLength(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff, 0x01})); err == nil {
+		t.Error("5-byte length accepted")
+	}
+}
+
+func TestClient_PublishQoS0And1(t *testing.T) {
+	b := mqtttest.NewBroker(t)
+	b.Username, b.Password = "ha", "pw"
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	c, err := Dial(ctx, DialOptions{Broker: b.URL(), ClientID: "dddns-test", Username: "ha", Password: "pw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Publish(ctx, "a/b", []byte("zero"), 0, false); err != nil {
+		t.Fatal(err)
+	}
+	big := strings.Repeat("x", 300) // two-byte remaining length
+	if err := c.Publish(ctx, "a/c", []byte(big), 1, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+
+	msgs := b.Messages()
+	if len(msgs) != 2 {
+		t.Fatalf("got %d messages", len(msgs))
+	}
+	if m := msgs[0]; m.Topic != "a/b" || string(m.Payload) != "zero" || m.QoS != 0 || m.Retain || m.ClientID != "dddns-test" {
+		t.Errorf("msg 0 = %+v", m)
+	}
+	if m := msgs[1]; m.Topic != "a/c" || string(m.Payload) != big || m.QoS != 1 || !m.Retain {
+		t.Errorf("msg 1 = %+v", m)
+	}
+}
+
+func TestDial_RefusedCredentials(t *testing.T) {
+	b := mqtttest.NewBroker(t)
+	b.Username, b.Password = "ha", "pw"
+	_, err := Dial(t.Context(), DialOptions{Broker: b.URL(), ClientID: "x", Username: "ha", Password: "wrong"})
+	if err == nil || !strings.Contains(err.Error(), "bad user name or password") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestDial_PasswordWithoutUsername(t *testing.T) {
+	b := mqtttest.NewBroker(t)
+	_, err := Dial(t.Context(), DialOptions{Broker: b.URL(), ClientID: "x", Password: "pw"})
+	if err == nil || !strings.Contains(err.Error(), "without a username") {
+		t.Errorf("err = %v", err)
+	}
+	if n := b.Connections(); n != 0 {
+		t.Errorf("broker saw %d connections, want none", n)
+	}
+}
+
+// TestClient_ReadRejectsOversizedPacket verifies a broker announcing a
+// huge packet gets an error, not a matching allocation.
+func TestClient_ReadRejectsOversizedPacket(t *testing.T) {
+	pkt := append([]byte{packetConnack << 4}, appendLength(nil, maxRemainingLength)...)
+	c := &Client{r: bufio.NewReader(bytes.NewReader(pkt))}
+	if _, _, err := c.read(); err == nil || !strings.Contains(err.Error(), "at most") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestDial_BadBroker(t *testing.T) {
+	for _, broker := range []string{"http://host:1883", "tcp://", "::"} {
+		if _, err := Dial(t.Context(), DialOptions{Broker: broker}); err == nil {
+			t.Errorf("Dial(%q) succeeded", broker)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+// Package mqtttest provides an in-process MQTT 3.1.1 broker for tests.
+// It accepts connections, checks credentials, acknowledges QoS 1
+// publishes, and records every message; it never forwards anything.
+package mqtttest
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Message is one PUBLISH the broker received.
+type Message struct {
+	ClientID string
+	Topic    string
+	Payload  []byte
+	QoS      byte
+	Retain   bool
+}
+
+// Broker is a fake MQTT broker listening on 127.0.0.1.
+type Broker struct {
+	// Username and Password, when set, are required on CONNECT; a
+	// mismatch is refused with return code 4.
+	Username, Password string
+
+	ln     net.Listener
+	scheme string
+	caPEM  []byte // TLS brokers: the self-signed certificate
+
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	msgs  []Message
+	conn  int // CONNECT packets accepted
+	open  map[net.Conn]bool
+	close bool
+}
+
+// NewBroker starts a broker and stops it when the test ends.
+func NewBroker(t testing.TB) *Broker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Broker{ln: ln, scheme: "tcp"}
+	b.wg.Go(b.serve)
+	t.Cleanup(b.Close)
+	return b
+}
+
+// NewTLSBroker starts a broker speaking TLS with a self-signed
+// certificate for 127.0.0.1; CAFile returns it for the client to trust.
+func NewTLSBroker(t testing.TB) *Broker {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Broker{ln: ln, scheme: "tls", caPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+	b.wg.Go(b.serve)
+	t.Cleanup(b.Close)
+	return b
+}
+
+// URL is the broker's tcp:// or tls:// address.
+func (b *Broker) URL() string {
+	return b.scheme + "://" + b.ln.Addr().String()
+}
+
+// CAFile writes a TLS broker's certificate to a PEM file in a test
+// temp dir and returns its path.
+func (b *Broker) CAFile(t testing.TB) string {
+	t.Helper()
+	if b.caPEM == nil {
+		t.Fatal("CAFile: not a TLS broker")
+	}
+	path := filepath.Join(t.TempDir(), "broker-ca.pem")
+	if err := os.WriteFile(path, b.caPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Messages returns the messages received so far.
+func (b *Broker) Messages() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Message(nil), b.msgs...)
+}
+
+// Retained returns the last retained payload per topic, as a
+// subscriber connecting now would see it.
+func (b *Broker) Retained() map[string][]byte {
+	out := map[string][]byte{}
+	for _, m := range b.Messages() {
+		if m.Retain {
+			out[m.Topic] = m.Payload
+		}
+	}
+	return out
+}
+
+// Connections returns the number of accepted sessions.
+func (b *Broker) Connections() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.conn
+}
+
+// Close stops the listener and waits for open sessions. Sessions get a
+// short grace period to drain what their client already sent; a client
+// that is still connected after that is dropped.
+func (b *Broker) Close() {
+	_ = b.ln.Close()
+	b.mu.Lock()
+	b.close = true
+	for c := range b.open {
+		_ = c.SetDeadline(time.Now().Add(time.Second))
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+}
+
+func (b *Broker) serve() {
+	for {
+		c, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		if b.close {
+			b.mu.Unlock()
+			_ = c.Close()
+			return
+		}
+		if b.open == nil {
+			b.open = map[net.Conn]bool{}
+		}
+		b.open[c] = true
+		b.mu.Unlock()
+		b.wg.Go(func() {
+			defer func() {
+				_ = c.Close()
+				b.mu.Lock()
+				delete(b.open, c)
+				b.mu.Unlock()
+			}()
+			_ = b.session(c)
+		})
+	}
+}
+
+// session handles one client until DISCONNECT or EOF.
+func (b *Broker) session(c net.Conn) error {
+	r := bufio.NewReader(c)
+	typ, _, body, err := readPacket(r)
+	if err != nil {
+		return err
+	}
+	if typ != 1 {
+		return errors.New("first packet is not CONNECT")
+	}
+	clientID, user, pass, err := parseConnect(body)
+	if err != nil {
+		return err
+	}
+	code := byte(0)
+	if b.Username != "" && (user != b.Username || pass != b.Password) {
+		code = 4
+	}
+	if _, err := c.Write([]byte{0x20, 2, 0, code}); err != nil || code != 0 {
+		return err
+	}
+	b.mu.Lock()
+	b.conn++
+	b.mu.Unlock()
+
+	for {
+		typ, flags, body, err := readPacket(r)
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case 3: // PUBLISH
+			m := Message{ClientID: clientID, QoS: (flags >> 1) & 3, Retain: flags&1 == 1}
+			topic, rest, err := readString(body)
+			if err != nil {
+				return err
+			}
+			m.Topic = topic
+			if m.QoS > 0 {
+				if len(rest) < 2 {
+					return errors.New("PUBLISH without packet id")
+				}
+				id := rest[:2]
+				rest = rest[2:]
+				if _, err := c.Write([]byte{0x40, 2, id[0], id[1]}); err != nil {
+					return err
+				}
+			}
+			m.Payload = append([]byte(nil), rest...)
+			b.mu.Lock()
+			b.msgs = append(b.msgs, m)
+			b.mu.Unlock()
+		case 14: // DISCONNECT
+			return nil
+		default:
+			return errors.New("unexpected packet type")
+		}
+	}
+}
+
+func readPacket(r *bufio.Reader) (typ, flags byte, body []byte, err error) {
+	h, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	n, mul := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, 0, nil, errors.New("malformed length")
+		}
+		d, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		n += int(d&0x7f) * mul
+		mul *= 128
+		if d&0x80 == 0 {
+			break
+		}
+	}
+	body = make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, nil, err
+	}
+	return h >> 4, h & 0x0f, body, nil
+}
+
+func parseConnect(body []byte) (clientID, user, pass string, err error) {
+	proto, rest, err := readString(body)
+	if err != nil || proto != "MQTT" || len(rest) < 4 || rest[0] != 4 {
+		return "", "", "", errors.New("not an MQTT 3.1.1 CONNECT")
+	}
+	flags := rest[1]
+	rest = rest[4:] // level, flags, keep-alive
+	if clientID, rest, err = readString(rest); err != nil {
+		return "", "", "", err
+	}
+	if flags&0x80 != 0 {
+		if user, rest, err = readString(rest); err != nil {
+			return "", "", "", err
+		}
+	}
+	if flags&0x40 != 0 {
+		if pass, _, err = readString(rest); err != nil {
+			return "", "", "", err
+		}
+	}
+	return clientID, user, pass, nil
+}
+
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errors.New("short string")
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, errors.New("short string")
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
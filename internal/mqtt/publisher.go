@@ -0,0 +1,277 @@
+package mqtt
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/descoped/dddns/internal/config"
+	"github.com/descoped/dddns/internal/logging"
+	"github.com/descoped/dddns/internal/version"
+)
+
+const (
+	defaultTimeout         = 10 * time.Second
+	defaultDiscoveryPrefix = "homeassistant"
+)
+
+// RequestStats summarises the requests a serve-mode listener has
+// handled since it started.
+type RequestStats struct {
+	Total         uint64 `json:"total"`
+	Updated       uint64 `json:"updated"`
+	Errors        uint64 `json:"errors"`
+	Rejected      uint64 `json:"rejected"` // CIDR/method denials, bad auth, lockouts, rate limits
+	LockedClients int    `json:"locked_clients"`
+}
+
+// State is the outcome of one update, published retained on
+// <prefix>/state.
+type State struct {
+	IP       string        `json:"ip,omitempty"`
+	OldIP    string        `json:"old_ip,omitempty"`
+	Action   string        `json:"action"` // the updater's action, or "failed"
+	Source   string        `json:"source,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Time     time.Time     `json:"time"`
+	Requests *RequestStats `json:"requests,omitempty"`
+}
+
+// change is published retained on <prefix>/changed when the record
+// changes, so "last change" survives later no-change updates.
+type change struct {
+	IP    string    `json:"ip"`
+	OldIP string    `json:"old_ip,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// Publisher sends update states to the configured broker. A nil
+// *Publisher is valid and publishes nothing.
+type Publisher struct {
+	cfg      config.MQTTConfig
+	hostname string
+	prefix   string
+	nodeID   string
+	timeout  time.Duration
+	tls      *tls.Config
+	logger   *slog.Logger
+	stats    func() RequestStats
+
+	async bool
+	wg    sync.WaitGroup
+
+	mu        sync.Mutex // serializes publishes; guards announced
+	announced bool       // discovery configs sent
+
+	now func() time.Time // injectable for tests
+}
+
+// NewPublisher builds a Publisher for hostname from c, or returns nil
+// when c is nil. logger receives publish failures (nil = slog.Default()).
+func NewPublisher(c *config.MQTTConfig, hostname string, logger *slog.Logger) (*Publisher, error) {
+	if c == nil {
+		return nil, nil
+	}
+	p := &Publisher{
+		cfg:      *c,
+		hostname: hostname,
+		prefix:   strings.TrimSuffix(c.TopicPrefix, "/"),
+		nodeID:   "dddns_" + sanitizeID(hostname),
+		timeout:  config.DurationOrDefault(c.Timeout, defaultTimeout),
+		logger:   logging.OrDefault(logger),
+		now:      time.Now,
+	}
+	if p.prefix == "" {
+		p.prefix = "dddns/" + hostname
+	}
+	if p.cfg.ClientID == "" {
+		p.cfg.ClientID = "dddns-" + sanitizeID(hostname)
+	}
+	if p.cfg.DiscoveryPrefix == "" {
+		p.cfg.DiscoveryPrefix = defaultDiscoveryPrefix
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("mqtt.ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("mqtt.ca_file: no PEM certificates in %s", c.CAFile)
+		}
+		p.tls = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return p, nil
+}
+
+// SetStats makes every published state carry fn's request summary.
+// serve sets it; the cron path has no request stats.
+func (p *Publisher) SetStats(fn func() RequestStats) {
+	if p != nil {
+		p.stats = fn
+	}
+}
+
+// SetAsync makes Publish return immediately and publish in the
+// background; Close waits for outstanding publishes.
+func (p *Publisher) SetAsync(async bool) {
+	if p != nil {
+		p.async = async
+	}
+}
+
+// Publish sends s and logs a failure. Errors are not returned: a dead
+// broker must not fail the update being reported.
+func (p *Publisher) Publish(ctx context.Context, s State) {
+	if p == nil {
+		return
+	}
+	publish := func(ctx context.Context) {
+		if err := p.PublishState(ctx, s); err != nil {
+			p.logger.Warn("mqtt publish failed", slog.String("broker", p.cfg.Broker), logging.Err(err))
+		}
+	}
+	if p.async {
+		ctx = context.WithoutCancel(ctx)
+		p.wg.Go(func() { publish(ctx) })
+		return
+	}
+	publish(ctx)
+}
+
+// Close waits for background publishes started in async mode.
+func (p *Publisher) Close() {
+	if p != nil {
+		p.wg.Wait()
+	}
+}
+
+// PublishState connects, sends the Home Assistant discovery configs the
+// first time, publishes s (and the change record for an update), and
+// disconnects.
+func (p *Publisher) PublishState(ctx context.Context, s State) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if s.Time.IsZero() {
+		s.Time = p.now().UTC()
+	}
+	if p.stats != nil {
+		stats := p.stats()
+		s.Requests = &stats
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	c, err := Dial(ctx, DialOptions{
+		Broker:    p.cfg.Broker,
+		ClientID:  p.cfg.ClientID,
+		Username:  p.cfg.Username,
+		Password:  p.cfg.Password,
+		TLSConfig: p.tls,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = c.Close() }()
+
+	qos := byte(p.cfg.QoS)
+	if p.cfg.Discovery && !p.announced {
+		for _, d := range p.discovery(s.Requests != nil) {
+			if err := c.Publish(ctx, d.topic, d.payload, qos, true); err != nil {
+				return fmt.Errorf("publish discovery: %w", err)
+			}
+		}
+		p.announced = true
+	}
+	if s.Action == "updated" {
+		body, _ := json.Marshal(change{IP: s.IP, OldIP: s.OldIP, Time: s.Time})
+		if err := c.Publish(ctx, p.prefix+"/changed", body, qos, true); err != nil {
+			return err
+		}
+	}
+	body, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return c.Publish(ctx, p.prefix+"/state", body, qos, true)
+}
+
+// message is one topic/payload pair.
+type message struct {
+	topic   string
+	payload []byte
+}
+
+// sensor is a Home Assistant MQTT sensor derived from the state topics.
+type sensor struct {
+	object, name, topic, template string
+	deviceClass, stateClass       string
+	icon, category                string
+}
+
+// discovery returns the retained Home Assistant sensor configs. The
+// request counters are only announced in serve mode.
+func (p *Publisher) discovery(serve bool) []message {
+	state, changed := p.prefix+"/state", p.prefix+"/changed"
+	sensors := []sensor{
+		{object: "public_ip", name: "Public IP", topic: state, template: "{{ value_json.ip }}", icon: "mdi:ip-network"},
+		{object: "last_result", name: "Last result", topic: state, template: "{{ value_json.action }}", icon: "mdi:dns"},
+		{object: "last_check", name: "Last check", topic: state, template: "{{ value_json.time }}", deviceClass: "timestamp"},
+		{object: "last_change", name: "Last change", topic: changed, template: "{{ value_json.time }}", deviceClass: "timestamp"},
+		{object: "last_error", name: "Last error", topic: state, template: "{{ value_json.error | default('') }}", icon: "mdi:alert-circle-outline", category: "diagnostic"},
+	}
+	if serve {
+		sensors = append(sensors,
+			sensor{object: "requests", name: "Requests", topic: state, template: "{{ value_json.requests.total }}", stateClass: "total_increasing", icon: "mdi:swap-vertical"},
+			sensor{object: "requests_rejected", name: "Rejected requests", topic: state, template: "{{ value_json.requests.rejected }}", stateClass: "total_increasing", icon: "mdi:shield-alert-outline", category: "diagnostic"},
+		)
+	}
+	device := map[string]any{
+		"identifiers":  []string{p.nodeID},
+		"name":         "dddns " + p.hostname,
+		"manufacturer": "descoped",
+		"model":        "dddns",
+		"sw_version":   version.GetVersion(),
+	}
+	out := make([]message, 0, len(sensors))
+	for _, s := range sensors {
+		cfg := map[string]any{
+			"name":           s.name,
+			"unique_id":      p.nodeID + "_" + s.object,
+			"state_topic":    s.topic,
+			"value_template": s.template,
+			"device":         device,
+		}
+		for k, v := range map[string]string{"device_class": s.deviceClass, "state_class": s.stateClass, "icon": s.icon, "entity_category": s.category} {
+			if v != "" {
+				cfg[k] = v
+			}
+		}
+		payload, _ := json.Marshal(cfg)
+		out = append(out, message{
+			topic:   fmt.Sprintf("%s/sensor/%s/%s/config", p.cfg.DiscoveryPrefix, p.nodeID, s.object),
+			payload: payload,
+		})
+	}
+	return out
+}
+
+// sanitizeID maps s to the [a-zA-Z0-9_] alphabet Home Assistant allows
+// in node and object IDs.
+func sanitizeID(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+}
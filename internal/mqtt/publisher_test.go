@@ -0,0 +1,148 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/descoped/dddns/internal/config"
+	"github.com/descoped/dddns/internal/logging"
+	"github.com/descoped/dddns/internal/mqtt/mqtttest"
+)
+
+func newTestPublisher(t *testing.T, c *config.MQTTConfig) *Publisher {
+	t.Helper()
+	p, err := NewPublisher(c, "home.example.com", logging.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.now = func() time.Time { return time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC) }
+	return p
+}
+
+func TestPublisher_StateAndDiscovery(t *testing.T) {
+	b := mqtttest.NewBroker(t)
+	p := newTestPublisher(t, &config.MQTTConfig{Broker: b.URL(), Discovery: true, QoS: 1})
+
+	err := p.PublishState(t.Context(), State{IP: "203.0.113.10", OldIP: "198.51.100.5", Action: "updated", Source: "local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PublishState(t.Context(), State{IP: "203.0.113.10", Action: "nochg-cache"}); err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+
+	retained := b.Retained()
+	var state map[string]any
+	if err := json.Unmarshal(retained["dddns/home.example.com/state"], &state); err != nil {
+		t.Fatalf("state: %v", err)
+	}
+	if state["ip"] != "203.0.113.10" || state["action"] != "nochg-cache" || state["time"] != "2026-05-01T12:00:00Z" {
+		t.Errorf("state = %v", state)
+	}
+	if _, ok := state["requests"]; ok {
+		t.Error("cron-style publisher sent request stats")
+	}
+	var changed map[string]any
+	if err := json.Unmarshal(retained["dddns/home.example.com/changed"], &changed); err != nil {
+		t.Fatalf("changed: %v", err)
+	}
+	if changed["ip"] != "203.0.113.10" || changed["old_ip"] != "198.51.100.5" {
+		t.Errorf("changed = %v", changed)
+	}
+
+	var disc map[string]any
+	topic := "homeassistant/sensor/dddns_home_example_com/public_ip/config"
+	if err := json.Unmarshal(retained[topic], &disc); err != nil {
+		t.Fatalf("discovery %s: %v (topics %v)", topic, err, keys(retained))
+	}
+	if disc["state_topic"] != "dddns/home.example.com/state" || disc["unique_id"] != "dddns_home_example_com_public_ip" ||
+		disc["value_template"] != "{{ value_json.ip }}" {
+		t.Errorf("discovery = %v", disc)
+	}
+	if _, ok := retained["homeassistant/sensor/dddns_home_example_com/requests/config"]; ok {
+		t.Error("request sensors announced without stats")
+	}
+
+	// Discovery is announced once per publisher.
+	n := 0
+	for _, m := range b.Messages() {
+		if strings.HasSuffix(m.Topic, "/public_ip/config") {
+			n++
+		}
+		if m.QoS != 1 || !m.Retain {
+			t.Errorf("%s: qos %d retain %v", m.Topic, m.QoS, m.Retain)
+		}
+	}
+	if n != 1 || b.Connections() != 2 {
+		t.Errorf("discovery sent %d times over %d connections", n, b.Connections())
+	}
+}
+
+func TestPublisher_StatsAndPrefix(t *testing.T) {
+	b := mqtttest.NewBroker(t)
+	p := newTestPublisher(t, &config.MQTTConfig{Broker: b.URL(), TopicPrefix: "lab/dns/", Discovery: true, DiscoveryPrefix: "ha"})
+	p.SetStats(func() RequestStats { return RequestStats{Total: 7, Updated: 1, Rejected: 2} })
+	p.SetAsync(true)
+
+	p.Publish(t.Context(), State{Action: "failed", IP: "203.0.113.10", Error: "route53: throttled"})
+	p.Close()
+	b.Close()
+
+	retained := b.Retained()
+	var state State
+	if err := json.Unmarshal(retained["lab/dns/state"], &state); err != nil {
+		t.Fatalf("state: %v (topics %v)", err, keys(retained))
+	}
+	if state.Requests == nil || state.Requests.Total != 7 || state.Requests.Rejected != 2 || state.Error != "route53: throttled" {
+		t.Errorf("state = %+v", state)
+	}
+	if _, ok := retained["ha/sensor/dddns_home_example_com/requests/config"]; !ok {
+		t.Errorf("request sensor not announced: %v", keys(retained))
+	}
+}
+
+func TestPublisher_TLSWithCAFile(t *testing.T) {
+	b := mqtttest.NewTLSBroker(t)
+	p := newTestPublisher(t, &config.MQTTConfig{Broker: b.URL(), CAFile: b.CAFile(t)})
+	if err := p.PublishState(t.Context(), State{Action: "nochg-dns", IP: "203.0.113.10"}); err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+	if len(b.Messages()) != 1 {
+		t.Errorf("got %d messages over TLS", len(b.Messages()))
+	}
+
+	// Without the CA the self-signed broker is rejected.
+	untrusted := newTestPublisher(t, &config.MQTTConfig{Broker: mqtttest.NewTLSBroker(t).URL()})
+	if err := untrusted.PublishState(t.Context(), State{Action: "nochg-dns"}); err == nil {
+		t.Error("publish to an untrusted TLS broker succeeded")
+	}
+}
+
+func TestPublisher_NilAndUnreachable(t *testing.T) {
+	var p *Publisher
+	p.Publish(t.Context(), State{})
+	p.Close()
+	if got, err := NewPublisher(nil, "h", nil); got != nil || err != nil {
+		t.Errorf("NewPublisher(nil) = %v, %v", got, err)
+	}
+
+	b := mqtttest.NewBroker(t)
+	url := b.URL()
+	b.Close()
+	dead := newTestPublisher(t, &config.MQTTConfig{Broker: url, Timeout: "1s"})
+	if err := dead.PublishState(t.Context(), State{Action: "updated"}); err == nil {
+		t.Error("publish to a closed broker succeeded")
+	}
+}
+
+func keys(m map[string][]byte) []string {
+	var out []string
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
@@ -0,0 +1,441 @@
+package notify
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/descoped/dddns/internal/config"
+	"github.com/descoped/dddns/internal/logging"
+)
+
+const (
+	defaultFailureThreshold = 3
+	defaultMinInterval      = 15 * time.Minute
+)
+
+// defaultSMTPPorts maps EmailConfig.Security to the port used when none
+// is configured.
+var defaultSMTPPorts = map[string]int{"starttls": 587, "tls": 465, "none": 25}
+
+// EmailStatePath returns the file that carries the email alert flag and
+// rate limit across runs — always next to the IP cache.
+func EmailStatePath(cfg *config.Config) string {
+	return filepath.Join(filepath.Dir(cfg.IPCacheFile), "notify-email.json")
+}
+
+// emailState is what the mail gate remembers between events. Cron runs
+// are separate processes, so it is persisted when a state path is set.
+type emailState struct {
+	Alerted    bool      `json:"alerted"`              // failure mail sent for this streak
+	LastSent   time.Time `json:"last_sent,omitzero"`   // last mail, for min_interval
+	Suppressed int       `json:"suppressed,omitempty"` // mails dropped since LastSent
+}
+
+// mailer sends events to the configured recipients through SMTP.
+type mailer struct {
+	config.EmailConfig
+	addr        string // host:port
+	threshold   int
+	minInterval time.Duration
+
+	mu        sync.Mutex // serializes the gate and its state, not the send
+	statePath string     // "" = keep the state in memory
+	state     emailState
+}
+
+func newMailer(c config.EmailConfig) *mailer {
+	m := &mailer{
+		EmailConfig: c,
+		threshold:   c.FailureThreshold,
+		minInterval: config.DurationOrDefault(c.MinInterval, defaultMinInterval),
+	}
+	if m.Security == "" {
+		m.Security = "starttls"
+	}
+	if len(m.Events) == 0 {
+		m.Events = config.NotifyEvents
+	}
+	if m.threshold <= 0 {
+		m.threshold = defaultFailureThreshold
+	}
+	port := c.Port
+	if port == 0 {
+		port = defaultSMTPPorts[m.Security]
+	}
+	m.addr = net.JoinHostPort(c.Host, strconv.Itoa(port))
+	return m
+}
+
+// SetStatePath persists the email alert flag and rate limit in path
+// (see EmailStatePath) so they span cron runs. Without it they last for
+// the process only.
+func (n *Notifier) SetStatePath(path string) {
+	if n != nil && n.email != nil {
+		n.email.statePath = path
+	}
+}
+
+// mail runs e through the email gate and sends it if the gate allows.
+// It returns the delivery, or nil when nothing was sent. The gate's
+// lock covers only the check: a mail the gate lets through is recorded
+// as sent before the SMTP exchange, so a slow server delays no other
+// notification, and the record is rolled back if delivery fails.
+func (n *Notifier) mail(ctx context.Context, e Event) *Delivery {
+	m := n.email
+	now := n.now()
+	send, prev, suppressed := m.reserve(n.logger, e, now)
+	if !send {
+		return nil
+	}
+
+	msg, err := m.compose(e, suppressed, now)
+	attempts := 0
+	if err == nil {
+		attempts, err = n.withRetries(ctx, func() (bool, error) { return n.smtpSend(ctx, msg) })
+	}
+	attrs := []slog.Attr{
+		slog.String("smtp", m.addr),
+		slog.String("event", e.Kind),
+		slog.Int("attempts", attempts),
+	}
+	if err != nil {
+		n.logger.LogAttrs(ctx, slog.LevelWarn, "notification failed", append(attrs, logging.Err(err))...)
+		if e.Kind != EventTest {
+			m.unreserve(n.logger, prev, suppressed, now)
+		}
+	} else {
+		n.logger.LogAttrs(ctx, slog.LevelDebug, "notification sent", attrs...)
+	}
+	return &Delivery{Name: "email", Attempts: attempts, Err: err}
+}
+
+// reserve runs the gate for e and, when it lets e through, records the
+// mail as sent at now. It returns the state before that record and the
+// suppressed count the mail reports.
+func (m *mailer) reserve(logger *slog.Logger, e Event, now time.Time) (send bool, prev emailState, suppressed int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st := m.load(logger)
+	before := st
+	send = m.gate(&st, e, now)
+	prev, suppressed = st, st.Suppressed
+	if send && e.Kind != EventTest {
+		st.LastSent, st.Suppressed = now, 0
+		st.Alerted = st.Alerted || e.Kind == EventFailed
+	}
+	if st != before {
+		if err := m.save(st); err != nil {
+			logger.Warn("email state not saved", logging.Err(err))
+		}
+	}
+	return send, prev, suppressed
+}
+
+// unreserve undoes reserve after a failed send, unless a later mail has
+// been recorded since: the failure alert stays pending and the mails
+// this one reported count as suppressed again.
+func (m *mailer) unreserve(logger *slog.Logger, prev emailState, suppressed int, sentAt time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st := m.load(logger)
+	if !st.LastSent.Equal(sentAt) {
+		return
+	}
+	st.LastSent, st.Alerted = prev.LastSent, prev.Alerted
+	st.Suppressed += suppressed
+	if err := m.save(st); err != nil {
+		logger.Warn("email state not saved", logging.Err(err))
+	}
+}
+
+// gate updates st for e and reports whether e should be mailed now.
+// Failures are mailed once per streak: when the streak (e.Failures)
+// reaches the threshold, or at once when the updater escalated it. A
+// recovery is mailed only after a failure mail, and ends the streak.
+// Mails within min_interval of the previous one are counted as
+// suppressed instead. Test events bypass the gate.
+func (m *mailer) gate(st *emailState, e Event, now time.Time) bool {
+	switch e.Kind {
+	case EventTest:
+		return true
+	case EventFailed:
+		if st.Alerted || (!e.Escalated && e.Failures < m.threshold) || !slices.Contains(m.Events, EventFailed) {
+			return false
+		}
+	case EventRecovered:
+		alerted := st.Alerted
+		st.Alerted = false
+		if !alerted || !slices.Contains(m.Events, EventRecovered) {
+			return false
+		}
+	case EventUpdated:
+		if !slices.Contains(m.Events, EventUpdated) {
+			return false
+		}
+	default:
+		return false
+	}
+	if !st.LastSent.IsZero() && now.Sub(st.LastSent) < m.minInterval {
+		st.Suppressed++
+		return false
+	}
+	return true
+}
+
+// load returns the gate state, from the state file when one is set. A
+// missing or corrupt file starts from zero.
+func (m *mailer) load(logger *slog.Logger) emailState {
+	if m.statePath == "" {
+		return m.state
+	}
+	var st emailState
+	data, err := os.ReadFile(m.statePath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Warn("email state unreadable", logging.Err(err))
+		}
+		return st
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		logger.Warn("ignoring corrupt email state", slog.String("path", m.statePath), logging.Err(err))
+		return emailState{}
+	}
+	return st
+}
+
+// save records st in memory or atomically replaces the state file.
+func (m *mailer) save(st emailState) error {
+	if m.statePath == "" {
+		m.state = st
+		return nil
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(m.statePath), ".notify-email-*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), m.statePath); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// compose renders e as a plain-text message. suppressed mails since the
+// last one are mentioned at the end.
+func (m *mailer) compose(e Event, suppressed int, now time.Time) ([]byte, error) {
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address")
+	}
+	to := make([]string, len(m.To))
+	for i, addr := range m.To {
+		a, err := mail.ParseAddress(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %q", addr)
+		}
+		to[i] = a.String()
+	}
+	if e.Time.IsZero() {
+		e.Time = now.UTC()
+	}
+	id := make([]byte, 12)
+	_, _ = rand.Read(id)
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\n", from)
+	fmt.Fprintf(&b, "To: %s\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\n", mime.QEncoding.Encode("utf-8", e.Title()))
+	fmt.Fprintf(&b, "Date: %s\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\n", hex.EncodeToString(id), domain)
+	b.WriteString("Auto-Submitted: auto-generated\n")
+	b.WriteString("MIME-Version: 1.0\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\n\n")
+
+	b.WriteString(e.Text() + "\n\n")
+	for _, f := range [][2]string{
+		{"Hostname", e.Hostname},
+		{"New IP", e.NewIP},
+		{"Old IP", e.OldIP},
+		{"Source", e.Source},
+		{"Error", e.Error},
+		{"Time", e.Time.Format(time.RFC3339)},
+	} {
+		if f[1] != "" {
+			fmt.Fprintf(&b, "%-9s %s\n", f[0]+":", f[1])
+		}
+	}
+	if suppressed > 0 {
+		fmt.Fprintf(&b, "\n%d earlier notification(s) were not mailed because of notify.email.min_interval.\n", suppressed)
+	}
+	return []byte(b.String()), nil
+}
+
+// smtpSend makes one delivery attempt: connect, secure the session,
+// authenticate and transfer msg. retry reports whether a failure is
+// worth retrying (network errors and 4xx replies).
+func (n *Notifier) smtpSend(ctx context.Context, msg []byte) (retry bool, err error) {
+	m := n.email
+	ctx, cancel := context.WithTimeout(ctx, n.timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return true, err
+	}
+	defer func() { _ = conn.Close() }()
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+
+	tlsConfig := &tls.Config{ServerName: m.Host, MinVersion: tls.VersionTLS12}
+	if n.mailTLS != nil {
+		tlsConfig = n.mailTLS.Clone()
+		tlsConfig.ServerName = m.Host
+	}
+	if m.Security == "tls" {
+		tc := tls.Client(conn, tlsConfig)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return transient(err), err
+		}
+		conn = tc
+	}
+	c, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		return transient(err), err
+	}
+	defer func() { _ = c.Close() }()
+
+	if m.Security == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return false, errors.New("server does not offer STARTTLS")
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return transient(err), err
+		}
+	}
+	if m.Username != "" {
+		if err := c.Auth(m.smtpAuth(c)); err != nil {
+			return transient(err), fmt.Errorf("auth: %w", err)
+		}
+	}
+	from, _ := mail.ParseAddress(m.From)
+	if err := c.Mail(from.Address); err != nil {
+		return transient(err), err
+	}
+	for _, addr := range m.To {
+		to, _ := mail.ParseAddress(addr)
+		if err := c.Rcpt(to.Address); err != nil {
+			return transient(err), fmt.Errorf("recipient %s: %w", to.Address, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return transient(err), err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return true, err
+	}
+	if err := w.Close(); err != nil {
+		return transient(err), err
+	}
+	_ = c.Quit()
+	return false, nil
+}
+
+// smtpAuth returns the configured mechanism, or PLAIN unless the server
+// only advertises LOGIN.
+func (m *mailer) smtpAuth(c *smtp.Client) smtp.Auth {
+	mech := m.Auth
+	if mech == "" {
+		mech = "plain"
+		_, params := c.Extension("AUTH")
+		offered := strings.Fields(strings.ToUpper(params))
+		if !slices.Contains(offered, "PLAIN") && slices.Contains(offered, "LOGIN") {
+			mech = "login"
+		}
+	}
+	if mech == "login" {
+		return &loginAuth{username: m.Username, password: m.Password, host: m.Host}
+	}
+	return smtp.PlainAuth("", m.Username, m.Password, m.Host)
+}
+
+// loginAuth implements the LOGIN mechanism, which net/smtp lacks. Like
+// smtp.PlainAuth it refuses to send credentials over an unencrypted
+// connection to anything but localhost.
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && server.Name != "localhost" && server.Name != "127.0.0.1" && server.Name != "::1" {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSuffix(strings.TrimSpace(string(fromServer)), ":")) {
+	case "username", "user name":
+		return []byte(a.username), nil
+	case "password":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected LOGIN challenge %q", fromServer)
+}
+
+// transient reports whether an SMTP failure may succeed on retry: 4xx
+// replies and connection errors, but not 5xx replies or TLS failures.
+func transient(err error) bool {
+	var perr *textproto.Error
+	if errors.As(err, &perr) {
+		return perr.Code >= 400 && perr.Code < 500
+	}
+	var (
+		cerr *tls.CertificateVerificationError
+		rerr tls.RecordHeaderError
+	)
+	return !errors.As(err, &cerr) && !errors.As(err, &rerr)
+}
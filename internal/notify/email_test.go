@@ -0,0 +1,504 @@
+package notify
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/descoped/dddns/internal/config"
+)
+
+// smtpMessage is one mail the stand-in accepted.
+type smtpMessage struct {
+	from string
+	to   []string
+	data string
+	tls  bool
+	auth string // mechanism used, "" when unauthenticated
+}
+
+// smtpServer is a minimal SMTP stand-in: EHLO, STARTTLS, AUTH PLAIN and
+// LOGIN, MAIL, RCPT, DATA, RSET and QUIT. It records accepted messages.
+type smtpServer struct {
+	ln       net.Listener
+	tls      *tls.Config
+	implicit bool     // TLS from the first byte
+	noTLS    bool     // never offer STARTTLS
+	mechs    string   // advertised AUTH mechanisms
+	user     string   // required credentials when set
+	pass     string   //
+	failData []string // replies to the first DATA commands, e.g. "451 try later"
+
+	mu   sync.Mutex
+	msgs []smtpMessage
+	wg   sync.WaitGroup
+}
+
+// startSMTP starts a stand-in on 127.0.0.1 with a certificate valid for
+// that address. configure runs before it accepts connections.
+func startSMTP(t *testing.T, configure func(s *smtpServer)) *smtpServer {
+	t.Helper()
+	// Borrow httptest's certificate for 127.0.0.1.
+	hs := httptest.NewUnstartedServer(nil)
+	hs.StartTLS()
+	cert := hs.TLS.Certificates[0]
+	hs.Close()
+
+	s := &smtpServer{tls: &tls.Config{Certificates: []tls.Certificate{cert}}, mechs: "PLAIN LOGIN"}
+	if configure != nil {
+		configure(s)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.implicit {
+		ln = tls.NewListener(ln, s.tls)
+	}
+	s.ln = ln
+	s.wg.Go(func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.wg.Go(func() { s.session(c) })
+		}
+	})
+	t.Cleanup(func() {
+		_ = ln.Close()
+		s.wg.Wait()
+	})
+	return s
+}
+
+// trust returns a client TLS config that trusts the stand-in.
+func (s *smtpServer) trust() *tls.Config {
+	pool := x509.NewCertPool()
+	leaf, _ := x509.ParseCertificate(s.tls.Certificates[0].Certificate[0])
+	pool.AddCert(leaf)
+	return &tls.Config{RootCAs: pool}
+}
+
+func (s *smtpServer) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpServer) messages() []smtpMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]smtpMessage(nil), s.msgs...)
+}
+
+func (s *smtpServer) session(c net.Conn) {
+	defer func() { _ = c.Close() }()
+	_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+	_, secure := c.(*tls.Conn)
+	tp := textproto.NewConn(c)
+	_ = tp.PrintfLine("220 localhost ESMTP stand-in")
+
+	var cur smtpMessage
+	authed := s.user == ""
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			ext := []string{"localhost", "8BITMIME"}
+			if !secure && !s.noTLS {
+				ext = append(ext, "STARTTLS")
+			}
+			if s.mechs != "" {
+				ext = append(ext, "AUTH "+s.mechs)
+			}
+			for i, e := range ext {
+				sep := "-"
+				if i == len(ext)-1 {
+					sep = " "
+				}
+				_ = tp.PrintfLine("250%s%s", sep, e)
+			}
+		case "STARTTLS":
+			_ = tp.PrintfLine("220 go ahead")
+			tc := tls.Server(c, s.tls)
+			if tc.Handshake() != nil {
+				return
+			}
+			c, secure = tc, true
+			tp = textproto.NewConn(tc)
+		case "AUTH":
+			mech, initial, _ := strings.Cut(arg, " ")
+			var user, pass string
+			switch strings.ToUpper(mech) {
+			case "PLAIN":
+				raw, _ := base64.StdEncoding.DecodeString(initial)
+				parts := strings.Split(string(raw), "\x00")
+				if len(parts) == 3 {
+					user, pass = parts[1], parts[2]
+				}
+			case "LOGIN":
+				user = s.challenge(tp, "Username:")
+				pass = s.challenge(tp, "Password:")
+			default:
+				_ = tp.PrintfLine("504 unrecognized mechanism")
+				continue
+			}
+			if s.user != "" && (user != s.user || pass != s.pass) {
+				_ = tp.PrintfLine("535 authentication failed")
+				continue
+			}
+			authed = true
+			cur.auth = strings.ToUpper(mech)
+			_ = tp.PrintfLine("235 ok")
+		case "MAIL":
+			if !authed {
+				_ = tp.PrintfLine("530 authentication required")
+				continue
+			}
+			addr, _, _ := strings.Cut(strings.TrimPrefix(arg, "FROM:"), " ")
+			cur.from = strings.Trim(addr, "<>")
+			_ = tp.PrintfLine("250 ok")
+		case "RCPT":
+			cur.to = append(cur.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			_ = tp.PrintfLine("250 ok")
+		case "DATA":
+			s.mu.Lock()
+			var reply string
+			if len(s.failData) > 0 {
+				reply, s.failData = s.failData[0], s.failData[1:]
+			}
+			s.mu.Unlock()
+			if reply != "" {
+				_ = tp.PrintfLine("%s", reply)
+				continue
+			}
+			_ = tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			cur.data, cur.tls = string(data), secure
+			s.mu.Lock()
+			s.msgs = append(s.msgs, cur)
+			s.mu.Unlock()
+			cur = smtpMessage{auth: cur.auth}
+			_ = tp.PrintfLine("250 queued")
+		case "RSET", "NOOP":
+			_ = tp.PrintfLine("250 ok")
+		case "QUIT":
+			_ = tp.PrintfLine("221 bye")
+			return
+		default:
+			_ = tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+// challenge sends a LOGIN prompt and returns the decoded answer.
+func (s *smtpServer) challenge(tp *textproto.Conn, prompt string) string {
+	_ = tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(prompt)))
+	line, _ := tp.ReadLine()
+	raw, _ := base64.StdEncoding.DecodeString(line)
+	return string(raw)
+}
+
+// emailNotifier builds a test Notifier mailing through s.
+func emailNotifier(t *testing.T, s *smtpServer, e config.EmailConfig) *Notifier {
+	t.Helper()
+	e.Host, e.Port = "127.0.0.1", s.port()
+	if e.From == "" {
+		e.From = "dddns <dddns@example.com>"
+	}
+	if e.To == nil {
+		e.To = []string{"ops@example.com", "Oncall <oncall@example.com>"}
+	}
+	n := newTest(t, &config.NotifyConfig{Email: &e})
+	n.mailTLS = s.trust()
+	return n
+}
+
+func TestEmail_STARTTLSWithPlainAuth(t *testing.T) {
+	s := startSMTP(t, func(s *smtpServer) { s.user, s.pass = "dddns", "s3cret" })
+	n := emailNotifier(t, s, config.EmailConfig{Username: "dddns", Password: "s3cret"})
+
+	ds := n.Deliver(t.Context(), updated)
+	if len(ds) != 1 || ds[0].Name != "email" || ds[0].Err != nil {
+		t.Fatalf("deliveries = %+v", ds)
+	}
+	msgs := s.messages()
+	if len(msgs) != 1 {
+		t.Fatalf("got %d messages", len(msgs))
+	}
+	m := msgs[0]
+	if !m.tls || m.auth != "PLAIN" || m.from != "dddns@example.com" || len(m.to) != 2 || m.to[1] != "oncall@example.com" {
+		t.Errorf("envelope = %+v", m)
+	}
+	for _, want := range []string{
+		"Subject: dddns: home.example.com updated",
+		`To: <ops@example.com>, "Oncall" <oncall@example.com>`,
+		"Auto-Submitted: auto-generated",
+		"home.example.com now points to 203.0.113.10 (was 198.51.100.5)",
+		"New IP:   203.0.113.10",
+		"Source:   local",
+	} {
+		if !strings.Contains(m.data, want) {
+			t.Errorf("message lacks %q:\n%s", want, m.data)
+		}
+	}
+}
+
+func TestEmail_ImplicitTLSWithLoginAuth(t *testing.T) {
+	s := startSMTP(t, func(s *smtpServer) {
+		s.implicit, s.mechs = true, "LOGIN"
+		s.user, s.pass = "dddns", "s3cret"
+	})
+	n := emailNotifier(t, s, config.EmailConfig{Security: "tls", Username: "dddns", Password: "s3cret"})
+	ds := n.Deliver(t.Context(), Event{Kind: EventTest, Hostname: "home.example.com"})
+	if len(ds) != 1 || ds[0].Err != nil {
+		t.Fatalf("deliveries = %+v", ds)
+	}
+	if m := s.messages(); len(m) != 1 || !m[0].tls || m[0].auth != "LOGIN" {
+		t.Errorf("messages = %+v", m)
+	}
+}
+
+func TestEmail_FailuresAndSTARTTLSRequirement(t *testing.T) {
+	t.Run("wrong password is not retried", func(t *testing.T) {
+		s := startSMTP(t, func(s *smtpServer) { s.user, s.pass = "dddns", "s3cret" })
+		n := emailNotifier(t, s, config.EmailConfig{Username: "dddns", Password: "wrong", Auth: "login"})
+		ds := n.Deliver(t.Context(), updated)
+		if len(ds) != 1 || ds[0].Err == nil || ds[0].Attempts != 1 || !strings.Contains(ds[0].Err.Error(), "535") {
+			t.Errorf("deliveries = %+v", ds)
+		}
+	})
+	t.Run("4xx is retried", func(t *testing.T) {
+		s := startSMTP(t, func(s *smtpServer) { s.failData = []string{"451 try later"} })
+		n := emailNotifier(t, s, config.EmailConfig{})
+		ds := n.Deliver(t.Context(), updated)
+		if len(ds) != 1 || ds[0].Err != nil || ds[0].Attempts != 2 || len(s.messages()) != 1 {
+			t.Errorf("deliveries = %+v, messages %d", ds, len(s.messages()))
+		}
+	})
+	t.Run("untrusted certificate", func(t *testing.T) {
+		s := startSMTP(t, nil)
+		n := emailNotifier(t, s, config.EmailConfig{})
+		n.mailTLS = nil
+		ds := n.Deliver(t.Context(), updated)
+		if len(ds) != 1 || ds[0].Err == nil || ds[0].Attempts != 1 {
+			t.Errorf("deliveries = %+v", ds)
+		}
+	})
+	t.Run("nothing sent without STARTTLS", func(t *testing.T) {
+		s := startSMTP(t, func(s *smtpServer) { s.noTLS = true })
+		n := emailNotifier(t, s, config.EmailConfig{})
+		if ds := n.Deliver(t.Context(), updated); len(ds) != 1 || ds[0].Err == nil {
+			t.Errorf("deliveries = %+v", ds)
+		}
+		if len(s.messages()) != 0 {
+			t.Error("message sent without TLS")
+		}
+	})
+}
+
+// TestEmail_ThresholdAndRateLimit walks a failure streak, a recovery
+// and a flapping WAN through the gate with a persisted state file.
+func TestEmail_ThresholdAndRateLimit(t *testing.T) {
+	s := startSMTP(t, nil)
+	state := filepath.Join(t.TempDir(), "notify-email.json")
+	clock := time.Unix(1700000000, 0)
+	newNotifier := func() *Notifier {
+		// A fresh Notifier per run, as cron would build.
+		n := emailNotifier(t, s, config.EmailConfig{FailureThreshold: 2, MinInterval: "1h"})
+		n.now = func() time.Time { return clock }
+		n.SetStatePath(state)
+		return n
+	}
+	failed := func(n int) Event {
+		return Event{Kind: EventFailed, Hostname: "home.example.com", Error: "route53: AccessDenied", Failures: n}
+	}
+	step := func(e Event) []Delivery {
+		clock = clock.Add(10 * time.Minute)
+		return newNotifier().Deliver(t.Context(), e)
+	}
+
+	if ds := step(failed(1)); len(ds) != 0 {
+		t.Fatalf("first failure mailed: %+v", ds)
+	}
+	if ds := step(failed(2)); len(ds) != 1 || ds[0].Err != nil {
+		t.Fatalf("threshold failure not mailed: %+v", ds)
+	}
+	if ds := step(failed(3)); len(ds) != 0 {
+		t.Fatalf("streak mailed twice: %+v", ds)
+	}
+	// Within min_interval of the failure mail: suppressed, twice.
+	if ds := step(Event{Kind: EventRecovered, Hostname: "home.example.com", Failures: 3}); len(ds) != 0 {
+		t.Fatalf("rate limit ignored: %+v", ds)
+	}
+	step(updated)
+	clock = clock.Add(time.Hour)
+	if ds := step(updated); len(ds) != 1 {
+		t.Fatalf("update after min_interval not mailed: %+v", ds)
+	}
+	// A recovery without a failure mail before it stays quiet.
+	clock = clock.Add(time.Hour)
+	if ds := step(Event{Kind: EventRecovered, Hostname: "home.example.com", Failures: 1}); len(ds) != 0 {
+		t.Fatalf("recovery mailed without an alert: %+v", ds)
+	}
+
+	msgs := s.messages()
+	if len(msgs) != 2 {
+		t.Fatalf("got %d messages", len(msgs))
+	}
+	if !strings.Contains(msgs[0].data, "update failed") || !strings.Contains(msgs[0].data, "Error:    route53: AccessDenied") {
+		t.Errorf("failure mail:\n%s", msgs[0].data)
+	}
+	if !strings.Contains(msgs[1].data, "2 earlier notification(s) were not mailed") {
+		t.Errorf("suppressed count missing:\n%s", msgs[1].data)
+	}
+
+	raw, err := os.ReadFile(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st emailState
+	if err := json.Unmarshal(raw, &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Alerted || st.Suppressed != 0 || !st.LastSent.Equal(clock.Add(-70*time.Minute)) {
+		t.Errorf("state = %+v", st)
+	}
+}
+
+func TestEmail_EventsFilterAndWebhooksUnaffected(t *testing.T) {
+	s := startSMTP(t, nil)
+	var c capture
+	srv := c.server(t, nil)
+	n := newTest(t, &config.NotifyConfig{
+		Webhooks: []config.WebhookConfig{{URL: srv.URL}},
+		Email: &config.EmailConfig{
+			Host: "127.0.0.1", Port: s.port(), From: "dddns@example.com", To: []string{"ops@example.com"},
+			Events: []string{"failed"}, FailureThreshold: 5,
+		},
+	})
+	n.mailTLS = s.trust()
+
+	if ds := n.Deliver(t.Context(), updated); len(ds) != 1 || ds[0].Name == "email" {
+		t.Errorf("updated deliveries = %+v", ds)
+	}
+	if ds := n.Deliver(t.Context(), Event{Kind: EventRecovered, Failures: 1}); len(ds) != 0 {
+		t.Errorf("recovered event delivered: %+v", ds)
+	}
+	// An escalated failure is mailed below the threshold.
+	ds := n.Deliver(t.Context(), Event{Kind: EventFailed, Hostname: "h", Error: "boom", Failures: 1, Escalated: true})
+	if len(ds) != 1 || ds[0].Name != "email" || ds[0].Err != nil {
+		t.Errorf("failed deliveries = %+v", ds)
+	}
+	if len(c.reqs) != 1 || len(s.messages()) != 1 {
+		t.Errorf("webhook requests %d, mails %d", len(c.reqs), len(s.messages()))
+	}
+}
+
+func TestEmail_ComposeEncodesSubject(t *testing.T) {
+	m := newMailer(config.EmailConfig{Host: "h", From: "d@example.com", To: []string{"o@example.com"}})
+	msg, err := m.compose(Event{Kind: EventUpdated, Hostname: "bad\r\nBcc: x@example.com", NewIP: "203.0.113.10"}, 0, time.Unix(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := textproto.NewReader(bufio.NewReader(strings.NewReader(string(msg))))
+	h, err := r.ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Get("Bcc") != "" || !strings.HasPrefix(h.Get("Subject"), "=?utf-8?") {
+		t.Errorf("header injection: %v", h)
+	}
+	if m.addr != "h:"+strconv.Itoa(587) {
+		t.Errorf("default address = %s", m.addr)
+	}
+}
+
+// TestEmail_SlowServerDoesNotBlockOthers verifies the gate's lock is not
+// held across the SMTP exchange: a second notification reaches the
+// server while the first is still waiting for its greeting.
+func TestEmail_SlowServerDoesNotBlockOthers(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c // never greets
+		}
+	}()
+	n := newTest(t, &config.NotifyConfig{Email: &config.EmailConfig{
+		Host: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port,
+		From: "dddns@example.com", To: []string{"ops@example.com"},
+	}})
+
+	done := make(chan []Delivery, 2)
+	for range 2 {
+		go func() { done <- n.Deliver(t.Context(), Event{Kind: EventTest, Hostname: "home.example.com"}) }()
+	}
+	var conns []net.Conn
+	for range 2 {
+		select {
+		case c := <-accepted:
+			conns = append(conns, c)
+		case <-time.After(2 * time.Second):
+			t.Fatal("second notification waited for the first one's SMTP exchange")
+		}
+	}
+	_ = ln.Close()
+	for _, c := range conns {
+		_ = c.Close()
+	}
+	for range 2 {
+		<-done
+	}
+}
+
+// TestEmail_FailedSendKeepsAlertPending verifies a failure mail that
+// could not be delivered is attempted again on the next failure.
+func TestEmail_FailedSendKeepsAlertPending(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	_ = ln.Close() // connection refused from here on
+
+	n := newTest(t, &config.NotifyConfig{Email: &config.EmailConfig{
+		Host: "127.0.0.1", Port: port, From: "dddns@example.com", To: []string{"ops@example.com"},
+		FailureThreshold: 1,
+	}})
+	failed := Event{Kind: EventFailed, Hostname: "home.example.com", Error: "route53: AccessDenied", Failures: 1}
+	for i := range 2 {
+		ds := n.Deliver(t.Context(), failed)
+		if len(ds) != 1 || ds[0].Err == nil {
+			t.Fatalf("failure %d: deliveries = %+v, want one failed attempt", i+1, ds)
+		}
+	}
+	if st := n.email.state; st.Alerted || !st.LastSent.IsZero() {
+		t.Errorf("state after failed sends = %+v, want nothing recorded", st)
+	}
+}
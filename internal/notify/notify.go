@@ -0,0 +1,452 @@
+// Package notify delivers change notifications to webhooks — a generic
+// JSON POST, the Slack/Discord/Teams incoming-webhook shapes, ntfy and
+// Gotify — and by SMTP mail. Deliveries are retried with backoff and
+// bounded per attempt; webhook bodies are optionally signed with
+// HMAC-SHA256 so receivers can authenticate them.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/descoped/dddns/internal/config"
+	"github.com/descoped/dddns/internal/logging"
+	"github.com/descoped/dddns/internal/version"
+)
+
+// Event kinds. A webhook subscribes to any of EventUpdated, EventFailed
+// and EventRecovered; EventTest is sent to every target by
+// `dddns notify test`. EventRecovered reports the first successful run
+// after an escalated failure streak.
+const (
+	EventUpdated   = "updated"
+	EventFailed    = "failed"
+	EventRecovered = "recovered"
+	EventTest      = "test"
+)
+
+// Signature headers set when a webhook has a secret. The signature is
+// "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body)).
+const (
+	HeaderTimestamp = "X-Dddns-Timestamp"
+	HeaderSignature = "X-Dddns-Signature"
+)
+
+const (
+	defaultTimeout  = 10 * time.Second
+	defaultAttempts = 3
+	maxBackoff      = 30 * time.Second
+)
+
+// Event is what a notification reports. It is the generic format's
+// body and the data templates are rendered with.
+type Event struct {
+	Kind     string    `json:"event"`
+	Hostname string    `json:"hostname"`
+	OldIP    string    `json:"old_ip,omitempty"`
+	NewIP    string    `json:"new_ip,omitempty"`
+	Source   string    `json:"source,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Time     time.Time `json:"time"`
+
+	// Failures and Since describe the failure streak a failed or
+	// recovered event belongs to. Escalated marks the failure that
+	// crossed the configured escalation thresholds.
+	Failures  int       `json:"failures,omitempty"`
+	Since     time.Time `json:"since,omitzero"`
+	Escalated bool      `json:"escalated,omitempty"`
+}
+
+// Title is a one-line summary used as the message title.
+func (e Event) Title() string {
+	switch e.Kind {
+	case EventUpdated:
+		return "dddns: " + e.Hostname + " updated"
+	case EventFailed:
+		return "dddns: " + e.Hostname + " update failed"
+	case EventRecovered:
+		return "dddns: " + e.Hostname + " recovered"
+	}
+	return "dddns: test notification"
+}
+
+// Text is the default message body for the chat-style formats.
+func (e Event) Text() string {
+	switch e.Kind {
+	case EventUpdated:
+		if e.OldIP == "" {
+			return fmt.Sprintf("%s now points to %s", e.Hostname, e.NewIP)
+		}
+		return fmt.Sprintf("%s now points to %s (was %s)", e.Hostname, e.NewIP, e.OldIP)
+	case EventFailed:
+		if e.Failures > 1 {
+			return fmt.Sprintf("Updating %s failed %d times in a row: %s", e.Hostname, e.Failures, e.Error)
+		}
+		return fmt.Sprintf("Updating %s failed: %s", e.Hostname, e.Error)
+	case EventRecovered:
+		return fmt.Sprintf("%s is updating again after %d failed attempts", e.Hostname, e.Failures)
+	}
+	return fmt.Sprintf("Test notification from dddns for %s", e.Hostname)
+}
+
+// Delivery is the outcome of sending one event to one webhook.
+type Delivery struct {
+	Name     string
+	Attempts int
+	Err      error
+}
+
+// webhook is a configured endpoint with its parsed template.
+type webhook struct {
+	config.WebhookConfig
+	name string
+	tmpl *template.Template
+}
+
+// wants reports whether the webhook subscribes to kind. Test events go
+// to every webhook.
+func (w *webhook) wants(kind string) bool {
+	if kind == EventTest {
+		return true
+	}
+	if len(w.Events) == 0 {
+		return kind == EventUpdated
+	}
+	return slices.Contains(w.Events, kind)
+}
+
+// Notifier sends events to the configured webhooks. A nil *Notifier is
+// valid and sends nothing, so callers need not check whether
+// notifications are configured.
+type Notifier struct {
+	hooks    []*webhook
+	email    *mailer
+	timeout  time.Duration
+	attempts int
+	logger   *slog.Logger
+	async    bool
+	wg       sync.WaitGroup
+
+	// Hooks overridden in tests. Not part of the public API.
+	client  *http.Client
+	mailTLS *tls.Config // nil = system roots
+	sleep   func(ctx context.Context, d time.Duration) error
+	now     func() time.Time
+}
+
+// New builds a Notifier from c. It returns nil when c is nil or
+// configures neither webhooks nor email. logger receives delivery
+// outcomes (nil = slog.Default()).
+func New(c *config.NotifyConfig, logger *slog.Logger) (*Notifier, error) {
+	if c == nil || (len(c.Webhooks) == 0 && c.Email == nil) {
+		return nil, nil
+	}
+	n := &Notifier{
+		timeout:  config.DurationOrDefault(c.Timeout, defaultTimeout),
+		attempts: c.Attempts,
+		logger:   logging.OrDefault(logger),
+		client:   &http.Client{},
+		sleep:    sleepContext,
+		now:      time.Now,
+	}
+	if n.attempts <= 0 {
+		n.attempts = defaultAttempts
+	}
+	for i, wc := range c.Webhooks {
+		w := &webhook{WebhookConfig: wc, name: wc.Name}
+		if w.Format == "" {
+			w.Format = "generic"
+		}
+		if w.name == "" {
+			w.name = fmt.Sprintf("%s[%d]", w.Format, i)
+		}
+		if wc.Template != "" {
+			t, err := template.New(w.name).Funcs(templateFuncs).Parse(wc.Template)
+			if err != nil {
+				return nil, fmt.Errorf("notify.webhooks[%d].template: %w", i, err)
+			}
+			w.tmpl = t
+		}
+		n.hooks = append(n.hooks, w)
+	}
+	if c.Email != nil {
+		n.email = newMailer(*c.Email)
+	}
+	return n, nil
+}
+
+// SetAsync makes Notify return immediately and deliver in the
+// background; Close waits for outstanding deliveries. serve uses this
+// so a slow webhook never holds up the dyndns response.
+func (n *Notifier) SetAsync(async bool) {
+	if n != nil {
+		n.async = async
+	}
+}
+
+// Notify sends e to every webhook subscribed to its kind and logs the
+// outcomes. Failures are logged, never returned: a notification must
+// not fail the update it reports on.
+func (n *Notifier) Notify(ctx context.Context, e Event) {
+	if n == nil {
+		return
+	}
+	if n.async {
+		ctx = context.WithoutCancel(ctx)
+		n.wg.Go(func() { n.Deliver(ctx, e) })
+		return
+	}
+	n.Deliver(ctx, e)
+}
+
+// Deliver sends e to every subscribed webhook and, when the email
+// threshold and rate limit allow, by mail, concurrently. It returns one
+// Delivery per webhook, in configuration order, followed by the mail's
+// if one was sent.
+func (n *Notifier) Deliver(ctx context.Context, e Event) []Delivery {
+	if n == nil {
+		return nil
+	}
+	if e.Time.IsZero() {
+		e.Time = n.now().UTC()
+	}
+	var hooks []*webhook
+	for _, w := range n.hooks {
+		if w.wants(e.Kind) {
+			hooks = append(hooks, w)
+		}
+	}
+	out := make([]Delivery, len(hooks))
+	var (
+		wg     sync.WaitGroup
+		mailed *Delivery
+	)
+	if n.email != nil {
+		wg.Go(func() { mailed = n.mail(ctx, e) })
+	}
+	for i, w := range hooks {
+		wg.Go(func() {
+			attempts, err := n.send(ctx, w, e)
+			out[i] = Delivery{Name: w.name, Attempts: attempts, Err: err}
+			attrs := []slog.Attr{
+				slog.String("webhook", w.name),
+				slog.String("event", e.Kind),
+				slog.Int("attempts", attempts),
+			}
+			if err != nil {
+				n.logger.LogAttrs(ctx, slog.LevelWarn, "notification failed", append(attrs, logging.Err(err))...)
+			} else {
+				n.logger.LogAttrs(ctx, slog.LevelDebug, "notification sent", attrs...)
+			}
+		})
+	}
+	wg.Wait()
+	if mailed != nil {
+		out = append(out, *mailed)
+	}
+	return out
+}
+
+// Close waits for background deliveries started in async mode.
+func (n *Notifier) Close() {
+	if n != nil {
+		n.wg.Wait()
+	}
+}
+
+// send renders and POSTs e to w, retrying network errors, 429 and 5xx
+// with exponential backoff. It returns the number of attempts made.
+func (n *Notifier) send(ctx context.Context, w *webhook, e Event) (int, error) {
+	target, body, err := render(w, e)
+	if err != nil {
+		return 0, err
+	}
+	return n.withRetries(ctx, func() (bool, error) { return n.post(ctx, w, target, body) })
+}
+
+// withRetries calls try until it succeeds, fails permanently or runs
+// out of attempts, backing off exponentially in between. It returns the
+// number of attempts made.
+func (n *Notifier) withRetries(ctx context.Context, try func() (retry bool, err error)) (int, error) {
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		retry, err := try()
+		if err == nil || !retry || attempt >= n.attempts {
+			return attempt, err
+		}
+		if serr := n.sleep(ctx, backoff); serr != nil {
+			return attempt, err
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// post makes one delivery attempt. retry reports whether a failure is
+// worth retrying.
+func (n *Notifier) post(ctx context.Context, w *webhook, target string, body []byte) (retry bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, n.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return false, errors.New("invalid webhook URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "dddns/"+version.GetVersion())
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	switch {
+	case w.Format == "gotify":
+		req.Header.Set("X-Gotify-Key", w.Token)
+	case w.Token != "":
+		req.Header.Set("Authorization", "Bearer "+w.Token)
+	}
+	if w.Secret != "" {
+		ts := strconv.FormatInt(n.now().Unix(), 10)
+		req.Header.Set(HeaderTimestamp, ts)
+		req.Header.Set(HeaderSignature, Sign(w.Secret, ts, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// *url.Error repeats the URL, which for Slack, Discord and Teams
+		// is the credential. Report only the cause.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return ctx.Err() == nil || errors.Is(ctx.Err(), context.DeadlineExceeded), err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("HTTP %d", resp.StatusCode)
+}
+
+// Sign returns the X-Dddns-Signature value for body sent at timestamp
+// ts (Unix seconds) under secret. Receivers recompute it over the raw
+// body and compare with hmac.Equal.
+func Sign(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// templateFuncs are available to webhook templates. json quotes a
+// value for embedding in a JSON body: {"host": {{json .Hostname}}}.
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// render returns the URL to POST to and the JSON body for w's format.
+func render(w *webhook, e Event) (target string, body []byte, err error) {
+	var text string
+	if w.tmpl != nil {
+		var buf strings.Builder
+		if err := w.tmpl.Execute(&buf, e); err != nil {
+			return "", nil, fmt.Errorf("template: %w", err)
+		}
+		text = buf.String()
+	} else {
+		text = e.Text()
+	}
+
+	target = w.URL
+	var payload any
+	switch w.Format {
+	case "slack":
+		payload = map[string]string{"text": text}
+	case "discord":
+		payload = map[string]string{"content": text}
+	case "teams":
+		payload = map[string]string{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  e.Title(),
+			"title":    e.Title(),
+			"text":     text,
+		}
+	case "ntfy":
+		// ntfy's JSON API takes the topic in the body and is posted to
+		// the server root.
+		u, err := url.Parse(w.URL)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid ntfy URL")
+		}
+		p := strings.TrimSuffix(u.Path, "/")
+		topic := path.Base(p)
+		u.Path = strings.TrimSuffix(p, topic)
+		target = u.String()
+		priority, tags := 3, []string{"globe_with_meridians"}
+		if e.Kind == EventFailed {
+			priority, tags = 4, []string{"warning"}
+		}
+		payload = map[string]any{
+			"topic":    topic,
+			"title":    e.Title(),
+			"message":  text,
+			"priority": priority,
+			"tags":     tags,
+		}
+	case "gotify":
+		target = strings.TrimSuffix(w.URL, "/") + "/message"
+		priority := 5
+		if e.Kind == EventFailed {
+			priority = 8
+		}
+		payload = map[string]any{
+			"title":    e.Title(),
+			"message":  text,
+			"priority": priority,
+		}
+	default: // generic
+		if w.tmpl != nil {
+			if !json.Valid([]byte(text)) {
+				return "", nil, fmt.Errorf("template output is not valid JSON")
+			}
+			return target, []byte(text), nil
+		}
+		payload = e
+	}
+	body, err = json.Marshal(payload)
+	return target, body, err
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,287 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/descoped/dddns/internal/config"
+	"github.com/descoped/dddns/internal/logging"
+)
+
+// capture is a webhook receiver recording every request.
+type capture struct {
+	mu   sync.Mutex
+	reqs []captured
+}
+
+type captured struct {
+	path   string
+	header http.Header
+	body   []byte
+}
+
+func (c *capture) server(t *testing.T, status func(n int) int) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		c.mu.Lock()
+		c.reqs = append(c.reqs, captured{path: r.URL.Path, header: r.Header.Clone(), body: body})
+		n := len(c.reqs)
+		c.mu.Unlock()
+		if status != nil {
+			w.WriteHeader(status(n))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newTest builds a Notifier with instant backoff and a fixed clock.
+func newTest(t *testing.T, c *config.NotifyConfig) *Notifier {
+	t.Helper()
+	n, err := New(c, logging.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.sleep = func(context.Context, time.Duration) error { return nil }
+	n.now = func() time.Time { return time.Unix(1700000000, 0) }
+	return n
+}
+
+var updated = Event{Kind: EventUpdated, Hostname: "home.example.com", OldIP: "198.51.100.5", NewIP: "203.0.113.10", Source: "local"}
+
+func TestNew_NilWhenUnconfigured(t *testing.T) {
+	for _, c := range []*config.NotifyConfig{nil, {Timeout: "5s"}} {
+		n, err := New(c, nil)
+		if err != nil || n != nil {
+			t.Errorf("New(%+v) = %v, %v; want nil, nil", c, n, err)
+		}
+	}
+	// The nil Notifier is usable.
+	var n *Notifier
+	n.Notify(t.Context(), updated)
+	if got := n.Deliver(t.Context(), updated); got != nil {
+		t.Errorf("nil Deliver = %v", got)
+	}
+	n.Close()
+}
+
+func TestDeliver_Formats(t *testing.T) {
+	cases := []struct {
+		format, path string
+		check        func(t *testing.T, body map[string]any, h http.Header)
+	}{
+		{"generic", "/hook", func(t *testing.T, b map[string]any, _ http.Header) {
+			if b["event"] != "updated" || b["hostname"] != "home.example.com" || b["new_ip"] != "203.0.113.10" ||
+				b["old_ip"] != "198.51.100.5" || b["source"] != "local" || b["time"] != "2023-11-14T22:13:20Z" {
+				t.Errorf("generic body = %v", b)
+			}
+		}},
+		{"slack", "/hook", func(t *testing.T, b map[string]any, _ http.Header) {
+			if b["text"] != "home.example.com now points to 203.0.113.10 (was 198.51.100.5)" {
+				t.Errorf("slack body = %v", b)
+			}
+		}},
+		{"discord", "/hook", func(t *testing.T, b map[string]any, _ http.Header) {
+			if !strings.Contains(b["content"].(string), "203.0.113.10") {
+				t.Errorf("discord body = %v", b)
+			}
+		}},
+		{"teams", "/hook", func(t *testing.T, b map[string]any, _ http.Header) {
+			if b["@type"] != "MessageCard" || b["title"] != "dddns: home.example.com updated" {
+				t.Errorf("teams body = %v", b)
+			}
+		}},
+		{"ntfy", "/", func(t *testing.T, b map[string]any, h http.Header) {
+			if b["topic"] != "hook" || b["priority"] != float64(3) || h.Get("Authorization") != "Bearer tk" {
+				t.Errorf("ntfy body = %v, auth %q", b, h.Get("Authorization"))
+			}
+		}},
+		{"gotify", "/hook/message", func(t *testing.T, b map[string]any, h http.Header) {
+			if b["title"] != "dddns: home.example.com updated" || h.Get("X-Gotify-Key") != "tk" {
+				t.Errorf("gotify body = %v, key %q", b, h.Get("X-Gotify-Key"))
+			}
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.format, func(t *testing.T) {
+			rx := &capture{}
+			srv := rx.server(t, nil)
+			n := newTest(t, &config.NotifyConfig{Webhooks: []config.WebhookConfig{
+				{Format: c.format, URL: srv.URL + "/hook", Token: "tk"},
+			}})
+			got := n.Deliver(t.Context(), updated)
+			if len(got) != 1 || got[0].Err != nil || got[0].Attempts != 1 {
+				t.Fatalf("Deliver = %+v", got)
+			}
+			if len(rx.reqs) != 1 {
+				t.Fatalf("got %d requests", len(rx.reqs))
+			}
+			r := rx.reqs[0]
+			if r.path != c.path {
+				t.Errorf("path = %q, want %q", r.path, c.path)
+			}
+			if ct := r.header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			var body map[string]any
+			if err := json.Unmarshal(r.body, &body); err != nil {
+				t.Fatalf("body is not JSON: %v: %s", err, r.body)
+			}
+			c.check(t, body, r.header)
+		})
+	}
+}
+
+func TestDeliver_EventFilter(t *testing.T) {
+	rx := &capture{}
+	srv := rx.server(t, nil)
+	n := newTest(t, &config.NotifyConfig{Webhooks: []config.WebhookConfig{
+		{Name: "changes", URL: srv.URL},
+		{Name: "alerts", URL: srv.URL, Events: []string{"failed"}},
+	}})
+
+	if got := n.Deliver(t.Context(), updated); len(got) != 1 || got[0].Name != "changes" {
+		t.Errorf("updated went to %+v", got)
+	}
+	if got := n.Deliver(t.Context(), Event{Kind: EventFailed, Hostname: "h", Error: "boom"}); len(got) != 1 || got[0].Name != "alerts" {
+		t.Errorf("failed went to %+v", got)
+	}
+	if got := n.Deliver(t.Context(), Event{Kind: EventTest, Hostname: "h"}); len(got) != 2 {
+		t.Errorf("test went to %+v", got)
+	}
+}
+
+func TestDeliver_Signature(t *testing.T) {
+	rx := &capture{}
+	srv := rx.server(t, nil)
+	n := newTest(t, &config.NotifyConfig{Webhooks: []config.WebhookConfig{
+		{URL: srv.URL, Secret: "hook-secret", Headers: map[string]string{"X-Env": "home"}},
+	}})
+	n.Deliver(t.Context(), updated)
+
+	r := rx.reqs[0]
+	ts := r.header.Get(HeaderTimestamp)
+	if ts != "1700000000" {
+		t.Errorf("timestamp = %q", ts)
+	}
+	if got, want := r.header.Get(HeaderSignature), Sign("hook-secret", ts, r.body); got != want || !strings.HasPrefix(got, "sha256=") {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+	if Sign("other", ts, r.body) == r.header.Get(HeaderSignature) {
+		t.Error("signature does not depend on the secret")
+	}
+	if r.header.Get("X-Env") != "home" {
+		t.Errorf("custom header missing: %v", r.header)
+	}
+}
+
+func TestDeliver_RetriesTransientFailures(t *testing.T) {
+	rx := &capture{}
+	srv := rx.server(t, func(n int) int {
+		if n < 3 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusNoContent
+	})
+	n := newTest(t, &config.NotifyConfig{Attempts: 3, Webhooks: []config.WebhookConfig{{URL: srv.URL}}})
+	var slept []time.Duration
+	n.sleep = func(_ context.Context, d time.Duration) error { slept = append(slept, d); return nil }
+
+	got := n.Deliver(t.Context(), updated)
+	if got[0].Err != nil || got[0].Attempts != 3 {
+		t.Errorf("Deliver = %+v, want success on attempt 3", got)
+	}
+	if len(slept) != 2 || slept[0] != time.Second || slept[1] != 2*time.Second {
+		t.Errorf("backoff = %v", slept)
+	}
+}
+
+func TestDeliver_NoRetryOnClientError(t *testing.T) {
+	rx := &capture{}
+	srv := rx.server(t, func(int) int { return http.StatusNotFound })
+	n := newTest(t, &config.NotifyConfig{Webhooks: []config.WebhookConfig{{URL: srv.URL}}})
+
+	got := n.Deliver(t.Context(), updated)
+	if got[0].Err == nil || got[0].Attempts != 1 || !strings.Contains(got[0].Err.Error(), "404") {
+		t.Errorf("Deliver = %+v, want one failed attempt", got)
+	}
+}
+
+func TestDeliver_TimeoutAndNoURLInError(t *testing.T) {
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { <-block }))
+	t.Cleanup(func() { close(block); srv.Close() })
+	n := newTest(t, &config.NotifyConfig{Timeout: "50ms", Attempts: 2, Webhooks: []config.WebhookConfig{
+		{Format: "slack", URL: srv.URL + "/services/T0/B0/credential"},
+	}})
+
+	got := n.Deliver(t.Context(), updated)
+	if got[0].Err == nil || got[0].Attempts != 2 {
+		t.Fatalf("Deliver = %+v, want two timed-out attempts", got)
+	}
+	if strings.Contains(got[0].Err.Error(), "credential") {
+		t.Errorf("error leaks the webhook URL: %v", got[0].Err)
+	}
+}
+
+func TestDeliver_Template(t *testing.T) {
+	rx := &capture{}
+	srv := rx.server(t, nil)
+	n := newTest(t, &config.NotifyConfig{Webhooks: []config.WebhookConfig{
+		{Name: "json", URL: srv.URL, Template: `{"host": {{json .Hostname}}, "ip": {{json .NewIP}}}`},
+		{Name: "chat", Format: "slack", URL: srv.URL, Template: `{{.Hostname}} → {{.NewIP}}`},
+		{Name: "broken", URL: srv.URL, Template: `not json {{.Hostname}}`},
+	}})
+
+	got := n.Deliver(t.Context(), updated)
+	if got[0].Err != nil || got[1].Err != nil {
+		t.Fatalf("Deliver = %+v", got)
+	}
+	if got[2].Err == nil || got[2].Attempts != 0 {
+		t.Errorf("non-JSON generic template should fail before sending: %+v", got[2])
+	}
+	if len(rx.reqs) != 2 {
+		t.Fatalf("got %d requests, want 2", len(rx.reqs))
+	}
+	bodies := map[string]bool{}
+	for _, r := range rx.reqs {
+		bodies[string(r.body)] = true
+	}
+	if !bodies[`{"host": "home.example.com", "ip": "203.0.113.10"}`] || !bodies[`{"text":"home.example.com → 203.0.113.10"}`] {
+		t.Errorf("bodies = %v", bodies)
+	}
+
+	if _, err := New(&config.NotifyConfig{Webhooks: []config.WebhookConfig{{URL: srv.URL, Template: "{{"}}}, nil); err == nil {
+		t.Error("New accepted an unparsable template")
+	}
+}
+
+func TestNotify_AsyncCloseWaits(t *testing.T) {
+	var hits atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		<-release
+		hits.Add(1)
+	}))
+	t.Cleanup(srv.Close)
+	n := newTest(t, &config.NotifyConfig{Webhooks: []config.WebhookConfig{{URL: srv.URL}}})
+	n.SetAsync(true)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	n.Notify(ctx, updated) // returns before the receiver answers
+	cancel()               // the caller's context ending must not abort delivery
+	close(release)
+	n.Close()
+	if hits.Load() != 1 {
+		t.Errorf("hits = %d, want 1", hits.Load())
+	}
+}
@@ -0,0 +1,238 @@
+// Package reqsign implements the HMAC-SHA256 request-signing scheme
+// accepted by serve mode and the Lambda handler as an alternative to
+// Basic Auth. The shared secret never crosses the wire; each request
+// carries a signature over its method, path, query, a timestamp and a
+// single-use nonce:
+//
+//	Authorization: DDDNS-HMAC-SHA256 ts=1713355200, nonce=9f2c…, sig=4b1e…
+//
+// The string to sign is the scheme name, method, path, canonical query
+// (url.Values.Encode order), timestamp and nonce, joined by "\n".
+package reqsign
+
+import (
+	"container/list"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scheme is the Authorization-header scheme name.
+const Scheme = "DDDNS-HMAC-SHA256"
+
+// DefaultWindow is the accepted clock skew either side of the verifier's
+// clock. A nonce is remembered until its timestamp leaves the window.
+const DefaultWindow = 5 * time.Minute
+
+// DefaultMaxNonces bounds the replay cache. At inadyn's push rate this
+// is weeks of traffic; see Verifier for what happens when it fills.
+const DefaultMaxNonces = 4096
+
+// Verification errors. Callers treat all of them as an auth failure;
+// the distinction is for the audit log.
+var (
+	ErrMalformed    = errors.New("malformed signature header")
+	ErrExpired      = errors.New("signature timestamp outside replay window")
+	ErrReplay       = errors.New("nonce already used")
+	ErrBadSignature = errors.New("signature mismatch")
+)
+
+// IsSigned reports whether an Authorization header value uses Scheme.
+func IsSigned(authorization string) bool {
+	scheme, _, _ := strings.Cut(authorization, " ")
+	return strings.EqualFold(scheme, Scheme)
+}
+
+// stringToSign builds the canonical string covered by the signature.
+func stringToSign(method, path string, query url.Values, ts int64, nonce string) string {
+	return strings.Join([]string{
+		Scheme,
+		strings.ToUpper(method),
+		path,
+		query.Encode(),
+		strconv.FormatInt(ts, 10),
+		nonce,
+	}, "\n")
+}
+
+func mac(secret, msg string) []byte {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(msg))
+	return m.Sum(nil)
+}
+
+// Header returns the Authorization header value for the given request
+// components.
+func Header(secret, method, path string, query url.Values, ts time.Time, nonce string) string {
+	sig := mac(secret, stringToSign(method, path, query, ts.Unix(), nonce))
+	return fmt.Sprintf("%s ts=%d, nonce=%s, sig=%s", Scheme, ts.Unix(), nonce, hex.EncodeToString(sig))
+}
+
+// SignRequest sets req's Authorization header using the current time and
+// a fresh random nonce.
+func SignRequest(req *http.Request, secret string) error {
+	nonce, err := NewNonce()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", Header(secret, req.Method, req.URL.Path, req.URL.Query(), time.Now(), nonce))
+	return nil
+}
+
+// NewNonce returns 128 random bits, hex-encoded.
+func NewNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate nonce: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// parsed is a decoded Authorization header.
+type parsed struct {
+	ts    int64
+	nonce string
+	sig   []byte
+}
+
+func parse(authorization string) (parsed, error) {
+	var p parsed
+	if !IsSigned(authorization) {
+		return p, ErrMalformed
+	}
+	_, params, _ := strings.Cut(authorization, " ")
+	var haveTS bool
+	for _, kv := range strings.Split(params, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		if !ok {
+			return p, ErrMalformed
+		}
+		switch k {
+		case "ts":
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return p, ErrMalformed
+			}
+			p.ts, haveTS = n, true
+		case "nonce":
+			p.nonce = v
+		case "sig":
+			b, err := hex.DecodeString(v)
+			if err != nil {
+				return p, ErrMalformed
+			}
+			p.sig = b
+		}
+	}
+	if !haveTS || len(p.nonce) < 16 || len(p.nonce) > 64 || len(p.sig) != sha256.Size {
+		return p, ErrMalformed
+	}
+	return p, nil
+}
+
+// Verifier checks signatures and remembers nonces for the replay window.
+// The zero value is not usable — construct with NewVerifier. Safe for
+// concurrent use.
+//
+// The nonce cache is bounded. When it is full the oldest nonce is
+// evicted and its timestamp becomes a floor: signatures at or before
+// the floor are rejected as possible replays. Eviction therefore never
+// reopens a replay window; at worst a client with a lagging clock is
+// refused until the floor ages out.
+type Verifier struct {
+	window    time.Duration
+	maxNonces int
+
+	mu    sync.Mutex
+	seen  map[string]*list.Element
+	order *list.List // front = oldest; Value is nonceEntry
+	floor int64
+	now   func() time.Time
+}
+
+type nonceEntry struct {
+	nonce string
+	ts    int64
+}
+
+// NewVerifier returns a Verifier accepting timestamps within window of
+// its clock and remembering at most maxNonces nonces. Non-positive
+// arguments select DefaultWindow / DefaultMaxNonces.
+func NewVerifier(window time.Duration, maxNonces int) *Verifier {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	if maxNonces <= 0 {
+		maxNonces = DefaultMaxNonces
+	}
+	return &Verifier{
+		window:    window,
+		maxNonces: maxNonces,
+		seen:      make(map[string]*list.Element),
+		order:     list.New(),
+		now:       time.Now,
+	}
+}
+
+// Verify checks authorization against secret and the request components.
+// The nonce is recorded only once the signature is valid, so forged
+// requests cannot fill the cache.
+func (v *Verifier) Verify(authorization, secret, method, path string, query url.Values) error {
+	p, err := parse(authorization)
+	if err != nil {
+		return err
+	}
+	now := v.now()
+	skew := now.Unix() - p.ts
+	if skew < 0 {
+		skew = -skew
+	}
+	if time.Duration(skew)*time.Second > v.window {
+		return ErrExpired
+	}
+	want := mac(secret, stringToSign(method, path, query, p.ts, p.nonce))
+	if !hmac.Equal(p.sig, want) {
+		return ErrBadSignature
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.expire(now)
+	if p.ts <= v.floor {
+		return ErrReplay
+	}
+	if _, dup := v.seen[p.nonce]; dup {
+		return ErrReplay
+	}
+	v.seen[p.nonce] = v.order.PushBack(nonceEntry{nonce: p.nonce, ts: p.ts})
+	for v.order.Len() > v.maxNonces {
+		oldest := v.order.Front()
+		e := v.order.Remove(oldest).(nonceEntry)
+		delete(v.seen, e.nonce)
+		v.floor = max(v.floor, e.ts)
+	}
+	return nil
+}
+
+// expire drops nonces whose timestamp can no longer pass the window
+// check. Caller must hold v.mu.
+func (v *Verifier) expire(now time.Time) {
+	cutoff := now.Add(-v.window).Unix()
+	for e := v.order.Front(); e != nil; {
+		next := e.Next()
+		if ne := e.Value.(nonceEntry); ne.ts < cutoff {
+			v.order.Remove(e)
+			delete(v.seen, ne.nonce)
+		}
+		e = next
+	}
+}
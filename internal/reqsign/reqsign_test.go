@@ -0,0 +1,148 @@
+package reqsign
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "correct-horse-battery-staple"
+
+var testNow = time.Date(2026, 4, 17, 12, 0, 0, 0, time.UTC)
+
+func newTestVerifier(maxNonces int) *Verifier {
+	v := NewVerifier(time.Minute, maxNonces)
+	v.now = func() time.Time { return testNow }
+	return v
+}
+
+func testQuery() url.Values {
+	return url.Values{"hostname": {"home.example.com"}, "myip": {"203.0.113.42"}}
+}
+
+func TestVerify_RoundTrip(t *testing.T) {
+	v := newTestVerifier(0)
+	h := Header(testSecret, "GET", "/nic/update", testQuery(), testNow, "0123456789abcdef0123456789abcdef")
+	if err := v.Verify(h, testSecret, "GET", "/nic/update", testQuery()); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+}
+
+// TestVerify_Rejections tampers with one signed component per case.
+func TestVerify_Rejections(t *testing.T) {
+	nonce := "0123456789abcdef0123456789abcdef"
+	good := Header(testSecret, "GET", "/nic/update", testQuery(), testNow, nonce)
+	otherQuery := testQuery()
+	otherQuery.Set("hostname", "evil.example.com")
+
+	cases := []struct {
+		name   string
+		header string
+		secret string
+		method string
+		path   string
+		query  url.Values
+		want   error
+	}{
+		{"wrong secret", good, "other", "GET", "/nic/update", testQuery(), ErrBadSignature},
+		{"method changed", good, testSecret, "POST", "/nic/update", testQuery(), ErrBadSignature},
+		{"path changed", good, testSecret, "GET", "/nic/other", testQuery(), ErrBadSignature},
+		{"query changed", good, testSecret, "GET", "/nic/update", otherQuery, ErrBadSignature},
+		{"too old", Header(testSecret, "GET", "/nic/update", testQuery(), testNow.Add(-2*time.Minute), nonce),
+			testSecret, "GET", "/nic/update", testQuery(), ErrExpired},
+		{"too far ahead", Header(testSecret, "GET", "/nic/update", testQuery(), testNow.Add(2*time.Minute), nonce),
+			testSecret, "GET", "/nic/update", testQuery(), ErrExpired},
+		{"basic header", "Basic ZGRkbnM6c2VjcmV0", testSecret, "GET", "/nic/update", testQuery(), ErrMalformed},
+		{"short nonce", Header(testSecret, "GET", "/nic/update", testQuery(), testNow, "abc"),
+			testSecret, "GET", "/nic/update", testQuery(), ErrMalformed},
+		{"missing sig", Scheme + " ts=1, nonce=" + nonce, testSecret, "GET", "/nic/update", testQuery(), ErrMalformed},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := newTestVerifier(0).Verify(tc.header, tc.secret, tc.method, tc.path, tc.query)
+			if !errors.Is(err, tc.want) {
+				t.Errorf("Verify = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestVerify_ReplayRejected(t *testing.T) {
+	v := newTestVerifier(0)
+	h := Header(testSecret, "GET", "/nic/update", testQuery(), testNow, "0123456789abcdef0123456789abcdef")
+	if err := v.Verify(h, testSecret, "GET", "/nic/update", testQuery()); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Verify(h, testSecret, "GET", "/nic/update", testQuery()); !errors.Is(err, ErrReplay) {
+		t.Errorf("second Verify = %v, want ErrReplay", err)
+	}
+}
+
+// TestVerify_ForgedDoesNotConsumeNonce: a bad signature must not burn
+// the nonce, or an attacker could pre-empt a legitimate request.
+func TestVerify_ForgedDoesNotConsumeNonce(t *testing.T) {
+	v := newTestVerifier(0)
+	nonce := "0123456789abcdef0123456789abcdef"
+	forged := Header("guess", "GET", "/nic/update", testQuery(), testNow, nonce)
+	if err := v.Verify(forged, testSecret, "GET", "/nic/update", testQuery()); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("forged Verify = %v", err)
+	}
+	real := Header(testSecret, "GET", "/nic/update", testQuery(), testNow, nonce)
+	if err := v.Verify(real, testSecret, "GET", "/nic/update", testQuery()); err != nil {
+		t.Errorf("legitimate Verify after forgery = %v", err)
+	}
+}
+
+// TestVerify_EvictionRaisesFloor fills a tiny cache and checks that an
+// evicted nonce still cannot be replayed.
+func TestVerify_EvictionRaisesFloor(t *testing.T) {
+	v := newTestVerifier(2)
+	headers := make([]string, 3)
+	for i := range headers {
+		ts := testNow.Add(time.Duration(i-3) * time.Second)
+		headers[i] = Header(testSecret, "GET", "/nic/update", testQuery(), ts, fmt.Sprintf("%032d", i))
+		if err := v.Verify(headers[i], testSecret, "GET", "/nic/update", testQuery()); err != nil {
+			t.Fatalf("Verify %d: %v", i, err)
+		}
+	}
+	if len(v.seen) != 2 {
+		t.Errorf("cache size = %d, want 2", len(v.seen))
+	}
+	if err := v.Verify(headers[0], testSecret, "GET", "/nic/update", testQuery()); !errors.Is(err, ErrReplay) {
+		t.Errorf("replay of evicted nonce = %v, want ErrReplay", err)
+	}
+}
+
+func TestVerify_ExpiredNoncesPruned(t *testing.T) {
+	v := newTestVerifier(0)
+	h := Header(testSecret, "GET", "/nic/update", testQuery(), testNow, "0123456789abcdef0123456789abcdef")
+	if err := v.Verify(h, testSecret, "GET", "/nic/update", testQuery()); err != nil {
+		t.Fatal(err)
+	}
+	v.now = func() time.Time { return testNow.Add(2 * time.Minute) }
+	h2 := Header(testSecret, "GET", "/nic/update", testQuery(), testNow.Add(2*time.Minute), "fedcba9876543210fedcba9876543210")
+	if err := v.Verify(h2, testSecret, "GET", "/nic/update", testQuery()); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.seen) != 1 {
+		t.Errorf("cache size = %d after expiry, want 1", len(v.seen))
+	}
+}
+
+func TestSignRequest(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1:53353/nic/update?hostname=home.example.com&myip=1.2.3.4", nil)
+	if err := SignRequest(req, testSecret); err != nil {
+		t.Fatal(err)
+	}
+	authz := req.Header.Get("Authorization")
+	if !IsSigned(authz) || !strings.Contains(authz, "nonce=") {
+		t.Fatalf("Authorization = %q", authz)
+	}
+	if err := NewVerifier(0, 0).Verify(authz, testSecret, req.Method, req.URL.Path, req.URL.Query()); err != nil {
+		t.Errorf("Verify(SignRequest) = %v", err)
+	}
+}
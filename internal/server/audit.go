@@ -1,85 +1,246 @@
 package server
 
 import (
-	"encoding/json"
 	"fmt"
+	"log/slog"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/descoped/dddns/internal/hooks"
+	"github.com/descoped/dddns/internal/logging"
+	"github.com/descoped/dddns/internal/logsink"
 )
 
 // AuditMaxSize is the default rotation threshold for the audit log —
-// when the file reaches this size it is renamed to path+".old" before
-// the next write appends to a fresh file. Matches the operational log
-// policy in scripts/install-on-unifi-os.sh.
+// when the file reaches this size it becomes generation 1 (path.1.gz)
+// before the next write appends to a fresh file. Matches the
+// operational log policy in scripts/install-on-unifi-os.sh.
 const AuditMaxSize int64 = 10 * 1024 * 1024
 
+// AuditMaxFiles is the default number of rotated generations kept.
+const AuditMaxFiles = 5
+
+// auditSinkQueue is how many entries may wait for a slow audit sink
+// before further ones are dropped from the sinks (never from the file).
+const auditSinkQueue = 256
+
 // AuditEntry is one line of the JSONL audit log. The handler fills in
 // the relevant fields for the request it just processed; omitted fields
 // are elided from the serialized form.
+//
+// Seq, Prev, Hash and MAC form the tamper-evidence chain and are set by
+// AuditLog.Write — see audit_chain.go.
 type AuditEntry struct {
 	Timestamp       time.Time `json:"ts"`
 	RemoteAddr      string    `json:"remote"`
+	Peer            string    `json:"peer,omitempty"` // TCP peer, set only when a trusted proxy relayed the request
 	Hostname        string    `json:"hostname,omitempty"`
 	MyIPClaimed     string    `json:"myip_claimed,omitempty"`
 	MyIPVerified    string    `json:"myip_verified,omitempty"`
+	AuthScheme      string    `json:"auth_scheme,omitempty"` // "basic" or "hmac"
 	AuthOutcome     string    `json:"auth,omitempty"`
 	Action          string    `json:"action,omitempty"`
 	Route53ChangeID string    `json:"route53_change_id,omitempty"`
 	Err             string    `json:"error,omitempty"`
+
+	// Hooks records the hook commands the update ran, with their
+	// captured output.
+	Hooks []hooks.Run `json:"hooks,omitempty"`
+
+	Seq  uint64 `json:"seq,omitempty"`
+	Prev string `json:"prev,omitempty"`
+	Hash string `json:"hash,omitempty"`
+	MAC  string `json:"mac,omitempty"`
 }
 
 // AuditLog is an append-only JSONL writer with size-based rotation. All
-// writes are serialized under a mutex; the on-disk append is a single
-// os.File.Write on O_APPEND-opened FD, which is atomic for typical audit
-// line sizes (well below PIPE_BUF).
+// writes are serialized under a mutex within the process and an
+// exclusive lock on path+".lock" across processes; the on-disk append
+// is a single os.File.Write on O_APPEND-opened FD, which is atomic for
+// typical audit line sizes (well below PIPE_BUF).
 type AuditLog struct {
-	path    string
-	maxSize int64
+	path     string
+	maxSize  int64
+	maxFiles int
+	maxAge   time.Duration  // 0 = rotated generations never expire
+	key      []byte         // optional HMAC key for the chain; nil = hash only
+	sinks    *logsink.Async // optional syslog/journald copies; nil = file only
 
-	mu  sync.Mutex
-	now func() time.Time // injectable for tests
+	mu     sync.Mutex
+	pruned bool             // age-based pruning has run at least once
+	now    func() time.Time // injectable for tests
 }
 
 // NewAuditLog constructs an AuditLog writing to path with the default
-// rotation threshold.
+// rotation policy.
 func NewAuditLog(path string) *AuditLog {
 	return &AuditLog{
-		path:    path,
-		maxSize: AuditMaxSize,
-		now:     time.Now,
+		path:     path,
+		maxSize:  AuditMaxSize,
+		maxFiles: AuditMaxFiles,
+		now:      time.Now,
+	}
+}
+
+// SetRotation overrides the rotation policy. Zero fields keep the
+// current value, except maxAge where zero means no age limit.
+func (a *AuditLog) SetRotation(maxSize int64, maxFiles int, maxAge time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if maxSize > 0 {
+		a.maxSize = maxSize
+	}
+	if maxFiles > 0 {
+		a.maxFiles = maxFiles
 	}
+	a.maxAge = maxAge
+}
+
+// SetHMACKey enables the keyed MAC on every subsequent entry. An empty
+// key disables it.
+func (a *AuditLog) SetHMACKey(key []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.key = key
+}
+
+// SetSinks forwards every subsequent entry to s after it is appended
+// to the file. Delivery runs in the background through a bounded queue,
+// so a stalled collector delays neither the request nor other processes
+// waiting for the file lock; delivery failures and dropped entries are
+// logged.
+func (a *AuditLog) SetSinks(s logsink.Sink) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sinks = logsink.NewAsync(s, auditSinkQueue, func(err error) {
+		slog.Default().Warn("audit sink write failed", logging.Err(err))
+	})
+}
+
+// Close delivers the entries still queued for the sinks (for a few
+// seconds at most) and releases them. The file itself is opened per
+// write and needs no closing.
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.sinks == nil {
+		return nil
+	}
+	return a.sinks.Close()
 }
 
 // Write serializes entry as one JSON line and appends it to the log,
 // rotating first if the file has reached the size threshold.
-// entry.Timestamp is overwritten with the current time.
+// entry.Timestamp is overwritten with the current time; the chain
+// fields are overwritten to link the entry to the last one on disk.
+// The sinks get the entry after both locks are released.
 func (a *AuditLog) Write(entry AuditEntry) error {
+	sealed, sinks, err := a.append(entry)
+	if err != nil {
+		return err
+	}
+	if sinks != nil {
+		// Only enqueues; the file holds the chained copy either way.
+		_ = sinks.Write(auditRecord(sealed))
+	}
+	return nil
+}
+
+// append chains and appends entry under a.mu and the file lock.
+func (a *AuditLog) append(entry AuditEntry) (AuditEntry, *logsink.Async, error) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
+	unlock, err := lockAudit(a.path)
+	if err != nil {
+		return entry, nil, err
+	}
+	defer unlock()
+
 	entry.Timestamp = a.now()
 
+	// Link to the last entry before rotating, so the chain carries
+	// across into the fresh file. Re-read every time: other processes
+	// (`dddns config rotate-secret`) append to the same log.
+	seq, prev := lastChainLink(a.path)
+	entry.Seq, entry.Prev = seq+1, prev
+
 	if info, err := os.Stat(a.path); err == nil && info.Size() >= a.maxSize {
-		// Overwrite any prior .old; one rotation keep is sufficient per the
-		// plan (operational log has the same policy).
-		_ = os.Rename(a.path, a.path+".old")
+		// Best effort: a failed rotation must not lose the entry, so
+		// keep appending to the live file.
+		_ = a.rotate()
+	} else if !a.pruned {
+		a.prune()
 	}
+	a.pruned = true
 
-	data, err := json.Marshal(entry)
+	data, sealed, err := sealAuditEntry(entry, a.key)
 	if err != nil {
-		return fmt.Errorf("marshal audit entry: %w", err)
+		return entry, nil, err
 	}
 	data = append(data, '\n')
 
 	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
 	if err != nil {
-		return fmt.Errorf("open audit log: %w", err)
+		return entry, nil, fmt.Errorf("open audit log: %w", err)
 	}
 	defer func() { _ = f.Close() }()
 
 	if _, err := f.Write(data); err != nil {
-		return fmt.Errorf("write audit log: %w", err)
+		return entry, nil, fmt.Errorf("write audit log: %w", err)
 	}
-	return nil
+	return sealed, a.sinks, nil
+}
+
+// auditRecord converts an entry to a log record: one field per non-empty
+// entry field, keyed by its JSON name, so journald sees DDDNS_ACTION,
+// DDDNS_REMOTE and so on.
+func auditRecord(e AuditEntry) logsink.Record {
+	var fields []logsink.Field
+	add := func(k, v string) {
+		if v != "" {
+			fields = append(fields, logsink.Field{Key: k, Value: v})
+		}
+	}
+	add("remote", e.RemoteAddr)
+	add("peer", e.Peer)
+	add("hostname", e.Hostname)
+	add("myip_claimed", e.MyIPClaimed)
+	add("myip_verified", e.MyIPVerified)
+	add("auth_scheme", e.AuthScheme)
+	add("auth", e.AuthOutcome)
+	add("action", e.Action)
+	add("route53_change_id", e.Route53ChangeID)
+	add("error", e.Err)
+	if len(e.Hooks) > 0 {
+		outcomes := make([]string, len(e.Hooks))
+		for i, h := range e.Hooks {
+			outcomes[i] = fmt.Sprintf("%s:%d", h.Hook, h.ExitCode)
+		}
+		add("hooks", strings.Join(outcomes, ","))
+	}
+	if e.Seq > 0 {
+		add("seq", strconv.FormatUint(e.Seq, 10))
+	}
+	add("hash", e.Hash)
+
+	sev := logsink.SevInfo
+	switch {
+	case e.Err != "":
+		sev = logsink.SevError
+	case badAuthOutcomes[e.AuthOutcome]:
+		sev = logsink.SevWarning
+	case e.Action == "updated" || e.Action == "rotate-secret":
+		sev = logsink.SevNotice
+	}
+	msg := "audit"
+	for _, v := range []string{e.Action, e.AuthOutcome, e.RemoteAddr} {
+		if v != "" {
+			msg += " " + v
+		}
+	}
+	return logsink.Record{Time: e.Timestamp, Severity: sev, MsgID: "audit", Message: msg, Fields: fields}
 }
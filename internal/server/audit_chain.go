@@ -0,0 +1,347 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// The audit log is a hash chain. Every entry carries Seq (previous + 1)
+// and Prev (the previous entry's Hash); Hash is the SHA-256 of the
+// entry serialized without Hash and MAC. Deleting, reordering, or
+// editing a line therefore breaks either the line's own hash or the
+// link from its successor. MAC, when a key is configured, is
+// HMAC-SHA256 over Hash so that someone who can write the file but
+// does not hold the key cannot recompute a consistent chain.
+//
+// The chain's head is anchored. A fresh log starts at Seq 1 with an
+// empty Prev. When rotation or age pruning deletes the oldest
+// generations, the last link of the deleted ones is first written to
+// path.anchor (with a MAC under the same key), and the oldest retained
+// entry must link to it. Cutting entries from the head, or stripping
+// the chain fields altogether, therefore fails verification too.
+//
+// A log written before the chain existed keeps its unchained lines
+// after an upgrade; the chain then starts at Seq 1 below them. That
+// entry marks the chain start: unchained lines above it are counted as
+// pre-chain and not verified, while an unchained line anywhere after it
+// is a problem.
+//
+// What the chain cannot show: lines removed from the very end (there
+// is no successor to notice).
+
+// auditTailSize bounds how much of the live file Write reads to find
+// the last chain link.
+const auditTailSize = 64 * 1024
+
+// sealAuditEntry serializes e and appends its hash (and MAC, when key
+// is non-empty) as the final fields. The hash covers exactly the bytes
+// that precede it, so verification can recompute it from the line. The
+// returned entry carries the Hash and MAC that were written.
+func sealAuditEntry(e AuditEntry, key []byte) ([]byte, AuditEntry, error) {
+	e.Hash, e.MAC = "", ""
+	body, err := json.Marshal(e)
+	if err != nil {
+		return nil, e, fmt.Errorf("marshal audit entry: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	e.Hash = hex.EncodeToString(sum[:])
+
+	line := append(body[:len(body)-1:len(body)-1], `,"hash":"`+e.Hash+`"`...)
+	if len(key) > 0 {
+		e.MAC = auditMAC(key, e.Hash)
+		line = append(line, `,"mac":"`+e.MAC+`"`...)
+	}
+	return append(line, '}'), e, nil
+}
+
+// unsealAuditLine parses one line and reconstructs the bytes its hash
+// was computed over. ok is false when the line does not carry the
+// seal in the form sealAuditEntry writes it.
+func unsealAuditLine(line []byte) (e AuditEntry, body []byte, ok bool, err error) {
+	if err := json.Unmarshal(line, &e); err != nil {
+		return e, nil, false, err
+	}
+	if e.Hash == "" {
+		return e, nil, false, nil
+	}
+	suffix := `,"hash":"` + e.Hash + `"`
+	if e.MAC != "" {
+		suffix += `,"mac":"` + e.MAC + `"`
+	}
+	suffix += "}"
+	if !bytes.HasSuffix(line, []byte(suffix)) {
+		return e, nil, false, nil
+	}
+	body = append(bytes.Clone(line[:len(line)-len(suffix)]), '}')
+	return e, body, true, nil
+}
+
+func auditMAC(key []byte, hash string) string {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(hash))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// auditAnchor is the last link of the generations deleted from the
+// head of the log, which the oldest retained entry links to.
+type auditAnchor struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+	MAC  string `json:"mac,omitempty"`
+}
+
+func auditAnchorPath(path string) string { return path + ".anchor" }
+
+func anchorMAC(key []byte, seq uint64, hash string) string {
+	return auditMAC(key, "anchor:"+strconv.FormatUint(seq, 10)+":"+hash)
+}
+
+// writeAuditAnchor records the last link of dropped, a generation about
+// to be deleted; the caller deletes it only when this succeeds. A
+// generation without a chained entry leaves the anchor as it is.
+// Called with the file lock held.
+func writeAuditAnchor(path, dropped string, key []byte) error {
+	seq, hash, found := scanChainLink(dropped)
+	if !found {
+		return nil
+	}
+	anchor := auditAnchor{Seq: seq, Hash: hash}
+	if len(key) > 0 {
+		anchor.MAC = anchorMAC(key, seq, hash)
+	}
+	data, err := json.Marshal(anchor)
+	if err != nil {
+		return fmt.Errorf("marshal audit anchor: %w", err)
+	}
+	tmp := auditAnchorPath(path) + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("write audit anchor: %w", err)
+	}
+	if err := os.Rename(tmp, auditAnchorPath(path)); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("write audit anchor: %w", err)
+	}
+	return nil
+}
+
+// readAuditAnchor reads path.anchor; found is false when there is none.
+func readAuditAnchor(path string) (anchor auditAnchor, found bool, err error) {
+	data, err := os.ReadFile(auditAnchorPath(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return anchor, false, nil
+	}
+	if err != nil {
+		return anchor, false, fmt.Errorf("read audit anchor: %w", err)
+	}
+	if err := json.Unmarshal(data, &anchor); err != nil || anchor.Hash == "" {
+		return anchor, false, fmt.Errorf("audit anchor %s is malformed", auditAnchorPath(path))
+	}
+	return anchor, true, nil
+}
+
+// lastChainLink returns the Seq and Hash of the newest chained entry in
+// the live file, falling back to the newest rotated generation when the
+// live file is empty or absent, and then to the anchor. Zero values
+// mean the chain starts fresh.
+func lastChainLink(path string) (seq uint64, hash string) {
+	if seq, hash, found := tailChainLink(path); found {
+		return seq, hash
+	}
+	files := AuditFiles(path)
+	for i := len(files) - 1; i >= 0; i-- {
+		if files[i] == path {
+			continue
+		}
+		// Rotated files are only read when the live file has no link,
+		// i.e. right after an out-of-process rotation or deletion.
+		if seq, hash, found := scanChainLink(files[i]); found {
+			return seq, hash
+		}
+	}
+	if anchor, found, _ := readAuditAnchor(path); found {
+		return anchor.Seq, anchor.Hash
+	}
+	return 0, ""
+}
+
+// tailChainLink reads only the last auditTailSize bytes of path.
+func tailChainLink(path string) (seq uint64, hash string, found bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", false
+	}
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return 0, "", false
+	}
+	off := max(info.Size()-auditTailSize, 0)
+	buf := make([]byte, info.Size()-off)
+	if _, err := f.ReadAt(buf, off); err != nil && err != io.EOF {
+		return 0, "", false
+	}
+	return lastLinkIn(buf)
+}
+
+// scanChainLink reads a whole (possibly gzipped) file.
+func scanChainLink(path string) (seq uint64, hash string, found bool) {
+	f, err := openAuditFile(path)
+	if err != nil {
+		return 0, "", false
+	}
+	defer func() { _ = f.Close() }()
+	buf, _ := io.ReadAll(f)
+	return lastLinkIn(buf)
+}
+
+func lastLinkIn(buf []byte) (seq uint64, hash string, found bool) {
+	lines := bytes.Split(buf, []byte{'\n'})
+	for i := len(lines) - 1; i >= 0; i-- {
+		var e AuditEntry
+		if json.Unmarshal(lines[i], &e) == nil && e.Hash != "" {
+			return e.Seq, e.Hash, true
+		}
+	}
+	return 0, "", false
+}
+
+// AuditProblem is one integrity failure found by VerifyAudit.
+type AuditProblem struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Seq  uint64 `json:"seq,omitempty"`
+	Msg  string `json:"message"`
+}
+
+// AuditVerifyReport is the result of VerifyAudit.
+type AuditVerifyReport struct {
+	Files      []string       `json:"files"`
+	Entries    int            `json:"entries"`
+	AnchorSeq  uint64         `json:"anchor_seq,omitempty"` // last seq of the generations deleted from the head
+	PreChain   int            `json:"pre_chain,omitempty"`  // unchained entries written before the chain started
+	FirstSeq   uint64         `json:"first_seq,omitempty"`
+	LastSeq    uint64         `json:"last_seq,omitempty"`
+	LastHash   string         `json:"last_hash,omitempty"`
+	MACChecked bool           `json:"mac_checked"`
+	Problems   []AuditProblem `json:"problems"`
+}
+
+// OK reports whether verification found no problems.
+func (r AuditVerifyReport) OK() bool { return len(r.Problems) == 0 }
+
+// VerifyAudit walks the rotated and live audit files oldest first and
+// checks every line's hash, its link to the preceding entry, and — when
+// key is non-empty — its MAC. The first entry must be Seq 1 with no
+// Prev, or link to the anchor rotation left when it deleted older
+// generations. Unchained lines above a Seq 1 start predate the chain and
+// are only counted; any other unchained line, and a log with no chained
+// entry at all, are problems.
+func VerifyAudit(path string, key []byte) (AuditVerifyReport, error) {
+	r := AuditVerifyReport{Files: AuditFiles(path), MACChecked: len(key) > 0, Problems: []AuditProblem{}}
+	anchor, anchored, err := readAuditAnchor(path)
+	if err != nil {
+		r.Problems = append(r.Problems, AuditProblem{File: auditAnchorPath(path), Msg: err.Error()})
+	}
+	if anchored {
+		r.AnchorSeq = anchor.Seq
+		if len(key) > 0 && !hmac.Equal([]byte(anchor.MAC), []byte(anchorMAC(key, anchor.Seq, anchor.Hash))) {
+			r.Problems = append(r.Problems, AuditProblem{File: auditAnchorPath(path), Seq: anchor.Seq,
+				Msg: "anchor MAC mismatch; anchor was rewritten without the key"})
+		}
+	}
+	var (
+		started  bool
+		lastSeq  uint64
+		lastHash string
+	)
+	for _, p := range r.Files {
+		f, err := openAuditFile(p)
+		if err != nil {
+			return r, fmt.Errorf("open %s: %w", p, err)
+		}
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 64*1024), auditMaxLine)
+		n := 0
+		for sc.Scan() {
+			n++
+			line := sc.Bytes()
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			problem := func(seq uint64, format string, args ...any) {
+				r.Problems = append(r.Problems, AuditProblem{File: p, Line: n, Seq: seq, Msg: fmt.Sprintf(format, args...)})
+			}
+			r.Entries++
+
+			e, body, sealed, err := unsealAuditLine(line)
+			switch {
+			case err != nil:
+				problem(0, "malformed line")
+				continue
+			case e.Hash == "" && !started && !anchored:
+				r.PreChain++
+				continue
+			case e.Hash == "":
+				problem(0, "unchained entry; hash, seq and prev are missing")
+				continue
+			case !sealed:
+				problem(e.Seq, "hash is not the final field; line was re-serialized")
+				continue
+			}
+
+			sum := sha256.Sum256(body)
+			if hex.EncodeToString(sum[:]) != e.Hash {
+				problem(e.Seq, "hash mismatch; entry was modified")
+			} else if len(key) > 0 && !hmac.Equal([]byte(e.MAC), []byte(auditMAC(key, e.Hash))) {
+				if e.MAC == "" {
+					problem(e.Seq, "missing MAC")
+				} else {
+					problem(e.Seq, "MAC mismatch; entry was rewritten without the key")
+				}
+			}
+
+			if !started {
+				started = true
+				r.FirstSeq = e.Seq
+				switch {
+				case anchored && (e.Seq != anchor.Seq+1 || e.Prev != anchor.Hash):
+					problem(e.Seq, "chain starts at seq %d but the anchor is seq %d; entries removed from the head", e.Seq, anchor.Seq)
+				case !anchored && (e.Seq != 1 || e.Prev != ""):
+					problem(e.Seq, "chain starts at seq %d with no anchor; entries removed from the head", e.Seq)
+				}
+			} else {
+				switch {
+				case e.Seq <= lastSeq:
+					problem(e.Seq, "sequence went from %d to %d; entries reordered or duplicated", lastSeq, e.Seq)
+				case e.Seq > lastSeq+1:
+					problem(e.Seq, "sequence jumps from %d to %d; %d entries missing", lastSeq, e.Seq, e.Seq-lastSeq-1)
+				case e.Prev != lastHash:
+					problem(e.Seq, "prev does not match the preceding entry's hash")
+				}
+			}
+			lastSeq, lastHash = e.Seq, e.Hash
+		}
+		_ = f.Close()
+		if err := sc.Err(); err != nil {
+			return r, fmt.Errorf("read %s: %w", p, err)
+		}
+	}
+	switch {
+	case !started && r.PreChain > 0:
+		r.Problems = append(r.Problems, AuditProblem{File: path, Msg: fmt.Sprintf(
+			"no chained entries yet; all %d entries predate the hash chain, which starts with the next audited request", r.PreChain)})
+	case !started:
+		r.Problems = append(r.Problems, AuditProblem{File: path, Msg: "no chained entries; nothing to verify"})
+	}
+	r.LastSeq, r.LastHash = lastSeq, lastHash
+	return r, nil
+}
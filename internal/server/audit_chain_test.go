@@ -0,0 +1,314 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeChain writes n entries through AuditLog and returns the lines.
+func writeChain(t *testing.T, path string, key []byte, n int) []string {
+	t.Helper()
+	a := NewAuditLog(path)
+	a.SetHMACKey(key)
+	for i := 0; i < n; i++ {
+		if err := a.Write(AuditEntry{RemoteAddr: "10.0.0.1:1", Action: "updated"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func rewrite(t *testing.T, path string, lines []string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuditChain_IntactWithMAC(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	key := []byte("audit-hmac-key-0123456789abcdef0123")
+	writeChain(t, path, key, 3)
+
+	r, err := VerifyAudit(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.OK() || r.Entries != 3 || r.FirstSeq != 1 || r.LastSeq != 3 || !r.MACChecked {
+		t.Errorf("report = %+v", r)
+	}
+}
+
+func TestAuditChain_ContinuesAcrossRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	a := NewAuditLog(path)
+	a.maxSize = 1  // rotate before every write after the first
+	a.maxFiles = 1 // and keep only one rotated generation
+	for i := 0; i < 3; i++ {
+		if err := a.Write(AuditEntry{Action: "updated"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := VerifyAudit(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first entry rotated away; the rest still link up.
+	if !r.OK() || r.FirstSeq != 2 || r.LastSeq != 3 || len(r.Files) != 2 {
+		t.Errorf("report = %+v", r)
+	}
+}
+
+func TestAuditChain_DetectsTampering(t *testing.T) {
+	key := []byte("audit-hmac-key-0123456789abcdef0123")
+	cases := []struct {
+		name   string
+		key    []byte
+		tamper func([]string) []string
+		want   string
+	}{
+		{"deleted", nil, func(l []string) []string { return append(l[:1:1], l[2:]...) }, "missing"},
+		{"reordered", nil, func(l []string) []string { l[1], l[2] = l[2], l[1]; return l }, "reordered"},
+		{"modified", nil, func(l []string) []string {
+			l[1] = strings.Replace(l[1], `"updated"`, `"nochg-dns"`, 1)
+			return l
+		}, "modified"},
+		{"unchained insert", nil, func(l []string) []string {
+			return append(l[:2:2], append([]string{`{"ts":"2026-04-17T12:00:00Z","remote":"x"}`}, l[2:]...)...)
+		}, "unchained"},
+		{"recomputed without key", key, func(l []string) []string {
+			// Forge line 3 with a valid hash but no key: the MAC gives it away.
+			e, _, _, _ := unsealAuditLine([]byte(l[2]))
+			e.Action = "forged"
+			forged, _, _ := sealAuditEntry(e, []byte("wrong-key"))
+			l[2] = string(forged)
+			return l
+		}, "MAC mismatch"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.log")
+			lines := writeChain(t, path, tc.key, 4)
+			rewrite(t, path, tc.tamper(lines))
+
+			r, err := VerifyAudit(path, tc.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.OK() {
+				t.Fatalf("tampering not detected: %+v", r)
+			}
+			found := false
+			for _, p := range r.Problems {
+				found = found || strings.Contains(p.Msg, tc.want)
+			}
+			if !found {
+				t.Errorf("problems = %+v, want %q", r.Problems, tc.want)
+			}
+		})
+	}
+}
+
+// TestAuditChain_UnchainedLines verifies lines without the chain fields
+// fail verification, whether before the chain or making up all of it.
+func TestAuditChain_UnchainedLines(t *testing.T) {
+	key := []byte("audit-hmac-key-0123456789abcdef0123")
+	unchained := `{"ts":"2026-04-17T12:00:00Z","remote":"10.0.0.1:1","action":"updated"}`
+	cases := []struct {
+		name  string
+		key   []byte
+		lines func(path string) []string
+		want  string
+	}{
+		{"after the chain started", nil, func(path string) []string {
+			lines := writeChain(t, path, nil, 2)
+			return append(lines, unchained)
+		}, "unchained entry"},
+		{"pre-chain log only", nil, func(string) []string {
+			return []string{unchained, unchained}
+		}, "all 2 entries predate the hash chain"},
+		{"chain stripped", key, func(path string) []string {
+			lines := writeChain(t, path, key, 3)
+			for i, l := range lines {
+				e, _, _, _ := unsealAuditLine([]byte(l))
+				e.Seq, e.Prev, e.Hash, e.MAC = 0, "", "", ""
+				stripped, _ := json.Marshal(e)
+				lines[i] = string(stripped)
+			}
+			return lines
+		}, "no chained entries"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.log")
+			rewrite(t, path, tc.lines(path))
+			r, err := VerifyAudit(path, tc.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.OK() || !hasProblem(r, tc.want) {
+				t.Errorf("problems = %+v, want %q", r.Problems, tc.want)
+			}
+		})
+	}
+}
+
+// TestAuditChain_PreChainLines verifies an upgraded log: unchained
+// lines above a chain that starts at seq 1 are counted, not failed.
+func TestAuditChain_PreChainLines(t *testing.T) {
+	key := []byte("audit-hmac-key-0123456789abcdef0123")
+	path := filepath.Join(t.TempDir(), "audit.log")
+	legacy := []string{
+		`{"ts":"2026-04-17T12:00:00Z","remote":"10.0.0.1:1","action":"updated"}`,
+		`{"ts":"2026-04-17T12:05:00Z","remote":"10.0.0.1:1","action":"nochg-cache"}`,
+	}
+	rewrite(t, path, legacy)
+	lines := writeChain(t, path, key, 3)[len(legacy):] // the upgraded listener appends
+
+	r, err := VerifyAudit(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.OK() || r.PreChain != 2 || r.FirstSeq != 1 || r.LastSeq != 3 {
+		t.Errorf("report = %+v, want OK with 2 pre-chain entries and seq 1-3", r)
+	}
+
+	// Stripping the chain's head turns its first entries into
+	// "pre-chain" lines, but the chain then no longer starts at seq 1.
+	e, _, _, _ := unsealAuditLine([]byte(lines[0]))
+	e.Seq, e.Prev, e.Hash, e.MAC = 0, "", "", ""
+	stripped, _ := json.Marshal(e)
+	rewrite(t, path, append(append(legacy, string(stripped)), lines[1:]...))
+	if r, _ := VerifyAudit(path, key); r.OK() || !hasProblem(r, "chain starts at seq 2") {
+		t.Errorf("stripped head: problems = %+v", r.Problems)
+	}
+}
+
+func TestAuditChain_EmptyLogFails(t *testing.T) {
+	r, err := VerifyAudit(filepath.Join(t.TempDir(), "audit.log"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.OK() || !hasProblem(r, "no chained entries") {
+		t.Errorf("problems = %+v", r.Problems)
+	}
+}
+
+// TestAuditChain_HeadTruncation verifies that deleting the oldest
+// entries, or the oldest generation, fails verification unless rotation
+// dropped it and anchored the chain.
+func TestAuditChain_HeadTruncation(t *testing.T) {
+	key := []byte("audit-hmac-key-0123456789abcdef0123")
+
+	t.Run("oldest lines deleted", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		lines := writeChain(t, path, key, 4)
+		rewrite(t, path, lines[2:])
+		r, _ := VerifyAudit(path, key)
+		if r.OK() || !hasProblem(r, "with no anchor") {
+			t.Errorf("problems = %+v", r.Problems)
+		}
+	})
+
+	rotated := func(t *testing.T) (string, *AuditLog) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		a := NewAuditLog(path)
+		a.SetHMACKey(key)
+		a.maxSize = 1
+		a.maxFiles = 2
+		for i := 0; i < 5; i++ {
+			if err := a.Write(AuditEntry{Action: "updated"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return path, a
+	}
+
+	t.Run("generation dropped by rotation", func(t *testing.T) {
+		path, _ := rotated(t)
+		r, err := VerifyAudit(path, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !r.OK() || r.AnchorSeq != 2 || r.FirstSeq != 3 || r.LastSeq != 5 {
+			t.Errorf("report = %+v", r)
+		}
+	})
+
+	t.Run("generation deleted by hand", func(t *testing.T) {
+		path, _ := rotated(t)
+		files := AuditFiles(path)
+		if err := os.Remove(files[0]); err != nil {
+			t.Fatal(err)
+		}
+		r, _ := VerifyAudit(path, key)
+		if r.OK() || !hasProblem(r, "but the anchor is seq 2") {
+			t.Errorf("problems = %+v", r.Problems)
+		}
+	})
+
+	t.Run("anchor forged without the key", func(t *testing.T) {
+		path, _ := rotated(t)
+		files := AuditFiles(path)
+		seq, hash, _ := scanChainLink(files[0])
+		if err := os.Remove(files[0]); err != nil {
+			t.Fatal(err)
+		}
+		forged, _ := json.Marshal(auditAnchor{Seq: seq, Hash: hash, MAC: anchorMAC([]byte("wrong-key"), seq, hash)})
+		if err := os.WriteFile(auditAnchorPath(path), forged, 0600); err != nil {
+			t.Fatal(err)
+		}
+		r, _ := VerifyAudit(path, key)
+		if r.OK() || !hasProblem(r, "anchor MAC mismatch") {
+			t.Errorf("problems = %+v", r.Problems)
+		}
+	})
+}
+
+// TestAuditChain_PruneAnchors verifies age pruning anchors the chain at
+// the newest generation it deletes.
+func TestAuditChain_PruneAnchors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	now := time.Date(2026, 4, 17, 12, 0, 0, 0, time.UTC)
+	a := NewAuditLog(path)
+	a.now = func() time.Time { return now }
+	a.maxSize = 1
+	for i := 0; i < 3; i++ {
+		if err := a.Write(AuditEntry{Action: "updated"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := now.Add(-48 * time.Hour)
+	for _, g := range auditGenerations(path) {
+		if err := os.Chtimes(g.path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.maxAge = 24 * time.Hour
+	a.prune()
+
+	r, err := VerifyAudit(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.OK() || r.AnchorSeq != 2 || r.FirstSeq != 3 || len(r.Files) != 1 {
+		t.Errorf("report = %+v", r)
+	}
+}
+
+func hasProblem(r AuditVerifyReport, want string) bool {
+	for _, p := range r.Problems {
+		if strings.Contains(p.Msg, want) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// auditMaxLine caps a single audit line when reading back. Entries are
+// a few hundred bytes; anything larger is corruption.
+const auditMaxLine = 1 << 20
+
+// AuditFiles returns the audit-log files under path that exist on disk,
+// oldest first: a legacy path.old, the rotated generations from
+// path.N.gz down to path.1.gz, then the live file.
+func AuditFiles(path string) []string {
+	var files []string
+	if _, err := os.Stat(path + ".old"); err == nil {
+		files = append(files, path+".old")
+	}
+	gens := auditGenerations(path)
+	for i := len(gens) - 1; i >= 0; i-- {
+		files = append(files, gens[i].path)
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	return files
+}
+
+// ReadAudit reads every entry from the rotated and live audit files in
+// chronological order, decompressing gzipped generations. Lines that do
+// not parse are counted in skipped rather than failing the read — a
+// partially written tail must not hide the rest of the history.
+func ReadAudit(path string) (entries []AuditEntry, skipped int, err error) {
+	for _, p := range AuditFiles(path) {
+		f, err := openAuditFile(p)
+		if err != nil {
+			return nil, 0, fmt.Errorf("open %s: %w", p, err)
+		}
+		n, err := decodeAudit(f, func(e AuditEntry) { entries = append(entries, e) })
+		_ = f.Close()
+		skipped += n
+		if err != nil {
+			return nil, 0, fmt.Errorf("read %s: %w", p, err)
+		}
+	}
+	return entries, skipped, nil
+}
+
+// decodeAudit calls fn for each well-formed JSONL entry in r and
+// returns the number of malformed lines.
+func decodeAudit(r io.Reader, fn func(AuditEntry)) (skipped int, err error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), auditMaxLine)
+	for sc.Scan() {
+		line := sc.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var e AuditEntry
+		if json.Unmarshal(line, &e) != nil {
+			skipped++
+			continue
+		}
+		fn(e)
+	}
+	return skipped, sc.Err()
+}
+
+// AuditFilter selects audit entries. Zero-valued fields match anything.
+type AuditFilter struct {
+	Since    time.Time
+	Until    time.Time
+	Remote   string // IP, CIDR, or exact remote string
+	Auth     string // auth outcome: ok, bad, locked, missing, ...
+	Action   string
+	Hostname string // case-insensitive
+}
+
+// Match reports whether e passes every set criterion.
+func (f AuditFilter) Match(e AuditEntry) bool {
+	if !f.Since.IsZero() && e.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Timestamp.After(f.Until) {
+		return false
+	}
+	if f.Auth != "" && e.AuthOutcome != f.Auth {
+		return false
+	}
+	if f.Action != "" && e.Action != f.Action {
+		return false
+	}
+	if f.Hostname != "" && !strings.EqualFold(e.Hostname, f.Hostname) {
+		return false
+	}
+	if f.Remote != "" && !matchRemote(f.Remote, e.RemoteAddr) {
+		return false
+	}
+	return true
+}
+
+// matchRemote compares a --remote filter against an entry's address:
+// CIDR containment, then IP equality ignoring the port, then exact text.
+func matchRemote(filter, remote string) bool {
+	ip := hostIP(remote)
+	if _, n, err := net.ParseCIDR(filter); err == nil {
+		return ip != nil && n.Contains(ip)
+	}
+	if want := net.ParseIP(filter); want != nil {
+		return ip != nil && ip.Equal(want)
+	}
+	return filter == remote
+}
+
+// badAuthOutcomes are the auth values counted as failed attempts.
+var badAuthOutcomes = map[string]bool{"bad": true, "locked": true, "missing": true, "unsigned": true}
+
+// AuditSummary aggregates a set of entries for `dddns serve audit --summary`.
+type AuditSummary struct {
+	Total           int            `json:"total"`
+	First           time.Time      `json:"first,omitzero"`
+	Last            time.Time      `json:"last,omitzero"`
+	ByAction        map[string]int `json:"by_action"`
+	ByAuth          map[string]int `json:"by_auth"`
+	BadAuthBySource []SourceCount  `json:"bad_auth_by_source"`
+}
+
+// SourceCount is one row of AuditSummary.BadAuthBySource.
+type SourceCount struct {
+	Source string `json:"source"`
+	Count  int    `json:"count"`
+}
+
+// SummarizeAudit counts entries by action and auth outcome, and failed
+// auth attempts per source IP (port dropped), most frequent first.
+func SummarizeAudit(entries []AuditEntry) AuditSummary {
+	s := AuditSummary{ByAction: map[string]int{}, ByAuth: map[string]int{}, BadAuthBySource: []SourceCount{}}
+	bad := map[string]int{}
+	for _, e := range entries {
+		s.Total++
+		if s.First.IsZero() || e.Timestamp.Before(s.First) {
+			s.First = e.Timestamp
+		}
+		if e.Timestamp.After(s.Last) {
+			s.Last = e.Timestamp
+		}
+		if e.Action != "" {
+			s.ByAction[e.Action]++
+		}
+		if e.AuthOutcome != "" {
+			s.ByAuth[e.AuthOutcome]++
+		}
+		if badAuthOutcomes[e.AuthOutcome] {
+			src := e.RemoteAddr
+			if ip := hostIP(src); ip != nil {
+				src = ip.String()
+			}
+			bad[src]++
+		}
+	}
+	for src, n := range bad {
+		s.BadAuthBySource = append(s.BadAuthBySource, SourceCount{Source: src, Count: n})
+	}
+	sort.Slice(s.BadAuthBySource, func(i, j int) bool {
+		a, b := s.BadAuthBySource[i], s.BadAuthBySource[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Source < b.Source
+	})
+	return s
+}
+
+// FollowAudit polls the live audit file every interval and calls fn for
+// each entry appended after offset bytes. A shrinking file or a new
+// inode is treated as a rotation and read from the start. It returns
+// when ctx is done.
+func FollowAudit(ctx context.Context, path string, offset int64, interval time.Duration, fn func(AuditEntry)) error {
+	var (
+		f       *os.File
+		current os.FileInfo
+		pending []byte
+	)
+	defer func() {
+		if f != nil {
+			_ = f.Close()
+		}
+	}()
+	// drain reads everything past offset and emits complete lines; a
+	// partial tail waits for the next poll.
+	drain := func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, rerr := f.ReadAt(buf, offset)
+			pending = append(pending, buf[:n]...)
+			offset += int64(n)
+			if rerr != nil || n == 0 {
+				break
+			}
+		}
+		if i := bytes.LastIndexByte(pending, '\n'); i >= 0 {
+			_, _ = decodeAudit(bytes.NewReader(pending[:i+1]), fn)
+			pending = append(pending[:0], pending[i+1:]...)
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		info, err := os.Stat(path)
+		switch {
+		case err == nil && f != nil && (!os.SameFile(info, current) || info.Size() < offset):
+			// Rotated: finish the old file (still readable through f
+			// after the rename), then start the new one from zero.
+			drain()
+			_ = f.Close()
+			f, offset, pending = nil, 0, nil
+			fallthrough
+		case err == nil && f == nil:
+			if f, err = os.Open(path); err != nil {
+				return fmt.Errorf("open audit log: %w", err)
+			}
+			current = info
+		case err != nil && !errors.Is(err, os.ErrNotExist):
+			return fmt.Errorf("stat audit log: %w", err)
+		}
+		if f != nil {
+			drain()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeAuditLines writes entries as a JSONL file via AuditLog so the
+// format under test is exactly what the handler produces.
+func writeAuditLines(t *testing.T, path string, at time.Time, entries ...AuditEntry) {
+	t.Helper()
+	a := NewAuditLog(path)
+	for i, e := range entries {
+		ts := at.Add(time.Duration(i) * time.Minute)
+		a.now = func() time.Time { return ts }
+		if err := a.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadAudit_RotatedThenLive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	base := time.Date(2026, 4, 17, 12, 0, 0, 0, time.UTC)
+	writeAuditLines(t, path+".old", base, AuditEntry{RemoteAddr: "10.0.0.1:1", Action: "updated"})
+	writeAuditLines(t, path, base.Add(time.Hour), AuditEntry{RemoteAddr: "10.0.0.2:1", Action: "nochg-dns"})
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString("{not json\n")
+	_ = f.Close()
+
+	entries, skipped, err := ReadAudit(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped != 1 {
+		t.Errorf("skipped = %d, want 1", skipped)
+	}
+	if len(entries) != 2 || entries[0].Action != "updated" || entries[1].Action != "nochg-dns" {
+		t.Errorf("entries = %+v, want rotated file first", entries)
+	}
+}
+
+func TestReadAudit_MissingIsEmpty(t *testing.T) {
+	entries, skipped, err := ReadAudit(filepath.Join(t.TempDir(), "absent.log"))
+	if err != nil || len(entries) != 0 || skipped != 0 {
+		t.Errorf("ReadAudit(missing) = %v, %d, %v", entries, skipped, err)
+	}
+}
+
+func TestAuditFilter_Match(t *testing.T) {
+	at := time.Date(2026, 4, 17, 12, 0, 0, 0, time.UTC)
+	e := AuditEntry{Timestamp: at, RemoteAddr: "192.168.1.9:4000", Hostname: "Home.Example.com", AuthOutcome: "bad", Action: ""}
+	cases := []struct {
+		name   string
+		filter AuditFilter
+		want   bool
+	}{
+		{"empty matches", AuditFilter{}, true},
+		{"since before", AuditFilter{Since: at.Add(-time.Minute)}, true},
+		{"since after", AuditFilter{Since: at.Add(time.Minute)}, false},
+		{"until before", AuditFilter{Until: at.Add(-time.Minute)}, false},
+		{"remote ip ignores port", AuditFilter{Remote: "192.168.1.9"}, true},
+		{"remote cidr", AuditFilter{Remote: "192.168.1.0/24"}, true},
+		{"remote other", AuditFilter{Remote: "192.168.2.0/24"}, false},
+		{"auth", AuditFilter{Auth: "bad"}, true},
+		{"auth mismatch", AuditFilter{Auth: "ok"}, false},
+		{"action mismatch", AuditFilter{Action: "updated"}, false},
+		{"hostname case-insensitive", AuditFilter{Hostname: "home.example.com"}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.filter.Match(e); got != tc.want {
+				t.Errorf("Match = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSummarizeAudit(t *testing.T) {
+	at := time.Date(2026, 4, 17, 12, 0, 0, 0, time.UTC)
+	s := SummarizeAudit([]AuditEntry{
+		{Timestamp: at, RemoteAddr: "10.0.0.1:1", AuthOutcome: "bad"},
+		{Timestamp: at.Add(time.Minute), RemoteAddr: "10.0.0.1:2", AuthOutcome: "locked"},
+		{Timestamp: at.Add(2 * time.Minute), RemoteAddr: "10.0.0.2:1", AuthOutcome: "missing"},
+		{Timestamp: at.Add(3 * time.Minute), RemoteAddr: "10.0.0.3:1", AuthOutcome: "ok", Action: "updated"},
+	})
+	if s.Total != 4 || !s.First.Equal(at) || !s.Last.Equal(at.Add(3*time.Minute)) {
+		t.Errorf("totals = %+v", s)
+	}
+	if s.ByAction["updated"] != 1 || s.ByAuth["bad"] != 1 || s.ByAuth["ok"] != 1 {
+		t.Errorf("counts = %v %v", s.ByAction, s.ByAuth)
+	}
+	want := []SourceCount{{"10.0.0.1", 2}, {"10.0.0.2", 1}}
+	if len(s.BadAuthBySource) != len(want) {
+		t.Fatalf("BadAuthBySource = %v, want %v", s.BadAuthBySource, want)
+	}
+	for i := range want {
+		if s.BadAuthBySource[i] != want[i] {
+			t.Errorf("BadAuthBySource[%d] = %v, want %v", i, s.BadAuthBySource[i], want[i])
+		}
+	}
+}
+
+// TestFollowAudit_AppendsAndRotation checks that --follow picks up new
+// lines, including ones written just before a rotation and the first
+// lines of the fresh file.
+func TestFollowAudit_AppendsAndRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	a := NewAuditLog(path)
+	if err := a.Write(AuditEntry{Action: "before"}); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(path)
+
+	var mu sync.Mutex
+	var got []string
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- FollowAudit(ctx, path, info.Size(), 5*time.Millisecond, func(e AuditEntry) {
+			mu.Lock()
+			got = append(got, e.Action)
+			mu.Unlock()
+		})
+	}()
+
+	waitFor := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			mu.Lock()
+			l := len(got)
+			mu.Unlock()
+			if l >= n {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("timed out waiting for %d entries, have %v", n, got)
+	}
+
+	_ = a.Write(AuditEntry{Action: "first"})
+	waitFor(1)
+	_ = a.Write(AuditEntry{Action: "pre-rotate"})
+	if err := os.Rename(path, path+".old"); err != nil {
+		t.Fatal(err)
+	}
+	_ = a.Write(AuditEntry{Action: "post-rotate"})
+	waitFor(3)
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"first", "pre-rotate", "post-rotate"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %v, want %v", got, want)
+			break
+		}
+	}
+}
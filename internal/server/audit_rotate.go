@@ -0,0 +1,201 @@
+package server
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Rotated audit generations live next to the live file as path.1.gz
+// (newest) … path.N.gz (oldest). A generation whose compression was
+// interrupted stays uncompressed as path.N; readers accept both. The
+// single path.old written by earlier releases is read as the oldest
+// generation and otherwise left alone, apart from age-based pruning.
+
+// auditGeneration is one rotated file and its index.
+type auditGeneration struct {
+	index int
+	path  string
+}
+
+// auditGenerations returns the rotated files under path, newest first.
+func auditGenerations(path string) []auditGeneration {
+	matches, _ := filepath.Glob(globEscape(path) + ".*")
+	var gens []auditGeneration
+	for _, m := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(m, path+"."), ".gz")
+		n, err := strconv.Atoi(suffix)
+		if err != nil || n < 1 {
+			continue
+		}
+		gens = append(gens, auditGeneration{index: n, path: m})
+	}
+	sort.Slice(gens, func(i, j int) bool { return gens[i].index < gens[j].index })
+	return gens
+}
+
+// globEscape quotes the glob metacharacters in a literal path.
+func globEscape(p string) string {
+	var b strings.Builder
+	for _, r := range p {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// rotate shifts every generation up by one, dropping those beyond
+// maxFiles, moves the live file to generation 1 and compresses it.
+// Called with a.mu and the file lock held.
+func (a *AuditLog) rotate() error {
+	gens := auditGenerations(a.path)
+	for i := len(gens) - 1; i >= 0; i-- {
+		g := gens[i]
+		if g.index >= a.maxFiles {
+			if err := a.dropGeneration(g.path); err != nil {
+				return fmt.Errorf("rotate audit log: %w", err)
+			}
+			continue
+		}
+		next := a.path + "." + strconv.Itoa(g.index+1)
+		if strings.HasSuffix(g.path, ".gz") {
+			next += ".gz"
+		}
+		if err := os.Rename(g.path, next); err != nil {
+			return fmt.Errorf("rotate audit log: %w", err)
+		}
+	}
+
+	first := a.path + ".1"
+	if err := os.Rename(a.path, first); err != nil {
+		return fmt.Errorf("rotate audit log: %w", err)
+	}
+	err := compressFile(first, first+".gz")
+	a.prune()
+	return err
+}
+
+// prune deletes rotated generations (and a legacy path.old) whose
+// modification time is older than maxAge, oldest first so the anchor
+// ends at the newest one deleted.
+func (a *AuditLog) prune() {
+	if a.maxAge <= 0 {
+		return
+	}
+	cutoff := a.now().Add(-a.maxAge)
+	candidates := []string{a.path + ".old"}
+	gens := auditGenerations(a.path)
+	for i := len(gens) - 1; i >= 0; i-- {
+		candidates = append(candidates, gens[i].path)
+	}
+	for _, p := range candidates {
+		if info, err := os.Stat(p); err == nil && info.ModTime().Before(cutoff) {
+			if a.dropGeneration(p) != nil {
+				return
+			}
+		}
+	}
+}
+
+// dropGeneration deletes a rotated generation from the head of the log
+// after anchoring the chain at its last entry. Without the anchor the
+// generation is kept: deleting it would look like tampering.
+func (a *AuditLog) dropGeneration(p string) error {
+	if err := writeAuditAnchor(a.path, p, a.key); err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+// compressFile gzips src into dst (via a temp file and rename, keeping
+// src's modification time for age pruning) and removes src. On failure
+// src is left in place.
+func compressFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("compress audit log: %w", err)
+	}
+	defer func() { _ = in.Close() }()
+	info, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("compress audit log: %w", err)
+	}
+
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("compress audit log: %w", err)
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		_ = os.Chtimes(tmp, info.ModTime(), info.ModTime())
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("compress audit log: %w", err)
+	}
+	_ = in.Close()
+	return os.Remove(src)
+}
+
+// openAuditFile opens one audit file for reading, transparently
+// decompressing rotated .gz generations.
+func openAuditFile(p string) (io.ReadCloser, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(p, ".gz") {
+		return f, nil
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return gzipFile{zr, f}, nil
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g gzipFile) Close() error {
+	_ = g.Reader.Close()
+	return g.f.Close()
+}
+
+// lockAudit takes an exclusive lock on path+".lock" so that rotation
+// and append are atomic with respect to other dddns processes writing
+// the same log. The lock lives in a sibling file because the log itself
+// is renamed away on rotation.
+func lockAudit(path string) (unlock func(), err error) {
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("lock audit log: %w", err)
+	}
+	if err := lockFile(f); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("lock audit log: %w", err)
+	}
+	return func() {
+		_ = unlockFile(f)
+		_ = f.Close()
+	}, nil
+}
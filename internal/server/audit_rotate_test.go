@@ -0,0 +1,122 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestAuditLog_KeepsGenerations checks the shift-and-drop of rotated
+// generations and that every kept entry reads back in order.
+func TestAuditLog_KeepsGenerations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	a := NewAuditLog(path)
+	a.maxSize = 1 // every entry after the first rotates
+	a.maxFiles = 3
+	for i := 0; i < 6; i++ {
+		if err := a.Write(AuditEntry{Hostname: string(rune('a' + i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{path + ".3.gz", path + ".2.gz", path + ".1.gz", path}
+	got := AuditFiles(path)
+	if len(got) != len(want) {
+		t.Fatalf("AuditFiles = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("AuditFiles[%d] = %s, want %s", i, got[i], want[i])
+		}
+	}
+
+	entries, skipped, err := ReadAudit(path)
+	if err != nil || skipped != 0 {
+		t.Fatalf("ReadAudit: %v, skipped %d", err, skipped)
+	}
+	var hosts string
+	for _, e := range entries {
+		hosts += e.Hostname
+	}
+	if hosts != "cdef" {
+		t.Errorf("entries = %q, want the newest four in order", hosts)
+	}
+}
+
+func TestAuditLog_PrunesByAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	old := time.Now().Add(-48 * time.Hour)
+	for _, p := range []string{path + ".old", path + ".2.gz"} {
+		if err := os.WriteFile(p, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		_ = os.Chtimes(p, old, old)
+	}
+	if err := os.WriteFile(path+".1.gz", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewAuditLog(path)
+	a.maxAge = 24 * time.Hour
+	if err := a.Write(AuditEntry{Action: "updated"}); err != nil {
+		t.Fatal(err)
+	}
+	for p, want := range map[string]bool{path + ".old": false, path + ".2.gz": false, path + ".1.gz": true} {
+		if _, err := os.Stat(p); (err == nil) != want {
+			t.Errorf("%s exists = %v, want %v", filepath.Base(p), err == nil, want)
+		}
+	}
+}
+
+// TestAuditLog_UncompressedGenerationShifts covers a rotation whose
+// compression was interrupted: the plain file is still a generation.
+func TestAuditLog_UncompressedGenerationShifts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	writeAuditLines(t, path+".1", time.Now(), AuditEntry{Hostname: "a"})
+	writeAuditLines(t, path, time.Now(), AuditEntry{Hostname: "b"})
+
+	a := NewAuditLog(path)
+	a.maxSize = 1
+	if err := a.Write(AuditEntry{Hostname: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".2"); err != nil {
+		t.Errorf("uncompressed generation not shifted: %v", err)
+	}
+	entries, _, _ := ReadAudit(path)
+	if len(entries) != 3 || entries[0].Hostname != "a" || entries[2].Hostname != "c" {
+		t.Errorf("entries = %+v", entries)
+	}
+}
+
+// TestLockAudit_Exclusive checks that a second holder waits for the
+// first to release. flock locks are per open file, so two opens in one
+// process contend the same way two processes do.
+func TestLockAudit_Exclusive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	unlock, err := lockAudit(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acquired := make(chan struct{})
+	go func() {
+		u, err := lockAudit(path)
+		if err == nil {
+			u()
+		}
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("second lock acquired while the first was held")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-acquired:
+	case <-time.After(2 * time.Second):
+		t.Fatal("second lock never acquired")
+	}
+}
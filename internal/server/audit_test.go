@@ -9,6 +9,8 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/descoped/dddns/internal/logsink"
 )
 
 func TestAuditLog_BasicWrite(t *testing.T) {
@@ -76,8 +78,8 @@ func TestAuditLog_AppendsMultipleLines(t *testing.T) {
 }
 
 // TestAuditLog_RotatesAtThreshold verifies that when the file exceeds the
-// configured size, it is renamed to path+".old" and the next write starts
-// a fresh file.
+// configured size, it is compressed to path+".1.gz" and the next write
+// starts a fresh file.
 func TestAuditLog_RotatesAtThreshold(t *testing.T) {
 	tmpDir := t.TempDir()
 	path := filepath.Join(tmpDir, "audit.log")
@@ -95,9 +97,9 @@ func TestAuditLog_RotatesAtThreshold(t *testing.T) {
 		}
 	}
 
-	// An .old file should now exist.
-	if _, err := os.Stat(path + ".old"); err != nil {
-		t.Errorf("expected rotated file at %s: %v", path+".old", err)
+	// A compressed first generation should now exist.
+	if _, err := os.Stat(path + ".1.gz"); err != nil {
+		t.Errorf("expected rotated file at %s: %v", path+".1.gz", err)
 	}
 
 	// And the live file should still be valid JSONL with at least one line.
@@ -194,3 +196,96 @@ func TestAuditLog_TimestampInjectable(t *testing.T) {
 		t.Errorf("Timestamp = %v, want %v", got.Timestamp, fixed)
 	}
 }
+
+// recordingSink captures forwarded records. With block set, each
+// Write waits until block is closed, like a stalled collector.
+type recordingSink struct {
+	mu      sync.Mutex
+	records []logsink.Record
+	block   chan struct{}
+}
+
+func (s *recordingSink) Write(r logsink.Record) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, r)
+	return nil
+}
+
+func (s *recordingSink) Close() error { return nil }
+
+// TestAuditLog_ForwardsToSinks verifies that each entry reaches the
+// configured sinks with one field per entry field, including the chain
+// hash, after it has been appended to the file.
+func TestAuditLog_ForwardsToSinks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log := NewAuditLog(path)
+	sink := &recordingSink{}
+	log.SetSinks(sink)
+
+	if err := log.Write(AuditEntry{RemoteAddr: "10.0.0.7:5000", AuthOutcome: "bad"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := log.Close(); err != nil { // delivers the queue
+		t.Fatal(err)
+	}
+	if len(sink.records) != 1 {
+		t.Fatalf("forwarded %d records, want 1", len(sink.records))
+	}
+	r := sink.records[0]
+	if r.Severity != logsink.SevWarning || r.MsgID != "audit" {
+		t.Errorf("record = %+v", r)
+	}
+	fields := map[string]string{}
+	for _, f := range r.Fields {
+		fields[f.Key] = f.Value
+	}
+	if fields["remote"] != "10.0.0.7:5000" || fields["auth"] != "bad" || fields["seq"] != "1" || len(fields["hash"]) != 64 {
+		t.Errorf("fields = %v", fields)
+	}
+}
+
+// TestAuditLog_StalledSinkDoesNotBlock verifies a sink that never
+// answers delays neither the file append nor another writer waiting
+// for the file lock.
+func TestAuditLog_StalledSinkDoesNotBlock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	stalled := &recordingSink{block: make(chan struct{})}
+	log := NewAuditLog(path)
+	log.SetSinks(stalled)
+	other := NewAuditLog(path) // another process writing the same file
+
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < 3; i++ {
+			if err := log.Write(AuditEntry{Action: "updated"}); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- other.Write(AuditEntry{Action: "rotate-secret"})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Write blocked on a stalled sink")
+	}
+
+	entries, _, err := ReadAudit(path)
+	if err != nil || len(entries) != 4 {
+		t.Fatalf("file has %d entries (%v), want 4", len(entries), err)
+	}
+	close(stalled.block)
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(stalled.records) != 3 {
+		t.Errorf("sink got %d records after it recovered, want 3", len(stalled.records))
+	}
+}
@@ -1,89 +1,411 @@
 package server
 
 import (
+	"container/list"
 	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
+
+	"github.com/descoped/dddns/internal/config"
+	"github.com/descoped/dddns/internal/dyndns"
+	"github.com/descoped/dddns/internal/lockout"
+	"github.com/descoped/dddns/internal/logging"
+	"github.com/descoped/dddns/internal/reqsign"
+)
+
+// Default lockout policy (layer L3 in the security model): if
+// MaxFailuresPerWindow or more auth failures from one client occur
+// within FailureWindow of each other, reject every subsequent attempt
+// from that client for LockoutDuration. Other clients are unaffected, so
+// a noisy LAN host cannot lock the router out. The policy itself lives
+// in internal/lockout, shared with the Lambda.
+const (
+	MaxFailuresPerWindow = lockout.MaxFailures
+	FailureWindow        = lockout.FailureWindow
+	LockoutDuration      = lockout.Duration
 )
 
-// Lockout policy (layer L3 in the security model): if MaxFailuresPerWindow
-// or more auth failures occur within FailureWindow of each other, reject
-// every subsequent attempt for LockoutDuration. This is a sliding window
-// keyed on the process — an attacker who can restart dddns loses little
-// because the supervisor respawns on a 5-second backoff.
+// Default rate limit for authenticated requests. inadyn pushes a handful
+// of times per day; ten per minute leaves headroom for manual
+// `dddns serve test` runs while stopping a misbehaving client from
+// hammering Route53.
 const (
-	MaxFailuresPerWindow = 5
-	FailureWindow        = 60 * time.Second
-	LockoutDuration      = 5 * time.Minute
+	DefaultRateLimit       = 10
+	DefaultRateLimitWindow = 60 * time.Second
 )
 
-// AuthResult is the three-valued outcome of Authenticator.Check.
-type AuthResult int
+// DefaultMaxClients bounds the per-client tracking table. When full, the
+// least recently seen client without lockout state is evicted; with none
+// left, new clients are refused (AuthRateLimited).
+const DefaultMaxClients = 1024
+
+// AuthResult is the outcome of Authenticator.Check — the dyndns
+// pipeline's, which the handler hands it to.
+type AuthResult = dyndns.AuthResult
 
 const (
-	AuthOK AuthResult = iota
-	AuthBadCredentials
-	AuthLockedOut
+	AuthOK             = dyndns.AuthOK
+	AuthBadCredentials = dyndns.AuthBadCredentials
+	AuthLockedOut      = dyndns.AuthLockedOut
+	AuthRateLimited    = dyndns.AuthRateLimited
 )
 
-// Authenticator verifies a Basic Auth password against a shared secret
-// and enforces the sliding-window lockout. The zero value is not usable —
-// construct with NewAuthenticator.
+// Policy holds the lockout and rate-limit thresholds. Build one with
+// DefaultPolicy or PolicyFromConfig.
+type Policy struct {
+	MaxFailures     int
+	FailureWindow   time.Duration
+	LockoutDuration time.Duration
+	RateLimit       int // successful requests per RateWindow per client
+	RateWindow      time.Duration
+	MaxClients      int
+	SignatureWindow time.Duration // clock skew accepted on HMAC-signed requests
+}
+
+// DefaultPolicy returns the package-default thresholds.
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxFailures:     MaxFailuresPerWindow,
+		FailureWindow:   FailureWindow,
+		LockoutDuration: LockoutDuration,
+		RateLimit:       DefaultRateLimit,
+		RateWindow:      DefaultRateLimitWindow,
+		MaxClients:      DefaultMaxClients,
+		SignatureWindow: reqsign.DefaultWindow,
+	}
+}
+
+// lockoutRule is p's lockout thresholds.
+func (p Policy) lockoutRule() lockout.Rule {
+	return lockout.Rule{MaxFailures: p.MaxFailures, FailureWindow: p.FailureWindow, Duration: p.LockoutDuration}
+}
+
+// PolicyFromConfig overlays the non-zero thresholds from s onto
+// DefaultPolicy. Malformed durations fall back to the default;
+// ServerConfig.Validate reports them at startup.
+func PolicyFromConfig(s *config.ServerConfig) Policy {
+	p := DefaultPolicy()
+	if s == nil {
+		return p
+	}
+	if s.MaxAuthFailures > 0 {
+		p.MaxFailures = s.MaxAuthFailures
+	}
+	if s.RateLimit > 0 {
+		p.RateLimit = s.RateLimit
+	}
+	p.FailureWindow = config.DurationOrDefault(s.AuthFailureWindow, p.FailureWindow)
+	p.LockoutDuration = config.DurationOrDefault(s.LockoutDuration, p.LockoutDuration)
+	p.RateWindow = config.DurationOrDefault(s.RateLimitWindow, p.RateWindow)
+	p.SignatureWindow = config.DurationOrDefault(s.SignatureWindow, p.SignatureWindow)
+	return p
+}
+
+// clientState is the per-client tracking record. Stored in an LRU list;
+// the element's Value is the *clientState.
+type clientState struct {
+	key string
+	lockout.State
+	requests []time.Time
+}
+
+// Authenticator verifies a Basic Auth password or an HMAC request
+// signature against a shared secret and enforces per-client lockout and
+// rate limiting. The zero value is not usable — construct with
+// NewAuthenticator.
 //
 // All methods are safe for concurrent use.
 type Authenticator struct {
-	secret []byte
+	secret   []byte
+	policy   Policy
+	verifier *reqsign.Verifier
 
-	mu          sync.Mutex
-	failures    []time.Time
-	lockedUntil time.Time
-	now         func() time.Time // override for deterministic tests
+	mu        sync.Mutex
+	clients   map[string]*list.Element
+	lru       *list.List // front = most recently seen
+	statePath string     // empty = lockout state is not persisted
+	stateSeq  uint64     // numbers lockout-table snapshots
+	now       func() time.Time
+	logger    *slog.Logger // persistence errors; defaults to discard
+
+	// writeMu serialises state-file writes, which happen after mu is
+	// released; writtenSeq is the newest snapshot on disk.
+	writeMu    sync.Mutex
+	writtenSeq uint64
 }
 
 // NewAuthenticator returns an Authenticator bound to the given shared
-// secret. The secret is stored verbatim — the caller is expected to have
-// already decrypted it from config.secure if applicable.
-func NewAuthenticator(secret string) *Authenticator {
+// secret and thresholds. The secret is stored verbatim — the caller is
+// expected to have already decrypted it from config.secure if applicable.
+func NewAuthenticator(secret string, policy Policy) *Authenticator {
+	if policy.MaxClients <= 0 {
+		policy.MaxClients = DefaultMaxClients
+	}
 	return &Authenticator{
-		secret: []byte(secret),
-		now:    time.Now,
+		secret:   []byte(secret),
+		policy:   policy,
+		verifier: reqsign.NewVerifier(policy.SignatureWindow, 0),
+		clients:  make(map[string]*list.Element),
+		lru:      list.New(),
+		now:      time.Now,
+		logger:   logging.Discard(),
 	}
 }
 
-// Check returns AuthOK on a matching password, AuthLockedOut if the
-// Authenticator is in a lockout window, and AuthBadCredentials otherwise
-// (after recording the failure for lockout tracking).
+// errBadPassword is the check error for a Basic Auth mismatch.
+var errBadPassword = errors.New("password mismatch")
+
+// errTableFull is the check error for a new client turned away because
+// every tracked client still carries lockout state.
+var errTableFull = errors.New("client table full of failing clients")
+
+// Check authenticates password for the client at remoteAddr ("host:port"
+// or a bare IP). It returns AuthLockedOut while that client is inside a
+// lockout window, AuthBadCredentials on a mismatch (recording the failure),
+// AuthRateLimited when a correct password exceeds the client's request
+// budget, and AuthOK otherwise.
 //
-// A successful authentication clears the pending-failures tally —
+// A successful authentication clears the client's pending-failures tally —
 // legitimate callers do not pay for historical typos.
-func (a *Authenticator) Check(password string) AuthResult {
+func (a *Authenticator) Check(remoteAddr, password string) AuthResult {
+	res, _ := a.check(remoteAddr, func() error {
+		if subtle.ConstantTimeCompare([]byte(password), a.secret) != 1 {
+			return errBadPassword
+		}
+		return nil
+	})
+	return res
+}
+
+// CheckSigned is Check for an HMAC-signed request (see internal/reqsign).
+// Expired, replayed and malformed signatures count as failures toward
+// the client's lockout exactly like a wrong password; the returned error
+// names the reason for the audit log.
+func (a *Authenticator) CheckSigned(remoteAddr, authorization, method, path string, query url.Values) (AuthResult, error) {
+	return a.check(remoteAddr, func() error {
+		return a.verifier.Verify(authorization, string(a.secret), method, path, query)
+	})
+}
+
+// check runs verify under the client's lockout and rate-limit state.
+// verify is only called outside a lockout, so a locked-out client cannot
+// consume nonces or probe the signature check. A failure's state-file
+// write happens after a.mu is released.
+func (a *Authenticator) check(remoteAddr string, verify func() error) (AuthResult, error) {
+	res, snap, err := a.checkLocked(remoteAddr, verify)
+	if snap != nil {
+		a.writeState(snap)
+	}
+	return res, err
+}
+
+func (a *Authenticator) checkLocked(remoteAddr string, verify func() error) (AuthResult, *stateSnapshot, error) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
 	now := a.now()
-	if now.Before(a.lockedUntil) {
-		return AuthLockedOut
+	c := a.client(lockout.Key(remoteAddr), now)
+	if c == nil {
+		return AuthRateLimited, nil, errTableFull
+	}
+	if c.Locked(now) {
+		return AuthLockedOut, nil, nil
+	}
+
+	if err := verify(); err != nil {
+		a.policy.lockoutRule().Fail(&c.State, now)
+		return AuthBadCredentials, a.snapshot(now), err
+	}
+
+	c.Succeed()
+	if a.policy.RateLimit > 0 {
+		c.requests = lockout.Prune(c.requests, now.Add(-a.policy.RateWindow))
+		if len(c.requests) >= a.policy.RateLimit {
+			return AuthRateLimited, nil, nil
+		}
+		c.requests = append(c.requests, now)
+	}
+	return AuthOK, nil, nil
+}
+
+// LockedClients returns how many clients are currently inside a lockout
+// window. Used by the /metrics lockout gauge.
+func (a *Authenticator) LockedClients() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	n := 0
+	for e := a.lru.Front(); e != nil; e = e.Next() {
+		if e.Value.(*clientState).Locked(now) {
+			n++
+		}
 	}
+	return n
+}
 
-	if subtle.ConstantTimeCompare([]byte(password), a.secret) == 1 {
-		a.failures = a.failures[:0]
-		return AuthOK
+// client returns the state for key, creating it if needed. A full
+// table makes room by evicting its least recently seen clean entry —
+// one whose failures and lockout have expired — never one that still
+// counts toward a lockout, so a flood of fresh source keys cannot push
+// a locked-out client out of the table. With no clean entry to evict,
+// client returns nil. Caller must hold a.mu.
+func (a *Authenticator) client(key string, now time.Time) *clientState {
+	if e, ok := a.clients[key]; ok {
+		a.lru.MoveToFront(e)
+		return e.Value.(*clientState)
+	}
+	if a.lru.Len() >= a.policy.MaxClients && !a.evictClean(now) {
+		return nil
 	}
+	c := &clientState{key: key}
+	a.clients[key] = a.lru.PushFront(c)
+	return c
+}
 
-	// Record failure and prune the sliding window.
-	a.failures = append(a.failures, now)
-	cutoff := now.Add(-FailureWindow)
-	kept := a.failures[:0]
-	for _, t := range a.failures {
-		if t.After(cutoff) {
-			kept = append(kept, t)
+// evictClean removes the least recently seen clean entry, reporting
+// whether there was one. Caller must hold a.mu.
+func (a *Authenticator) evictClean(now time.Time) bool {
+	rule := a.policy.lockoutRule()
+	for e := a.lru.Back(); e != nil; e = e.Prev() {
+		c := e.Value.(*clientState)
+		if now.Before(rule.Expires(c.State)) {
+			continue
 		}
+		a.lru.Remove(e)
+		delete(a.clients, c.key)
+		return true
 	}
-	a.failures = kept
+	return false
+}
+
+// --- persistence ---
 
-	if len(a.failures) >= MaxFailuresPerWindow {
-		a.lockedUntil = now.Add(LockoutDuration)
-		a.failures = a.failures[:0]
+// persistedState is the on-disk form of the lockout table. Only clients
+// with pending failures or an active lockout are written; rate-limit
+// windows are short-lived and deliberately not persisted.
+type persistedState struct {
+	Clients []persistedClient `json:"clients"`
+}
+
+type persistedClient struct {
+	Key string `json:"key"`
+	lockout.State
+}
+
+// Persist loads any lockout state saved at path and arranges for every
+// subsequent failure to be written back, so a crash loop cannot reset
+// an attacker's lockout. A missing file is not an error. logger receives
+// write-side errors, which never fail the auth check itself.
+func (a *Authenticator) Persist(path string, logger *slog.Logger) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.statePath = path
+	if logger != nil {
+		a.logger = logger
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("read lockout state: %w", err)
+	}
+	var st persistedState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("parse lockout state: %w", err)
+	}
+	now := a.now()
+	for _, pc := range st.Clients {
+		c := a.client(pc.Key, now)
+		if c == nil {
+			break
+		}
+		c.Failures = lockout.Prune(append(c.Failures[:0], pc.Failures...), now.Add(-a.policy.FailureWindow))
+		c.LockedUntil = pc.LockedUntil
+	}
+	return nil
+}
+
+// stateSnapshot is the lockout table as of one failure, numbered so
+// that a slower writer never overwrites a newer snapshot.
+type stateSnapshot struct {
+	seq  uint64
+	path string
+	st   persistedState
+}
+
+// snapshot captures the lockout table for writeState, or returns nil
+// when state is not persisted. Caller must hold a.mu.
+func (a *Authenticator) snapshot(now time.Time) *stateSnapshot {
+	if a.statePath == "" {
+		return nil
+	}
+	a.stateSeq++
+	st := persistedState{Clients: []persistedClient{}}
+	for e := a.lru.Back(); e != nil; e = e.Prev() {
+		c := e.Value.(*clientState)
+		locked := c.Locked(now)
+		if len(c.Failures) == 0 && !locked {
+			continue
+		}
+		pc := persistedClient{Key: c.key, State: lockout.State{Failures: c.Failures}}
+		if locked {
+			pc.LockedUntil = c.LockedUntil
+		}
+		st.Clients = append(st.Clients, pc)
+	}
+	return &stateSnapshot{seq: a.stateSeq, path: a.statePath, st: st}
+}
+
+// writeState writes snap atomically unless a newer snapshot is already
+// on disk, so concurrent failures coalesce instead of queueing writes.
+// It runs without a.mu: a slow disk delays only the failing request.
+func (a *Authenticator) writeState(snap *stateSnapshot) {
+	a.writeMu.Lock()
+	defer a.writeMu.Unlock()
+	if snap.seq <= a.writtenSeq {
+		return
+	}
+	if err := writeJSONAtomic(snap.path, snap.st); err != nil {
+		a.logger.Error("lockout state write failed", logging.Err(err))
+		return
+	}
+	a.writtenSeq = snap.seq
+}
+
+// writeJSONAtomic writes v as JSON to path via temp file + rename, so a
+// reader (or a crash) never observes a partial file.
+func writeJSONAtomic(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("create tmp: %w", err)
+	}
+	tmpName := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpName)
+		return fmt.Errorf("write tmp: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmpName)
+		return fmt.Errorf("close tmp: %w", err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		_ = os.Remove(tmpName)
+		return fmt.Errorf("rename tmp: %w", err)
 	}
-	return AuthBadCredentials
+	return nil
 }
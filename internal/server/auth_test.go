@@ -1,13 +1,24 @@
 package server
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/descoped/dddns/internal/config"
+	"github.com/descoped/dddns/internal/reqsign"
 )
 
-const testSecret = "super-secret-value"
+const (
+	testSecret = "super-secret-value"
+	testRemote = "192.168.1.1:40000"
+)
 
 // fakeClock returns a stubbed now() that tests advance manually.
 type fakeClock struct{ t time.Time }
@@ -20,28 +31,28 @@ func (f *fakeClock) advance(d time.Duration) {
 func newTestAuth(t *testing.T) (*Authenticator, *fakeClock) {
 	t.Helper()
 	fc := &fakeClock{t: time.Unix(1_700_000_000, 0)}
-	a := NewAuthenticator(testSecret)
+	a := NewAuthenticator(testSecret, DefaultPolicy())
 	a.now = fc.now
 	return a, fc
 }
 
 func TestAuth_OK(t *testing.T) {
 	a, _ := newTestAuth(t)
-	if got := a.Check(testSecret); got != AuthOK {
+	if got := a.Check(testRemote, testSecret); got != AuthOK {
 		t.Errorf("Check(correct) = %v, want AuthOK", got)
 	}
 }
 
 func TestAuth_BadCredentials(t *testing.T) {
 	a, _ := newTestAuth(t)
-	if got := a.Check("wrong"); got != AuthBadCredentials {
+	if got := a.Check(testRemote, "wrong"); got != AuthBadCredentials {
 		t.Errorf("Check(wrong) = %v, want AuthBadCredentials", got)
 	}
 }
 
 func TestAuth_EmptyPassword(t *testing.T) {
 	a, _ := newTestAuth(t)
-	if got := a.Check(""); got != AuthBadCredentials {
+	if got := a.Check(testRemote, ""); got != AuthBadCredentials {
 		t.Errorf("Check(empty) = %v, want AuthBadCredentials", got)
 	}
 }
@@ -53,14 +64,14 @@ func TestAuth_LockoutTriggersAfterThreshold(t *testing.T) {
 	a, fc := newTestAuth(t)
 
 	for i := 0; i < MaxFailuresPerWindow; i++ {
-		if got := a.Check("wrong"); got != AuthBadCredentials {
+		if got := a.Check(testRemote, "wrong"); got != AuthBadCredentials {
 			t.Fatalf("failure %d: got %v, want AuthBadCredentials", i, got)
 		}
 		fc.advance(1 * time.Second)
 	}
 
 	// Correct password should still be rejected while lockout is active.
-	if got := a.Check(testSecret); got != AuthLockedOut {
+	if got := a.Check(testRemote, testSecret); got != AuthLockedOut {
 		t.Errorf("Check(correct) during lockout = %v, want AuthLockedOut", got)
 	}
 }
@@ -72,13 +83,13 @@ func TestAuth_FailuresOutsideWindowDoNotCount(t *testing.T) {
 
 	// 4 failures right now.
 	for i := 0; i < 4; i++ {
-		a.Check("wrong")
+		a.Check(testRemote, "wrong")
 	}
 	// Advance beyond the window so those 4 age out.
 	fc.advance(FailureWindow + 1*time.Second)
 	// A 5th failure now should NOT trip the lockout.
-	a.Check("wrong")
-	if got := a.Check(testSecret); got != AuthOK {
+	a.Check(testRemote, "wrong")
+	if got := a.Check(testRemote, testSecret); got != AuthOK {
 		t.Errorf("Check(correct) after aged-out failures = %v, want AuthOK", got)
 	}
 }
@@ -89,14 +100,14 @@ func TestAuth_LockoutExpires(t *testing.T) {
 	a, fc := newTestAuth(t)
 	// Trip the lockout.
 	for i := 0; i < MaxFailuresPerWindow; i++ {
-		a.Check("wrong")
+		a.Check(testRemote, "wrong")
 	}
-	if got := a.Check(testSecret); got != AuthLockedOut {
+	if got := a.Check(testRemote, testSecret); got != AuthLockedOut {
 		t.Fatalf("precondition: expected lockout, got %v", got)
 	}
 	// Advance beyond LockoutDuration.
 	fc.advance(LockoutDuration + 1*time.Second)
-	if got := a.Check(testSecret); got != AuthOK {
+	if got := a.Check(testRemote, testSecret); got != AuthOK {
 		t.Errorf("Check(correct) after lockout expired = %v, want AuthOK", got)
 	}
 }
@@ -107,17 +118,17 @@ func TestAuth_LockoutExpires(t *testing.T) {
 func TestAuth_SuccessClearsFailureTally(t *testing.T) {
 	a, _ := newTestAuth(t)
 	for i := 0; i < MaxFailuresPerWindow-1; i++ {
-		a.Check("wrong")
+		a.Check(testRemote, "wrong")
 	}
 	// One success clears the count.
-	if got := a.Check(testSecret); got != AuthOK {
+	if got := a.Check(testRemote, testSecret); got != AuthOK {
 		t.Fatalf("Check(correct) = %v, want AuthOK", got)
 	}
 	// Now MaxFailuresPerWindow-1 more failures should not lock us out.
 	for i := 0; i < MaxFailuresPerWindow-1; i++ {
-		a.Check("wrong")
+		a.Check(testRemote, "wrong")
 	}
-	if got := a.Check(testSecret); got != AuthOK {
+	if got := a.Check(testRemote, testSecret); got != AuthOK {
 		t.Errorf("Check(correct) = %v, want AuthOK (success should have cleared tally)", got)
 	}
 }
@@ -125,13 +136,13 @@ func TestAuth_SuccessClearsFailureTally(t *testing.T) {
 // TestAuth_ConcurrentSafety runs many Check calls in parallel to exercise
 // the lock. The race detector (go test -race) will flag any data race.
 func TestAuth_ConcurrentSafety(t *testing.T) {
-	a := NewAuthenticator(testSecret)
+	a := NewAuthenticator(testSecret, DefaultPolicy())
 
 	const (
 		workers       = 20
 		callsPerGroup = 100
 	)
-	var okCount, badCount, lockedCount atomic.Int64
+	var okCount, badCount, lockedCount, limitedCount atomic.Int64
 
 	var wg sync.WaitGroup
 	wg.Add(workers)
@@ -146,21 +157,255 @@ func TestAuth_ConcurrentSafety(t *testing.T) {
 		go func(pw string) {
 			defer wg.Done()
 			for i := 0; i < callsPerGroup; i++ {
-				switch a.Check(pw) {
+				switch a.Check(testRemote, pw) {
 				case AuthOK:
 					okCount.Add(1)
 				case AuthBadCredentials:
 					badCount.Add(1)
 				case AuthLockedOut:
 					lockedCount.Add(1)
+				case AuthRateLimited:
+					limitedCount.Add(1)
 				}
 			}
 		}(pw)
 	}
 	wg.Wait()
 
-	total := okCount.Load() + badCount.Load() + lockedCount.Load()
+	total := okCount.Load() + badCount.Load() + lockedCount.Load() + limitedCount.Load()
 	if total != int64(workers*callsPerGroup) {
 		t.Errorf("lost calls: total=%d want=%d", total, workers*callsPerGroup)
 	}
 }
+
+// TestAuth_LockoutIsPerClient is the regression for the process-wide
+// lockout DoS: one noisy client tripping the threshold must not lock
+// out a different client presenting the correct secret.
+func TestAuth_LockoutIsPerClient(t *testing.T) {
+	a, _ := newTestAuth(t)
+	const attacker = "192.168.1.66:5000"
+	for i := 0; i < MaxFailuresPerWindow; i++ {
+		a.Check(attacker, "wrong")
+	}
+	if got := a.Check(attacker, testSecret); got != AuthLockedOut {
+		t.Errorf("attacker Check = %v, want AuthLockedOut", got)
+	}
+	if got := a.Check(testRemote, testSecret); got != AuthOK {
+		t.Errorf("router Check = %v, want AuthOK (lockout must be per client)", got)
+	}
+	if got := a.LockedClients(); got != 1 {
+		t.Errorf("LockedClients = %d, want 1", got)
+	}
+}
+
+// TestAuth_IPv6KeyedBySlash64 verifies addresses within one /64 share a
+// lockout — otherwise a host could rotate its interface ID to reset it.
+func TestAuth_IPv6KeyedBySlash64(t *testing.T) {
+	a, _ := newTestAuth(t)
+	for i := 0; i < MaxFailuresPerWindow; i++ {
+		a.Check(fmt.Sprintf("[2001:db8:1:2::%x]:443", i+1), "wrong")
+	}
+	if got := a.Check("[2001:db8:1:2::ffff]:443", testSecret); got != AuthLockedOut {
+		t.Errorf("same /64 Check = %v, want AuthLockedOut", got)
+	}
+	if got := a.Check("[2001:db8:1:3::1]:443", testSecret); got != AuthOK {
+		t.Errorf("other /64 Check = %v, want AuthOK", got)
+	}
+}
+
+func TestAuth_RateLimitOnSuccess(t *testing.T) {
+	a, fc := newTestAuth(t)
+	for i := 0; i < DefaultRateLimit; i++ {
+		if got := a.Check(testRemote, testSecret); got != AuthOK {
+			t.Fatalf("request %d: got %v, want AuthOK", i, got)
+		}
+	}
+	if got := a.Check(testRemote, testSecret); got != AuthRateLimited {
+		t.Errorf("request over budget = %v, want AuthRateLimited", got)
+	}
+	// Another client has its own budget.
+	if got := a.Check("192.168.1.2:1", testSecret); got != AuthOK {
+		t.Errorf("other client = %v, want AuthOK", got)
+	}
+	// Budget refills once the window slides past.
+	fc.advance(DefaultRateLimitWindow + time.Second)
+	if got := a.Check(testRemote, testSecret); got != AuthOK {
+		t.Errorf("after window = %v, want AuthOK", got)
+	}
+}
+
+func TestAuth_RateLimitDisabledWithZero(t *testing.T) {
+	p := DefaultPolicy()
+	p.RateLimit = 0
+	a := NewAuthenticator(testSecret, p)
+	for i := 0; i < 5*DefaultRateLimit; i++ {
+		if got := a.Check(testRemote, testSecret); got != AuthOK {
+			t.Fatalf("request %d: got %v, want AuthOK with rate limit disabled", i, got)
+		}
+	}
+}
+
+// TestAuth_LRUEvictsLeastRecentlySeen bounds memory: a flood of distinct
+// source addresses cannot grow the table past MaxClients.
+func TestAuth_LRUEvictsLeastRecentlySeen(t *testing.T) {
+	p := DefaultPolicy()
+	p.MaxClients = 3
+	a := NewAuthenticator(testSecret, p)
+	for i := 1; i <= 10; i++ {
+		a.Check(fmt.Sprintf("10.0.0.%d:1", i), testSecret)
+	}
+	if got := a.lru.Len(); got != 3 {
+		t.Errorf("table size = %d, want 3", got)
+	}
+	if _, ok := a.clients["10.0.0.1"]; ok {
+		t.Error("oldest client should have been evicted")
+	}
+	if _, ok := a.clients["10.0.0.10"]; !ok {
+		t.Error("newest client should be retained")
+	}
+}
+
+// TestAuth_FullTableKeepsLockedClients verifies an attacker cannot
+// reset its own lockout by flooding failures from fresh source keys:
+// entries with lockout state are never evicted, and new keys are
+// refused while no clean entry is left.
+func TestAuth_FullTableKeepsLockedClients(t *testing.T) {
+	a, fc := newTestAuth(t)
+	a.policy.MaxClients = 3
+	for range a.policy.MaxFailures {
+		a.Check(testRemote, "wrong")
+	}
+	a.Check("10.0.0.1:1", testSecret) // clean
+	a.Check("10.0.0.2:1", "wrong")    // pending failure
+
+	if got := a.Check("10.0.0.3:1", "wrong"); got != AuthBadCredentials {
+		t.Errorf("new key with a clean entry to evict = %v, want AuthBadCredentials", got)
+	}
+	if _, ok := a.clients["10.0.0.1"]; ok {
+		t.Error("the clean entry should have been evicted")
+	}
+	if got, err := a.CheckSigned("10.0.0.4:1", "", "GET", "/nic/update", nil); got != AuthRateLimited || !errors.Is(err, errTableFull) {
+		t.Errorf("new key with no clean entry = %v, %v; want AuthRateLimited, errTableFull", got, err)
+	}
+	if got := a.Check(testRemote, testSecret); got != AuthLockedOut {
+		t.Errorf("locked client after the flood = %v, want AuthLockedOut", got)
+	}
+
+	fc.advance(a.policy.LockoutDuration)
+	if got := a.Check("10.0.0.4:1", testSecret); got != AuthOK {
+		t.Errorf("new key once the lockouts expired = %v, want AuthOK", got)
+	}
+}
+
+func TestPolicyFromConfig(t *testing.T) {
+	if got := PolicyFromConfig(nil); got != DefaultPolicy() {
+		t.Errorf("nil config = %+v, want defaults", got)
+	}
+	got := PolicyFromConfig(&config.ServerConfig{
+		MaxAuthFailures:   3,
+		AuthFailureWindow: "2m",
+		LockoutDuration:   "1h",
+		RateLimit:         2,
+		RateLimitWindow:   "10s",
+		SignatureWindow:   "90s",
+	})
+	want := Policy{
+		MaxFailures:     3,
+		FailureWindow:   2 * time.Minute,
+		LockoutDuration: time.Hour,
+		RateLimit:       2,
+		RateWindow:      10 * time.Second,
+		MaxClients:      DefaultMaxClients,
+		SignatureWindow: 90 * time.Second,
+	}
+	if got != want {
+		t.Errorf("PolicyFromConfig = %+v, want %+v", got, want)
+	}
+}
+
+// TestAuth_PersistSurvivesRestart simulates a crash loop: a client is
+// locked out, the process dies, a fresh Authenticator loads the state
+// file, and the lockout still holds.
+func TestAuth_PersistSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "serve-lockout.json")
+	fc := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+
+	first := NewAuthenticator(testSecret, DefaultPolicy())
+	first.now = fc.now
+	if err := first.Persist(path, nil); err != nil {
+		t.Fatalf("Persist on missing file: %v", err)
+	}
+	for i := 0; i < MaxFailuresPerWindow; i++ {
+		first.Check(testRemote, "wrong")
+	}
+
+	fc.advance(time.Minute)
+	second := NewAuthenticator(testSecret, DefaultPolicy())
+	second.now = fc.now
+	if err := second.Persist(path, nil); err != nil {
+		t.Fatalf("Persist reload: %v", err)
+	}
+	if got := second.Check(testRemote, testSecret); got != AuthLockedOut {
+		t.Errorf("after restart Check = %v, want AuthLockedOut", got)
+	}
+
+	fc.advance(LockoutDuration)
+	if got := second.Check(testRemote, testSecret); got != AuthOK {
+		t.Errorf("after expiry Check = %v, want AuthOK", got)
+	}
+}
+
+// TestAuth_PersistCarriesPendingFailures verifies sub-threshold failures
+// also survive, so each restart doesn't grant a fresh set of guesses.
+func TestAuth_PersistCarriesPendingFailures(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "serve-lockout.json")
+	fc := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+
+	first := NewAuthenticator(testSecret, DefaultPolicy())
+	first.now = fc.now
+	_ = first.Persist(path, nil)
+	for i := 0; i < MaxFailuresPerWindow-1; i++ {
+		first.Check(testRemote, "wrong")
+	}
+
+	second := NewAuthenticator(testSecret, DefaultPolicy())
+	second.now = fc.now
+	_ = second.Persist(path, nil)
+	second.Check(testRemote, "wrong")
+	if got := second.Check(testRemote, testSecret); got != AuthLockedOut {
+		t.Errorf("Check = %v, want AuthLockedOut (failures should carry over)", got)
+	}
+}
+
+func TestAuth_PersistRejectsCorruptState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "serve-lockout.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	a := NewAuthenticator(testSecret, DefaultPolicy())
+	if err := a.Persist(path, nil); err == nil {
+		t.Error("expected parse error for corrupt state file")
+	}
+}
+
+// TestAuth_CheckSignedReplayCountsAsFailure verifies signed requests share
+// the lockout budget: a replayed signature is a failure like a bad
+// password, and enough of them lock the client out.
+func TestAuth_CheckSignedReplayCountsAsFailure(t *testing.T) {
+	a := NewAuthenticator(testSecret, Policy{MaxFailures: 2, FailureWindow: time.Minute, LockoutDuration: time.Minute})
+	q := url.Values{"hostname": {testHostname}}
+	authz := reqsign.Header(testSecret, "GET", "/nic/update", q, time.Now(), "0123456789abcdef0123456789abcdef")
+
+	if res, err := a.CheckSigned(testRemote, authz, "GET", "/nic/update", q); res != AuthOK || err != nil {
+		t.Fatalf("first CheckSigned = %v, %v; want AuthOK", res, err)
+	}
+	res, err := a.CheckSigned(testRemote, authz, "GET", "/nic/update", q)
+	if res != AuthBadCredentials || !errors.Is(err, reqsign.ErrReplay) {
+		t.Fatalf("replay = %v, %v; want AuthBadCredentials/ErrReplay", res, err)
+	}
+	forged := reqsign.Header("guess", "GET", "/nic/update", q, time.Now(), "fedcba9876543210fedcba9876543210")
+	_, _ = a.CheckSigned(testRemote, forged, "GET", "/nic/update", q)
+	if res, _ := a.CheckSigned(testRemote, authz, "GET", "/nic/update", q); res != AuthLockedOut {
+		t.Errorf("after two failures = %v, want AuthLockedOut", res)
+	}
+}
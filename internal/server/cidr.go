@@ -19,15 +19,7 @@ import (
 // Both IPv4 and IPv6 literals are supported, including the "%zone" suffix
 // occasionally seen in IPv6 addresses.
 func IsAllowed(remoteAddr string, cidrs []string) bool {
-	host, _, err := net.SplitHostPort(remoteAddr)
-	if err != nil {
-		// Callers sometimes pass just the IP. Try it directly.
-		host = remoteAddr
-	}
-	if i := strings.Index(host, "%"); i >= 0 {
-		host = host[:i]
-	}
-	ip := net.ParseIP(host)
+	ip := hostIP(remoteAddr)
 	if ip == nil {
 		return false
 	}
@@ -42,3 +34,17 @@ func IsAllowed(remoteAddr string, cidrs []string) bool {
 	}
 	return false
 }
+
+// hostIP extracts the IP from "host:port" or a bare IP literal, dropping
+// any IPv6 "%zone" suffix. Returns nil when the host is not an IP.
+func hostIP(remoteAddr string) net.IP {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		// Callers sometimes pass just the IP. Try it directly.
+		host = remoteAddr
+	}
+	if i := strings.Index(host, "%"); i >= 0 {
+		host = host[:i]
+	}
+	return net.ParseIP(host)
+}
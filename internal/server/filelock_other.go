@@ -0,0 +1,11 @@
+//go:build !unix && !windows
+
+package server
+
+import "os"
+
+// lockFile is a no-op where no file-locking primitive is available; the
+// in-process mutex still serializes writes.
+func lockFile(*os.File) error { return nil }
+
+func unlockFile(*os.File) error { return nil }
@@ -3,13 +3,18 @@ package server
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/descoped/dddns/internal/config"
+	"github.com/descoped/dddns/internal/dyndns"
+	"github.com/descoped/dddns/internal/hooks"
+	"github.com/descoped/dddns/internal/logging"
+	"github.com/descoped/dddns/internal/mqtt"
+	"github.com/descoped/dddns/internal/notify"
 	"github.com/descoped/dddns/internal/updater"
 	"github.com/descoped/dddns/internal/wanip"
 )
@@ -19,14 +24,20 @@ import (
 const handlerTimeout = 30 * time.Second
 
 // Handler processes dyndns-style requests from UniFi's inadyn. It owns
-// the CIDR allowlist, Basic-Auth check, query validation, authoritative
-// WAN-IP lookup, Route53 UPSERT (via updater), audit logging, and status
-// snapshot.
+// client-address resolution (trusted proxies), the CIDR allowlist,
+// Basic-Auth check, query validation, authoritative WAN-IP lookup,
+// Route53 UPSERT (via updater), audit logging, and status snapshot.
 type Handler struct {
-	cfg    *config.Config
-	auth   *Authenticator
-	audit  *AuditLog
-	status *StatusWriter
+	cfg      *config.Config
+	auth     *Authenticator
+	audit    *AuditLog
+	status   *StatusWriter
+	metrics  *Metrics
+	route53  *route53Tracker
+	resolver *ClientResolver
+	logger   *slog.Logger
+	notifier *notify.Notifier
+	mqtt     *mqtt.Publisher
 
 	// Hooks overridden in tests. Not part of the public API.
 	wanIP    func(iface string) (net.IP, error)
@@ -36,11 +47,19 @@ type Handler struct {
 
 // NewHandler constructs a Handler with production dependencies.
 func NewHandler(cfg *config.Config, auth *Authenticator, audit *AuditLog, status *StatusWriter) *Handler {
+	var trusted []string
+	if cfg.Server != nil {
+		trusted = cfg.Server.TrustedProxies
+	}
 	return &Handler{
 		cfg:      cfg,
 		auth:     auth,
 		audit:    audit,
 		status:   status,
+		metrics:  NewMetrics(auth),
+		route53:  &route53Tracker{},
+		resolver: NewClientResolver(trusted),
+		logger:   slog.Default(),
 		wanIP:    wanip.FromInterface,
 		updateIP: updater.Update,
 		now:      time.Now,
@@ -48,144 +67,176 @@ func NewHandler(cfg *config.Config, auth *Authenticator, audit *AuditLog, status
 }
 
 // ServeHTTP implements http.Handler for the dyndns update endpoint.
-// See §10 of the design doc for the full response-code table.
+// See §10 of the design doc for the full response-code table; the
+// protocol itself is internal/dyndns, shared with the Lambda.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	entry := AuditEntry{RemoteAddr: r.RemoteAddr}
+	start := h.now()
+	// Every per-client decision below (allowlist, lockout, audit) uses
+	// the derived client; the relaying proxy is recorded alongside.
+	peer, client := h.resolver.Resolve(r)
+	entry := AuditEntry{RemoteAddr: client}
+	if peer != client {
+		entry.Peer = peer
+	}
 
 	defer func() {
 		if rec := recover(); rec != nil {
 			entry.Action = "panic"
 			entry.Err = fmt.Sprintf("%v", rec)
-			h.writeDyndns(w, "911", "")
-			h.emit(entry)
+			h.writeDyndns(w, dyndns.Response{Status: http.StatusOK, Code: dyndns.CodeServerErr})
+			h.emit(entry, start)
 		}
 	}()
 
-	// L1: network origin.
-	if !IsAllowed(r.RemoteAddr, h.cfg.Server.AllowedCIDRs) {
-		entry.Action = "cidr-deny"
-		w.WriteHeader(http.StatusForbidden)
-		h.emit(entry)
-		return
-	}
-
-	// Protocol: only GET.
-	if r.Method != http.MethodGet {
-		entry.Action = "method-deny"
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		h.emit(entry)
-		return
-	}
+	ctx, cancel := context.WithTimeout(r.Context(), handlerTimeout)
+	defer cancel()
+	b := &serveRequest{h: h, client: client}
+	resp, e := dyndns.Handle(ctx, &dyndns.Request{
+		Method:        r.Method,
+		Path:          r.URL.Path,
+		Query:         r.URL.Query(),
+		Authorization: r.Header.Get("Authorization"),
+		RemoteAddr:    client,
+	}, b, dyndns.Options{RequireSignature: h.cfg.Server.RequireSignature})
 
-	// L2: authentication (L3 lockout is inside Authenticator).
-	_, password, ok := r.BasicAuth()
-	if !ok {
-		entry.AuthOutcome = "missing"
-		h.writeDyndns(w, "badauth", "")
-		h.emit(entry)
-		return
-	}
-	switch h.auth.Check(password) {
-	case AuthOK:
-		entry.AuthOutcome = "ok"
-	case AuthLockedOut:
-		entry.AuthOutcome = "locked"
-		h.writeDyndns(w, "badauth", "")
-		h.emit(entry)
-		return
-	default:
-		entry.AuthOutcome = "bad"
-		h.writeDyndns(w, "badauth", "")
-		h.emit(entry)
-		return
-	}
+	entry.Hostname = e.Hostname
+	entry.MyIPClaimed = e.MyIPClaimed
+	entry.MyIPVerified = e.MyIPVerified
+	entry.AuthScheme = e.AuthScheme
+	entry.AuthOutcome = e.AuthOutcome
+	entry.Action = e.Action
+	entry.Err = e.Err
+	entry.Hooks = b.hooks
+	h.writeDyndns(w, resp)
+	h.emit(entry, start)
+}
 
-	// Query validation.
-	hostname := r.URL.Query().Get("hostname")
-	entry.Hostname = hostname
-	entry.MyIPClaimed = r.URL.Query().Get("myip")
+// serveRequest is the dyndns.Backend of one serve-mode request: the
+// CIDR allowlist (L1), the Authenticator with its lockout and rate
+// limit (L2, L3), the configured hostname, the local WAN address (L4)
+// and the shared updater.
+type serveRequest struct {
+	h      *Handler
+	client string
+	hooks  []hooks.Run
+}
 
-	if hostname == "" {
-		entry.Action = "notfqdn"
-		h.writeDyndns(w, "notfqdn", "")
-		h.emit(entry)
-		return
+func (s *serveRequest) Admit(context.Context, *dyndns.Request) error {
+	if !IsAllowed(s.client, s.h.cfg.Server.AllowedCIDRs) {
+		return &dyndns.Error{Action: "cidr-deny", Status: http.StatusForbidden}
 	}
-	// RFC 1035 §2.3.3: DNS names are case-insensitive. strings.EqualFold
-	// (ASCII fold) is correct here — hostnames are ASCII-only per RFC 952.
-	if !strings.EqualFold(hostname, h.cfg.Hostname) {
-		entry.Action = "nohost"
-		h.writeDyndns(w, "nohost", "")
-		h.emit(entry)
-		return
+	return nil
+}
+
+func (s *serveRequest) Authenticate(_ context.Context, req *dyndns.Request, cred dyndns.Credentials) (dyndns.AuthResult, error) {
+	if cred.Scheme == dyndns.SchemeHMAC {
+		return s.h.auth.CheckSigned(s.client, cred.Authorization, req.Method, req.Path, req.Query)
 	}
+	return s.h.auth.Check(s.client, cred.Password), nil
+}
 
-	// L4: authoritative local WAN IP. The `myip` query param is a hint
-	// only — an anomaly is logged if it disagrees with the real value.
+// Owns compares with strings.EqualFold — RFC 1035 §2.3.3 makes DNS
+// names case-insensitive, and hostnames are ASCII-only per RFC 952.
+func (s *serveRequest) Owns(hostname string) bool {
+	return strings.EqualFold(hostname, s.h.cfg.Hostname)
+}
+
+// Address is the authoritative local WAN IP. The myip query parameter
+// is a hint only; the audit entry records it next to this value.
+func (s *serveRequest) Address(context.Context, *dyndns.Request) (string, error) {
 	iface := ""
-	if h.cfg.Server != nil {
-		iface = h.cfg.Server.WANInterface
+	if s.h.cfg.Server != nil {
+		iface = s.h.cfg.Server.WANInterface
 	}
-	localIP, err := h.wanIP(iface)
+	ip, err := s.h.wanIP(iface)
 	if err != nil {
-		entry.Action = "wanip-error"
-		entry.Err = err.Error()
-		h.writeDyndns(w, "dnserr", "")
-		h.emit(entry)
-		return
+		return "", &dyndns.Error{Action: "wanip-error", Err: err}
 	}
-	entry.MyIPVerified = localIP.String()
+	return ip.String(), nil
+}
 
-	// Route53 UPSERT via the shared updater.
-	ctx, cancel := context.WithTimeout(r.Context(), handlerTimeout)
-	defer cancel()
-	result, err := h.updateIP(ctx, h.cfg, updater.Options{
-		OverrideIP: localIP.String(),
-		Quiet:      true, // handler logs via audit, not stdout
+// Update runs the Route53 UPSERT through the shared updater.
+func (s *serveRequest) Update(ctx context.Context, _, ip string) (string, string, error) {
+	result, err := s.h.updateIP(ctx, s.h.cfg, updater.Options{
+		OverrideIP: ip,
+		Quiet:      true, // per-step progress at Debug; emit logs the outcome
+		Logger:     s.h.logger,
+		Observe:    s.h.observeRoute53,
+		OnHook:     func(r hooks.Run) { s.hooks = append(s.hooks, r) },
+		Notifier:   s.h.notifier,
+		MQTT:       s.h.mqtt,
 	})
 	if err != nil {
-		entry.Action = "dnserr"
-		entry.Err = err.Error()
-		h.writeDyndns(w, "dnserr", "")
-		h.emit(entry)
-		return
+		return "", "", err
 	}
-
-	entry.Action = result.Action
-	switch result.Action {
-	case "updated":
-		h.writeDyndns(w, "good", result.NewIP)
-	case "nochg-cache", "nochg-dns", "dry-run":
-		h.writeDyndns(w, "nochg", result.NewIP)
-	default:
-		entry.Err = "unknown updater action: " + result.Action
-		h.writeDyndns(w, "dnserr", "")
+	if result.Action == "vetoed" {
+		// The record still holds the old address.
+		return result.Action, result.OldIP, nil
+	}
+	if result.Action == "updated" || strings.HasPrefix(result.Action, "nochg") {
+		s.h.metrics.recordPublished(result.NewIP, s.h.now())
 	}
-	h.emit(entry)
+	return result.Action, result.NewIP, nil
+}
+
+// observeRoute53 is passed to updater.Options.Observe. It feeds both the
+// latency histogram and the readiness probe's last-call tracker.
+func (h *Handler) observeRoute53(op string, elapsed time.Duration, err error) {
+	h.metrics.observeRoute53(op, elapsed, err)
+	h.route53.record(op, h.now(), err)
+}
+
+// SetLogger replaces the operational logger (default slog.Default()).
+func (h *Handler) SetLogger(l *slog.Logger) {
+	h.logger = logging.OrDefault(l)
+}
+
+// SetNotifier sets the notifier updates report to (default none).
+func (h *Handler) SetNotifier(n *notify.Notifier) {
+	h.notifier = n
+}
+
+// SetMQTT sets the MQTT publisher updates report to (default none).
+func (h *Handler) SetMQTT(p *mqtt.Publisher) {
+	h.mqtt = p
+}
+
+// Resolver returns the handler's client-address resolver so NewServer
+// can apply the same derivation to the auxiliary endpoints.
+func (h *Handler) Resolver() *ClientResolver {
+	return h.resolver
+}
+
+// Metrics returns the handler's collectors so NewServer can mount them.
+func (h *Handler) Metrics() *Metrics {
+	return h.metrics
 }
 
-// writeDyndns writes a dyndns-protocol response: plain text, trailing
-// newline, always HTTP 200. The IP is appended when non-empty (e.g.
-// "good 1.2.3.4\n").
-func (h *Handler) writeDyndns(w http.ResponseWriter, code, ip string) {
+// writeDyndns writes a dyndns-protocol response: plain text with a
+// trailing newline and HTTP 200 (e.g. "good 1.2.3.4\n"), or a bare
+// status for a request turned away before the protocol (403, 405).
+func (h *Handler) writeDyndns(w http.ResponseWriter, resp dyndns.Response) {
+	if resp.Code == "" {
+		w.WriteHeader(resp.Status)
+		return
+	}
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
-	body := code
-	if ip != "" {
-		body = code + " " + ip
-	}
-	_, _ = w.Write([]byte(body + "\n"))
+	_, _ = w.Write([]byte(resp.Body()))
 }
 
-// emit writes an audit-log line and refreshes the status file. Errors
-// are swallowed — failure to emit must not prevent us from responding
-// to the client.
-func (h *Handler) emit(entry AuditEntry) {
+// emit writes an audit-log line, refreshes the status file, and logs
+// the request's outcome. Errors are swallowed — failure to emit must
+// not prevent us from responding to the client.
+func (h *Handler) emit(entry AuditEntry, start time.Time) {
 	// Audit and status writes must not block the response, but silent
-	// failure erodes forensic value. Log to stderr (journaled by systemd)
-	// so operators see write-side errors without the client waiting.
+	// failure erodes forensic value. Log them (stderr is journaled by
+	// systemd) so operators see write-side errors without the client
+	// waiting.
+	h.metrics.observeRequest(entry)
 	if err := h.audit.Write(entry); err != nil {
-		log.Printf("serve: audit write failed: %v", err)
+		h.metrics.writeFailure("audit")
+		h.logger.Error("audit write failed", logging.Err(err))
 	}
 	if err := h.status.Write(StatusSnapshot{
 		LastRequestAt:   h.now(),
@@ -194,6 +245,48 @@ func (h *Handler) emit(entry AuditEntry) {
 		LastAction:      entry.Action,
 		LastError:       entry.Err,
 	}); err != nil {
-		log.Printf("serve: status write failed: %v", err)
+		h.metrics.writeFailure("status")
+		h.logger.Error("status write failed", logging.Err(err))
+	}
+
+	attrs := []slog.Attr{
+		slog.String(logging.KeyAction, entry.Action),
+		slog.String(logging.KeyRemoteAddr, entry.RemoteAddr),
+		logging.DurationMS(h.now().Sub(start)),
+	}
+	if entry.Hostname != "" {
+		attrs = append(attrs, slog.String(logging.KeyHostname, entry.Hostname))
+	}
+	if entry.MyIPVerified != "" {
+		attrs = append(attrs, slog.String(logging.KeyNewIP, entry.MyIPVerified))
 	}
+	if entry.AuthOutcome != "" {
+		attrs = append(attrs, slog.String("auth", entry.AuthOutcome))
+	}
+	if entry.Err != "" {
+		attrs = append(attrs, slog.String(logging.KeyError, entry.Err))
+	}
+	h.logger.LogAttrs(context.Background(), requestLevel(entry), "dyndns request", attrs...)
+}
+
+// requestLevel grades a request for the operational log: rejected
+// clients at Warn, failures at Error, published or vetoed changes at
+// Notice, and routine no-change pushes at Info.
+func requestLevel(e AuditEntry) slog.Level {
+	switch {
+	case rejected(e):
+		return slog.LevelWarn
+	case e.Err != "":
+		return slog.LevelError
+	case e.Action == "updated", e.Action == "dry-run", e.Action == "vetoed":
+		return logging.LevelNotice
+	}
+	return slog.LevelInfo
+}
+
+// rejected reports whether the request was turned away before reaching
+// the updater: allowlist or method denial, failed auth, lockout, or
+// rate limit.
+func rejected(e AuditEntry) bool {
+	return badAuthOutcomes[e.AuthOutcome] || e.Action == "cidr-deny" || e.Action == "method-deny" || e.Action == "rate-limited"
 }
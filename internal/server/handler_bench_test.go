@@ -47,7 +47,7 @@ func BenchmarkHandler_HappyPath(b *testing.B) {
 			AuditLog:     filepath.Join(tmp, "audit.log"),
 		},
 	}
-	srv, err := NewServer(cfg)
+	srv, err := NewServer(cfg, nil)
 	if err != nil {
 		b.Fatal(err)
 	}
@@ -9,11 +9,16 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/descoped/dddns/internal/config"
+	"github.com/descoped/dddns/internal/dyndns/dyndnstest"
+	"github.com/descoped/dddns/internal/hooks"
+	"github.com/descoped/dddns/internal/logsink"
+	"github.com/descoped/dddns/internal/reqsign"
 	"github.com/descoped/dddns/internal/updater"
 )
 
@@ -60,7 +65,7 @@ func newFixture(t *testing.T) *fixture {
 			AllowedCIDRs: []string{"127.0.0.0/8", "192.168.0.0/16"},
 		},
 	}
-	f.auth = NewAuthenticator(testSecretV)
+	f.auth = NewAuthenticator(testSecretV, DefaultPolicy())
 	f.audit = NewAuditLog(f.auditPath)
 	f.status = NewStatusWriter(f.statusPath)
 	h := NewHandler(cfg, f.auth, f.audit, f.status)
@@ -230,7 +235,7 @@ func TestHandler_LockoutResponds_Badauth(t *testing.T) {
 	f := newFixture(t)
 	// Trip the lockout with enough failures.
 	for i := 0; i < MaxFailuresPerWindow; i++ {
-		f.auth.Check("wrong")
+		f.auth.Check("127.0.0.1:54321", "wrong")
 	}
 	// Correct password now.
 	req := newReq(t, map[string]string{"hostname": testHostname}, testSecretV)
@@ -273,6 +278,33 @@ func TestHandler_MyipAnomalyLoggedButLocalIPUsed(t *testing.T) {
 	}
 }
 
+// TestHandler_AuditsHookRuns verifies hook outcomes reported by the
+// updater land in the request's audit entry, output included.
+func TestHandler_AuditsHookRuns(t *testing.T) {
+	f := newFixture(t)
+	f.handler.updateIP = func(_ context.Context, _ *config.Config, opts updater.Options) (*updater.Result, error) {
+		opts.OnHook(hooks.Run{Hook: hooks.PreUpdate, ExitCode: 0})
+		opts.OnHook(hooks.Run{Hook: hooks.PostUpdate, ExitCode: 2, Output: "wg: endpoint reload failed"})
+		return &updater.Result{Action: "updated", NewIP: testPublicIP}, nil
+	}
+	f.do(newReq(t, map[string]string{"hostname": testHostname}, testSecretV), "127.0.0.1:54321")
+
+	raw, err := os.ReadFile(f.auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry AuditEntry
+	if err := json.Unmarshal(raw[:len(raw)-1], &entry); err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.Hooks) != 2 || entry.Hooks[1].ExitCode != 2 || entry.Hooks[1].Output != "wg: endpoint reload failed" {
+		t.Errorf("audit hooks = %+v", entry.Hooks)
+	}
+	if r := auditRecord(entry); !slices.Contains(r.Fields, logsink.Field{Key: "hooks", Value: "pre_update:0,post_update:2"}) {
+		t.Errorf("sink fields = %v", r.Fields)
+	}
+}
+
 // TestHandler_WritesStatus verifies the status.json file is refreshed
 // on every request with the expected fields.
 func TestHandler_WritesStatus(t *testing.T) {
@@ -465,3 +497,90 @@ func TestStatusWriter_Atomic(t *testing.T) {
 	}
 	<-done // block until the writer finishes so TempDir cleanup is safe
 }
+
+// TestHandler_RateLimitedRespondsAbuse verifies a client over its
+// request budget gets the dyndns "abuse" code and never reaches Route53.
+func TestHandler_RateLimitedRespondsAbuse(t *testing.T) {
+	f := newFixture(t)
+	f.updaterResult = &updater.Result{Action: "nochg-cache", NewIP: testPublicIP}
+	for i := 0; i < DefaultRateLimit; i++ {
+		f.do(newReq(t, map[string]string{"hostname": testHostname}, testSecretV), "127.0.0.1:54321")
+	}
+	f.updaterCalled = false
+
+	w := f.do(newReq(t, map[string]string{"hostname": testHostname}, testSecretV), "127.0.0.1:54321")
+	if got := strings.TrimSpace(w.Body.String()); got != "abuse" {
+		t.Errorf("body = %q, want abuse", got)
+	}
+	if f.updaterCalled {
+		t.Error("rate-limited request must not reach the updater")
+	}
+}
+
+func TestHandler_SignedRequestAccepted(t *testing.T) {
+	f := newFixture(t)
+	f.updaterResult = &updater.Result{Action: "nochg-dns", NewIP: testPublicIP}
+	req := newReq(t, map[string]string{"hostname": testHostname}, "")
+	if err := reqsign.SignRequest(req, testSecretV); err != nil {
+		t.Fatal(err)
+	}
+	w := f.do(req, "127.0.0.1:54321")
+	if got := strings.TrimSpace(w.Body.String()); got != "nochg "+testPublicIP {
+		t.Errorf("body = %q, want nochg", got)
+	}
+	data, _ := os.ReadFile(f.auditPath)
+	if !strings.Contains(string(data), `"auth_scheme":"hmac"`) {
+		t.Errorf("audit entry missing hmac scheme: %s", data)
+	}
+
+	// The same signature again is a replay.
+	w = f.do(req, "127.0.0.1:54321")
+	if got := strings.TrimSpace(w.Body.String()); got != "badauth" {
+		t.Errorf("replayed request body = %q, want badauth", got)
+	}
+}
+
+func TestHandler_RequireSignatureRejectsBasic(t *testing.T) {
+	f := newFixture(t)
+	f.handler.cfg.Server.RequireSignature = true
+	w := f.do(newReq(t, map[string]string{"hostname": testHostname}, testSecretV), "127.0.0.1:54321")
+	if got := strings.TrimSpace(w.Body.String()); got != "badauth" {
+		t.Errorf("body = %q, want badauth", got)
+	}
+	if f.updaterCalled {
+		t.Error("updater called for an unsigned request")
+	}
+	data, _ := os.ReadFile(f.auditPath)
+	if !strings.Contains(string(data), `"auth":"unsigned"`) {
+		t.Errorf("audit entry should record auth=unsigned: %s", data)
+	}
+}
+
+// TestHandler_Conformance runs the dyndns conformance table, shared
+// with the Lambda, against serve mode: a LAN client pushing for the
+// configured hostname, published as the local WAN address.
+func TestHandler_Conformance(t *testing.T) {
+	dyndnstest.Run(t, func(t *testing.T, opts dyndnstest.Options) dyndnstest.Target {
+		f := newFixture(t)
+		f.handler.cfg.Hostname = dyndnstest.Hostname
+		f.handler.cfg.Server.RequireSignature = opts.RequireSignature
+		f.handler.auth = NewAuthenticator(dyndnstest.Secret, DefaultPolicy())
+		updates := 0
+		f.handler.updateIP = func(_ context.Context, _ *config.Config, opts updater.Options) (*updater.Result, error) {
+			updates++
+			return &updater.Result{Action: "updated", NewIP: opts.OverrideIP}, nil
+		}
+		return dyndnstest.Target{
+			Do: func(method, path, rawQuery, authorization string) (int, string) {
+				req := httptest.NewRequest(method, path+"?"+rawQuery, nil)
+				if authorization != "" {
+					req.Header.Set("Authorization", authorization)
+				}
+				w := f.do(req, "192.168.1.1:54321")
+				return w.Code, w.Body.String()
+			},
+			Updates: func() int { return updates },
+			IP:      testPublicIP,
+		}
+	})
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/descoped/dddns/internal/config"
+	"github.com/descoped/dddns/internal/logging"
+	"github.com/descoped/dddns/internal/wanip"
+)
+
+// Probe status values. The HTTP status code carries the same signal
+// for supervisors that don't parse bodies: 200 for "ok", 503 for "fail".
+const (
+	ProbeOK   = "ok"
+	ProbeFail = "fail"
+)
+
+// ProbeReport is the JSON body served by /healthz and /readyz.
+type ProbeReport struct {
+	Status string        `json:"status"`
+	Checks []ProbeResult `json:"checks"`
+}
+
+// ProbeResult is one named check within a ProbeReport.
+type ProbeResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// credentialsTTL is how long /readyz reuses a credentials check. Each
+// check reloads the config from disk — for a .secure config, a device-key
+// decryption — which a tight supervisor poll should not repeat.
+const credentialsTTL = 30 * time.Second
+
+// route53Tracker remembers the outcome of the most recent Route53 call
+// made on behalf of a request. The readiness probe fails while the last
+// call failed, and recovers on the next success.
+type route53Tracker struct {
+	mu      sync.Mutex
+	lastAt  time.Time
+	lastOp  string
+	lastErr error
+}
+
+func (t *route53Tracker) record(op string, at time.Time, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastAt, t.lastOp, t.lastErr = at, op, err
+}
+
+func (t *route53Tracker) last() (at time.Time, op string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lastAt, t.lastOp, t.lastErr
+}
+
+// Health serves the liveness and readiness probes. Neither endpoint
+// requires Basic Auth — supervisors can't hold the shared secret — but
+// NewServer mounts both behind the server's CIDR allowlist.
+type Health struct {
+	cfg       *config.Config
+	auditPath string
+	route53   *route53Tracker
+	logger    *slog.Logger
+
+	credMu  sync.Mutex
+	credAt  time.Time
+	credRes ProbeResult
+
+	// Hooks overridden in tests.
+	wanIP      func(iface string) (net.IP, error)
+	loadConfig func() (*config.Config, error)
+	now        func() time.Time
+}
+
+// NewHealth builds the probes for cfg. route53 is shared with the
+// Handler that records Route53 outcomes.
+func NewHealth(cfg *config.Config, auditPath string, h *Handler) *Health {
+	return &Health{
+		cfg:        cfg,
+		auditPath:  auditPath,
+		route53:    h.route53,
+		logger:     h.logger,
+		wanIP:      wanip.FromInterface,
+		loadConfig: config.Load,
+		now:        time.Now,
+	}
+}
+
+// Liveness answers /healthz: the process is serving and holds a config.
+// It never touches the network or disk, so it stays cheap enough for a
+// tight supervisor poll.
+func (h *Health) Liveness() http.Handler {
+	return probeHandler(func() []ProbeResult {
+		res := ProbeResult{Name: "config", Status: ProbeOK, Detail: "hostname " + h.cfg.Hostname}
+		if h.cfg.Hostname == "" || h.cfg.Server == nil {
+			res = ProbeResult{Name: "config", Status: ProbeFail, Detail: "no usable config loaded"}
+		}
+		return []ProbeResult{{Name: "process", Status: ProbeOK}, res}
+	})
+}
+
+// Readiness answers /readyz: every dependency a /nic/update request
+// needs is currently usable.
+func (h *Health) Readiness() http.Handler {
+	return probeHandler(func() []ProbeResult {
+		return []ProbeResult{
+			h.checkWANIP(),
+			h.checkCredentials(),
+			h.checkRoute53(),
+			h.checkAuditWritable(),
+		}
+	})
+}
+
+func (h *Health) checkWANIP() ProbeResult {
+	iface := ""
+	if h.cfg.Server != nil {
+		iface = h.cfg.Server.WANInterface
+	}
+	ip, err := h.wanIP(iface)
+	if err != nil {
+		return ProbeResult{Name: "wan_ip", Status: ProbeFail, Detail: err.Error()}
+	}
+	return ProbeResult{Name: "wan_ip", Status: ProbeOK, Detail: ip.String()}
+}
+
+// checkCredentials reloads the active config from disk, at most once
+// per credentialsTTL. For a .secure config this re-runs the device-key
+// decryption, which is what breaks when the hardware identity changes
+// under a running process. The probe body names no cause — it is
+// served without auth — so a load error is logged instead.
+func (h *Health) checkCredentials() ProbeResult {
+	h.credMu.Lock()
+	defer h.credMu.Unlock()
+	now := h.now()
+	if !h.credAt.IsZero() && now.Sub(h.credAt) < credentialsTTL {
+		return h.credRes
+	}
+	h.credAt = now
+
+	cfg, err := h.loadConfig()
+	switch {
+	case err != nil:
+		h.logger.Error("readiness: config reload failed", logging.Err(err))
+		h.credRes = ProbeResult{Name: "credentials", Status: ProbeFail, Detail: "config reload failed; see the server log"}
+	case cfg.AWSAccessKey == "" || cfg.AWSSecretKey == "":
+		h.credRes = ProbeResult{Name: "credentials", Status: ProbeFail, Detail: "AWS credentials missing from config"}
+	default:
+		h.credRes = ProbeResult{Name: "credentials", Status: ProbeOK}
+	}
+	return h.credRes
+}
+
+func (h *Health) checkRoute53() ProbeResult {
+	at, op, err := h.route53.last()
+	switch {
+	case at.IsZero():
+		return ProbeResult{Name: "route53", Status: ProbeOK, Detail: "no calls yet"}
+	case err != nil:
+		return ProbeResult{Name: "route53", Status: ProbeFail,
+			Detail: fmt.Sprintf("last %s at %s failed: %v", op, at.Format(time.RFC3339), err)}
+	default:
+		return ProbeResult{Name: "route53", Status: ProbeOK,
+			Detail: fmt.Sprintf("last %s at %s succeeded", op, at.Format(time.RFC3339))}
+	}
+}
+
+// checkAuditWritable creates and removes a scratch file in the audit
+// log's directory. It never opens the log itself, which AuditLog
+// rotates and appends to under its own lock.
+func (h *Health) checkAuditWritable() ProbeResult {
+	f, err := os.CreateTemp(filepath.Dir(h.auditPath), ".readyz-*")
+	if err != nil {
+		return ProbeResult{Name: "audit_log", Status: ProbeFail, Detail: err.Error()}
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+	return ProbeResult{Name: "audit_log", Status: ProbeOK, Detail: h.auditPath}
+}
+
+// probeHandler runs checks on every GET and writes a ProbeReport with
+// 200 when all pass, 503 otherwise.
+func probeHandler(checks func() []ProbeResult) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		report := ProbeReport{Status: ProbeOK, Checks: checks()}
+		for _, c := range report.Checks {
+			if c.Status != ProbeOK {
+				report.Status = ProbeFail
+				break
+			}
+		}
+		code := http.StatusOK
+		if report.Status != ProbeOK {
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		if r.Method == http.MethodGet {
+			_ = json.NewEncoder(w).Encode(report)
+		}
+	})
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/descoped/dddns/internal/config"
+)
+
+// newHealthFixture wires Health against the handler fixture with every
+// dependency healthy. Tests break one thing at a time.
+func newHealthFixture(t *testing.T) (*Health, *fixture) {
+	t.Helper()
+	f := newFixture(t)
+	h := NewHealth(f.handler.cfg, f.auditPath, f.handler)
+	h.wanIP = func(string) (net.IP, error) { return net.ParseIP(testPublicIP), nil }
+	h.loadConfig = func() (*config.Config, error) {
+		return &config.Config{AWSAccessKey: "AKIATEST", AWSSecretKey: "SECRETTEST"}, nil
+	}
+	return h, f
+}
+
+func probe(t *testing.T, handler http.Handler) (int, ProbeReport) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var report ProbeReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("decode probe body %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, report
+}
+
+func checkStatus(report ProbeReport, name string) string {
+	for _, c := range report.Checks {
+		if c.Name == name {
+			return c.Status
+		}
+	}
+	return ""
+}
+
+func TestLiveness_OK(t *testing.T) {
+	h, _ := newHealthFixture(t)
+	code, report := probe(t, h.Liveness())
+	if code != http.StatusOK || report.Status != ProbeOK {
+		t.Errorf("liveness = %d %+v, want 200 ok", code, report)
+	}
+}
+
+func TestReadiness_AllHealthy(t *testing.T) {
+	h, _ := newHealthFixture(t)
+	code, report := probe(t, h.Readiness())
+	if code != http.StatusOK || report.Status != ProbeOK {
+		t.Fatalf("readiness = %d %+v, want 200 ok", code, report)
+	}
+	for _, name := range []string{"wan_ip", "credentials", "route53", "audit_log"} {
+		if checkStatus(report, name) != ProbeOK {
+			t.Errorf("check %q = %q, want ok", name, checkStatus(report, name))
+		}
+	}
+}
+
+// TestReadiness_EachFailure breaks one dependency per case and checks
+// that the probe flips to 503 and names the failing check.
+func TestReadiness_EachFailure(t *testing.T) {
+	cases := []struct {
+		name    string
+		check   string
+		breakIt func(h *Health, f *fixture)
+	}{
+		{"wan ip unresolvable", "wan_ip", func(h *Health, _ *fixture) {
+			h.wanIP = func(string) (net.IP, error) { return nil, errors.New("no public IPv4") }
+		}},
+		{"credentials undecryptable", "credentials", func(h *Health, _ *fixture) {
+			h.loadConfig = func() (*config.Config, error) { return nil, errors.New("failed to decrypt credentials") }
+		}},
+		{"last route53 call failed", "route53", func(h *Health, _ *fixture) {
+			h.route53.record("upsert", time.Now(), errors.New("AccessDenied"))
+		}},
+		{"audit log unwritable", "audit_log", func(h *Health, f *fixture) {
+			h.auditPath = filepath.Join(f.auditPath, "missing-dir", "audit.log")
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h, f := newHealthFixture(t)
+			tc.breakIt(h, f)
+			code, report := probe(t, h.Readiness())
+			if code != http.StatusServiceUnavailable || report.Status != ProbeFail {
+				t.Errorf("readiness = %d %q, want 503 fail", code, report.Status)
+			}
+			if got := checkStatus(report, tc.check); got != ProbeFail {
+				t.Errorf("check %q = %q, want fail", tc.check, got)
+			}
+		})
+	}
+}
+
+// TestReadiness_CredentialsCached verifies /readyz reloads the config
+// at most once per credentialsTTL, and keeps the load error out of the
+// unauthenticated probe body.
+func TestReadiness_CredentialsCached(t *testing.T) {
+	h, _ := newHealthFixture(t)
+	now := time.Now()
+	h.now = func() time.Time { return now }
+	loads := 0
+	h.loadConfig = func() (*config.Config, error) {
+		loads++
+		return nil, errors.New("decrypt /data/.dddns/config.secure: device key mismatch")
+	}
+
+	for range 3 {
+		rec := httptest.NewRecorder()
+		h.Readiness().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if strings.Contains(rec.Body.String(), "config.secure") || strings.Contains(rec.Body.String(), "device key") {
+			t.Errorf("probe body leaks the load error: %s", rec.Body.String())
+		}
+	}
+	if loads != 1 {
+		t.Errorf("config loads = %d within the TTL, want 1", loads)
+	}
+	now = now.Add(credentialsTTL)
+	probe(t, h.Readiness())
+	if loads != 2 {
+		t.Errorf("config loads = %d after the TTL, want 2", loads)
+	}
+}
+
+// TestReadiness_AuditProbeLeavesLogAlone verifies the audit check
+// neither creates the log nor leaves a scratch file behind.
+func TestReadiness_AuditProbeLeavesLogAlone(t *testing.T) {
+	h, _ := newHealthFixture(t)
+	h.auditPath = filepath.Join(t.TempDir(), "audit.log")
+	if code, _ := probe(t, h.Readiness()); code != http.StatusOK {
+		t.Fatalf("readiness = %d, want 200", code)
+	}
+	names, err := os.ReadDir(filepath.Dir(h.auditPath))
+	if err != nil || len(names) != 0 {
+		t.Errorf("audit dir after probe = %v (%v), want empty", names, err)
+	}
+}
+
+// TestReadiness_Route53RecoversOnSuccess verifies the probe reflects the
+// most recent call only — one failure doesn't latch.
+func TestReadiness_Route53RecoversOnSuccess(t *testing.T) {
+	h, _ := newHealthFixture(t)
+	h.route53.record("upsert", time.Now(), errors.New("Throttling"))
+	h.route53.record("upsert", time.Now(), nil)
+	if code, _ := probe(t, h.Readiness()); code != http.StatusOK {
+		t.Errorf("readiness = %d after recovery, want 200", code)
+	}
+}
+
+// TestServer_ProbesBypassAuthButHonourCIDR covers the NewServer wiring:
+// no Authorization header is needed from an allowed peer, and a peer
+// outside the allowlist gets a bare 403.
+func TestServer_ProbesBypassAuthButHonourCIDR(t *testing.T) {
+	cfg := validConfig(t)
+	srv, err := NewServer(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/healthz", "/readyz"} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "127.0.0.1:1"
+		srv.Handler().ServeHTTP(rec, req)
+		if rec.Code == http.StatusForbidden || rec.Code == http.StatusNotFound {
+			t.Errorf("%s from loopback: status = %d, want a probe response", path, rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s Content-Type = %q", path, ct)
+		}
+
+		rec = httptest.NewRecorder()
+		req.RemoteAddr = "8.8.8.8:1"
+		srv.Handler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s from 8.8.8.8: status = %d, want 403", path, rec.Code)
+		}
+	}
+	// Probes must not write audit entries.
+	if _, err := os.Stat(cfg.Server.AuditLog); err == nil {
+		data, _ := os.ReadFile(cfg.Server.AuditLog)
+		if len(data) != 0 {
+			t.Errorf("probes wrote to the audit log: %q", data)
+		}
+	}
+}
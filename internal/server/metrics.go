@@ -0,0 +1,108 @@
+package server
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/descoped/dddns/internal/metrics"
+	"github.com/descoped/dddns/internal/mqtt"
+)
+
+// Metrics holds the serve-mode collectors exposed on /metrics. Every
+// Handler owns one; it is only reachable over HTTP when the operator
+// opts in via server.metrics.enabled.
+type Metrics struct {
+	registry *metrics.Registry
+
+	requests        *metrics.CounterVec   // action, auth
+	route53Duration *metrics.HistogramVec // op, outcome
+	lastSuccess     *metrics.GaugeVec     // unlabelled
+	publishedIP     *metrics.GaugeVec     // ip
+	writeFailures   *metrics.CounterVec   // sink
+
+	// Label-free totals for RequestStats.
+	auth                             *Authenticator
+	total, updated, errors, rejected atomic.Uint64
+}
+
+// NewMetrics registers the serve-mode collectors. auth backs the
+// lockout gauge, which is read at scrape time rather than pushed.
+func NewMetrics(auth *Authenticator) *Metrics {
+	r := metrics.NewRegistry()
+	m := &Metrics{
+		registry: r,
+		auth:     auth,
+		requests: r.NewCounterVec("dddns_requests_total",
+			"Requests handled by /nic/update, by resulting action and auth outcome.",
+			"action", "auth"),
+		route53Duration: r.NewHistogramVec("dddns_route53_request_duration_seconds",
+			"Latency of Route53 API calls made on behalf of serve-mode requests.",
+			nil, "op", "outcome"),
+		lastSuccess: r.NewGaugeVec("dddns_last_success_timestamp_seconds",
+			"Unix time of the last request that left DNS matching the WAN IP (good or nochg)."),
+		publishedIP: r.NewGaugeVec("dddns_published_ip_info",
+			"Always 1; the ip label carries the address most recently confirmed in DNS.",
+			"ip"),
+		writeFailures: r.NewCounterVec("dddns_write_failures_total",
+			"Failed writes of the audit log or status file.",
+			"sink"),
+	}
+	r.NewGaugeFunc("dddns_auth_locked_clients",
+		"Client addresses currently inside a Basic-Auth lockout window.",
+		func() float64 { return float64(auth.LockedClients()) })
+	return m
+}
+
+// Handler serves the registry in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return m.registry.Handler()
+}
+
+// observeRequest counts one handled request from its audit entry.
+// Requests rejected before auth (CIDR, method) carry an empty auth label.
+func (m *Metrics) observeRequest(entry AuditEntry) {
+	m.requests.Inc(entry.Action, entry.AuthOutcome)
+	m.total.Add(1)
+	switch {
+	case rejected(entry):
+		m.rejected.Add(1)
+	case entry.Err != "":
+		m.errors.Add(1)
+	case entry.Action == "updated":
+		m.updated.Add(1)
+	}
+}
+
+// RequestStats summarises the requests handled so far for the MQTT
+// state topic.
+func (m *Metrics) RequestStats() mqtt.RequestStats {
+	return mqtt.RequestStats{
+		Total:         m.total.Load(),
+		Updated:       m.updated.Load(),
+		Errors:        m.errors.Load(),
+		Rejected:      m.rejected.Load(),
+		LockedClients: m.auth.LockedClients(),
+	}
+}
+
+// observeRoute53 is passed to updater.Options.Observe.
+func (m *Metrics) observeRoute53(op string, elapsed time.Duration, err error) {
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	m.route53Duration.Observe(elapsed.Seconds(), op, outcome)
+}
+
+// recordPublished notes that ip is now confirmed in DNS as of at.
+func (m *Metrics) recordPublished(ip string, at time.Time) {
+	m.lastSuccess.Set(float64(at.Unix()))
+	m.publishedIP.Reset()
+	m.publishedIP.Set(1, ip)
+}
+
+// writeFailure counts a failed audit ("audit") or status ("status") write.
+func (m *Metrics) writeFailure(sink string) {
+	m.writeFailures.Inc(sink)
+}
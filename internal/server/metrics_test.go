@@ -0,0 +1,207 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/descoped/dddns/internal/config"
+	"github.com/descoped/dddns/internal/updater"
+)
+
+// scrape renders the fixture's metrics registry as text.
+func (f *fixture) scrape(t *testing.T) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := f.handler.Metrics().registry.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestMetrics_CountsRequestsByActionAndAuth(t *testing.T) {
+	f := newFixture(t)
+	f.updaterResult = &updater.Result{Action: "updated", NewIP: testPublicIP}
+
+	f.do(newReq(t, map[string]string{"hostname": testHostname}, testSecretV), "127.0.0.1:1")
+	f.do(newReq(t, map[string]string{"hostname": testHostname}, "wrong"), "127.0.0.1:1")
+	f.do(newReq(t, map[string]string{"hostname": testHostname}, testSecretV), "8.8.8.8:1")
+
+	out := f.scrape(t)
+	for _, want := range []string{
+		`dddns_requests_total{action="updated",auth="ok"} 1`,
+		`dddns_requests_total{action="",auth="bad"} 1`,
+		`dddns_requests_total{action="cidr-deny",auth=""} 1`,
+		`dddns_published_ip_info{ip="` + testPublicIP + `"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+}
+
+// TestMetrics_RequestStats verifies the label-free totals published on
+// the MQTT state topic.
+func TestMetrics_RequestStats(t *testing.T) {
+	f := newFixture(t)
+	f.updaterResult = &updater.Result{Action: "updated", NewIP: testPublicIP}
+	f.do(newReq(t, map[string]string{"hostname": testHostname}, testSecretV), "127.0.0.1:1")
+	f.do(newReq(t, map[string]string{"hostname": testHostname}, "wrong"), "127.0.0.1:1")
+	f.do(newReq(t, map[string]string{"hostname": testHostname}, testSecretV), "8.8.8.8:1")
+	f.updaterErr = errors.New("route53: throttled")
+	f.do(newReq(t, map[string]string{"hostname": testHostname}, testSecretV), "127.0.0.1:1")
+
+	got := f.handler.Metrics().RequestStats()
+	if got.Total != 4 || got.Updated != 1 || got.Rejected != 2 || got.Errors != 1 {
+		t.Errorf("RequestStats() = %+v", got)
+	}
+}
+
+// TestMetrics_Route53LatencyFromObserver verifies the handler passes an
+// Observe hook to the updater and that it lands in the histogram,
+// split by outcome.
+func TestMetrics_Route53LatencyFromObserver(t *testing.T) {
+	f := newFixture(t)
+	f.handler.updateIP = func(_ context.Context, _ *config.Config, opts updater.Options) (*updater.Result, error) {
+		if opts.Observe == nil {
+			t.Fatal("handler did not set updater.Options.Observe")
+		}
+		opts.Observe("get", 20*time.Millisecond, errors.New("throttled"))
+		opts.Observe("upsert", 300*time.Millisecond, nil)
+		return &updater.Result{Action: "updated", NewIP: testPublicIP}, nil
+	}
+	f.do(newReq(t, map[string]string{"hostname": testHostname}, testSecretV), "127.0.0.1:1")
+
+	m := f.handler.Metrics()
+	if got := m.route53Duration.Count("get", "error"); got != 1 {
+		t.Errorf("get/error count = %d, want 1", got)
+	}
+	if got := m.route53Duration.Count("upsert", "ok"); got != 1 {
+		t.Errorf("upsert/ok count = %d, want 1", got)
+	}
+}
+
+func TestMetrics_DryRunDoesNotMarkPublished(t *testing.T) {
+	f := newFixture(t)
+	f.updaterResult = &updater.Result{Action: "dry-run", NewIP: testPublicIP}
+	f.do(newReq(t, map[string]string{"hostname": testHostname}, testSecretV), "127.0.0.1:1")
+
+	if strings.Contains(f.scrape(t), "dddns_published_ip_info{") {
+		t.Error("dry-run must not set the published-IP info metric")
+	}
+}
+
+func TestMetrics_LockoutGauge(t *testing.T) {
+	f := newFixture(t)
+	if !strings.Contains(f.scrape(t), "dddns_auth_locked_clients 0") {
+		t.Fatal("expected dddns_auth_locked_clients 0 before any failures")
+	}
+	for i := 0; i < MaxFailuresPerWindow; i++ {
+		f.do(newReq(t, map[string]string{"hostname": testHostname}, "wrong"), "127.0.0.1:1")
+	}
+	if !strings.Contains(f.scrape(t), "dddns_auth_locked_clients 1") {
+		t.Error("expected dddns_auth_locked_clients 1 after lockout")
+	}
+}
+
+func TestMetrics_AuditWriteFailureCounted(t *testing.T) {
+	f := newFixture(t)
+	// Make the audit path a directory so OpenFile fails.
+	if err := os.Mkdir(f.auditPath, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	f.do(newReq(t, map[string]string{"hostname": testHostname}, "wrong"), "127.0.0.1:1")
+	if got := f.handler.Metrics().writeFailures.Value("audit"); got != 1 {
+		t.Errorf("audit write failures = %v, want 1", got)
+	}
+}
+
+// --- wiring in NewServer ---
+
+func TestNewServer_MetricsDisabledByDefault(t *testing.T) {
+	srv, err := NewServer(validConfig(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.RemoteAddr = "127.0.0.1:1"
+	srv.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404 when metrics are not enabled", rec.Code)
+	}
+}
+
+func TestNewServer_MetricsOnSharedListenerHonoursCIDR(t *testing.T) {
+	cfg := validConfig(t)
+	cfg.Server.Metrics = &config.MetricsConfig{Enabled: true}
+	srv, err := NewServer(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.metricsHTTP != nil {
+		t.Error("no dedicated listener expected when metrics.bind is empty")
+	}
+
+	for _, tc := range []struct {
+		remote string
+		want   int
+	}{
+		{"127.0.0.1:1", http.StatusOK},
+		{"8.8.8.8:1", http.StatusForbidden},
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.RemoteAddr = tc.remote
+		srv.Handler().ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("remote %s: status = %d, want %d", tc.remote, rec.Code, tc.want)
+		}
+	}
+}
+
+func TestNewServer_MetricsDedicatedListener(t *testing.T) {
+	cfg := validConfig(t)
+	cfg.Server.Metrics = &config.MetricsConfig{
+		Enabled:      true,
+		Bind:         "127.0.0.1:9153",
+		AllowedCIDRs: []string{"10.0.0.0/8"},
+	}
+	srv, err := NewServer(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.metricsHTTP == nil || srv.metricsHTTP.Addr != "127.0.0.1:9153" {
+		t.Fatalf("metricsHTTP = %+v, want dedicated listener on :9153", srv.metricsHTTP)
+	}
+
+	// Not on the main mux.
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.RemoteAddr = "127.0.0.1:1"
+	srv.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("main listener /metrics status = %d, want 404", rec.Code)
+	}
+
+	// Metrics allowlist overrides the server one.
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.RemoteAddr = "127.0.0.1:1"
+	srv.metricsHTTP.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("loopback scrape status = %d, want 403 (metrics.allowed_cidrs is 10/8)", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	req.RemoteAddr = "10.1.2.3:1"
+	srv.metricsHTTP.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("10/8 scrape status = %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,306 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClientResolver derives the originating client address of a request
+// when dddns sits behind one or more trusted reverse proxies. Forwarding
+// headers are only believed when the hop that added them is inside
+// trusted; with an empty list every header is ignored and the TCP peer
+// is the client, which is the pre-proxy behaviour.
+type ClientResolver struct {
+	trusted []*net.IPNet
+}
+
+// NewClientResolver parses the trusted-proxy CIDRs. Malformed entries
+// are skipped — ServerConfig.Validate rejects them upstream.
+func NewClientResolver(trusted []string) *ClientResolver {
+	r := &ClientResolver{}
+	for _, c := range trusted {
+		if _, n, err := net.ParseCIDR(c); err == nil {
+			r.trusted = append(r.trusted, n)
+		}
+	}
+	return r
+}
+
+// isTrusted reports whether addr ("host:port" or bare IP) is a trusted
+// proxy.
+func (c *ClientResolver) isTrusted(addr string) bool {
+	ip := hostIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range c.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the TCP peer of the connection and the derived client
+// address. They are equal unless a trusted proxy relayed the request.
+//
+// The chain is walked right to left — r.RemoteAddr first (already
+// rewritten by the PROXY protocol listener when enabled), then each
+// forwarded hop — and the first address that is not a trusted proxy is
+// the client. Hops left of it were written by an untrusted party and
+// are ignored. An unparseable hop ends the walk and is returned as-is,
+// so it fails the CIDR allowlist rather than being skipped.
+func (c *ClientResolver) Resolve(r *http.Request) (peer, client string) {
+	peer = r.RemoteAddr
+	if pc, ok := r.Context().Value(proxyConnKey{}).(*proxyConn); ok {
+		peer = pc.Conn.RemoteAddr().String()
+	}
+
+	client = r.RemoteAddr
+	if !c.isTrusted(client) {
+		return peer, client
+	}
+	hops := forwardedFor(r.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		client = hops[i]
+		if !c.isTrusted(client) {
+			break
+		}
+	}
+	return peer, client
+}
+
+// forwardedFor returns the client chain from the RFC 7239 Forwarded
+// header, or from X-Forwarded-For when Forwarded is absent. Order is
+// left (original client) to right (nearest proxy).
+func forwardedFor(h http.Header) []string {
+	var hops []string
+	if values := h.Values("Forwarded"); len(values) > 0 {
+		for _, line := range values {
+			for _, elem := range strings.Split(line, ",") {
+				for _, pair := range strings.Split(elem, ";") {
+					k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+					if !ok || !strings.EqualFold(k, "for") {
+						continue
+					}
+					hops = append(hops, unquoteForwardedNode(v))
+				}
+			}
+		}
+		return hops
+	}
+	for _, line := range h.Values("X-Forwarded-For") {
+		for _, v := range strings.Split(line, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				hops = append(hops, v)
+			}
+		}
+	}
+	return hops
+}
+
+// unquoteForwardedNode turns an RFC 7239 node ("192.0.2.1",
+// "\"[2001:db8::1]:4711\"") into something hostIP accepts.
+func unquoteForwardedNode(v string) string {
+	v = strings.Trim(strings.TrimSpace(v), `"`)
+	if strings.HasPrefix(v, "[") {
+		if _, _, err := net.SplitHostPort(v); err == nil {
+			return v
+		}
+		return strings.TrimSuffix(strings.TrimPrefix(v, "["), "]")
+	}
+	return v
+}
+
+// --- PROXY protocol ---
+
+// proxyHeaderTimeout bounds how long a trusted peer has to send the
+// PROXY header before the connection is dropped.
+const proxyHeaderTimeout = 5 * time.Second
+
+// proxyConnKey is the context key under which NewServer's ConnContext
+// stores the *proxyConn, so Resolve can report the real TCP peer.
+type proxyConnKey struct{}
+
+// proxyListener wraps a net.Listener and accepts HAProxy PROXY protocol
+// v1 and v2 headers from trusted peers. Connections from untrusted peers
+// are passed through untouched — a client cannot claim another address
+// by sending its own header.
+type proxyListener struct {
+	net.Listener
+	resolver *ClientResolver
+}
+
+// NewProxyListener wraps ln with PROXY protocol support for peers
+// inside resolver's trusted set.
+func NewProxyListener(ln net.Listener, resolver *ClientResolver) net.Listener {
+	return &proxyListener{Listener: ln, resolver: resolver}
+}
+
+// Accept returns the next connection. Header parsing is deferred to the
+// connection's own goroutine (first Read or RemoteAddr) so one slow
+// proxy cannot stall the accept loop.
+func (l *proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: c, expectHeader: l.resolver.isTrusted(c.RemoteAddr().String())}, nil
+}
+
+// proxyConn is a net.Conn whose RemoteAddr reflects the PROXY header.
+type proxyConn struct {
+	net.Conn
+	expectHeader bool
+
+	once   sync.Once
+	br     *bufio.Reader
+	source net.Addr
+	err    error
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.br = bufio.NewReader(c.Conn)
+		if !c.expectHeader {
+			return
+		}
+		_ = c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.source, c.err = readProxyHeader(c.br)
+		_ = c.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+// Read returns the stream after the PROXY header. A malformed header
+// from a trusted peer is a hard error — the connection is unusable.
+func (c *proxyConn) Read(p []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.br.Read(p)
+}
+
+// RemoteAddr returns the source address carried in the PROXY header,
+// or the TCP peer for untrusted connections and LOCAL/UNKNOWN headers.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.source != nil {
+		return c.source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// readProxyHeader consumes a v1 or v2 PROXY header from br. It returns
+// a nil address (and nil error) for LOCAL/UNKNOWN headers, meaning "use
+// the TCP peer".
+func readProxyHeader(br *bufio.Reader) (net.Addr, error) {
+	sig, err := br.Peek(len(proxyV2Signature))
+	if err == nil && bytes.Equal(sig, proxyV2Signature) {
+		return readProxyV2(br)
+	}
+	prefix, err := br.Peek(6)
+	if err == nil && string(prefix) == "PROXY " {
+		return readProxyV1(br)
+	}
+	return nil, errors.New("proxy protocol: missing header from trusted peer")
+}
+
+// readProxyV1 parses "PROXY TCP4 <src> <dst> <sport> <dport>\r\n".
+// The spec caps the line at 107 bytes.
+func readProxyV1(br *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < 108 {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("proxy protocol v1: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("proxy protocol v1: header too long or not CRLF-terminated")
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("proxy protocol v1: malformed header %q", line)
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("proxy protocol v1: bad source %q port %q", fields[2], fields[4])
+	}
+	if (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("proxy protocol v1: %s with address %s", fields[1], fields[2])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 parses the binary v2 header: 12-byte signature, version/
+// command, family/protocol, 16-bit length, then the address block.
+func readProxyV2(br *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return nil, fmt.Errorf("proxy protocol v2: %w", err)
+	}
+	verCmd, famProto := hdr[12], hdr[13]
+	length := int(binary.BigEndian.Uint16(hdr[14:16]))
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("proxy protocol v2: unsupported version %d", verCmd>>4)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(br, body); err != nil {
+		return nil, fmt.Errorf("proxy protocol v2: %w", err)
+	}
+
+	switch verCmd & 0x0F {
+	case 0x0: // LOCAL — health check from the proxy itself.
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("proxy protocol v2: unknown command %#x", verCmd&0x0F)
+	}
+
+	switch famProto {
+	case 0x11: // TCP over IPv4
+		if length < 12 {
+			return nil, errors.New("proxy protocol v2: short IPv4 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if length < 36 {
+			return nil, errors.New("proxy protocol v2: short IPv6 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	default:
+		// UNSPEC, UDP, or UNIX: no usable TCP source; keep the peer.
+		return nil, nil
+	}
+}
+
+// proxyConnContext is installed as http.Server.ConnContext when PROXY
+// protocol is enabled, exposing the wrapper to ClientResolver.
+func proxyConnContext(ctx context.Context, c net.Conn) context.Context {
+	if pc, ok := c.(*proxyConn); ok {
+		return context.WithValue(ctx, proxyConnKey{}, pc)
+	}
+	return ctx
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/descoped/dddns/internal/updater"
+)
+
+func TestClientResolver_Resolve(t *testing.T) {
+	r := NewClientResolver([]string{"10.0.0.0/24", "fd00::/64"})
+	cases := []struct {
+		name       string
+		remote     string
+		headers    map[string]string
+		wantPeer   string
+		wantClient string
+	}{
+		{"direct, no headers", "192.168.1.5:4000", nil, "192.168.1.5:4000", "192.168.1.5:4000"},
+		{"untrusted peer headers ignored", "192.168.1.5:4000",
+			map[string]string{"X-Forwarded-For": "127.0.0.1"}, "192.168.1.5:4000", "192.168.1.5:4000"},
+		{"trusted proxy xff", "10.0.0.2:4000",
+			map[string]string{"X-Forwarded-For": "192.168.1.9"}, "10.0.0.2:4000", "192.168.1.9"},
+		{"spoofed left hop ignored", "10.0.0.2:4000",
+			map[string]string{"X-Forwarded-For": "127.0.0.1, 8.8.8.8, 10.0.0.3"}, "10.0.0.2:4000", "8.8.8.8"},
+		{"forwarded preferred over xff", "10.0.0.2:4000",
+			map[string]string{"Forwarded": `for=192.0.2.60;proto=http, for="[2001:db8::1]:4711"`, "X-Forwarded-For": "1.1.1.1"},
+			"10.0.0.2:4000", "[2001:db8::1]:4711"},
+		{"all hops trusted", "10.0.0.2:4000",
+			map[string]string{"X-Forwarded-For": "10.0.0.9"}, "10.0.0.2:4000", "10.0.0.9"},
+		{"obfuscated node fails closed", "10.0.0.2:4000",
+			map[string]string{"Forwarded": "for=_hidden"}, "10.0.0.2:4000", "_hidden"},
+		{"trusted ipv6 proxy", "[fd00::1]:4000",
+			map[string]string{"X-Forwarded-For": "192.168.1.9"}, "[fd00::1]:4000", "192.168.1.9"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tc.remote
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			peer, client := r.Resolve(req)
+			if peer != tc.wantPeer || client != tc.wantClient {
+				t.Errorf("Resolve = (%q, %q), want (%q, %q)", peer, client, tc.wantPeer, tc.wantClient)
+			}
+		})
+	}
+}
+
+// TestClientResolver_EmptyTrustsNothing verifies the default: with no
+// trusted proxies every forwarding header is ignored.
+func TestClientResolver_EmptyTrustsNothing(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "127.0.0.1:1"
+	req.Header.Set("X-Forwarded-For", "8.8.8.8")
+	if _, client := NewClientResolver(nil).Resolve(req); client != "127.0.0.1:1" {
+		t.Errorf("client = %q, want the TCP peer", client)
+	}
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	v2 := func(cmd, fam byte, addr []byte) []byte {
+		b := append([]byte{}, proxyV2Signature...)
+		b = append(b, 0x20|cmd, fam, 0, 0)
+		binary.BigEndian.PutUint16(b[14:], uint16(len(addr)))
+		return append(b, addr...)
+	}
+	v4Block := []byte{192, 168, 1, 9, 10, 0, 0, 1, 0x30, 0x39, 0x01, 0xbb} // :12345 → :443
+	v6Block := make([]byte, 36)
+	copy(v6Block, net.ParseIP("2001:db8::7"))
+	binary.BigEndian.PutUint16(v6Block[32:], 8080)
+
+	cases := []struct {
+		name    string
+		header  []byte
+		want    string // "" = keep TCP peer
+		wantErr bool
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 192.168.1.9 10.0.0.1 12345 443\r\n"), "192.168.1.9:12345", false},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::7 fd00::1 8080 443\r\n"), "[2001:db8::7]:8080", false},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), "", false},
+		{"v1 family mismatch", []byte("PROXY TCP4 2001:db8::7 fd00::1 1 2\r\n"), "", true},
+		{"v1 no crlf", []byte("PROXY TCP4 192.168.1.9 10.0.0.1 1 2\n"), "", true},
+		{"v1 too long", append([]byte("PROXY "), bytes.Repeat([]byte("x"), 200)...), "", true},
+		{"v2 tcp4", v2(1, 0x11, v4Block), "192.168.1.9:12345", false},
+		{"v2 tcp6", v2(1, 0x21, v6Block), "[2001:db8::7]:8080", false},
+		{"v2 local", v2(0, 0x00, nil), "", false},
+		{"v2 short block", v2(1, 0x11, v4Block[:4]), "", true},
+		{"missing header", []byte("GET / HTTP/1.1\r\n\r\n"), "", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			br := bufio.NewReader(bytes.NewReader(append(tc.header, "rest"...)))
+			addr, err := readProxyHeader(br)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			got := ""
+			if addr != nil {
+				got = addr.String()
+			}
+			if got != tc.want {
+				t.Errorf("addr = %q, want %q", got, tc.want)
+			}
+			if rest, _ := io.ReadAll(br); string(rest) != "rest" {
+				t.Errorf("stream after header = %q, want %q", rest, "rest")
+			}
+		})
+	}
+}
+
+// TestProxyListener_EndToEnd drives a real listener: the trusted peer's
+// PROXY header becomes r.RemoteAddr and the TCP peer is still reported
+// via Resolve.
+func TestProxyListener_EndToEnd(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := NewClientResolver([]string{"127.0.0.1/32"})
+	type seen struct{ remote, peer, client string }
+	got := make(chan seen, 1)
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			peer, client := resolver.Resolve(r)
+			got <- seen{r.RemoteAddr, peer, client}
+		}),
+		ConnContext: proxyConnContext,
+	}
+	go func() { _ = srv.Serve(NewProxyListener(ln, resolver)) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_, _ = io.WriteString(conn, "PROXY TCP4 192.168.1.9 127.0.0.1 5555 80\r\nGET / HTTP/1.1\r\nHost: x\r\n\r\n")
+
+	s := <-got
+	if s.remote != "192.168.1.9:5555" || s.client != "192.168.1.9:5555" {
+		t.Errorf("remote/client = %q/%q, want the PROXY source", s.remote, s.client)
+	}
+	if !strings.HasPrefix(s.peer, "127.0.0.1:") {
+		t.Errorf("peer = %q, want the TCP peer", s.peer)
+	}
+}
+
+// TestProxyConn_UntrustedPassThrough verifies a PROXY header from an
+// untrusted peer is left in the stream rather than honoured.
+func TestProxyConn_UntrustedPassThrough(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+	pc := &proxyConn{Conn: server}
+	go func() { _, _ = io.WriteString(client, "PROXY TCP4 1.2.3.4 5.6.7.8 1 2\r\n"); _ = client.Close() }()
+
+	if pc.RemoteAddr() != server.RemoteAddr() {
+		t.Errorf("RemoteAddr = %v, want the pipe peer", pc.RemoteAddr())
+	}
+	data, _ := io.ReadAll(pc)
+	if !strings.HasPrefix(string(data), "PROXY TCP4") {
+		t.Errorf("stream = %q, want the header untouched", data)
+	}
+}
+
+// TestHandler_TrustedProxyClientUsed checks the handler applies the
+// allowlist and auth to the derived client and audits both addresses.
+func TestHandler_TrustedProxyClientUsed(t *testing.T) {
+	f := newFixture(t)
+	f.handler.resolver = NewClientResolver([]string{"10.9.0.0/24"})
+	f.updaterResult = &updater.Result{Action: "nochg-dns", NewIP: testPublicIP}
+
+	// Proxy outside allowed_cidrs, client inside: allowed.
+	req := newReq(t, map[string]string{"hostname": testHostname}, testSecretV)
+	req.Header.Set("X-Forwarded-For", "192.168.1.50")
+	if w := f.do(req, "10.9.0.1:443"); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	// Proxy relays a client outside allowed_cidrs: denied.
+	req = newReq(t, map[string]string{"hostname": testHostname}, testSecretV)
+	req.Header.Set("X-Forwarded-For", "8.8.8.8")
+	if w := f.do(req, "10.9.0.1:443"); w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403", w.Code)
+	}
+
+	data, err := os.ReadFile(f.auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("audit lines = %d, want 2", len(lines))
+	}
+	var first, second AuditEntry
+	_ = json.Unmarshal([]byte(lines[0]), &first)
+	_ = json.Unmarshal([]byte(lines[1]), &second)
+	if first.RemoteAddr != "192.168.1.50" || first.Peer != "10.9.0.1:443" {
+		t.Errorf("first entry remote/peer = %q/%q", first.RemoteAddr, first.Peer)
+	}
+	if second.RemoteAddr != "8.8.8.8" || second.Action != "cidr-deny" {
+		t.Errorf("second entry = %+v, want cidr-deny for 8.8.8.8", second)
+	}
+}
+
+// TestHandler_DirectRequestOmitsPeer verifies direct connections keep
+// the pre-proxy audit shape.
+func TestHandler_DirectRequestOmitsPeer(t *testing.T) {
+	f := newFixture(t)
+	f.updaterResult = &updater.Result{Action: "nochg-dns", NewIP: testPublicIP}
+	f.do(newReq(t, map[string]string{"hostname": testHostname}, testSecretV), "127.0.0.1:1")
+	data, _ := os.ReadFile(f.auditPath)
+	if bytes.Contains(data, []byte(`"peer"`)) {
+		t.Errorf("direct request wrote a peer field: %s", data)
+	}
+}
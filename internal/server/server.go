@@ -4,11 +4,17 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"path/filepath"
 	"time"
 
 	"github.com/descoped/dddns/internal/config"
+	"github.com/descoped/dddns/internal/logging"
+	"github.com/descoped/dddns/internal/logsink"
+	"github.com/descoped/dddns/internal/mqtt"
+	"github.com/descoped/dddns/internal/notify"
 )
 
 // Server wraps the HTTP listener that backs `dddns serve`. Dependencies
@@ -17,12 +23,22 @@ import (
 type Server struct {
 	http           *http.Server
 	listenAndServe func() error // set in NewServer; tests swap in an httptest listener
+
+	// metricsHTTP is the optional dedicated /metrics listener; nil when
+	// metrics are disabled or share the main listener.
+	metricsHTTP           *http.Server
+	listenAndServeMetrics func() error
+
+	audit    *AuditLog        // closed after the listeners drain
+	notifier *notify.Notifier // drained after the listeners
+	mqtt     *mqtt.Publisher  // drained after the listeners
 }
 
 // NewServer wires the handler chain from a validated Config. Both
 // Config.Validate and ServerConfig.Validate are called — fail-closed
-// startup per §3 L6.
-func NewServer(cfg *config.Config) (*Server, error) {
+// startup per §3 L6. logger receives the operational log (nil =
+// slog.Default()); per-request records go to the audit log regardless.
+func NewServer(cfg *config.Config, logger *slog.Logger) (*Server, error) {
 	if err := cfg.Validate(); err != nil {
 		return nil, fmt.Errorf("config invalid: %w", err)
 	}
@@ -33,13 +49,45 @@ func NewServer(cfg *config.Config) (*Server, error) {
 		return nil, fmt.Errorf("server config invalid: %w", err)
 	}
 
-	auth := NewAuthenticator(cfg.Server.SharedSecret)
-	audit := NewAuditLog(AuditPath(cfg))
+	logger = logging.OrDefault(logger).With("component", "serve")
+
+	auth := NewAuthenticator(cfg.Server.SharedSecret, PolicyFromConfig(cfg.Server))
+	if err := auth.Persist(LockoutStatePath(cfg), logger); err != nil {
+		// A corrupt state file must not keep the listener down; start
+		// with a clean table and say so.
+		logger.Warn("ignoring lockout state", logging.Err(err))
+	}
+	audit, err := OpenAuditLog(cfg)
+	if err != nil {
+		return nil, err
+	}
+	notifier, err := notify.New(cfg.Notify, logger)
+	if err != nil {
+		return nil, err
+	}
+	// Deliver in the background so a slow webhook never delays inadyn.
+	notifier.SetAsync(true)
+	notifier.SetStatePath(notify.EmailStatePath(cfg))
+	publisher, err := mqtt.NewPublisher(cfg.MQTT, cfg.Hostname, logger)
+	if err != nil {
+		return nil, err
+	}
+	publisher.SetAsync(true)
 	status := NewStatusWriter(StatusPath(cfg))
 	handler := NewHandler(cfg, auth, audit, status)
+	handler.SetLogger(logger)
+	handler.SetNotifier(notifier)
+	handler.SetMQTT(publisher)
+	publisher.SetStats(handler.Metrics().RequestStats)
+	health := NewHealth(cfg, AuditPath(cfg), handler)
 
 	mux := http.NewServeMux()
 	mux.Handle("/nic/update", handler)
+	// Probes skip Basic Auth (supervisors don't hold the secret) but
+	// stay behind the allowlist.
+	resolver := handler.Resolver()
+	mux.Handle("/healthz", requireCIDR(resolver, cfg.Server.AllowedCIDRs, health.Liveness()))
+	mux.Handle("/readyz", requireCIDR(resolver, cfg.Server.AllowedCIDRs, health.Readiness()))
 
 	httpSrv := &http.Server{
 		Addr:              cfg.Server.Bind,
@@ -48,35 +96,115 @@ func NewServer(cfg *config.Config) (*Server, error) {
 		ReadTimeout:       10 * time.Second,
 		WriteTimeout:      35 * time.Second, // must exceed handlerTimeout (30s)
 		IdleTimeout:       30 * time.Second,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
 	}
-	return &Server{
+	srv := &Server{
 		http:           httpSrv,
 		listenAndServe: func() error { return httpSrv.ListenAndServe() },
-	}, nil
+		audit:          audit,
+		notifier:       notifier,
+		mqtt:           publisher,
+	}
+	if cfg.Server.ProxyProtocol {
+		// Trusted peers must open every connection with a PROXY header;
+		// other peers are served as direct clients.
+		httpSrv.ConnContext = proxyConnContext
+		srv.listenAndServe = func() error {
+			ln, err := net.Listen("tcp", httpSrv.Addr)
+			if err != nil {
+				return err
+			}
+			return httpSrv.Serve(NewProxyListener(ln, resolver))
+		}
+	}
+
+	if m := cfg.Server.Metrics; m != nil && m.Enabled {
+		cidrs := m.AllowedCIDRs
+		if len(cidrs) == 0 {
+			cidrs = cfg.Server.AllowedCIDRs
+		}
+		metricsHandler := requireCIDR(resolver, cidrs, handler.Metrics().Handler())
+		if m.Bind == "" {
+			mux.Handle("/metrics", metricsHandler)
+		} else {
+			metricsMux := http.NewServeMux()
+			metricsMux.Handle("/metrics", metricsHandler)
+			metricsSrv := &http.Server{
+				Addr:              m.Bind,
+				Handler:           metricsMux,
+				ReadHeaderTimeout: 5 * time.Second,
+				ReadTimeout:       10 * time.Second,
+				WriteTimeout:      10 * time.Second,
+				IdleTimeout:       30 * time.Second,
+				ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+			}
+			srv.metricsHTTP = metricsSrv
+			srv.listenAndServeMetrics = func() error { return metricsSrv.ListenAndServe() }
+		}
+	}
+	return srv, nil
+}
+
+// requireCIDR wraps next so requests whose derived client is outside
+// cidrs get a bare 403. Used for the auxiliary endpoints, which are not
+// audited.
+func requireCIDR(resolver *ClientResolver, cidrs []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, client := resolver.Resolve(r); !IsAllowed(client, cidrs) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 // Run starts the listener and blocks until ctx is cancelled. On
 // cancellation it performs an http.Server.Shutdown with a 5-second
 // deadline, draining any in-flight request.
+//
+// When a dedicated metrics listener is configured it runs alongside the
+// main one; a failure of either stops both.
 func (s *Server) Run(ctx context.Context) error {
-	errCh := make(chan error, 1)
+	errCh := make(chan error, 2)
 	go func() {
 		errCh <- s.listenAndServe()
 	}()
+	if s.metricsHTTP != nil {
+		go func() {
+			errCh <- s.listenAndServeMetrics()
+		}()
+	}
 
 	select {
 	case <-ctx.Done():
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		return s.http.Shutdown(shutdownCtx)
+		return s.shutdown()
 	case err := <-errCh:
 		if errors.Is(err, http.ErrServerClosed) {
 			return nil
 		}
+		_ = s.shutdown()
 		return err
 	}
 }
 
+// shutdown drains every listener with a shared 5-second deadline.
+func (s *Server) shutdown() error {
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := s.http.Shutdown(shutdownCtx)
+	if s.metricsHTTP != nil {
+		if merr := s.metricsHTTP.Shutdown(shutdownCtx); err == nil {
+			err = merr
+		}
+	}
+	s.notifier.Close()
+	s.mqtt.Close()
+	if s.audit != nil {
+		_ = s.audit.Close()
+	}
+	return err
+}
+
 // Handler returns the underlying http.Handler. Exposed for tests that
 // want to use httptest.NewServer instead of binding a real port.
 func (s *Server) Handler() http.Handler {
@@ -93,6 +221,34 @@ func AuditPath(cfg *config.Config) string {
 	return filepath.Join(filepath.Dir(cfg.IPCacheFile), "serve-audit.log")
 }
 
+// OpenAuditLog returns the AuditLog at AuditPath(cfg) with the
+// configured rotation policy, keyed with server.audit_hmac_key when one
+// is set and forwarding to server.audit_sinks. Close it to release the
+// sinks.
+func OpenAuditLog(cfg *config.Config) (*AuditLog, error) {
+	a := NewAuditLog(AuditPath(cfg))
+	if s := cfg.Server; s != nil {
+		a.SetRotation(int64(s.AuditMaxSizeMB)<<20, s.AuditMaxFiles, config.DurationOrDefault(s.AuditMaxAge, 0))
+		if s.AuditHMACKey != "" {
+			a.SetHMACKey([]byte(s.AuditHMACKey))
+		}
+		if len(s.AuditSinks) > 0 {
+			sinks, err := logsink.OpenAll(s.AuditSinks)
+			if err != nil {
+				return nil, fmt.Errorf("audit sinks: %w", err)
+			}
+			a.SetSinks(logsink.Multi(sinks))
+		}
+	}
+	return a, nil
+}
+
+// LockoutStatePath returns the file that persists per-client auth
+// lockouts across restarts — always next to the IP cache.
+func LockoutStatePath(cfg *config.Config) string {
+	return filepath.Join(filepath.Dir(cfg.IPCacheFile), "serve-lockout.json")
+}
+
 // StatusPath returns the serve-status.json path — always in the same
 // directory as the IP cache.
 func StatusPath(cfg *config.Config) string {
@@ -36,7 +36,7 @@ func validConfig(t *testing.T) *config.Config {
 }
 
 func TestNewServer_ValidConfig(t *testing.T) {
-	srv, err := NewServer(validConfig(t))
+	srv, err := NewServer(validConfig(t), nil)
 	if err != nil {
 		t.Fatalf("NewServer failed: %v", err)
 	}
@@ -48,7 +48,7 @@ func TestNewServer_ValidConfig(t *testing.T) {
 func TestNewServer_InvalidConfigTopLevel(t *testing.T) {
 	cfg := validConfig(t)
 	cfg.Hostname = "" // Config.Validate rejects
-	if _, err := NewServer(cfg); err == nil {
+	if _, err := NewServer(cfg, nil); err == nil {
 		t.Error("expected error for invalid top-level config")
 	}
 }
@@ -56,7 +56,7 @@ func TestNewServer_InvalidConfigTopLevel(t *testing.T) {
 func TestNewServer_MissingServerBlock(t *testing.T) {
 	cfg := validConfig(t)
 	cfg.Server = nil
-	if _, err := NewServer(cfg); err == nil {
+	if _, err := NewServer(cfg, nil); err == nil {
 		t.Error("expected error when server block is absent")
 	}
 }
@@ -64,7 +64,7 @@ func TestNewServer_MissingServerBlock(t *testing.T) {
 func TestNewServer_InvalidServerBlock(t *testing.T) {
 	cfg := validConfig(t)
 	cfg.Server.AllowedCIDRs = nil // ServerConfig.Validate rejects empty list
-	if _, err := NewServer(cfg); err == nil {
+	if _, err := NewServer(cfg, nil); err == nil {
 		t.Error("expected error when ServerConfig.Validate fails")
 	}
 }
@@ -75,7 +75,7 @@ func TestNewServer_InvalidServerBlock(t *testing.T) {
 // no network / AWS is touched.
 func TestServer_Integration_EndToEnd(t *testing.T) {
 	cfg := validConfig(t)
-	srv, err := NewServer(cfg)
+	srv, err := NewServer(cfg, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -120,7 +120,7 @@ func TestServer_Integration_EndToEnd(t *testing.T) {
 // and exits cleanly when ctx is cancelled.
 func TestServer_Run_GracefulShutdown(t *testing.T) {
 	cfg := validConfig(t)
-	srv, err := NewServer(cfg)
+	srv, err := NewServer(cfg, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,208 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/descoped/dddns/internal/config"
+	"github.com/descoped/dddns/internal/constants"
+	"github.com/descoped/dddns/internal/dns"
+)
+
+// Error classes recorded in FailureState.ErrorClass.
+const (
+	ClassIPLookup  = "ip-lookup" // the public IP could not be determined
+	ClassAuth      = "auth"      // missing, invalid or expired AWS credentials
+	ClassThrottled = "throttled" // Route53 rate limiting
+	ClassRoute53   = "route53"   // any other Route53 failure
+	ClassTimeout   = "timeout"   // the run exceeded its deadline
+	ClassOther     = "other"
+)
+
+// FailureState is the current run of consecutive failed updates. It is
+// persisted next to the IP cache (see FailureStatePath) and removed by
+// the next successful update.
+type FailureState struct {
+	Failures     int       `json:"consecutive_failures"`
+	FirstFailure time.Time `json:"first_failure"`
+	LastFailure  time.Time `json:"last_failure"`
+	ErrorClass   string    `json:"last_error_class"`
+	LastError    string    `json:"last_error"`
+	// Escalated is set once the streak crosses the cfg.Escalation
+	// thresholds; without an escalation block, on the first failure.
+	Escalated bool `json:"escalated,omitempty"`
+}
+
+// FailureStatePath returns the failure-state file for cfg — always next
+// to the IP cache.
+func FailureStatePath(cfg *config.Config) string {
+	return filepath.Join(filepath.Dir(cfg.IPCacheFile), "update-failures.json")
+}
+
+// ReadFailureState returns the failure streak recorded in path. A
+// missing file is a zero state: the last update succeeded.
+func ReadFailureState(path string) (FailureState, error) {
+	var st FailureState
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return FailureState{}, fmt.Errorf("corrupt failure state %s: %w", path, err)
+	}
+	return st, nil
+}
+
+// FailureError is returned by Update when the run failed. State is the
+// streak including this failure; Escalated reports whether it has
+// crossed the escalation thresholds, which is what `dddns update` turns
+// into a non-zero exit. A dry run records no streak and is always
+// escalated.
+type FailureError struct {
+	Err       error
+	State     FailureState
+	Escalated bool
+}
+
+func (e *FailureError) Error() string { return e.Err.Error() }
+
+func (e *FailureError) Unwrap() error { return e.Err }
+
+// stageError tags an error from run with the class of the step that
+// produced it, without changing its message.
+type stageError struct {
+	class string
+	err   error
+}
+
+func (e *stageError) Error() string { return e.err.Error() }
+
+func (e *stageError) Unwrap() error { return e.err }
+
+// ErrorClass returns the class of an error returned by Update.
+func ErrorClass(err error) string {
+	var apiErr *dns.APIError
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.DeadlineExceeded):
+		return ClassTimeout
+	case errors.As(err, &apiErr):
+		switch apiErr.Code {
+		case "AccessDenied", "InvalidClientTokenId", "SignatureDoesNotMatch", "ExpiredToken", "UnrecognizedClientException":
+			return ClassAuth
+		case "Throttling", "ThrottlingException", "PriorRequestNotComplete":
+			return ClassThrottled
+		}
+		switch apiErr.Status {
+		case 401, 403:
+			return ClassAuth
+		case 429:
+			return ClassThrottled
+		}
+		return ClassRoute53
+	}
+	var se *stageError
+	if errors.As(err, &se) {
+		return se.class
+	}
+	return ClassOther
+}
+
+// failMu serializes read-modify-write of the failure state; serve mode
+// can run updates concurrently.
+var failMu sync.Mutex
+
+// recordFailure adds err to the streak in path. crossed reports whether
+// this failure is the one that escalated it.
+func recordFailure(path string, esc *config.EscalationConfig, err error, now time.Time) (st FailureState, crossed bool, werr error) {
+	failMu.Lock()
+	defer failMu.Unlock()
+
+	st, rerr := ReadFailureState(path)
+	if rerr != nil {
+		st = FailureState{} // a corrupt record restarts the streak
+	}
+	if st.Failures == 0 {
+		st.FirstFailure = now
+	}
+	st.Failures++
+	st.LastFailure = now
+	st.ErrorClass = ErrorClass(err)
+	st.LastError = err.Error()
+	if !st.Escalated && escalates(esc, st) {
+		st.Escalated, crossed = true, true
+	}
+	return st, crossed, writeFailureState(path, st)
+}
+
+// clearFailures ends the streak in path and returns it; the zero state
+// when there was none.
+func clearFailures(path string) (FailureState, error) {
+	failMu.Lock()
+	defer failMu.Unlock()
+
+	st, err := ReadFailureState(path)
+	if err == nil && st.Failures == 0 {
+		return st, nil
+	}
+	if rerr := os.Remove(path); rerr != nil && !errors.Is(rerr, os.ErrNotExist) {
+		return st, rerr
+	}
+	return st, nil
+}
+
+// escalates reports whether st crosses esc. Either threshold suffices;
+// without esc every failure escalates.
+func escalates(esc *config.EscalationConfig, st FailureState) bool {
+	if esc == nil {
+		return true
+	}
+	if esc.Failures > 0 && st.Failures >= esc.Failures {
+		return true
+	}
+	if esc.Duration != "" {
+		d := config.DurationOrDefault(esc.Duration, 0)
+		return d > 0 && st.LastFailure.Sub(st.FirstFailure) >= d
+	}
+	return false
+}
+
+// writeFailureState atomically replaces the state file.
+func writeFailureState(path string, st FailureState) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, constants.CacheDirPerm); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".update-failures-*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/descoped/dddns/internal/config"
+	"github.com/descoped/dddns/internal/dns"
+	"github.com/descoped/dddns/internal/logging"
+	"github.com/descoped/dddns/internal/notify"
+)
+
+// eventRecorder returns a Notifier posting every event kind to a test
+// server, and a reader for the events it received.
+func eventRecorder(t *testing.T) (*notify.Notifier, func() []notify.Event) {
+	t.Helper()
+	var events []notify.Event
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		var e notify.Event
+		_ = json.NewDecoder(r.Body).Decode(&e)
+		events = append(events, e)
+	}))
+	t.Cleanup(srv.Close)
+	n, err := notify.New(&config.NotifyConfig{Webhooks: []config.WebhookConfig{
+		{URL: srv.URL, Events: config.NotifyEvents},
+	}}, logging.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n, func() []notify.Event { return events }
+}
+
+// TestUpdate_EscalationAndRecovery walks a failure streak below and
+// across escalation.failures, then the recovery: one failed event at the
+// crossing, one recovered event after it, and the state file tracking
+// the streak in between.
+func TestUpdate_EscalationAndRecovery(t *testing.T) {
+	n, events := eventRecorder(t)
+	cfg := baseConfig(t.TempDir())
+	cfg.Escalation = &config.EscalationConfig{Failures: 3}
+	denied := &dns.APIError{Status: 403, Code: "AccessDenied", Message: "not authorized"}
+	opts := Options{
+		OverrideIP: testPublicIP,
+		Force:      true,
+		Quiet:      true,
+		Notifier:   n,
+		Logger:     logging.Discard(),
+		Client:     &fakeDNSClient{getIP: "198.51.100.1", updateErr: denied},
+	}
+
+	for i := 1; i <= 4; i++ {
+		_, err := Update(context.Background(), cfg, opts)
+		var failure *FailureError
+		if !errors.As(err, &failure) || !errors.Is(err, denied) {
+			t.Fatalf("run %d: err = %v, want *FailureError wrapping the API error", i, err)
+		}
+		if failure.State.Failures != i || failure.Escalated != (i >= 3) {
+			t.Errorf("run %d: failure = %+v", i, failure)
+		}
+	}
+	st, err := ReadFailureState(FailureStatePath(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Failures != 4 || st.ErrorClass != ClassAuth || !st.Escalated || st.FirstFailure.After(st.LastFailure) ||
+		st.LastError != "failed to update Route53: "+denied.Error() {
+		t.Errorf("state = %+v", st)
+	}
+
+	opts.Client = &fakeDNSClient{getIP: "198.51.100.1"}
+	if _, err := Update(context.Background(), cfg, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(FailureStatePath(cfg)); !os.IsNotExist(err) {
+		t.Errorf("failure state not removed on success: %v", err)
+	}
+
+	got := events()
+	if len(got) != 3 {
+		t.Fatalf("got %d events, want 3: %+v", len(got), got)
+	}
+	if e := got[0]; e.Kind != notify.EventFailed || e.Failures != 3 || !e.Escalated || !e.Since.Equal(st.FirstFailure) {
+		t.Errorf("failed event = %+v", e)
+	}
+	if e := got[1]; e.Kind != notify.EventRecovered || e.Failures != 4 || e.NewIP != testPublicIP {
+		t.Errorf("recovered event = %+v", e)
+	}
+	if got[2].Kind != notify.EventUpdated {
+		t.Errorf("third event = %+v", got[2])
+	}
+}
+
+// TestUpdate_EscalationByDuration verifies a streak older than
+// escalation.duration escalates on its next failure, and that a streak
+// which never escalated recovers silently.
+func TestUpdate_EscalationByDuration(t *testing.T) {
+	n, events := eventRecorder(t)
+	cfg := baseConfig(t.TempDir())
+	cfg.Escalation = &config.EscalationConfig{Duration: "2h"}
+	opts := Options{
+		OverrideIP: testPublicIP,
+		Force:      true,
+		Quiet:      true,
+		Notifier:   n,
+		Logger:     logging.Discard(),
+		Client:     &fakeDNSClient{getIP: "198.51.100.1", updateErr: errors.New("connection reset")},
+	}
+
+	// A fresh streak stays below the threshold and recovers quietly.
+	if _, err := Update(context.Background(), cfg, opts); err == nil {
+		t.Fatal("expected failure")
+	}
+	ok := opts
+	ok.Client = &fakeDNSClient{getIP: "198.51.100.1"}
+	if _, err := Update(context.Background(), cfg, ok); err != nil {
+		t.Fatal(err)
+	}
+	if got := events(); len(got) != 1 || got[0].Kind != notify.EventUpdated {
+		t.Fatalf("events = %+v, want only the update", got)
+	}
+
+	// A streak that started three hours ago escalates now.
+	old := FailureState{
+		Failures:     2,
+		FirstFailure: time.Now().Add(-3 * time.Hour).UTC(),
+		LastFailure:  time.Now().Add(-time.Hour).UTC(),
+		ErrorClass:   ClassRoute53,
+		LastError:    "earlier",
+	}
+	if err := writeFailureState(FailureStatePath(cfg), old); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Update(context.Background(), cfg, opts)
+	var failure *FailureError
+	if !errors.As(err, &failure) || !failure.Escalated || failure.State.Failures != 3 || failure.State.ErrorClass != ClassRoute53 {
+		t.Fatalf("err = %v (%+v), want an escalated third failure", err, failure)
+	}
+	if got := events(); len(got) != 2 || got[1].Kind != notify.EventFailed || got[1].Failures != 3 {
+		t.Errorf("events = %+v", got)
+	}
+}
+
+// TestUpdate_DryRunLeavesFailureState verifies a dry run neither
+// extends nor ends a streak.
+func TestUpdate_DryRunLeavesFailureState(t *testing.T) {
+	cfg := baseConfig(t.TempDir())
+	old := FailureState{Failures: 2, FirstFailure: time.Now().UTC(), LastFailure: time.Now().UTC(), ErrorClass: ClassOther}
+	if err := writeFailureState(FailureStatePath(cfg), old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Update(context.Background(), cfg, Options{
+		OverrideIP: testPublicIP,
+		DryRun:     true,
+		Quiet:      true,
+		Logger:     logging.Discard(),
+		Client:     &fakeDNSClient{getIP: "198.51.100.1"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := ReadFailureState(FailureStatePath(cfg)); err != nil || st.Failures != 2 {
+		t.Errorf("state = %+v, %v", st, err)
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{&stageError{ClassIPLookup, errors.New("failed to get public IP: no route")}, ClassIPLookup},
+		{&stageError{ClassRoute53, fmt.Errorf("failed to update Route53: %w", &dns.APIError{Status: 403, Code: "SignatureDoesNotMatch"})}, ClassAuth},
+		{&stageError{ClassRoute53, &dns.APIError{Status: 400, Code: "Throttling"}}, ClassThrottled},
+		{&stageError{ClassRoute53, &dns.APIError{Status: 400, Code: "InvalidChangeBatch"}}, ClassRoute53},
+		{&stageError{ClassRoute53, errors.New("connection reset")}, ClassRoute53},
+		{&stageError{ClassAuth, errors.New("failed to create Route53 client: no credentials")}, ClassAuth},
+		{fmt.Errorf("get: %w", context.DeadlineExceeded), ClassTimeout},
+		{errors.New("boom"), ClassOther},
+		{nil, ""},
+	}
+	for _, c := range cases {
+		if got := ErrorClass(c.err); got != c.want {
+			t.Errorf("ErrorClass(%v) = %q, want %q", c.err, got, c.want)
+		}
+	}
+}
+
+func TestReadCache(t *testing.T) {
+	path := baseConfig(t.TempDir()).IPCacheFile
+	if ip, updated := ReadCache(path); ip != "" || !updated.IsZero() {
+		t.Errorf("missing cache = %q, %v", ip, updated)
+	}
+	before := time.Now().Add(-time.Second)
+	if err := writeCachedIP(path, testPublicIP); err != nil {
+		t.Fatal(err)
+	}
+	if ip, updated := ReadCache(path); ip != testPublicIP || updated.Before(before) {
+		t.Errorf("ReadCache = %q, %v", ip, updated)
+	}
+}
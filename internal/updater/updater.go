@@ -5,7 +5,7 @@ package updater
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"os"
 	"path/filepath"
@@ -16,6 +16,10 @@ import (
 	"github.com/descoped/dddns/internal/config"
 	"github.com/descoped/dddns/internal/constants"
 	"github.com/descoped/dddns/internal/dns"
+	"github.com/descoped/dddns/internal/hooks"
+	"github.com/descoped/dddns/internal/logging"
+	"github.com/descoped/dddns/internal/mqtt"
+	"github.com/descoped/dddns/internal/notify"
 	"github.com/descoped/dddns/internal/profile"
 	"github.com/descoped/dddns/internal/wanip"
 )
@@ -49,11 +53,11 @@ func defaultResolver() *resolver {
 
 // resolveIP picks between the local WAN interface and a remote lookup
 // based on cfg.IPSource. Empty / "auto" defaults to local on the UDM
-// profile, remote elsewhere. The returned description is human-readable
-// ("local (auto → udm profile)", "remote (cfg.ip_source=remote)") and is
-// only consumed by --verbose — production paths ignore it.
-func (r *resolver) resolveIP(ctx context.Context, cfg *config.Config) (ip, description string, err error) {
-	source := cfg.IPSource
+// profile, remote elsewhere. source is the path taken ("local" or
+// "remote"); description is human-readable ("local (auto → udm
+// profile, iface=\"eth8\")") and is only logged at Debug.
+func (r *resolver) resolveIP(ctx context.Context, cfg *config.Config) (ip, source, description string, err error) {
+	source = cfg.IPSource
 	autoDecision := ""
 	if source == "" || source == "auto" {
 		if r.profile() == "udm" {
@@ -75,16 +79,16 @@ func (r *resolver) resolveIP(ctx context.Context, cfg *config.Config) (ip, descr
 		if autoDecision != "" {
 			description = fmt.Sprintf("local (%s, iface=%q)", autoDecision, iface)
 		}
-		return ip, description, err
+		return ip, source, description, err
 	case "remote":
 		ip, err = r.remoteIP(ctx)
 		description = "remote (checkip.amazonaws.com)"
 		if autoDecision != "" {
 			description = fmt.Sprintf("remote (%s, checkip.amazonaws.com)", autoDecision)
 		}
-		return ip, description, err
+		return ip, source, description, err
 	default:
-		return "", "", fmt.Errorf("unknown ip_source %q", source)
+		return "", "", "", fmt.Errorf("unknown ip_source %q", source)
 	}
 }
 
@@ -100,25 +104,50 @@ type DNSClient interface {
 type Options struct {
 	Force      bool
 	DryRun     bool
-	Quiet      bool
-	Verbose    bool   // emit per-step diagnostic output (source choice, interface, TTL)
+	Quiet      bool   // log per-step progress at Debug instead of Info; outcomes are unaffected
 	OverrideIP string // empty = resolve via myip.GetPublicIP (default cron behavior)
 
+	// Logger receives the run's log records; nil = slog.Default(). The
+	// outcome (updated, dry-run) logs at logging.LevelNotice, per-step
+	// progress at Info, diagnostics (source choice, interface) at Debug.
+	Logger *slog.Logger
+
 	// Client, if set, replaces the Route53 client the updater would otherwise
 	// construct from cfg. Intended for tests and for the serve handler.
 	Client DNSClient
+
+	// Observe, if set, is called after every Route53 API call with the
+	// operation ("get" or "upsert"), its wall-clock duration, and its
+	// error (nil on success). The serve handler feeds /metrics from it.
+	Observe func(op string, elapsed time.Duration, err error)
+
+	// OnHook, if set, is called with the outcome of each hook command
+	// (cfg.Hooks). The serve handler records them in the audit entry.
+	OnHook func(r hooks.Run)
+
+	// Notifier, if set, is sent an "updated" event when the record
+	// changes, a "failed" event when the run fails (with cfg.Escalation,
+	// only when the failure streak escalates), and a "recovered" event
+	// when a run ends an escalated streak.
+	Notifier *notify.Notifier
+
+	// MQTT, if set, is sent the outcome of every run except a dry run.
+	MQTT *mqtt.Publisher
 }
 
 // Result describes the outcome of Update.
 type Result struct {
-	Action   string // "updated" | "nochg-cache" | "nochg-dns" | "dry-run"
+	Action   string // "updated" | "nochg-cache" | "nochg-dns" | "dry-run" | "vetoed"
 	OldIP    string
 	NewIP    string
 	Hostname string
+	Source   string // "local" | "remote" | "override"
 }
 
 // Update performs the full update flow: resolve IP → compare cache →
-// compare DNS → upsert → update cache.
+// compare DNS → upsert → update cache. Every run except a dry run or a
+// vetoed one also updates the failure streak (see FailureState); a
+// failed run returns a *FailureError.
 func Update(ctx context.Context, cfg *config.Config, opts Options) (*Result, error) {
 	return updateWithResolver(ctx, cfg, opts, defaultResolver())
 }
@@ -126,44 +155,194 @@ func Update(ctx context.Context, cfg *config.Config, opts Options) (*Result, err
 // updateWithResolver is the production entry point's core. It is exposed
 // (within-package) so tests can inject a deterministic resolver.
 func updateWithResolver(ctx context.Context, cfg *config.Config, opts Options, res *resolver) (*Result, error) {
-	logInfo := func(format string, args ...interface{}) {
-		if !opts.Quiet {
-			log.Printf(format, args...)
+	opts.Logger = logging.OrDefault(opts.Logger).With(slog.String(logging.KeyHostname, cfg.Hostname))
+	// known collects what the run learned before any failure, so the
+	// failure can still be reported with the IPs involved.
+	known := &Result{Hostname: cfg.Hostname}
+	result, err := run(ctx, cfg, opts, res, known)
+
+	// Track the failure streak. A dry run or a veto changes nothing, so
+	// it neither extends nor ends one.
+	var (
+		streak    FailureState
+		crossed   bool
+		recovered FailureState
+	)
+	vetoed := err == nil && result.Action == "vetoed"
+	if !opts.DryRun && !vetoed {
+		statePath := FailureStatePath(cfg)
+		if err != nil {
+			var werr error
+			streak, crossed, werr = recordFailure(statePath, cfg.Escalation, err, time.Now().UTC())
+			if werr != nil {
+				opts.Logger.Warn("failed to record update failure", logging.Err(werr))
+			}
+			if crossed && cfg.Escalation != nil {
+				opts.Logger.Error("failure streak escalated",
+					slog.Int("consecutive_failures", streak.Failures),
+					slog.Time("since", streak.FirstFailure),
+					slog.String("error_class", streak.ErrorClass))
+			}
+		} else {
+			var cerr error
+			if recovered, cerr = clearFailures(statePath); cerr != nil {
+				opts.Logger.Warn("failed to clear update failures", logging.Err(cerr))
+			}
+			if recovered.Failures > 0 {
+				opts.Logger.Log(ctx, logging.LevelNotice, "recovered from failures",
+					slog.Int("consecutive_failures", recovered.Failures),
+					slog.Time("since", recovered.FirstFailure))
+			}
+		}
+	}
+
+	// A failed run may have exhausted ctx; hooks and the notifier bound
+	// their own work.
+	after := context.WithoutCancel(ctx)
+	if err != nil && cfg.Hooks != nil && len(cfg.Hooks.OnError) > 0 {
+		runHook(after, cfg, opts, cfg.Hooks.OnError, hooks.Input{
+			Hook:     hooks.OnError,
+			Hostname: cfg.Hostname,
+			OldIP:    known.OldIP,
+			NewIP:    known.NewIP,
+			Action:   "failed",
+			Source:   known.Source,
+			Error:    err.Error(),
+		})
+	}
+	if opts.Notifier != nil {
+		switch {
+		case err != nil:
+			// Without an escalation block every failure is reported, as
+			// before; with one, only the failure that escalates.
+			if cfg.Escalation == nil || crossed {
+				opts.Notifier.Notify(after, notify.Event{
+					Kind:      notify.EventFailed,
+					Hostname:  cfg.Hostname,
+					OldIP:     known.OldIP,
+					NewIP:     known.NewIP,
+					Source:    known.Source,
+					Error:     err.Error(),
+					Failures:  streak.Failures,
+					Since:     streak.FirstFailure,
+					Escalated: cfg.Escalation != nil && crossed,
+				})
+			}
+		default:
+			if recovered.Escalated {
+				opts.Notifier.Notify(after, notify.Event{
+					Kind:     notify.EventRecovered,
+					Hostname: result.Hostname,
+					NewIP:    result.NewIP,
+					Source:   result.Source,
+					Error:    recovered.LastError,
+					Failures: recovered.Failures,
+					Since:    recovered.FirstFailure,
+				})
+			}
+			if result.Action == "updated" {
+				opts.Notifier.Notify(after, notify.Event{
+					Kind:     notify.EventUpdated,
+					Hostname: result.Hostname,
+					OldIP:    result.OldIP,
+					NewIP:    result.NewIP,
+					Source:   result.Source,
+				})
+			}
 		}
 	}
-	logVerbose := func(format string, args ...interface{}) {
-		if opts.Verbose {
-			log.Printf(format, args...)
+	switch {
+	case err != nil:
+		opts.MQTT.Publish(after, mqtt.State{
+			Action: "failed",
+			IP:     known.NewIP,
+			OldIP:  known.OldIP,
+			Source: known.Source,
+			Error:  err.Error(),
+		})
+	case result.Action != "dry-run":
+		ip := result.NewIP
+		if vetoed {
+			ip = result.OldIP // the record kept its address
 		}
+		opts.MQTT.Publish(after, mqtt.State{
+			Action: result.Action,
+			IP:     ip,
+			OldIP:  result.OldIP,
+			Source: result.Source,
+		})
+	}
+	if err != nil {
+		return nil, &FailureError{Err: err, State: streak, Escalated: opts.DryRun || streak.Escalated}
+	}
+	return result, nil
+}
+
+// runHook executes one hook command, logs its outcome and reports it to
+// opts.OnHook.
+func runHook(ctx context.Context, cfg *config.Config, opts Options, argv []string, in hooks.Input) hooks.Run {
+	r := hooks.Exec(ctx, argv, config.DurationOrDefault(cfg.Hooks.Timeout, hooks.DefaultTimeout), in)
+	level := slog.LevelInfo
+	attrs := []slog.Attr{
+		slog.String("hook", r.Hook),
+		slog.Int("exit_code", r.ExitCode),
+		slog.Int64(logging.KeyDurationMS, r.DurationMS),
+	}
+	if r.Output != "" {
+		attrs = append(attrs, slog.String("output", r.Output))
+	}
+	if r.Failed() {
+		level = slog.LevelWarn
+		attrs = append(attrs, slog.String(logging.KeyError, r.Reason()))
+	}
+	opts.Logger.LogAttrs(ctx, level, "hook finished", attrs...)
+	if opts.OnHook != nil {
+		opts.OnHook(r)
+	}
+	return r
+}
+
+// run performs one update, recording the resolved IP, its source and
+// the previous IP in known as they are learned.
+func run(ctx context.Context, cfg *config.Config, opts Options, res *resolver, known *Result) (*Result, error) {
+	logger := opts.Logger
+	progress := slog.LevelInfo
+	if opts.Quiet {
+		progress = slog.LevelDebug
+	}
+	logf := func(level slog.Level, msg string, attrs ...slog.Attr) {
+		logger.LogAttrs(ctx, level, msg, attrs...)
 	}
 
 	// 1. Resolve current IP (override, or dispatch on cfg.IPSource).
-	currentIP := opts.OverrideIP
+	currentIP, source := opts.OverrideIP, "override"
 	if currentIP == "" {
-		detected, source, err := res.resolveIP(ctx, cfg)
+		detected, src, description, err := res.resolveIP(ctx, cfg)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get public IP: %w", err)
+			return nil, &stageError{ClassIPLookup, fmt.Errorf("failed to get public IP: %w", err)}
 		}
-		currentIP = detected
-		logVerbose("IP source: %s", source)
-		logInfo("Current public IP: %s", currentIP)
-	} else {
-		logInfo("Using custom IP: %s", currentIP)
+		currentIP, source = detected, src
+		logf(slog.LevelDebug, "resolved IP source", slog.String(logging.KeySource, source), slog.String("detail", description))
 	}
+	known.NewIP, known.Source = currentIP, source
+	logf(progress, "current public IP", slog.String(logging.KeyNewIP, currentIP), slog.String(logging.KeySource, source))
 
 	// 2. Compare against cache.
 	cachedIP := readCachedIP(cfg.IPCacheFile)
+	known.OldIP = cachedIP
 	if cachedIP != "" {
-		logInfo("Last known IP: %s", cachedIP)
+		logf(progress, "last known IP", slog.String(logging.KeyOldIP, cachedIP))
 	}
 
 	if !opts.Force && currentIP == cachedIP {
-		logInfo("IP unchanged (%s), skipping update", currentIP)
+		logf(progress, "IP unchanged, skipping update",
+			slog.String(logging.KeyAction, "nochg-cache"), slog.String(logging.KeyNewIP, currentIP))
 		return &Result{
 			Action:   "nochg-cache",
 			OldIP:    cachedIP,
 			NewIP:    currentIP,
 			Hostname: cfg.Hostname,
+			Source:   source,
 		}, nil
 	}
 
@@ -172,10 +351,13 @@ func updateWithResolver(ctx context.Context, cfg *config.Config, opts Options, r
 	if client == nil {
 		r53, err := dns.NewFromConfig(ctx, cfg)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create Route53 client: %w", err)
+			return nil, &stageError{ClassAuth, fmt.Errorf("failed to create Route53 client: %w", err)}
 		}
 		client = r53
 	}
+	if opts.Observe != nil {
+		client = &observedClient{next: client, observe: opts.Observe}
+	}
 
 	// 4. Compare against DNS.
 	var dnsIP string
@@ -183,48 +365,104 @@ func updateWithResolver(ctx context.Context, cfg *config.Config, opts Options, r
 		if ctx.Err() != nil {
 			return nil, err
 		}
-		logInfo("Warning: could not get current DNS record: %v", err)
+		logf(slog.LevelWarn, "could not get current DNS record", logging.Err(err))
 	} else {
 		dnsIP = ip
-		logInfo("Current DNS record: %s", dnsIP)
+		known.OldIP = dnsIP
+		logf(progress, "current DNS record", slog.String(logging.KeyOldIP, dnsIP))
 		if currentIP == dnsIP && !opts.Force {
-			logInfo("DNS already up to date with %s", currentIP)
+			logf(progress, "DNS already up to date",
+				slog.String(logging.KeyAction, "nochg-dns"), slog.String(logging.KeyNewIP, currentIP))
 			if werr := writeCachedIP(cfg.IPCacheFile, currentIP); werr != nil {
-				logInfo("Warning: failed to update cache file: %v", werr)
+				logf(slog.LevelWarn, "failed to update cache file", logging.Err(werr))
 			}
 			return &Result{
 				Action:   "nochg-dns",
 				OldIP:    dnsIP,
 				NewIP:    currentIP,
 				Hostname: cfg.Hostname,
+				Source:   source,
 			}, nil
 		}
 	}
 
 	// 5. Dry-run short-circuit.
 	if opts.DryRun {
-		log.Printf("[DRY RUN] Would update %s to %s (TTL: %d)", cfg.Hostname, currentIP, cfg.TTL)
+		logf(logging.LevelNotice, "dry run: would update record",
+			slog.String(logging.KeyAction, "dry-run"),
+			slog.String(logging.KeyOldIP, dnsIP),
+			slog.String(logging.KeyNewIP, currentIP),
+			slog.String(logging.KeySource, source),
+			slog.Int64("ttl", cfg.TTL))
 		if cachedIP != "" {
-			log.Printf("[DRY RUN] Would update cache from %s to %s", cachedIP, currentIP)
+			logf(logging.LevelNotice, "dry run: would update cache",
+				slog.String(logging.KeyOldIP, cachedIP), slog.String(logging.KeyNewIP, currentIP))
 		}
 		return &Result{
 			Action:   "dry-run",
 			OldIP:    dnsIP,
 			NewIP:    currentIP,
 			Hostname: cfg.Hostname,
+			Source:   source,
 		}, nil
 	}
 
-	// 6. UPSERT.
-	logInfo("Updating %s to %s...", cfg.Hostname, currentIP)
+	// 6. pre_update hook; a non-zero exit vetoes the change.
+	if cfg.Hooks != nil && len(cfg.Hooks.PreUpdate) > 0 {
+		r := runHook(ctx, cfg, opts, cfg.Hooks.PreUpdate, hooks.Input{
+			Hook:     hooks.PreUpdate,
+			Hostname: cfg.Hostname,
+			OldIP:    dnsIP,
+			NewIP:    currentIP,
+			Action:   "updating",
+			Source:   source,
+		})
+		if r.Failed() {
+			// A veto is policy, not a fault: the record stays as it is
+			// and the run is not a failure.
+			logger.LogAttrs(ctx, logging.LevelNotice, "update vetoed by pre_update hook",
+				slog.String(logging.KeyOldIP, dnsIP),
+				slog.String(logging.KeyNewIP, currentIP),
+				slog.String("reason", r.Reason()))
+			return &Result{
+				Action:   "vetoed",
+				OldIP:    dnsIP,
+				NewIP:    currentIP,
+				Hostname: cfg.Hostname,
+				Source:   source,
+			}, nil
+		}
+	}
+
+	// 7. UPSERT.
+	logf(progress, "updating record", slog.String(logging.KeyNewIP, currentIP))
+	start := time.Now()
 	if err := client.UpdateIP(ctx, currentIP); err != nil {
-		return nil, fmt.Errorf("failed to update Route53: %w", err)
+		return nil, &stageError{ClassRoute53, fmt.Errorf("failed to update Route53: %w", err)}
 	}
-	log.Printf("Successfully updated %s to %s", cfg.Hostname, currentIP)
+	logf(logging.LevelNotice, "updated record",
+		slog.String(logging.KeyAction, "updated"),
+		slog.String(logging.KeyOldIP, dnsIP),
+		slog.String(logging.KeyNewIP, currentIP),
+		slog.String(logging.KeySource, source),
+		logging.DurationMS(time.Since(start)))
 
-	// 7. Refresh cache.
+	// 8. Refresh cache.
 	if err := writeCachedIP(cfg.IPCacheFile, currentIP); err != nil {
-		logInfo("Warning: failed to update cache file: %v", err)
+		logf(slog.LevelWarn, "failed to update cache file", logging.Err(err))
+	}
+
+	// 9. post_update hook. The record has changed whatever it returns,
+	// so a failure is only logged.
+	if cfg.Hooks != nil && len(cfg.Hooks.PostUpdate) > 0 {
+		runHook(context.WithoutCancel(ctx), cfg, opts, cfg.Hooks.PostUpdate, hooks.Input{
+			Hook:     hooks.PostUpdate,
+			Hostname: cfg.Hostname,
+			OldIP:    dnsIP,
+			NewIP:    currentIP,
+			Action:   "updated",
+			Source:   source,
+		})
 	}
 
 	return &Result{
@@ -232,31 +470,68 @@ func updateWithResolver(ctx context.Context, cfg *config.Config, opts Options, r
 		OldIP:    dnsIP,
 		NewIP:    currentIP,
 		Hostname: cfg.Hostname,
+		Source:   source,
 	}, nil
 }
 
+// observedClient wraps a DNSClient and reports each call's latency and
+// outcome to an Options.Observe callback.
+type observedClient struct {
+	next    DNSClient
+	observe func(op string, elapsed time.Duration, err error)
+}
+
+func (o *observedClient) GetCurrentIP(ctx context.Context) (string, error) {
+	start := time.Now()
+	ip, err := o.next.GetCurrentIP(ctx)
+	o.observe("get", time.Since(start), err)
+	return ip, err
+}
+
+func (o *observedClient) UpdateIP(ctx context.Context, newIP string) error {
+	start := time.Now()
+	err := o.next.UpdateIP(ctx, newIP)
+	o.observe("upsert", time.Since(start), err)
+	return err
+}
+
 // readCachedIP reads the last known IP from cache file.
-// Supports both the current YAML format and the legacy bare-IP format.
 func readCachedIP(path string) string {
+	ip, _ := ReadCache(path)
+	return ip
+}
+
+// ReadCache returns the last known IP in the cache file at path and when
+// it was written; "" and the zero time when there is none. Supports both
+// the current YAML format and the legacy bare-IP format, which carries
+// no timestamp.
+func ReadCache(path string) (ip string, updated time.Time) {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return ""
+		return "", time.Time{}
 	}
 
-	// YAML format: "last_known_ip: x.x.x.x"
+	// YAML format: "last_known_ip: x.x.x.x\nlast_updated: <RFC 3339>"
+	found := false
 	for _, line := range strings.Split(string(data), "\n") {
-		if strings.HasPrefix(line, "last_known_ip:") {
-			return strings.TrimSpace(strings.TrimPrefix(line, "last_known_ip:"))
+		if v, ok := strings.CutPrefix(line, "last_known_ip:"); ok {
+			ip, found = strings.TrimSpace(v), true
+		}
+		if v, ok := strings.CutPrefix(line, "last_updated:"); ok {
+			updated, _ = time.Parse(time.RFC3339, strings.TrimSpace(v))
 		}
 	}
+	if found {
+		return ip, updated
+	}
 
 	// Legacy format (bare IP).
-	ip := strings.TrimSpace(string(data))
+	ip = strings.TrimSpace(string(data))
 	if net.ParseIP(ip) != nil {
-		return ip
+		return ip, time.Time{}
 	}
 
-	return ""
+	return "", time.Time{}
 }
 
 // writeCachedIP writes the current IP to the cache file with a timestamp.
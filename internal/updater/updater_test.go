@@ -1,16 +1,49 @@
 package updater
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/descoped/dddns/internal/config"
+	"github.com/descoped/dddns/internal/hooks"
+	"github.com/descoped/dddns/internal/logging"
+	"github.com/descoped/dddns/internal/mqtt"
+	"github.com/descoped/dddns/internal/mqtt/mqtttest"
+	"github.com/descoped/dddns/internal/notify"
 )
 
+// TestMain doubles as a hook command: with UPDATER_TEST_HOOK set, the
+// test binary appends its stdin to $UPDATER_TEST_HOOK_LOG and exits
+// with the status the variable names ("ok" = 0, "veto" = 1).
+func TestMain(m *testing.M) {
+	if mode := os.Getenv("UPDATER_TEST_HOOK"); mode != "" {
+		stdin, _ := io.ReadAll(os.Stdin)
+		f, err := os.OpenFile(os.Getenv("UPDATER_TEST_HOOK_LOG"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err == nil {
+			_, _ = f.Write(stdin)
+			_ = f.Close()
+		}
+		fmt.Println("hook ran for", os.Getenv("DDDNS_HOSTNAME"))
+		if mode == "veto" && os.Getenv("DDDNS_HOOK") == hooks.PreUpdate {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
 // testPublicIP is the single source of truth for the placeholder public
 // IPv4 used across updater test fixtures. RFC 5737 TEST-NET-3.
 const testPublicIP = "203.0.113.42"
@@ -282,7 +315,7 @@ func TestResolveIP_ExplicitLocal(t *testing.T) {
 		nil,
 		"linux")
 
-	ip, desc, err := res.resolveIP(context.Background(), &config.Config{
+	ip, source, desc, err := res.resolveIP(context.Background(), &config.Config{
 		IPSource: "local",
 		Server:   &config.ServerConfig{WANInterface: "eth8"},
 	})
@@ -292,6 +325,9 @@ func TestResolveIP_ExplicitLocal(t *testing.T) {
 	if ip != "1.2.3.4" {
 		t.Errorf("got %s", ip)
 	}
+	if source != "local" {
+		t.Errorf("source = %q, want local", source)
+	}
 	if captured != "eth8" {
 		t.Errorf("wan_interface not forwarded: got %q", captured)
 	}
@@ -306,13 +342,16 @@ func TestResolveIP_ExplicitRemote(t *testing.T) {
 		func(context.Context) (string, error) { return "5.6.7.8", nil },
 		"udm") // even on UDM, explicit remote overrides the default
 
-	ip, desc, err := res.resolveIP(context.Background(), &config.Config{IPSource: "remote"})
+	ip, source, desc, err := res.resolveIP(context.Background(), &config.Config{IPSource: "remote"})
 	if err != nil {
 		t.Fatal(err)
 	}
 	if ip != "5.6.7.8" {
 		t.Errorf("got %s", ip)
 	}
+	if source != "remote" {
+		t.Errorf("source = %q, want remote", source)
+	}
 	if !strings.Contains(desc, "remote") {
 		t.Errorf("description %q should mention 'remote'", desc)
 	}
@@ -329,13 +368,16 @@ func TestResolveIP_AutoOnUDM_PicksLocal(t *testing.T) {
 		nil,
 		"udm")
 
-	ip, desc, err := res.resolveIP(context.Background(), &config.Config{IPSource: ""})
+	ip, source, desc, err := res.resolveIP(context.Background(), &config.Config{IPSource: ""})
 	if err != nil {
 		t.Fatal(err)
 	}
 	if ip != testPublicIP {
 		t.Errorf("got %s", ip)
 	}
+	if source != "local" {
+		t.Errorf("source = %q, want local", source)
+	}
 	if !strings.Contains(desc, "auto") || !strings.Contains(desc, "udm") {
 		t.Errorf("description %q should mention 'auto' and 'udm'", desc)
 	}
@@ -347,13 +389,16 @@ func TestResolveIP_AutoOffUDM_PicksRemote(t *testing.T) {
 		func(context.Context) (string, error) { return "5.6.7.8", nil },
 		"macos")
 
-	ip, desc, err := res.resolveIP(context.Background(), &config.Config{IPSource: "auto"})
+	ip, source, desc, err := res.resolveIP(context.Background(), &config.Config{IPSource: "auto"})
 	if err != nil {
 		t.Fatal(err)
 	}
 	if ip != "5.6.7.8" {
 		t.Errorf("got %s", ip)
 	}
+	if source != "remote" {
+		t.Errorf("source = %q, want remote", source)
+	}
 	if !strings.Contains(desc, "auto") || !strings.Contains(desc, "non-udm") {
 		t.Errorf("description %q should mention 'auto' and 'non-udm'", desc)
 	}
@@ -365,7 +410,7 @@ func TestResolveIP_InvalidSource(t *testing.T) {
 		func(context.Context) (string, error) { return "", nil },
 		"linux")
 
-	if _, _, err := res.resolveIP(context.Background(), &config.Config{IPSource: "bogus"}); err == nil {
+	if _, _, _, err := res.resolveIP(context.Background(), &config.Config{IPSource: "bogus"}); err == nil {
 		t.Error("expected error for invalid ip_source")
 	}
 }
@@ -469,3 +514,281 @@ func TestUpdate_DryRunWithExistingCache(t *testing.T) {
 		t.Error("UpdateIP should not be called on dry-run even with existing cache")
 	}
 }
+
+// TestUpdate_LogsStructuredOutcome verifies the outcome record carries
+// the shared field names at Notice, and that Quiet demotes per-step
+// progress below Info.
+func TestUpdate_LogsStructuredOutcome(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := baseConfig(tmpDir)
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, logging.FormatJSON, slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Update(context.Background(), cfg, Options{
+		OverrideIP: testPublicIP,
+		Quiet:      true,
+		Client:     &fakeDNSClient{getIP: "198.51.100.1"},
+		Logger:     logger,
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Quiet run logged %d lines at Info+, want only the outcome:\n%s", len(lines), buf.String())
+	}
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"level":    "NOTICE",
+		"action":   "updated",
+		"hostname": "test.example.com",
+		"old_ip":   "198.51.100.1",
+		"new_ip":   testPublicIP,
+		"source":   "override",
+	}
+	for k, v := range want {
+		if rec[k] != v {
+			t.Errorf("%s = %v, want %v", k, rec[k], v)
+		}
+	}
+	if _, ok := rec["duration_ms"]; !ok {
+		t.Error("outcome record has no duration_ms")
+	}
+}
+
+// TestUpdate_ObserveReportsEachRoute53Call verifies the Observe hook
+// sees both the GET and the UPSERT, in order, with their errors. The
+// serve handler's latency histogram and readiness check depend on it.
+func TestUpdate_ObserveReportsEachRoute53Call(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := baseConfig(tmpDir)
+	getErr := errors.New("throttled")
+	fake := &fakeDNSClient{getErr: getErr}
+
+	var ops []string
+	var errs []error
+	_, err := Update(context.Background(), cfg, Options{
+		OverrideIP: testPublicIP,
+		Client:     fake,
+		Quiet:      true,
+		Observe: func(op string, _ time.Duration, err error) {
+			ops = append(ops, op)
+			errs = append(errs, err)
+		},
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if len(ops) != 2 || ops[0] != "get" || ops[1] != "upsert" {
+		t.Fatalf("observed ops = %v, want [get upsert]", ops)
+	}
+	if !errors.Is(errs[0], getErr) || errs[1] != nil {
+		t.Errorf("observed errs = %v, want [throttled <nil>]", errs)
+	}
+}
+
+// TestUpdate_NotifiesOnChangeAndFailure verifies the notifier sees an
+// "updated" event for a published change, a "failed" event for an
+// error, and nothing for a no-change run.
+func TestUpdate_NotifiesOnChangeAndFailure(t *testing.T) {
+	var events []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		var e map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&e)
+		events = append(events, e)
+	}))
+	t.Cleanup(srv.Close)
+	n, err := notify.New(&config.NotifyConfig{Webhooks: []config.WebhookConfig{
+		{URL: srv.URL, Events: []string{"updated", "failed"}},
+	}}, logging.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := baseConfig(t.TempDir())
+	opts := Options{OverrideIP: testPublicIP, Quiet: true, Notifier: n, Logger: logging.Discard()}
+
+	opts.Client = &fakeDNSClient{getIP: "198.51.100.1"}
+	if _, err := Update(context.Background(), cfg, opts); err != nil {
+		t.Fatal(err)
+	}
+	// Cache now matches: no event.
+	if _, err := Update(context.Background(), cfg, opts); err != nil {
+		t.Fatal(err)
+	}
+	opts.Force = true
+	opts.Client = &fakeDNSClient{getIP: "198.51.100.1", updateErr: errors.New("AccessDenied")}
+	if _, err := Update(context.Background(), cfg, opts); err == nil {
+		t.Fatal("expected Route53 error")
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2: %v", len(events), events)
+	}
+	if e := events[0]; e["event"] != "updated" || e["old_ip"] != "198.51.100.1" || e["new_ip"] != testPublicIP || e["source"] != "override" {
+		t.Errorf("updated event = %v", e)
+	}
+	if e := events[1]; e["event"] != "failed" || !strings.Contains(e["error"].(string), "AccessDenied") {
+		t.Errorf("failed event = %v", e)
+	}
+}
+
+// TestUpdate_PublishesMQTTState verifies each run's outcome reaches the
+// broker's retained state topic, failures included, and that a dry run
+// publishes nothing.
+func TestUpdate_PublishesMQTTState(t *testing.T) {
+	b := mqtttest.NewBroker(t)
+	p, err := mqtt.NewPublisher(&config.MQTTConfig{Broker: b.URL()}, "test.example.com", logging.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := baseConfig(t.TempDir())
+	opts := Options{OverrideIP: testPublicIP, Quiet: true, MQTT: p, Logger: logging.Discard()}
+
+	opts.DryRun = true
+	opts.Client = &fakeDNSClient{getIP: "198.51.100.1"}
+	if _, err := Update(context.Background(), cfg, opts); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(b.Messages()); n != 0 {
+		t.Fatalf("dry run published %d messages", n)
+	}
+
+	opts.DryRun = false
+	if _, err := Update(context.Background(), cfg, opts); err != nil {
+		t.Fatal(err)
+	}
+	opts.Force = true
+	opts.Client = &fakeDNSClient{getIP: "198.51.100.1", updateErr: errors.New("AccessDenied")}
+	if _, err := Update(context.Background(), cfg, opts); err == nil {
+		t.Fatal("expected Route53 error")
+	}
+	b.Close()
+
+	var states []mqtt.State
+	for _, m := range b.Messages() {
+		if m.Topic == "dddns/test.example.com/state" {
+			var s mqtt.State
+			if err := json.Unmarshal(m.Payload, &s); err != nil {
+				t.Fatal(err)
+			}
+			states = append(states, s)
+		}
+	}
+	if len(states) != 2 {
+		t.Fatalf("got %d states, want 2: %+v", len(states), states)
+	}
+	if s := states[0]; s.Action != "updated" || s.IP != testPublicIP || s.OldIP != "198.51.100.1" || s.Source != "override" {
+		t.Errorf("updated state = %+v", s)
+	}
+	if s := states[1]; s.Action != "failed" || s.IP != testPublicIP || !strings.Contains(s.Error, "AccessDenied") {
+		t.Errorf("failed state = %+v", s)
+	}
+	if _, ok := b.Retained()["dddns/test.example.com/changed"]; !ok {
+		t.Error("change record not published")
+	}
+}
+
+// hookLog points the hook helper at a fresh log file and returns a
+// reader for the hook inputs it recorded.
+func hookLog(t *testing.T, mode string) func() []hooks.Input {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hooks.jsonl")
+	t.Setenv("UPDATER_TEST_HOOK", mode)
+	t.Setenv("UPDATER_TEST_HOOK_LOG", path)
+	return func() []hooks.Input {
+		data, _ := os.ReadFile(path)
+		var ins []hooks.Input
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var in hooks.Input
+			if line != "" && json.Unmarshal([]byte(line), &in) == nil {
+				ins = append(ins, in)
+			}
+		}
+		return ins
+	}
+}
+
+func hookConfig(tmpDir string) *config.Config {
+	cfg := baseConfig(tmpDir)
+	argv := []string{os.Args[0], "-test.run=^$"}
+	cfg.Hooks = &config.HooksConfig{PreUpdate: argv, PostUpdate: argv, OnError: argv, Timeout: "1m"}
+	return cfg
+}
+
+// TestUpdate_RunsPreAndPostHooks verifies both hooks see the change and
+// that their outcomes reach OnHook in order.
+func TestUpdate_RunsPreAndPostHooks(t *testing.T) {
+	recorded := hookLog(t, "ok")
+	cfg := hookConfig(t.TempDir())
+	var runs []hooks.Run
+
+	_, err := Update(context.Background(), cfg, Options{
+		OverrideIP: testPublicIP,
+		Quiet:      true,
+		Client:     &fakeDNSClient{getIP: "198.51.100.1"},
+		Logger:     logging.Discard(),
+		OnHook:     func(r hooks.Run) { runs = append(runs, r) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ins := recorded()
+	if len(ins) != 2 || ins[0].Hook != hooks.PreUpdate || ins[1].Hook != hooks.PostUpdate {
+		t.Fatalf("hook inputs = %+v", ins)
+	}
+	want := hooks.Input{Hook: hooks.PostUpdate, Hostname: "test.example.com", OldIP: "198.51.100.1", NewIP: testPublicIP, Action: "updated", Source: "override"}
+	if ins[1] != want {
+		t.Errorf("post_update input = %+v, want %+v", ins[1], want)
+	}
+	if len(runs) != 2 || runs[1].Failed() || runs[1].Output != "hook ran for test.example.com" {
+		t.Errorf("OnHook runs = %+v", runs)
+	}
+
+	// No change, no hooks.
+	if _, err := Update(context.Background(), cfg, Options{OverrideIP: testPublicIP, Quiet: true, Client: &fakeDNSClient{}, Logger: logging.Discard()}); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(recorded()); got != 2 {
+		t.Errorf("no-change run invoked hooks (%d inputs)", got)
+	}
+}
+
+// TestUpdate_PreHookVeto verifies a failing pre_update hook stops the
+// UPSERT and ends the run as "vetoed": no error, no on_error hook and no
+// failure streak.
+func TestUpdate_PreHookVeto(t *testing.T) {
+	recorded := hookLog(t, "veto")
+	cfg := hookConfig(t.TempDir())
+	fake := &fakeDNSClient{getIP: "198.51.100.1"}
+
+	result, err := Update(context.Background(), cfg, Options{
+		OverrideIP: testPublicIP,
+		Quiet:      true,
+		Client:     fake,
+		Logger:     logging.Discard(),
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if result.Action != "vetoed" || result.OldIP != "198.51.100.1" || result.NewIP != testPublicIP {
+		t.Errorf("result = %+v, want vetoed 198.51.100.1 -> %s", result, testPublicIP)
+	}
+	if fake.updateCalled {
+		t.Error("vetoed update reached Route53")
+	}
+	if ins := recorded(); len(ins) != 1 || ins[0].Hook != hooks.PreUpdate {
+		t.Errorf("hook inputs = %+v, want only pre_update", ins)
+	}
+	if _, err := os.Stat(FailureStatePath(cfg)); !os.IsNotExist(err) {
+		t.Errorf("veto recorded a failure streak (stat err = %v)", err)
+	}
+}
@@ -5,12 +5,14 @@ package verify
 
 import (
 	"context"
+	"log/slog"
 	"net"
 	"time"
 
 	"github.com/descoped/dddns/internal/commands/myip"
 	"github.com/descoped/dddns/internal/config"
 	"github.com/descoped/dddns/internal/dns"
+	"github.com/descoped/dddns/internal/logging"
 )
 
 // ResolverResult captures the outcome of a single named DNS server lookup.
@@ -72,8 +74,28 @@ var (
 // when the initial public-IP lookup fails; per-step failures (Route53,
 // stdlib, named resolvers) are folded into the Report so the caller can
 // display partial results.
-func Run(ctx context.Context, cfg *config.Config) (*Report, error) {
+//
+// logger receives one Debug record per step with its result and
+// duration_ms; nil discards them (the Report is the primary output).
+func Run(ctx context.Context, cfg *config.Config, logger *slog.Logger) (*Report, error) {
+	if logger == nil {
+		logger = logging.Discard()
+	}
+	logger = logger.With(slog.String(logging.KeyHostname, cfg.Hostname))
+	step := func(name string, start time.Time, ip string, err error, attrs ...slog.Attr) {
+		attrs = append(attrs, slog.String("step", name), logging.DurationMS(time.Since(start)))
+		if ip != "" {
+			attrs = append(attrs, slog.String("ip", ip))
+		}
+		if err != nil {
+			attrs = append(attrs, logging.Err(err))
+		}
+		logger.LogAttrs(ctx, slog.LevelDebug, "verify step", attrs...)
+	}
+
+	start := time.Now()
 	publicIP, err := fetchPublicIP(ctx)
+	step("public-ip", start, publicIP, err)
 	if err != nil {
 		return nil, err
 	}
@@ -81,6 +103,7 @@ func Run(ctx context.Context, cfg *config.Config) (*Report, error) {
 	rep := &Report{PublicIP: publicIP}
 
 	// Route53.
+	start = time.Now()
 	r53, err := newRoute53(ctx, cfg)
 	if err != nil {
 		rep.Route53Error = err
@@ -94,8 +117,10 @@ func Run(ctx context.Context, cfg *config.Config) (*Report, error) {
 			rep.Route53IP = ip
 		}
 	}
+	step("route53", start, rep.Route53IP, rep.Route53Error)
 
 	// Stdlib lookup.
+	start = time.Now()
 	stdCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	ips, lookupErr := stdLookup(stdCtx, cfg.Hostname)
 	cancel()
@@ -109,10 +134,12 @@ func Run(ctx context.Context, cfg *config.Config) (*Report, error) {
 			}
 		}
 	}
+	step("stdlib", start, rep.StdlibIP, rep.StdlibError)
 
 	// Named resolvers.
 	rep.Resolvers = make([]ResolverResult, 0, len(namedResolvers))
 	for _, nr := range namedResolvers {
+		start = time.Now()
 		res := ResolverResult{Name: nr.Name, Server: nr.Address}
 		ip, err := queryNamed(ctx, cfg.Hostname, nr.Address)
 		if err != nil {
@@ -120,6 +147,7 @@ func Run(ctx context.Context, cfg *config.Config) (*Report, error) {
 		} else {
 			res.IP = ip
 		}
+		step("resolver", start, res.IP, res.Error, slog.String("server", nr.Address))
 		rep.Resolvers = append(rep.Resolvers, res)
 	}
 
@@ -73,7 +73,7 @@ func TestRun_HappyPath(t *testing.T) {
 		func(_ context.Context, _, _ string) (string, error) { return publicIP, nil },
 	)
 
-	rep, err := Run(context.Background(), testCfg())
+	rep, err := Run(context.Background(), testCfg(), nil)
 	if err != nil {
 		t.Fatalf("Run returned error: %v", err)
 	}
@@ -124,7 +124,7 @@ func TestRun_PublicIPFailurePropagates(t *testing.T) {
 		},
 	)
 
-	rep, err := Run(context.Background(), testCfg())
+	rep, err := Run(context.Background(), testCfg(), nil)
 	if rep != nil {
 		t.Errorf("Run returned non-nil report on public-IP failure: %+v", rep)
 	}
@@ -149,7 +149,7 @@ func TestRun_Route53ErrorFoldedIntoReport(t *testing.T) {
 		func(_ context.Context, _, _ string) (string, error) { return publicIP, nil },
 	)
 
-	rep, err := Run(context.Background(), testCfg())
+	rep, err := Run(context.Background(), testCfg(), nil)
 	if err != nil {
 		t.Fatalf("Run returned error despite Route53 failure: %v", err)
 	}
@@ -186,7 +186,7 @@ func TestRun_Route53GetCurrentIPError(t *testing.T) {
 		func(_ context.Context, _, _ string) (string, error) { return publicIP, nil },
 	)
 
-	rep, err := Run(context.Background(), testCfg())
+	rep, err := Run(context.Background(), testCfg(), nil)
 	if err != nil {
 		t.Fatalf("Run returned error: %v", err)
 	}
@@ -216,7 +216,7 @@ func TestRun_StdlibLookupErrorFoldedIntoReport(t *testing.T) {
 		func(_ context.Context, _, _ string) (string, error) { return publicIP, nil },
 	)
 
-	rep, err := Run(context.Background(), testCfg())
+	rep, err := Run(context.Background(), testCfg(), nil)
 	if err != nil {
 		t.Fatalf("Run returned error: %v", err)
 	}
@@ -255,7 +255,7 @@ func TestRun_StdlibSkipsIPv6(t *testing.T) {
 		func(_ context.Context, _, _ string) (string, error) { return wantIPv4, nil },
 	)
 
-	rep, err := Run(context.Background(), testCfg())
+	rep, err := Run(context.Background(), testCfg(), nil)
 	if err != nil {
 		t.Fatalf("Run returned error: %v", err)
 	}
@@ -281,7 +281,7 @@ func TestRun_ZeroResolversReturnEmpty(t *testing.T) {
 		func(_ context.Context, _, _ string) (string, error) { return "", nil }, // NODATA: success with no records
 	)
 
-	rep, err := Run(context.Background(), testCfg())
+	rep, err := Run(context.Background(), testCfg(), nil)
 	if err != nil {
 		t.Fatalf("Run returned error: %v", err)
 	}
@@ -315,7 +315,7 @@ func TestRun_PerResolverTimeout(t *testing.T) {
 		},
 	)
 
-	rep, err := Run(context.Background(), testCfg())
+	rep, err := Run(context.Background(), testCfg(), nil)
 	if err != nil {
 		t.Fatalf("Run returned error: %v", err)
 	}
@@ -365,7 +365,7 @@ func TestRun_IPMismatchAcrossSourcesIsReported(t *testing.T) {
 		},
 	)
 
-	rep, err := Run(context.Background(), testCfg())
+	rep, err := Run(context.Background(), testCfg(), nil)
 	if err != nil {
 		t.Fatalf("Run returned error: %v", err)
 	}
@@ -217,7 +217,7 @@ ip_cache_file: ` + filepath.Join(tmpDir, "cache.txt")
 	output, err := cmd.CombinedOutput()
 
 	// Should succeed even with fake credentials in dry-run
-	if err != nil && !strings.Contains(string(output), "DRY RUN") {
+	if err != nil && !strings.Contains(string(output), "dry run") {
 		t.Errorf("dry-run failed: %v\nOutput: %s", err, output)
 	}
 